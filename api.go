@@ -0,0 +1,490 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+const (
+	API_PREFIX = "/api/v1/"
+	// API_MAX_BODY_BYTES leaves room for the longest content rules.go allows
+	API_MAX_BODY_BYTES = 1 << 20
+)
+
+// apiError is the body of every failed api request
+type apiError struct {
+	Error apiErrorDetails `json:"error"`
+}
+
+type apiErrorDetails struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// apiUser is a user as the api shows it, without the password hash
+type apiUser struct {
+	ID         xid.ID     `json:"id"`
+	Name       string     `json:"name"`
+	Role       string     `json:"role"`
+	Banned     bool       `json:"banned"`
+	Deleted    bool       `json:"deleted"`
+	DateJoined *time.Time `json:"date_joined,omitempty"`
+}
+
+type apiPost struct {
+	ID           xid.ID     `json:"id"`
+	Title        string     `json:"title"`
+	Content      string     `json:"content"`
+	PosterID     xid.ID     `json:"poster_id"`
+	BoardID      xid.ID     `json:"board_id"`
+	CommentCount int        `json:"comment_count"`
+	Locked       bool       `json:"locked"`
+	Hidden       bool       `json:"hidden"`
+	DateCreated  time.Time  `json:"date_created"`
+	DateEdited   *time.Time `json:"date_edited,omitempty"`
+}
+
+type apiComment struct {
+	ID          xid.ID       `json:"id"`
+	PostID      xid.ID       `json:"post_id"`
+	ParentID    xid.ID       `json:"parent_id"`
+	Poster      *apiUser     `json:"poster,omitempty"`
+	Content     string       `json:"content"`
+	Deleted     bool         `json:"deleted"`
+	Hidden      bool         `json:"hidden"`
+	DateCreated time.Time    `json:"date_created"`
+	DateEdited  *time.Time   `json:"date_edited,omitempty"`
+	Replies     []apiComment `json:"replies,omitempty"`
+}
+
+// apiCursors are the cursors to pass as after and before to get the pages around a page
+type apiCursors struct {
+	Previous string `json:"previous,omitempty"`
+	Next     string `json:"next,omitempty"`
+}
+
+type apiPostList struct {
+	Posts   []apiPost  `json:"posts"`
+	Cursors apiCursors `json:"cursors"`
+}
+
+type apiPostPage struct {
+	Post     apiPost      `json:"post"`
+	Poster   apiUser      `json:"poster"`
+	Comments []apiComment `json:"comments"`
+	Cursors  apiCursors   `json:"cursors"`
+}
+
+type apiNewPost struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	// slug of the board, the default board if empty
+	Board string `json:"board"`
+}
+
+type apiNewComment struct {
+	Content string `json:"content"`
+	// the comment replied to, empty to reply to the post
+	ParentID string `json:"parent_id"`
+}
+
+// APIHandler serves the json api under /api/v1/, it uses the same session
+// as the html pages, or a session or api token sent as a bearer token
+func APIHandler(w http.ResponseWriter, r *http.Request) {
+	path := pathIntoArray(strings.TrimPrefix(r.URL.EscapedPath(), API_PREFIX))
+	switch {
+	case len(path) == 1 && path[0] == "whoami":
+		if apiMethodAllowed(w, r, "GET") && apiRequireScope(w, r, ScopeRead) {
+			apiWhoami(w, r)
+		}
+	case len(path) == 1 && path[0] == "posts":
+		if !apiMethodAllowed(w, r, "GET", "POST") {
+			return
+		}
+		if r.Method == "GET" && apiRequireScope(w, r, ScopeRead) {
+			apiListPosts(w, r)
+		} else if r.Method == "POST" && apiRequireScope(w, r, ScopePost) {
+			apiCreatePost(w, r)
+		}
+	case len(path) == 2 && path[0] == "posts":
+		if apiMethodAllowed(w, r, "GET") && apiRequireScope(w, r, ScopeRead) {
+			apiGetPost(w, r, path[1])
+		}
+	case len(path) == 3 && path[0] == "posts" && path[2] == "comments":
+		if apiMethodAllowed(w, r, "POST") && apiRequireScope(w, r, ScopeComment) {
+			apiCreateComment(w, r, path[1])
+		}
+	case len(path) == 2 && path[0] == "users":
+		if apiMethodAllowed(w, r, "GET") && apiRequireScope(w, r, ScopeRead) {
+			apiGetUser(w, r, path[1])
+		}
+	default:
+		writeAPIError(w, http.StatusNotFound, "unknown api endpoint")
+	}
+}
+
+func apiWhoami(w http.ResponseWriter, r *http.Request) {
+	profile := profileFromCtx(r.Context())
+	if !profile.OK {
+		writeAPIError(w, http.StatusUnauthorized, ErrNotSignedIn.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIUser(profile.User))
+}
+
+// apiListPosts lists a page of posts, newest first, of the board in the board query parameter or of every board
+func apiListPosts(w http.ResponseWriter, r *http.Request) {
+	page, err := pageFromRequest(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page.IncludeHidden = profileFromCtx(r.Context()).Moderator
+	var posts []database.Post
+	var cursors database.PageCursors
+	if slug := r.URL.Query().Get("board"); slug != "" {
+		board, err := db.FindBoardBySlug(r.Context(), slug)
+		if err == database.ErrNoBoardFoundBySlug {
+			writeAPIError(w, http.StatusNotFound, "that board does not exist")
+			return
+		}
+		if err != nil {
+			apiInternalError(w, err)
+			return
+		}
+		posts, cursors, err = db.PageBoardPosts(r.Context(), board.ID, page)
+	} else {
+		posts, cursors, err = db.PagePosts(r.Context(), page)
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	list := apiPostList{Posts: []apiPost{}, Cursors: toAPICursors(cursors)}
+	for _, post := range posts {
+		list.Posts = append(list.Posts, toAPIPost(post))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func apiCreatePost(w http.ResponseWriter, r *http.Request) {
+	if !apiRequirePermission(w, r, PermissionPost) {
+		return
+	}
+	var body apiNewPost
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+	if err := isTitleValid(body.Title); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := isContentValid(body.Content); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Board == "" {
+		body.Board = DEFAULT_BOARD_SLUG
+	}
+	board, err := db.FindBoardBySlug(r.Context(), body.Board)
+	if err == database.ErrNoBoardFoundBySlug {
+		writeAPIError(w, http.StatusBadRequest, "that board does not exist")
+		return
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	postID, err := db.AddPost(r.Context(), body.Title, body.Content, profileFromCtx(r.Context()).User.ID, board.ID)
+	if err == database.ErrNoBoardFoundByID {
+		writeAPIError(w, http.StatusBadRequest, "that board does not exist")
+		return
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	post, err := db.GetPost(r.Context(), postID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	publishPost(post.ID, post.Title, post.BoardID, profileFromCtx(r.Context()).User)
+	w.Header().Set("Location", API_PREFIX+"posts/"+postID.String())
+	writeJSON(w, http.StatusCreated, toAPIPost(post))
+}
+
+// apiGetPost returns a post with a page of its comments, paged like the post page
+func apiGetPost(w http.ResponseWriter, r *http.Request, encodedID string) {
+	postID, err := xid.FromString(encodedID)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "malformed post id")
+		return
+	}
+	page, err := pageFromRequest(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	post, poster, comments, users, cursors, err := db.GetPostPageData(r.Context(), postID, page)
+	moderator := profileFromCtx(r.Context()).Moderator
+	if err == database.ErrNoPostFoundByID || (err == nil && post.Hidden && !moderator) {
+		writeAPIError(w, http.StatusNotFound, "that post does not exist")
+		return
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPostPage{
+		Post:     toAPIPost(post),
+		Poster:   toAPIUser(poster),
+		Comments: toAPIComments(comments, users, moderator),
+		Cursors:  toAPICursors(cursors),
+	})
+}
+
+func apiCreateComment(w http.ResponseWriter, r *http.Request, encodedID string) {
+	if !apiRequirePermission(w, r, PermissionPost) {
+		return
+	}
+	postID, err := xid.FromString(encodedID)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "malformed post id")
+		return
+	}
+	var body apiNewComment
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+	parentID := xid.NilID()
+	if body.ParentID != "" {
+		parentID, err = xid.FromString(body.ParentID)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "malformed parent comment id")
+			return
+		}
+	}
+	if err := isContentValid(body.Content); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	post, err := db.GetPost(r.Context(), postID)
+	if err == database.ErrNoPostFoundByID || (err == nil && post.Hidden && !profileFromCtx(r.Context()).Moderator) {
+		writeAPIError(w, http.StatusNotFound, "that post does not exist")
+		return
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	// moderators can still answer in locked threads
+	if post.Locked && checkPermission(r, PermissionModerate) != nil {
+		writeAPIError(w, http.StatusForbidden, "this thread is locked")
+		return
+	}
+	commentID, err := db.AddComment(r.Context(), body.Content, postID, profileFromCtx(r.Context()).User.ID, parentID)
+	if err == database.ErrNoCommentFoundByID {
+		writeAPIError(w, http.StatusBadRequest, ErrCannotReply.Error())
+		return
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	comment, err := db.GetComment(r.Context(), commentID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	publishComment(r.Context(), post, commentID, profileFromCtx(r.Context()).User, nil)
+	writeJSON(w, http.StatusCreated, toAPIComment(comment, nil, true))
+}
+
+func apiGetUser(w http.ResponseWriter, r *http.Request, encodedID string) {
+	userID, err := xid.FromString(encodedID)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "malformed user id")
+		return
+	}
+	user, err := db.GetUser(r.Context(), userID)
+	if err == database.ErrNoUserFoundByID {
+		writeAPIError(w, http.StatusNotFound, "that user does not exist")
+		return
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	// deleted accounts keep their id but nothing else, like everywhere else they show up
+	if user.Deleted {
+		deleted := database.DeletedUser
+		deleted.ID = user.ID
+		user = deleted
+	}
+	writeJSON(w, http.StatusOK, toAPIUser(user))
+}
+
+// isAPIRequest reports whether a request is for the json api
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, API_PREFIX)
+}
+
+// apiMethodAllowed writes a method not allowed error and returns false
+// if the method of the request is not one of allowed
+func apiMethodAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) bool {
+	for _, method := range allowed {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Add("Allow", strings.Join(allowed, ", "))
+	writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// apiRequirePermission is requirePermission for the api
+func apiRequirePermission(w http.ResponseWriter, r *http.Request, perm Permission) bool {
+	err := checkPermission(r, perm)
+	switch err {
+	case nil:
+		return true
+	case ErrNotSignedIn:
+		writeAPIError(w, http.StatusUnauthorized, err.Error())
+	default:
+		writeAPIError(w, http.StatusForbidden, err.Error())
+	}
+	return false
+}
+
+// apiRequireScope writes a forbidden error and returns false
+// if the request was made with an api token that lacks scope
+func apiRequireScope(w http.ResponseWriter, r *http.Request, scope Scope) bool {
+	if hasScope(r, scope) {
+		return true
+	}
+	writeAPIError(w, http.StatusForbidden, "this api token lacks the "+string(scope)+" scope")
+	return false
+}
+
+// decodeAPIBody decodes the json body of a request into v,
+// if it cannot it writes a bad request error and returns false
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, API_MAX_BODY_BYTES))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "malformed json body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zapper.Error("error", zap.Error(err))
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{apiErrorDetails{Status: status, Message: message}})
+}
+
+// apiInternalError logs err and writes a generic error, so internals are not leaked
+func apiInternalError(w http.ResponseWriter, err error) {
+	zapper.Error("error", zap.Error(err))
+	writeAPIError(w, http.StatusInternalServerError, "internal server error")
+}
+
+func toAPIUser(user database.User) apiUser {
+	u := apiUser{
+		ID:      user.ID,
+		Name:    user.Name,
+		Role:    user.Role.String(),
+		Banned:  user.Banned,
+		Deleted: user.Deleted,
+	}
+	if !user.DateJoined.IsZero() {
+		u.DateJoined = &user.DateJoined
+	}
+	return u
+}
+
+func toAPIPost(post database.Post) apiPost {
+	return apiPost{
+		ID:           post.ID,
+		Title:        post.Title,
+		Content:      post.Content,
+		PosterID:     post.PosterID,
+		BoardID:      post.BoardID,
+		CommentCount: len(post.CommentIDs),
+		Locked:       post.Locked,
+		Hidden:       post.Hidden,
+		DateCreated:  post.DateCreated,
+		DateEdited:   optionalTime(post.DateEdited),
+	}
+}
+
+// toAPIComment converts a comment, poster may be nil.
+// the content of hidden comments is only shown to moderators
+func toAPIComment(comment database.Comment, poster *apiUser, moderator bool) apiComment {
+	c := apiComment{
+		ID:          comment.ID,
+		PostID:      comment.PostID,
+		ParentID:    comment.ParentID,
+		Poster:      poster,
+		Content:     comment.Content,
+		Deleted:     comment.Deleted,
+		Hidden:      comment.Hidden,
+		DateCreated: comment.DateCreated,
+		DateEdited:  optionalTime(comment.DateEdited),
+	}
+	if comment.Hidden && !moderator {
+		c.Content = ""
+	}
+	return c
+}
+
+// toAPIComments converts a comment tree, users are the posters keyed by comment id
+func toAPIComments(nodes []database.CommentNode, users map[xid.ID]database.User, moderator bool) []apiComment {
+	comments := make([]apiComment, 0, len(nodes))
+	for _, node := range nodes {
+		var poster *apiUser
+		if user, ok := users[node.ID]; ok {
+			u := toAPIUser(user)
+			poster = &u
+		}
+		c := toAPIComment(node.Comment, poster, moderator)
+		if len(node.Replies) > 0 {
+			c.Replies = toAPIComments(node.Replies, users, moderator)
+		}
+		comments = append(comments, c)
+	}
+	return comments
+}
+
+func toAPICursors(cursors database.PageCursors) apiCursors {
+	var c apiCursors
+	if !cursors.Previous.IsZero() {
+		c.Previous = cursors.Previous.String()
+	}
+	if !cursors.Next.IsZero() {
+		c.Next = cursors.Next.String()
+	}
+	return c
+}
+
+// optionalTime returns nil for the zero time, so it is left out of the json
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/courtier/carrotbb/database"
+)
+
+// newAPITestServer connects a fresh sqlite database and returns the api behind
+// the same middlewares main uses, along with the bearer token of a signed in user
+func newAPITestServer(t *testing.T) (http.Handler, string) {
+	ctx := context.Background()
+	newTestDB(t)
+	userID, err := db.AddUser(ctx, "carrot", "carrot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := newToken(SessionToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionCache.Write(ctx, token, session{userID: userID, expiry: time.Now().Add(time.Hour)})
+	mux := http.NewServeMux()
+	mux.HandleFunc(API_PREFIX, APIHandler)
+	return NewAuthMiddleware(NewCSRFMiddleware(mux)), token
+}
+
+func apiRequest(t *testing.T, handler http.Handler, method, path, token, body string, out interface{}) int {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if out != nil {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatal(method, path, "returned malformed json:", err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAPI(t *testing.T) {
+	ctx := context.Background()
+	handler, token := newAPITestServer(t)
+
+	var me apiUser
+	if code := apiRequest(t, handler, "GET", "/api/v1/whoami", token, "", &me); code != http.StatusOK || me.Name != "carrot" {
+		t.Error("whoami expected carrot, got:", code, me)
+	}
+	var failure apiError
+	if code := apiRequest(t, handler, "GET", "/api/v1/whoami", "", "", &failure); code != http.StatusUnauthorized || failure.Error.Status != code {
+		t.Error("whoami without a token expected:", http.StatusUnauthorized, "got:", code, failure)
+	}
+
+	var post apiPost
+	code := apiRequest(t, handler, "POST", "/api/v1/posts", token, `{"title": "hello", "content": "world"}`, &post)
+	if code != http.StatusCreated || post.Title != "hello" || post.PosterID != me.ID {
+		t.Fatal("create post expected:", http.StatusCreated, "got:", code, post)
+	}
+	if code := apiRequest(t, handler, "POST", "/api/v1/posts", "", `{"title": "hello", "content": "world"}`, &failure); code != http.StatusForbidden {
+		t.Error("create post without a token or csrf token expected:", http.StatusForbidden, "got:", code)
+	}
+	if code := apiRequest(t, handler, "POST", "/api/v1/posts", token, `{"title": "", "content": "world"}`, &failure); code != http.StatusBadRequest || failure.Error.Message != ErrTitleBadLength.Error() {
+		t.Error("create post with an empty title expected:", ErrTitleBadLength, "got:", code, failure)
+	}
+
+	var comment apiComment
+	path := "/api/v1/posts/" + post.ID.String()
+	if code := apiRequest(t, handler, "POST", path+"/comments", token, `{"content": "first"}`, &comment); code != http.StatusCreated {
+		t.Fatal("create comment expected:", http.StatusCreated, "got:", code)
+	}
+	body := `{"content": "reply", "parent_id": "` + comment.ID.String() + `"}`
+	if code := apiRequest(t, handler, "POST", path+"/comments", token, body, nil); code != http.StatusCreated {
+		t.Fatal("create reply expected:", http.StatusCreated, "got:", code)
+	}
+
+	var page apiPostPage
+	if code := apiRequest(t, handler, "GET", path, "", "", &page); code != http.StatusOK {
+		t.Fatal("get post expected:", http.StatusOK, "got:", code)
+	}
+	if len(page.Comments) != 1 || len(page.Comments[0].Replies) != 1 || page.Comments[0].Poster == nil || page.Comments[0].Poster.Name != "carrot" {
+		t.Error("get post expected one comment with one reply, got:", page.Comments)
+	}
+	var list apiPostList
+	if code := apiRequest(t, handler, "GET", "/api/v1/posts?board="+DEFAULT_BOARD_SLUG, "", "", &list); code != http.StatusOK || len(list.Posts) != 1 {
+		t.Error("list posts expected one post, got:", code, list)
+	}
+	if code := apiRequest(t, handler, "GET", "/api/v1/users/"+me.ID.String(), "", "", nil); code != http.StatusOK {
+		t.Error("get user expected:", http.StatusOK, "got:", code)
+	}
+	leaverID, err := db.AddUser(ctx, "leaver", "leaver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteUser(ctx, leaverID); err != nil {
+		t.Fatal(err)
+	}
+	var leaver apiUser
+	if code := apiRequest(t, handler, "GET", "/api/v1/users/"+leaverID.String(), "", "", &leaver); code != http.StatusOK || leaver.ID != leaverID || leaver.Name != database.DeletedUser.Name || leaver.DateJoined != nil {
+		t.Error("get deleted user expected only its id, got:", code, leaver)
+	}
+	if code := apiRequest(t, handler, "GET", "/api/v1/carrots", "", "", &failure); code != http.StatusNotFound {
+		t.Error("unknown endpoint expected:", http.StatusNotFound, "got:", code)
+	}
+	if code := apiRequest(t, handler, "DELETE", path, token, "", &failure); code != http.StatusMethodNotAllowed {
+		t.Error("DELETE expected:", http.StatusMethodNotAllowed, "got:", code)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	payloads := map[string]string{
+		"":               "",
+		"Bearer abc":     "abc",
+		"bearer abc":     "abc",
+		"Basic abc":      "",
+		"Bearer":         "",
+		"Bearer  abc   ": "abc",
+	}
+	for k, v := range payloads {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", k)
+		if res := bearerToken(r); res != v {
+			t.Error("Header:", k, "expected:", v, "got:", res)
+		}
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	ctx := context.Background()
+	handler, session := newAPITestServer(t)
+	var me apiUser
+	if code := apiRequest(t, handler, "GET", "/api/v1/whoami", session, "", &me); code != http.StatusOK {
+		t.Fatal("whoami expected:", http.StatusOK, "got:", code)
+	}
+	if _, err := createAPIToken(ctx, me.ID, "", nil); err != ErrTokenNameBadLength {
+		t.Error("token without a name expected:", ErrTokenNameBadLength, "got:", err)
+	}
+	if _, err := createAPIToken(ctx, me.ID, "bot", []string{"carrots"}); err != ErrUnknownScope {
+		t.Error("token with an unknown scope expected:", ErrUnknownScope, "got:", err)
+	}
+	reader, err := createAPIToken(ctx, me.ID, "reader", []string{string(ScopeRead)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isTokenOfKind(reader, APIToken) {
+		t.Error("expected an api token, got:", reader)
+	}
+
+	var whoami apiUser
+	if code := apiRequest(t, handler, "GET", "/api/v1/whoami", reader, "", &whoami); code != http.StatusOK || whoami.ID != me.ID {
+		t.Error("whoami with a read token expected:", me.ID, "got:", code, whoami)
+	}
+	var failure apiError
+	if code := apiRequest(t, handler, "POST", "/api/v1/posts", reader, `{"title": "hello", "content": "world"}`, &failure); code != http.StatusForbidden {
+		t.Error("create post with a read token expected:", http.StatusForbidden, "got:", code, failure)
+	}
+	tokens, err := db.UserAPITokens(ctx, me.ID)
+	if err != nil || len(tokens) != 1 || tokens[0].LastUsed.IsZero() {
+		t.Error("expected the token to be marked as used, got:", tokens, err)
+	}
+
+	everything, err := createAPIToken(ctx, me.ID, "everything", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := apiRequest(t, handler, "POST", "/api/v1/posts", everything, `{"title": "hello", "content": "world"}`, nil); code != http.StatusCreated {
+		t.Error("create post with an unscoped token expected:", http.StatusCreated, "got:", code)
+	}
+
+	r := httptest.NewRequest("GET", "/self", nil)
+	r.Header.Set("Authorization", "Bearer "+everything)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusUnauthorized {
+		t.Error("api token outside the api expected:", http.StatusUnauthorized, "got:", rec.Code)
+	}
+
+	if err = db.DeleteAPIToken(ctx, tokens[0].ID, me.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := apiRequest(t, handler, "GET", "/api/v1/whoami", reader, "", &failure); code != http.StatusUnauthorized {
+		t.Error("revoked token expected:", http.StatusUnauthorized, "got:", code)
+	}
+}
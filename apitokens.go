@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/courtier/carrotbb/templates"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+// Scope limits what an api token can be used for
+type Scope string
+
+const (
+	ScopeRead    Scope = "read"
+	ScopePost    Scope = "post"
+	ScopeComment Scope = "comment"
+)
+
+const (
+	MAX_API_TOKENS = 20
+	// API_TOKEN_TOUCH_INTERVAL is how stale the last used time of a token may get,
+	// so scripts do not cause a write on every request
+	API_TOKEN_TOUCH_INTERVAL = time.Minute
+)
+
+var (
+	ErrTooManyAPITokens = errors.New("you cannot have more than 20 api tokens")
+	ErrUnknownScope     = errors.New("unknown api token scope")
+	ErrInvalidAPIToken  = errors.New("invalid api token")
+)
+
+var (
+	// allScopes are the scopes a new token gets when none are picked
+	allScopes = []Scope{ScopeRead, ScopePost, ScopeComment}
+)
+
+// createAPIToken issues a new api token for a user, limited to scopes or unrestricted if there are none.
+// the token is returned so it can be shown once, only its hash is stored
+func createAPIToken(ctx context.Context, userID xid.ID, name string, scopes []string) (string, error) {
+	if err := isTokenNameValid(name); err != nil {
+		return "", err
+	}
+	if len(scopes) == 0 {
+		for _, scope := range allScopes {
+			scopes = append(scopes, string(scope))
+		}
+	}
+	for _, scope := range scopes {
+		if !isKnownScope(scope) {
+			return "", ErrUnknownScope
+		}
+	}
+	tokens, err := db.UserAPITokens(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if len(tokens) >= MAX_API_TOKENS {
+		return "", ErrTooManyAPITokens
+	}
+	token, err := newToken(APIToken)
+	if err != nil {
+		return "", err
+	}
+	if _, err = db.AddAPIToken(ctx, userID, name, hashToken(token), scopes); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// extractAPITokenUser returns the api token and the user it belongs to,
+// returns ErrInvalidAPIToken if it was never issued or has been revoked
+func extractAPITokenUser(ctx context.Context, token string) (database.APIToken, database.User, error) {
+	apiToken, err := db.FindAPITokenByHash(ctx, hashToken(token))
+	if err == database.ErrNoAPITokenFound {
+		return database.APIToken{}, database.User{}, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return database.APIToken{}, database.User{}, err
+	}
+	user, err := db.GetUser(ctx, apiToken.UserID)
+	if err == database.ErrNoUserFoundByID || (err == nil && user.Deleted) {
+		return database.APIToken{}, database.User{}, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return database.APIToken{}, database.User{}, err
+	}
+	if now := time.Now(); now.Sub(apiToken.LastUsed) > API_TOKEN_TOUCH_INTERVAL {
+		if err := db.TouchAPIToken(ctx, apiToken.ID, now); err != nil {
+			zapper.Error("error", zap.Error(err))
+		}
+	}
+	return apiToken, user, nil
+}
+
+// serveAPIToken authenticates a request carrying an api token, which only works on the api
+func (a *AuthMiddleware) serveAPIToken(w http.ResponseWriter, r *http.Request, token string) {
+	if !isAPIRequest(r) {
+		writeAPIError(w, http.StatusUnauthorized, "api tokens only work on the api")
+		return
+	}
+	apiToken, user, err := extractAPITokenUser(r.Context(), token)
+	if err == ErrInvalidAPIToken {
+		writeAPIError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	reqCtx := context.WithValue(r.Context(), ContextString("user"), user)
+	reqCtx = context.WithValue(reqCtx, ContextString("scopes"), apiToken.Scopes)
+	a.handler.ServeHTTP(w, r.WithContext(reqCtx))
+}
+
+// hasScope reports whether a request may do what scope allows,
+// requests not authenticated with an api token have every scope
+func hasScope(r *http.Request, scope Scope) bool {
+	scopes, ok := r.Context().Value(ContextString("scopes")).([]string)
+	if !ok {
+		return true
+	}
+	for _, s := range scopes {
+		if s == string(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func isKnownScope(scope string) bool {
+	for _, s := range allScopes {
+		if scope == string(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// createTokenAction creates an api token from the form on /self and shows it once
+func createTokenAction(w http.ResponseWriter, r *http.Request) {
+	profile := profileFromCtx(r.Context())
+	token, err := createAPIToken(r.Context(), profile.User.ID, r.Form.Get("name"), r.Form["scope"])
+	if err != nil {
+		switch err {
+		case ErrTokenNameBadLength, ErrUnknownScope, ErrTooManyAPITokens:
+			w.WriteHeader(http.StatusBadRequest)
+			templates.GenerateErrorPage(w, err.Error())
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			templates.GenerateErrorPage(w, "error creating api token")
+		}
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	generateSelfProfile(r.Context(), w, profile, token)
+}
+
+// revokeTokenAction deletes one of the api tokens of the user
+func revokeTokenAction(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := xid.FromString(r.Form.Get("tokenID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "malformed token id")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	if err = db.DeleteAPIToken(r.Context(), tokenID, profileFromCtx(r.Context()).User.ID); err != nil {
+		if err == database.ErrNoAPITokenFound {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		templates.GenerateErrorPage(w, "error revoking api token")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	http.Redirect(w, r, "/self", http.StatusFound)
+}
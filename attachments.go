@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/courtier/carrotbb/blobs"
+	"github.com/courtier/carrotbb/database"
+	"github.com/courtier/carrotbb/templates"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+const (
+	ATTACHMENTS_PREFIX = "/attachments/"
+	// MAX_ATTACHMENTS is how many images a single post or comment can carry
+	MAX_ATTACHMENTS      = 4
+	MAX_ATTACHMENT_BYTES = 8 << 20
+	// MAX_UPLOAD_BYTES caps a whole multipart body, the text fields get a megabyte next to the images
+	MAX_UPLOAD_BYTES = MAX_ATTACHMENTS*MAX_ATTACHMENT_BYTES + 1<<20
+	// UPLOAD_MEMORY is how much of an upload is held in memory, the rest goes to temporary files
+	UPLOAD_MEMORY = 10 << 20
+	// MAX_IMAGE_PIXELS keeps small files that decode into huge images out, it is checked before decoding
+	MAX_IMAGE_PIXELS = 25_000_000
+	// THUMBNAIL_SIZE is the longest side of a thumbnail
+	THUMBNAIL_SIZE = 320
+	JPEG_QUALITY   = 90
+	// BLOB_SWEEP_INTERVAL is how often blobs no attachment refers to anymore are removed
+	BLOB_SWEEP_INTERVAL = time.Hour
+	// BLOB_GRACE_PERIOD keeps blobs stored recently, images are stored before the post or comment
+	// they come with is created, and a failed request leaves them behind for the sweep
+	BLOB_GRACE_PERIOD = time.Hour
+)
+
+var (
+	ErrTooManyAttachments = errors.New("too many images, at most 4 can be attached")
+	ErrAttachmentTooLarge = errors.New("images can be at most 8 MB")
+	ErrUnsupportedImage   = errors.New("only png, jpeg and gif images can be attached")
+	ErrImageTooLarge      = errors.New("images can be at most 25 megapixels")
+)
+
+var (
+	blobStore blobs.Store
+	// uploadPaths are the only paths that take multipart bodies
+	uploadPaths = []string{"/createpost", "/createcomment"}
+)
+
+// upload is an image that passed validation, re-encoded and ready to be stored
+type upload struct {
+	Image       []byte
+	Thumbnail   []byte
+	ContentType string
+	Width       int
+	Height      int
+	// set once the upload is in the blob store
+	Key          string
+	ThumbnailKey string
+}
+
+// isMultipartRequest reports whether a request has a multipart body, as forms with uploads do
+func isMultipartRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// isUploadPath reports whether images can be attached to requests to path
+func isUploadPath(path string) bool {
+	for _, uploadPath := range uploadPaths {
+		if path == uploadPath {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsUploads turns away multipart bodies before they are read, unless they are sent to
+// an upload path by someone who can post. returns false after writing an error
+func acceptsUploads(w http.ResponseWriter, r *http.Request) bool {
+	if !isUploadPath(r.URL.Path) {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		templates.GenerateErrorPage(w, "images can only be attached to new posts and comments")
+		return false
+	}
+	if !profileFromCtx(r.Context()).CanPost {
+		w.WriteHeader(http.StatusForbidden)
+		templates.GenerateErrorPage(w, "sign in to attach images")
+		return false
+	}
+	return true
+}
+
+// parseUploadForm caps and parses a multipart body, it has to run before anything
+// reads the form as the csrf token is sent in it. returns false after writing an error
+func parseUploadForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_UPLOAD_BYTES)
+	err := r.ParseMultipartForm(UPLOAD_MEMORY)
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		templates.GenerateErrorPage(w, "the upload is too large")
+		return false
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	templates.GenerateErrorPage(w, "error parsing form")
+	zapper.Error("error", zap.Error(err))
+	return false
+}
+
+// uploadsFromForm validates and processes the images attached to a parsed form,
+// forms without a multipart body have none
+func uploadsFromForm(r *http.Request) ([]upload, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+	uploads := []upload{}
+	for _, header := range r.MultipartForm.File[templates.AttachmentFieldName] {
+		// browsers send an empty file when nothing was picked
+		if header.Filename == "" && header.Size == 0 {
+			continue
+		}
+		if len(uploads) == MAX_ATTACHMENTS {
+			return nil, ErrTooManyAttachments
+		}
+		if header.Size > MAX_ATTACHMENT_BYTES {
+			return nil, ErrAttachmentTooLarge
+		}
+		f, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(f, MAX_ATTACHMENT_BYTES+1))
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > MAX_ATTACHMENT_BYTES {
+			return nil, ErrAttachmentTooLarge
+		}
+		u, err := processImage(data)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, nil
+}
+
+// processImage checks that data is an image we accept and re-encodes it along with a thumbnail.
+// Only the pixels survive re-encoding, which strips exif and any other metadata the file carried,
+// so jpegs are turned by their exif orientation first. Animated gifs keep their first frame
+func processImage(data []byte) (upload, error) {
+	contentType := http.DetectContentType(data)
+	if contentType != "image/png" && contentType != "image/jpeg" && contentType != "image/gif" {
+		return upload{}, ErrUnsupportedImage
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || "image/"+format != contentType {
+		return upload{}, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return upload{}, ErrUnsupportedImage
+	}
+	if config.Width*config.Height > MAX_IMAGE_PIXELS {
+		return upload{}, ErrImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return upload{}, ErrUnsupportedImage
+	}
+	if contentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+	u := upload{ContentType: contentType, Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+	if u.Image, err = encodeImage(img, contentType); err != nil {
+		return upload{}, err
+	}
+	if u.Thumbnail, err = encodeImage(thumbnail(img, THUMBNAIL_SIZE), thumbnailContentType(contentType)); err != nil {
+		return upload{}, err
+	}
+	return u, nil
+}
+
+func encodeImage(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: JPEG_QUALITY})
+	case "image/gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+// thumbnailContentType is what the thumbnail of an image is encoded as,
+// gifs get png thumbnails since scaling them down blends colors outside their palette
+func thumbnailContentType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return contentType
+	}
+	return "image/png"
+}
+
+// thumbnail scales img down to fit in a size by size square, every pixel of the thumbnail
+// is the average of the pixels it covers. Images that already fit are returned as they are
+func thumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, size
+	if w > h {
+		th = max(1, h*size/w)
+	} else {
+		tw = max(1, w*size/h)
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		y0, y1 := bounds.Min.Y+ty*h/th, bounds.Min.Y+(ty+1)*h/th
+		for tx := 0; tx < tw; tx++ {
+			x0, x1 := bounds.Min.X+tx*w/tw, bounds.Min.X+(tx+1)*w/tw
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					// premultiplied, so averaging them blends transparent pixels correctly
+					pr, pg, pb, pa := img.At(x, y).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			thumb.SetRGBA(tx, ty, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return thumb
+}
+
+// uploadsFromRequest processes the images attached to a form and puts them in the blob store,
+// before the post or comment they belong to is created, if that fails they are left to sweepBlobs.
+// returns false after writing an error
+func uploadsFromRequest(w http.ResponseWriter, r *http.Request) ([]upload, bool) {
+	uploads, err := uploadsFromForm(r)
+	if err == ErrTooManyAttachments || err == ErrAttachmentTooLarge || err == ErrUnsupportedImage || err == ErrImageTooLarge {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, err.Error())
+		return nil, false
+	}
+	if err == nil {
+		err = storeUploads(uploads)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while saving the images")
+		zapper.Error("error", zap.Error(err))
+		return nil, false
+	}
+	return uploads, true
+}
+
+// storeUploads puts the images and thumbnails of uploads in the blob store
+func storeUploads(uploads []upload) error {
+	for n := range uploads {
+		var err error
+		if uploads[n].Key, err = blobStore.Put(uploads[n].Image); err != nil {
+			return err
+		}
+		if uploads[n].ThumbnailKey, err = blobStore.Put(uploads[n].Thumbnail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeUnusedBlobs deletes the blobs older than grace that no attachment refers to,
+// blobs are shared by every attachment with the same content so they are only removed once none is left.
+// returns how many were removed
+func removeUnusedBlobs(ctx context.Context, store blobs.Store, grace time.Duration) (int, error) {
+	// listed before the references are read, so blobs stored in between are too new to be listed
+	keys, err := store.Keys(ctx, time.Now().Add(-grace))
+	if err != nil {
+		return 0, err
+	}
+	used, err := db.AttachmentKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, key := range keys {
+		if used[key] {
+			continue
+		}
+		if err = store.Delete(key); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// sweepBlobs removes unused blobs every interval until ctx is done
+func sweepBlobs(ctx context.Context, store blobs.Store, interval, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := removeUnusedBlobs(ctx, store, grace)
+			if err != nil {
+				zapper.Error("error sweeping blobs", zap.Error(err))
+			}
+			if removed > 0 {
+				zapper.Info("removed unused blobs", zap.Int("count", removed))
+			}
+		}
+	}
+}
+
+// recordAttachments records stored uploads as the attachments of a post or comment
+func recordAttachments(ctx context.Context, uploads []upload, postID, targetID, uploaderID xid.ID) ([]database.Attachment, error) {
+	attachments := []database.Attachment{}
+	for _, u := range uploads {
+		attachment := database.Attachment{
+			TargetID:     targetID,
+			PostID:       postID,
+			UploaderID:   uploaderID,
+			Key:          u.Key,
+			ThumbnailKey: u.ThumbnailKey,
+			ContentType:  u.ContentType,
+			Width:        u.Width,
+			Height:       u.Height,
+			Size:         int64(len(u.Image)),
+		}
+		id, err := db.AddAttachment(ctx, attachment)
+		if err != nil {
+			return nil, err
+		}
+		attachment.ID = id
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+// AttachmentHandler serves images at /attachments/{id} and their thumbnails at /attachments/{id}/thumbnail,
+// attachments of hidden posts and comments are only served to moderators
+func AttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Add("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	pathSplit := pathIntoArray(strings.TrimPrefix(r.URL.EscapedPath(), ATTACHMENTS_PREFIX))
+	if len(pathSplit) != 1 && (len(pathSplit) != 2 || pathSplit[1] != "thumbnail") {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "that attachment does not exist")
+		return
+	}
+	id, err := xid.FromString(pathSplit[0])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "malformed attachment id")
+		return
+	}
+	attachment, err := db.GetAttachment(r.Context(), id)
+	if err == database.ErrNoAttachmentFound {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "that attachment does not exist")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while fetching the attachment")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	hidden, err := isAttachmentHidden(r.Context(), attachment)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while fetching the attachment")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	if hidden && !profileFromCtx(r.Context()).Moderator {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "that attachment does not exist")
+		return
+	}
+	key, contentType := attachment.Key, attachment.ContentType
+	if len(pathSplit) == 2 {
+		key, contentType = attachment.ThumbnailKey, thumbnailContentType(attachment.ContentType)
+	}
+	blob, err := blobStore.Open(key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while fetching the attachment")
+		zapper.Error("error", zap.Error(err), zap.String("key", key))
+		return
+	}
+	defer blob.Close()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// blobs never change, but moderators can still hide the post they belong to
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("ETag", `"`+key+`"`)
+	http.ServeContent(w, r, "", attachment.DateCreated, blob)
+}
+
+// isAttachmentHidden reports whether the post or comment an attachment belongs to is hidden,
+// the comment it belongs to being deleted counts as hidden
+func isAttachmentHidden(ctx context.Context, attachment database.Attachment) (bool, error) {
+	post, err := db.GetPost(ctx, attachment.PostID)
+	if err != nil {
+		return false, err
+	}
+	if post.Hidden || attachment.TargetID == attachment.PostID {
+		return post.Hidden, nil
+	}
+	comment, err := db.GetComment(ctx, attachment.TargetID)
+	if err != nil {
+		return false, err
+	}
+	return comment.Hidden || comment.Deleted, nil
+}
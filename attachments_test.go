@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/courtier/carrotbb/blobs"
+	"github.com/courtier/carrotbb/database"
+	"github.com/rs/xid"
+)
+
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	return img
+}
+
+// testJPEG encodes an image and slips an exif segment in right after the start of image marker
+func testJPEG(t *testing.T, w, h int, exif string) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(w, h), nil); err != nil {
+		t.Fatal(err)
+	}
+	payload := append([]byte("Exif\x00\x00"), exif...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	data := append([]byte{}, buf.Bytes()[:2]...)
+	data = append(data, segment...)
+	data = append(data, payload...)
+	return append(data, buf.Bytes()[2:]...)
+}
+
+func TestProcessImage(t *testing.T) {
+	data := testJPEG(t, 640, 480, "GPS 52.5200 13.4050")
+	if !bytes.Contains(data, []byte("GPS 52.5200")) {
+		t.Fatal("test jpeg is missing its exif")
+	}
+	u, err := processImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ContentType != "image/jpeg" || u.Width != 640 || u.Height != 480 {
+		t.Error("processImage returned wrong type or size:", u.ContentType, u.Width, u.Height)
+	}
+	if bytes.Contains(u.Image, []byte("GPS 52.5200")) || bytes.Contains(u.Image, []byte("Exif")) {
+		t.Error("processImage should strip exif")
+	}
+	thumb, format, err := image.Decode(bytes.NewReader(u.Thumbnail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" || thumb.Bounds().Dx() != THUMBNAIL_SIZE || thumb.Bounds().Dy() != THUMBNAIL_SIZE*480/640 {
+		t.Error("thumbnail expected a", THUMBNAIL_SIZE, "wide jpeg, got:", format, thumb.Bounds())
+	}
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, testImage(100, 50)); err != nil {
+		t.Fatal(err)
+	}
+	u, err = processImage(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumb, format, err = image.Decode(bytes.NewReader(u.Thumbnail)); err != nil || format != "png" || thumb.Bounds().Dx() != 100 {
+		t.Error("small images should keep their size in the thumbnail, got:", format, err)
+	}
+
+	for name, data := range map[string][]byte{
+		"text":      []byte("carrot"),
+		"svg":       []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`),
+		"truncated": buf.Bytes()[:len(buf.Bytes())/2],
+	} {
+		if _, err = processImage(data); err != ErrUnsupportedImage {
+			t.Error(name, "expected:", ErrUnsupportedImage, "got:", err)
+		}
+	}
+
+	// claims to be 10000x10000 in its header, which is all that should be read of it
+	huge := append([]byte{}, buf.Bytes()...)
+	binary.BigEndian.PutUint32(huge[16:], 10000)
+	binary.BigEndian.PutUint32(huge[20:], 10000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	if _, err = processImage(huge); err != ErrImageTooLarge {
+		t.Error("huge image expected:", ErrImageTooLarge, "got:", err)
+	}
+}
+
+func TestThumbnailAveragesPixels(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x += 2 {
+		img.SetRGBA(x, 0, color.RGBA{255, 255, 255, 255})
+		img.SetRGBA(x, 1, color.RGBA{255, 255, 255, 255})
+	}
+	thumb := thumbnail(img, 2)
+	if thumb.Bounds().Dx() != 2 || thumb.Bounds().Dy() != 1 {
+		t.Fatal("thumbnail expected 2x1, got:", thumb.Bounds())
+	}
+	if r, _, _, a := thumb.At(0, 0).RGBA(); r>>8 != 127 || a>>8 != 127 {
+		t.Error("half white and half transparent pixels should average to half, got:", r>>8, a>>8)
+	}
+}
+
+func uploadRequest(t *testing.T, path, session, csrf string, fields map[string]string, images ...[]byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields["csrf_token"] = csrf
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	for _, data := range images {
+		part, err := form.CreateFormFile("attachments", "image.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	form.Close()
+	r := httptest.NewRequest("POST", path, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.AddCookie(&http.Cookie{Name: "session_token", Value: session})
+	r.AddCookie(&http.Cookie{Name: CSRF_COOKIE_NAME, Value: csrf})
+	return r
+}
+
+func TestAttachmentUpload(t *testing.T) {
+	ctx := context.Background()
+	_, session := newAPITestServer(t)
+	store, err := blobs.ConnectLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobStore = store
+	csrf := sessionCSRFToken(session)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/createpost", CreatePostHandler)
+	mux.HandleFunc("/createcomment", CreateCommentHandler)
+	mux.HandleFunc(ATTACHMENTS_PREFIX, AttachmentHandler)
+	handler := NewAuthMiddleware(NewCSRFMiddleware(mux))
+	post := map[string]string{"title": "carrots", "content": "look at them", "board": DEFAULT_BOARD_SLUG}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, uploadRequest(t, "/createpost", session, csrf, post, []byte("not an image")))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), ErrUnsupportedImage.Error()) {
+		t.Error("upload of a non image expected:", http.StatusBadRequest, "got:", rec.Code)
+	}
+	if posts, err := db.AllPosts(ctx); err != nil || len(posts) != 0 {
+		t.Error("a rejected upload should not create the post, got:", posts, err)
+	}
+
+	images := make([][]byte, MAX_ATTACHMENTS+1)
+	for n := range images {
+		images[n] = testJPEG(t, 400, 300, "carrot")
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, uploadRequest(t, "/createpost", session, csrf, post, images...))
+	if rec.Code != http.StatusBadRequest {
+		t.Error("upload of too many images expected:", http.StatusBadRequest, "got:", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, uploadRequest(t, "/createpost", session, csrf, post, images[0]))
+	if rec.Code != http.StatusFound {
+		t.Fatal("upload expected:", http.StatusFound, "got:", rec.Code, rec.Body.String())
+	}
+	posts, err := db.AllPosts(ctx)
+	if err != nil || len(posts) != 1 {
+		t.Fatal("upload should create a post, got:", posts, err)
+	}
+	postID := posts[0].ID
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, uploadRequest(t, "/createcomment", session, csrf, map[string]string{"postID": postID.String(), "comment": "and another"}, images[1]))
+	if rec.Code != http.StatusFound {
+		t.Fatal("comment upload expected:", http.StatusFound, "got:", rec.Code, rec.Body.String())
+	}
+	attachments, err := db.PostAttachments(ctx, postID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 2 || len(attachments[postID]) != 1 {
+		t.Fatal("the post and its comment should have an attachment each, got:", attachments)
+	}
+	attachment := attachments[postID][0]
+	if attachment.Width != 400 || attachment.Height != 300 || attachment.ContentType != "image/jpeg" {
+		t.Error("attachment recorded with the wrong size or type:", attachment)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/attachments/"+attachment.ID.String(), nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatal("attachment expected a jpeg, got:", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if bytes.Contains(rec.Body.Bytes(), []byte("Exif")) {
+		t.Error("served attachment should not carry exif")
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/attachments/"+attachment.ID.String()+"/thumbnail", nil))
+	thumb, _, err := image.DecodeConfig(rec.Body)
+	if rec.Code != http.StatusOK || err != nil || thumb.Width != THUMBNAIL_SIZE {
+		t.Error("thumbnail expected", THUMBNAIL_SIZE, "wide, got:", rec.Code, thumb.Width, err)
+	}
+
+	if err = db.SetPostHidden(ctx, postID, true); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/attachments/"+attachment.ID.String(), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Error("attachment of a hidden post expected:", http.StatusNotFound, "got:", rec.Code)
+	}
+}
+
+func TestUploadFormLimits(t *testing.T) {
+	_, session := newAPITestServer(t)
+	store, err := blobs.ConnectLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobStore = store
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	csrf := sessionCSRFToken(session)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/createpost", CreatePostHandler)
+	mux.HandleFunc("/editpost", EditPostHandler)
+	handler := NewAuthMiddleware(NewCSRFMiddleware(mux))
+	post := map[string]string{"title": "carrots", "content": "look at them", "board": DEFAULT_BOARD_SLUG}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, uploadRequest(t, "/createpost", "", csrf, post, []byte("junk")))
+	if rec.Code != http.StatusForbidden {
+		t.Error("upload without a session expected:", http.StatusForbidden, "got:", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, uploadRequest(t, "/editpost", session, csrf, post, []byte("junk")))
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Error("upload to a path without uploads expected:", http.StatusUnsupportedMediaType, "got:", rec.Code)
+	}
+
+	// more than fits in memory, so the parser spills to temporary files
+	large := bytes.Repeat([]byte("junk"), UPLOAD_MEMORY/8+1)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, uploadRequest(t, "/createpost", session, csrf, post, large, large))
+	if rec.Code != http.StatusBadRequest {
+		t.Error("upload of junk expected:", http.StatusBadRequest, "got:", rec.Code)
+	}
+	if left, err := os.ReadDir(tmp); err != nil || len(left) != 0 {
+		t.Error("temporary upload files should be removed, got:", left, err)
+	}
+}
+
+func TestRemoveUnusedBlobs(t *testing.T) {
+	ctx := context.Background()
+	newAPITestServer(t)
+	store, err := blobs.ConnectLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]string{}
+	for _, name := range []string{"image", "thumbnail", "orphan"} {
+		if keys[name], err = store.Put([]byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	postID := xid.New()
+	_, err = db.AddAttachment(ctx, database.Attachment{TargetID: postID, PostID: postID, Key: keys["image"], ThumbnailKey: keys["thumbnail"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed, err := removeUnusedBlobs(ctx, store, time.Hour); err != nil || removed != 0 {
+		t.Error("blobs within the grace period should be kept, got:", removed, err)
+	}
+	removed, err := removeUnusedBlobs(ctx, store, -time.Minute)
+	if err != nil || removed != 1 {
+		t.Error("only the orphan should be removed, got:", removed, err)
+	}
+	if _, err = store.Open(keys["orphan"]); err != blobs.ErrNoBlobFound {
+		t.Error("the orphan expected:", blobs.ErrNoBlobFound, "got:", err)
+	}
+	for _, name := range []string{"image", "thumbnail"} {
+		blob, err := store.Open(keys[name])
+		if err != nil {
+			t.Error("blobs attachments refer to should be kept, got:", err)
+			continue
+		}
+		blob.Close()
+	}
+}
@@ -2,19 +2,14 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/hex"
 	"errors"
-	"fmt"
-	"math/rand"
 	"net/http"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/courtier/carrotbb/database"
 	"github.com/rs/xid"
-	"golang.org/x/crypto/argon2"
+	"go.uber.org/zap"
 )
 
 const (
@@ -24,45 +19,14 @@ const (
 var (
 	ErrSessionNotCached    = errors.New("session token not in cache")
 	ErrExpiredSessionToken = errors.New("session token has expired")
-	ErrRandReadUnmatched   = errors.New("rand read less bytes than required")
+	ErrSessionUserDeleted  = errors.New("session belongs to a deleted user")
 )
 
-type MapCache struct {
-	cache map[string]session
-	lock  sync.RWMutex
-}
-
 var (
-	sessionCache = &MapCache{
-		cache: make(map[string]session),
-	}
+	// sessionCache is replaced in main once the database is connected
+	sessionCache SessionStore = NewMapCache()
 )
 
-func (m *MapCache) ReadOK(key string) (session, bool) {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
-	s, ok := m.cache[key]
-	return s, ok
-}
-
-func (m *MapCache) Read(key string) session {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
-	return m.cache[key]
-}
-
-func (m *MapCache) Write(key string, value session) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	m.cache[key] = value
-}
-
-func (m *MapCache) Delete(key string) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	delete(m.cache, key)
-}
-
 type session struct {
 	userID xid.ID
 	expiry time.Time
@@ -73,43 +37,16 @@ func (s session) isExpired() bool {
 	return s.expiry.Before(time.Now())
 }
 
-// newRandomToken should be used for generating session/csrf tokens
-func newRandomToken() (string, error) {
-	b := make([]byte, 8)
-	// We use math/rand here as I think sha256 hashing is
-	// sufficient entropy
-	n, err := rand.Read(b)
-	if err != nil {
-		return "", err
-	}
-	if n != len(b) {
-		return "", ErrRandReadUnmatched
-	}
-	return hex.EncodeToString(sha256.New().Sum(b)), nil
-}
-
-const (
-	argonTime    = 4
-	argonMemory  = 16 * 1024
-	argonThreads = 4
-	argonKeyLen  = 32
-)
-
-// saltAndHash returns the Argon2 hash of initial using salt as salt
-func saltAndHash(initial, salt string) string {
-	key := argon2.IDKey([]byte(initial), []byte(salt), argonTime, argonMemory, argonThreads, argonKeyLen)
-	basedKey := base64.RawStdEncoding.EncodeToString(key)
-	basedSalt := base64.RawStdEncoding.EncodeToString([]byte(salt))
-	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads, basedKey, basedSalt)
-	return encoded
-}
-
 // TODO: these two functions could be done in a better way
 
-// extractSessionToken extracts the session token from the session_token cookie
-// if possible, returns an error if the cookie does not exist.
+// extractSessionToken extracts the session token from the Authorization bearer header,
+// or from the session_token cookie if there is no such header.
+// returns http.ErrNoCookie if there is neither.
 // this function does not do any sanitizing/validating, the token string could be anything
 func extractSessionToken(r *http.Request) (string, error) {
+	if token := bearerToken(r); token != "" {
+		return token, nil
+	}
 	c, err := r.Cookie("session_token")
 	if err == http.ErrNoCookie {
 		return "", err
@@ -117,30 +54,48 @@ func extractSessionToken(r *http.Request) (string, error) {
 	return c.Value, err
 }
 
+// bearerToken returns the token of an Authorization: Bearer header, or an empty string.
+// requests carrying one are never sent by browsers on their own, so they need no csrf token
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
 // extractUser extracts a user from a request using the session_token cookie
 // if the token is in the cache. if it is not it returns ErrSessionNotCached
 // iif the token is cached, but it is expired it returns ErrExpiredSessionToken
+// and if the user has since deleted their account it returns ErrSessionUserDeleted
 func extractUser(r *http.Request) (token string, user database.User, err error) {
 	token, err = extractSessionToken(r)
 	if err == http.ErrNoCookie {
 		return
 	}
-	sesh, ok := sessionCache.ReadOK(token)
-	if !ok {
+	if !isTokenOfKind(token, SessionToken) {
 		err = ErrSessionNotCached
 		return
 	}
+	sesh, err := sessionCache.Read(r.Context(), token)
+	if err != nil {
+		return
+	}
 	if sesh.isExpired() {
 		err = ErrExpiredSessionToken
 		return
 	}
-	user, err = db.GetUser(sesh.userID)
+	user, err = db.GetUser(r.Context(), sesh.userID)
+	if err == nil && user.Deleted {
+		err = ErrSessionUserDeleted
+	}
 	return
 }
 
 type ContextString string
 
-// AuthMiddleware checks for expired tokens and deletes them if it catches any
+// AuthMiddleware checks for expired tokens and deletes them if it catches any,
+// api tokens sent as bearer tokens are accepted on the api as well
 type AuthMiddleware struct {
 	handler http.Handler
 }
@@ -150,41 +105,66 @@ func NewAuthMiddleware(handler http.Handler) *AuthMiddleware {
 }
 
 func (a *AuthMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if token := bearerToken(r); isTokenOfKind(token, APIToken) {
+		a.serveAPIToken(w, r, token)
+		return
+	}
 	reqCtx := r.Context()
 	token, user, err := extractUser(r)
 	if err != nil {
-		if err == ErrExpiredSessionToken {
-			unauthenticateUser(w, token)
+		// clear cookies of sessions that are gone, so stale cookies do not linger
+		if err == ErrExpiredSessionToken || err == ErrSessionUserDeleted || err == ErrSessionNotCached {
+			unauthenticateUser(r.Context(), w, token)
+		} else if err != http.ErrNoCookie {
+			zapper.Error("error", zap.Error(err))
 		}
 	} else {
 		reqCtx = context.WithValue(reqCtx, ContextString("user"), user)
+		// the csrf token is bound to sessions kept in cookies, bearer requests do not need one
+		if bearerToken(r) == "" {
+			reqCtx = context.WithValue(reqCtx, ContextString("session"), token)
+		}
 	}
 	a.handler.ServeHTTP(w, r.WithContext(reqCtx))
 }
 
-// authenticateUser puts the token and session in the cache and sets the cookie
-func authenticateUser(w http.ResponseWriter, token string, userID xid.ID) {
-	sessionCache.Write(token, session{
+// authenticateUser creates a new session token, puts it and the session in the cache and sets the cookie,
+// along with the csrf token of the new session
+func authenticateUser(ctx context.Context, w http.ResponseWriter, userID xid.ID) error {
+	token, err := newToken(SessionToken)
+	if err != nil {
+		return err
+	}
+	err = sessionCache.Write(ctx, token, session{
 		userID: userID,
 		expiry: time.Now().Add(DEFAULT_SESSION_EXPIRY),
 	})
+	if err != nil {
+		return err
+	}
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_token",
 		Value:    token,
 		Expires:  time.Now().Add(DEFAULT_SESSION_EXPIRY),
 		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 		Path:     "/",
 	})
+	setCSRFCookie(w, sessionCSRFToken(token))
+	return nil
 }
 
 // unauthenticateUser removes the token and session from the cache and removes the cookie
-func unauthenticateUser(w http.ResponseWriter, token string) {
-	sessionCache.Delete(token)
+func unauthenticateUser(ctx context.Context, w http.ResponseWriter, token string) {
+	if err := sessionCache.Delete(ctx, token); err != nil {
+		zapper.Error("error", zap.Error(err))
+	}
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_token",
 		Value:    "",
 		Expires:  time.Now(),
 		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 		Path:     "/",
 	})
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"testing"
 	"time"
@@ -16,17 +17,10 @@ func TestIsExpired(t *testing.T) {
 	}
 }
 
-func TestSaltAndHash(t *testing.T) {
-	hash := saltAndHash("hello", "world")
-	expected := "$argon2id$v=19$m=16384,t=4,p=4$Kmu5BL5wS9ervTy25ilRQCwjj1T2rkwf00ekySVkvQs$d29ybGQ"
-	if hash != expected {
-		t.Error("Expected:", expected, "got:", hash)
-	}
-}
-
 func TestExtractSession(t *testing.T) {
+	ctx := context.Background()
 	sessionToken := "token"
-	sessionCache.Write(sessionToken, session{expiry: time.Now().Add(10 * time.Second)})
+	sessionCache.Write(ctx, sessionToken, session{expiry: time.Now().Add(10 * time.Second)})
 	r, err := http.NewRequest("GET", "/signup", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,70 @@
+package blobs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrUnsupportedBlobStore = errors.New("unsupported blob store")
+	ErrNoBlobFound          = errors.New("no matching blob found")
+	ErrMalformedKey         = errors.New("malformed blob key")
+)
+
+// Store keeps files by the hash of their content,
+// storing the same content twice keeps it only once
+type Store interface {
+	// Put stores data and returns the key it is kept under
+	Put(data []byte) (string, error)
+	// Open opens the blob with that key, the caller has to close it.
+	// returns ErrNoBlobFound if there is none
+	Open(key string) (io.ReadSeekCloser, error)
+	// Delete removes a blob, deleting a missing blob is not an error
+	Delete(key string) error
+	// Keys returns the keys of the blobs last stored before before,
+	// storing data that is already kept counts as storing it again
+	Keys(ctx context.Context, before time.Time) ([]string, error)
+	// Ping checks that blobs can be stored, for readiness probes
+	Ping(ctx context.Context) error
+}
+
+// Key returns the key data is stored under, the hex encoded sha256 of it
+func Key(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// isValidKey reports whether key could have been returned by Key,
+// keys end up in paths so nothing else may be used as one
+func isValidKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	for _, r := range key {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Config is what Connect needs to reach a blob store, only the settings of Backend are used
+type Config struct {
+	// Backend is "local"
+	Backend    string
+	FolderPath string
+}
+
+// Connect connects to the configured blob store
+func Connect(config Config) (Store, error) {
+	switch config.Backend {
+	case "local":
+		return ConnectLocal(config.FolderPath)
+	default:
+		return nil, ErrUnsupportedBlobStore
+	}
+}
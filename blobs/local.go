@@ -0,0 +1,132 @@
+package blobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	ErrEmptyBlobFolderPath = errors.New("blob folder path is empty")
+)
+
+// LocalStore keeps blobs as files in a folder,
+// spread over sub folders named after the first two characters of their keys
+type LocalStore struct {
+	root string
+}
+
+// ConnectLocal keeps blobs under the folder root, creating it if it does not exist
+func ConnectLocal(root string) (*LocalStore, error) {
+	if root == "" {
+		return nil, ErrEmptyBlobFolderPath
+	}
+	root = filepath.FromSlash(root)
+	if err := os.MkdirAll(root, 0777); err != nil {
+		return nil, err
+	}
+	return &LocalStore{root}, nil
+}
+
+func (l *LocalStore) path(key string) string {
+	return filepath.Join(l.root, key[:2], key)
+}
+
+// Put writes to a temporary file first and renames it into place,
+// so a blob is either missing or complete
+func (l *LocalStore) Put(data []byte) (string, error) {
+	key := Key(data)
+	path := l.path(key)
+	if _, err := os.Stat(path); err == nil {
+		// the blob might be about to be swept as unused, storing it again has to keep it
+		now := time.Now()
+		return key, os.Chtimes(path, now, now)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (l *LocalStore) Open(key string) (io.ReadSeekCloser, error) {
+	if !isValidKey(key) {
+		return nil, ErrMalformedKey
+	}
+	f, err := os.Open(l.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNoBlobFound
+	}
+	return f, err
+}
+
+func (l *LocalStore) Delete(key string) error {
+	if !isValidKey(key) {
+		return ErrMalformedKey
+	}
+	err := os.Remove(l.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Keys goes through the sub folders of root, by when blobs were stored as the modification time of their files
+func (l *LocalStore) Keys(ctx context.Context, before time.Time) ([]string, error) {
+	keys := []string{}
+	err := filepath.WalkDir(l.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		// temporary files of uploads and pings are not blobs yet
+		if entry.IsDir() || !isValidKey(entry.Name()) {
+			return nil
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(before) {
+			keys = append(keys, entry.Name())
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Ping checks that a file can be created in root
+func (l *LocalStore) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(l.root, ".ping-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
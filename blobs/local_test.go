@@ -0,0 +1,117 @@
+package blobs
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestLocalStore(t *testing.T) *LocalStore {
+	l, err := ConnectLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLocalStore(t *testing.T) {
+	l := newTestLocalStore(t)
+	key, err := l.Put([]byte("carrot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != Key([]byte("carrot")) {
+		t.Error("Put should store data under its Key, got:", key)
+	}
+	again, err := l.Put([]byte("carrot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != key {
+		t.Error("storing the same data twice should give the same key, got:", again, key)
+	}
+	blob, err := l.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "carrot" {
+		t.Error("Open returned different data:", string(data))
+	}
+	if err = l.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Open(key); err != ErrNoBlobFound {
+		t.Error("Open of a deleted blob expected:", ErrNoBlobFound, "got:", err)
+	}
+	if err = l.Delete(key); err != nil {
+		t.Error("deleting a missing blob should not be an error, got:", err)
+	}
+}
+
+func TestLocalStoreMalformedKey(t *testing.T) {
+	l := newTestLocalStore(t)
+	for _, key := range []string{"", "../../etc/passwd", Key(nil)[:63] + "/", Key(nil)[:63] + "A"} {
+		if _, err := l.Open(key); err != ErrMalformedKey {
+			t.Errorf("Open(%q) expected: %v got: %v", key, ErrMalformedKey, err)
+		}
+		if err := l.Delete(key); err != ErrMalformedKey {
+			t.Errorf("Delete(%q) expected: %v got: %v", key, ErrMalformedKey, err)
+		}
+	}
+}
+
+func TestLocalStorePing(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "blobs")
+	l, err := ConnectLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(root); len(files) != 0 {
+		t.Error("Ping should not leave files behind, got:", files)
+	}
+	if err = os.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Ping(context.Background()); err == nil {
+		t.Error("Ping expected an error when blobs cannot be stored")
+	}
+}
+
+func TestLocalStoreKeys(t *testing.T) {
+	l := newTestLocalStore(t)
+	old, err := l.Put([]byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(l.path(old), past, past); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Put([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := l.Keys(context.Background(), time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != old {
+		t.Error("Keys should only return blobs stored before the cutoff, got:", keys)
+	}
+	if _, err = l.Put([]byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err = l.Keys(context.Background(), time.Now().Add(-time.Minute)); err != nil || len(keys) != 0 {
+		t.Error("storing a blob again should count as storing it now, got:", keys, err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/courtier/carrotbb/templates"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+const (
+	DEFAULT_BOARD_NAME        = "general"
+	DEFAULT_BOARD_SLUG        = "general"
+	DEFAULT_BOARD_DESCRIPTION = "anything goes"
+)
+
+// ensureDefaultBoard creates the default board when there are no boards yet,
+// posts can only be created in a board. Posts from before boards existed are moved into
+// the first board, so every post belongs to one
+func ensureDefaultBoard(ctx context.Context, db database.Database) error {
+	boards, err := db.AllBoards(ctx)
+	if err != nil {
+		return err
+	}
+	if len(boards) == 0 {
+		_, err = db.AddBoard(ctx, DEFAULT_BOARD_NAME, DEFAULT_BOARD_SLUG, DEFAULT_BOARD_DESCRIPTION, 0)
+		// another instance might have got there first
+		if err != nil && err != database.ErrBoardSlugTaken {
+			return err
+		}
+		if err == nil {
+			zapper.Info("created default board", zap.String("slug", DEFAULT_BOARD_SLUG))
+		}
+		if boards, err = db.AllBoards(ctx); err != nil {
+			return err
+		}
+	}
+	moved, err := db.MovePostsWithoutBoard(ctx, boards[0].ID)
+	if moved > 0 {
+		zapper.Info("moved posts without a board", zap.Int64("count", moved), zap.String("slug", boards[0].Slug))
+	}
+	return err
+}
+
+// BoardsHandler lets admins create boards and rename, describe and reorder them
+func BoardsHandler(w http.ResponseWriter, r *http.Request) {
+	if !profileFromCtx(r.Context()).OK {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		w.Header().Add("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !requirePermission(w, r, PermissionAdminister) {
+		return
+	}
+	if r.Method == "GET" {
+		boards, err := db.AllBoards(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			templates.GenerateErrorPage(w, "error while fetching the boards")
+			zapper.Error("error", zap.Error(err))
+			return
+		}
+		if err := templates.GenerateManageBoardsPage(w, profileFromCtx(r.Context()), boards); err != nil {
+			zapper.Error("error", zap.Error(err))
+		}
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "error parsing form")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	name, slug, description := r.Form.Get("name"), r.Form.Get("slug"), r.Form.Get("description")
+	for _, err := range []error{isBoardNameValid(name), isSlugValid(slug), isDescriptionValid(description)} {
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			templates.GenerateErrorPage(w, err.Error())
+			return
+		}
+	}
+	position, err := strconv.Atoi(r.Form.Get("position"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "position has to be a whole number")
+		return
+	}
+	var boardID xid.ID
+	switch action := r.Form.Get("action"); action {
+	case "create":
+		boardID, err = db.AddBoard(r.Context(), name, slug, description, position)
+	case "update":
+		boardID, err = xid.FromString(r.Form.Get("boardID"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			templates.GenerateErrorPage(w, "malformed board id")
+			return
+		}
+		err = db.UpdateBoard(r.Context(), boardID, name, slug, description, position)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "unknown board action")
+		return
+	}
+	if err == database.ErrBoardSlugTaken {
+		w.WriteHeader(http.StatusConflict)
+		templates.GenerateErrorPage(w, "another board already uses that slug")
+		return
+	}
+	if err == database.ErrNoBoardFoundByID {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "error while fetching the board")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error saving the board")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	logModeration(r, "board"+r.Form.Get("action"), boardID)
+	http.Redirect(w, r, "/boards", http.StatusFound)
+}
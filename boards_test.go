@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/courtier/carrotbb/database"
+)
+
+// boardsRequest posts form to BoardsHandler as user, leaving the middlewares out
+func boardsRequest(user database.User, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/boards", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r = r.WithContext(context.WithValue(r.Context(), ContextString("user"), user))
+	rec := httptest.NewRecorder()
+	BoardsHandler(rec, r)
+	return rec
+}
+
+func TestBoardsHandler(t *testing.T) {
+	ctx := context.Background()
+	newTestDB(t)
+	admin := database.User{Name: "admin", Role: database.RoleAdmin}
+	moderator := database.User{Name: "moderator", Role: database.RoleModerator}
+	create := url.Values{"action": {"create"}, "name": {"carrots"}, "slug": {"carrots"}, "description": {"orange"}, "position": {"1"}}
+
+	if rec := boardsRequest(moderator, create); rec.Code != http.StatusForbidden {
+		t.Error("board creation by a moderator expected:", http.StatusForbidden, "got:", rec.Code)
+	}
+	if rec := boardsRequest(admin, create); rec.Code != http.StatusFound {
+		t.Fatal("board creation expected:", http.StatusFound, "got:", rec.Code, rec.Body.String())
+	}
+	board, err := db.FindBoardBySlug(ctx, "carrots")
+	if err != nil || board.Description != "orange" || board.Position != 1 {
+		t.Fatal("the board should be created, got:", board, err)
+	}
+	if rec := boardsRequest(admin, create); rec.Code != http.StatusConflict {
+		t.Error("board creation with a taken slug expected:", http.StatusConflict, "got:", rec.Code)
+	}
+
+	update := url.Values{"action": {"update"}, "boardID": {board.ID.String()}, "name": {"roots"}, "slug": {"roots"}, "description": {""}, "position": {"0"}}
+	if rec := boardsRequest(admin, update); rec.Code != http.StatusFound {
+		t.Fatal("board update expected:", http.StatusFound, "got:", rec.Code, rec.Body.String())
+	}
+	if board, err = db.GetBoard(ctx, board.ID); err != nil || board.Name != "roots" || board.Slug != "roots" || board.Position != 0 {
+		t.Error("the board should be updated, got:", board, err)
+	}
+	update.Set("slug", "Roots")
+	if rec := boardsRequest(admin, update); rec.Code != http.StatusBadRequest {
+		t.Error("board update with a malformed slug expected:", http.StatusBadRequest, "got:", rec.Code)
+	}
+}
+
+func TestEnsureDefaultBoard(t *testing.T) {
+	ctx := context.Background()
+	newTestDB(t)
+	if err := ensureDefaultBoard(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	boards, err := db.AllBoards(ctx)
+	if err != nil || len(boards) != 1 || boards[0].Slug != DEFAULT_BOARD_SLUG {
+		t.Error("ensureDefaultBoard should create the default board once, got:", boards, err)
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/BurntSushi/toml"
+	"github.com/courtier/carrotbb/blobs"
+	"github.com/courtier/carrotbb/database"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// CONFIG_FILE_ENV names the config file when there is no -config flag
+	CONFIG_FILE_ENV = "CONFIG_FILE"
+	REDACTED        = "[redacted]"
+)
+
+var (
+	ErrUnknownConfigFormat = errors.New("config files have to end in .toml, .yaml or .yml")
+	ErrUnknownSetting      = errors.New("unknown setting")
+	ErrMalformedSetting    = errors.New("has to be a string or a number")
+	ErrNotANumber          = errors.New("has to be a whole number")
+	ErrEmptySetting        = errors.New("has to be set")
+	ErrInvalidPort         = errors.New("has to be a port between 1 and 65535, or 0 to turn it off")
+	ErrNoListener          = errors.New("http_port and https_port cannot both be turned off")
+	ErrHTTPSWithoutDomain  = errors.New("has to be set to serve https")
+	ErrPortTaken           = errors.New("is already used by another listener")
+	ErrNegativeSetting     = errors.New("cannot be negative")
+	ErrTimeoutTooShort     = errors.New("has to be at least 1 second")
+)
+
+// Config is every setting of the board. A setting can be given, from lowest to highest precedence,
+// in a toml or yaml config file, in the environment or .env, and as a flag.
+// The key of a setting is used in config files, its environment variable is the key in upper case
+// and its flag the key with dashes, so page_size is also PAGE_SIZE and -page-size
+type Config struct {
+	DBBackend        string
+	JSONFolderPath   string
+	JSONFileName     string
+	SQLiteFilePath   string
+	PostgresHost     string
+	PostgresPort     int
+	PostgresUser     string
+	PostgresPassword string
+	PostgresDB       string
+	BlobStore        string
+	BlobFolderPath   string
+	SessionStore     string
+	TokenBytes       int
+	PageSize         int
+	Domain           string
+	HTTPPort         int
+	HTTPSPort        int
+	TLSCertFile      string
+	TLSKeyFile       string
+	MetricsPort      int
+	ShutdownDelay    int
+	RequestTimeout   int
+
+	// sources tells where each setting was last set, by key
+	sources map[string]string
+	// warnings are about deprecated ways of setting something, printed once there is somewhere to print them
+	warnings []string
+}
+
+// setting is a single value of a Config
+type setting struct {
+	Key   string
+	Usage string
+	// secrets are redacted when the config is printed
+	Secret bool
+	// an empty environment variable turns the setting off instead of counting as unset,
+	// like it did before there were config files
+	EmptyIsOff bool
+	Value      flag.Value
+}
+
+func (s setting) env() string {
+	return strings.ToUpper(s.Key)
+}
+
+func (s setting) flag() string {
+	return strings.ReplaceAll(s.Key, "_", "-")
+}
+
+type stringSetting struct{ p *string }
+
+func (s stringSetting) Set(value string) error {
+	*s.p = value
+	return nil
+}
+
+func (s stringSetting) String() string {
+	if s.p == nil {
+		return ""
+	}
+	return *s.p
+}
+
+type intSetting struct{ p *int }
+
+func (s intSetting) Set(value string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return ErrNotANumber
+	}
+	*s.p = n
+	return nil
+}
+
+func (s intSetting) String() string {
+	if s.p == nil {
+		return "0"
+	}
+	return strconv.Itoa(*s.p)
+}
+
+func defaultConfig() Config {
+	return Config{
+		DBBackend:      "sqlite",
+		JSONFolderPath: "carrotbb/storage",
+		JSONFileName:   "database.json",
+		SQLiteFilePath: "carrotbb/storage/database.sqlite",
+		PostgresHost:   "localhost",
+		PostgresPort:   5432,
+		PostgresDB:     "carrotbb",
+		BlobStore:      "local",
+		BlobFolderPath: "carrotbb/blobs",
+		SessionStore:   "database",
+		TokenBytes:     DEFAULT_TOKEN_BYTES,
+		PageSize:       DEFAULT_PAGE_SIZE,
+		HTTPPort:       8080,
+		RequestTimeout: DEFAULT_REQUEST_TIMEOUT,
+		sources:        make(map[string]string),
+	}
+}
+
+// settings lists the settings of c in the order they are printed
+func (c *Config) settings() []setting {
+	return []setting{
+		{Key: "db_backend", Usage: "database backend: json, sqlite or postgres", Value: stringSetting{&c.DBBackend}},
+		{Key: "json_folder_path", Usage: "folder of the json database", Value: stringSetting{&c.JSONFolderPath}},
+		{Key: "json_file_name", Usage: "file name of the json database", Value: stringSetting{&c.JSONFileName}},
+		{Key: "sqlite_file_path", Usage: "file of the sqlite database", Value: stringSetting{&c.SQLiteFilePath}},
+		{Key: "postgres_host", Usage: "host of the postgres server", Value: stringSetting{&c.PostgresHost}},
+		{Key: "postgres_port", Usage: "port of the postgres server", Value: intSetting{&c.PostgresPort}},
+		{Key: "postgres_user", Usage: "postgres user to connect as", Value: stringSetting{&c.PostgresUser}},
+		{Key: "postgres_password", Usage: "password of the postgres user", Secret: true, Value: stringSetting{&c.PostgresPassword}},
+		{Key: "postgres_db", Usage: "postgres database to use", Value: stringSetting{&c.PostgresDB}},
+		{Key: "blob_store", Usage: "where uploaded images are kept: local", Value: stringSetting{&c.BlobStore}},
+		{Key: "blob_folder_path", Usage: "folder of the local blob store", Value: stringSetting{&c.BlobFolderPath}},
+		{Key: "session_store", Usage: "where sessions are kept: database, or memory to sign everyone out on restart", Value: stringSetting{&c.SessionStore}},
+		{Key: "token_bytes", Usage: "random bytes in session and csrf tokens, at least 16", Value: intSetting{&c.TokenBytes}},
+		{Key: "page_size", Usage: "posts and comments per page, at most 500", Value: intSetting{&c.PageSize}},
+		{Key: "domain", Usage: "domain of the board, for https certificates and the links in feeds", Value: stringSetting{&c.Domain}},
+		{Key: "http_port", Usage: "port to serve http on, 0 to turn it off", EmptyIsOff: true, Value: intSetting{&c.HTTPPort}},
+		{Key: "https_port", Usage: "port to serve https on, 0 to turn it off", EmptyIsOff: true, Value: intSetting{&c.HTTPSPort}},
+		// not ssl_cert_file, SSL_CERT_FILE is where go and openssl look for root certificates
+		{Key: "tls_cert_file", Usage: "certificate to serve https with, instead of fetching one", Value: stringSetting{&c.TLSCertFile}},
+		{Key: "tls_key_file", Usage: "key of tls_cert_file", Value: stringSetting{&c.TLSKeyFile}},
+		{Key: "request_timeout", Usage: "seconds a request gets before its queries are canceled, uploads get longer", Value: intSetting{&c.RequestTimeout}},
+		{Key: "shutdown_delay", Usage: "seconds /readyz reports shutting down before the listeners close, for load balancers to notice", Value: intSetting{&c.ShutdownDelay}},
+		{Key: "metrics_port", Usage: "port to serve prometheus metrics on at /metrics, 0 to turn it off", Value: intSetting{&c.MetricsPort}},
+	}
+}
+
+// renamedEnv are the environment variables settings were read from before they were renamed, by key.
+// They are only read as a pair, SSL_CERT_FILE alone is where go and openssl look for root certificates
+var renamedEnv = map[string]string{
+	"tls_cert_file": "SSL_CERT_FILE",
+	"tls_key_file":  "SSL_KEY_FILE",
+}
+
+// set sets a setting and records where the value came from
+func (c *Config) set(s setting, value, source string) error {
+	if err := s.Value.Set(value); err != nil {
+		return fmt.Errorf("%s from %s: %w", s.Key, source, err)
+	}
+	c.sources[s.Key] = source
+	return nil
+}
+
+// loadConfig builds the config from its defaults, then the config file, the environment and the flags in args,
+// each overriding the ones before it. Empty environment variables count as unset so a copied .env does not
+// clear the config file, except for the ports where they mean 0, while flags and config files can set a setting to be empty.
+// Flags the caller defined on flags beforehand are parsed along with the ones of the settings
+func loadConfig(flags *flag.FlagSet, args []string) (Config, error) {
+	config := defaultConfig()
+	settings := config.settings()
+	path := flags.String("config", "", "toml or yaml config file, defaults to $"+CONFIG_FILE_ENV)
+	// flags are only applied once the file and the environment are, to take precedence over them
+	values := make(map[string]*string)
+	for _, s := range settings {
+		values[s.flag()] = flags.String(s.flag(), s.Value.String(), s.Usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return config, fmt.Errorf("loading .env: %w", err)
+	}
+	if *path == "" {
+		*path = os.Getenv(CONFIG_FILE_ENV)
+	}
+	if *path != "" {
+		if err := config.loadFile(*path); err != nil {
+			return config, err
+		}
+	}
+	for _, s := range settings {
+		value, ok := os.LookupEnv(s.env())
+		if ok && value == "" && s.EmptyIsOff {
+			value = "0"
+		}
+		if value != "" {
+			if err := config.set(s, value, "$"+s.env()); err != nil {
+				return config, err
+			}
+		}
+	}
+	if err := config.loadRenamedEnv(settings); err != nil {
+		return config, err
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if err == nil && f.Name == s.flag() {
+				err = config.set(s, *values[f.Name], "-"+f.Name)
+			}
+		}
+	})
+	return config, err
+}
+
+// loadRenamedEnv applies the old names of renamed settings with a warning,
+// when all of them are set and none of the settings were set some other way
+func (c *Config) loadRenamedEnv(settings []setting) error {
+	for key, old := range renamedEnv {
+		if _, ok := c.sources[key]; ok || os.Getenv(old) == "" {
+			return nil
+		}
+	}
+	for _, s := range settings {
+		old, ok := renamedEnv[s.Key]
+		if !ok {
+			continue
+		}
+		if err := c.set(s, os.Getenv(old), "$"+old); err != nil {
+			return err
+		}
+		c.warnings = append(c.warnings, fmt.Sprintf("%s is deprecated, rename it to %s", old, s.env()))
+	}
+	return nil
+}
+
+// loadFile applies the settings in a toml or yaml file, keys that are not settings are an error
+func (c *Config) loadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	file := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(content, &file)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &file)
+	default:
+		return ErrUnknownConfigFormat
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	settings := make(map[string]setting)
+	for _, s := range c.settings() {
+		settings[s.Key] = s
+	}
+	for key, value := range file {
+		s, ok := settings[key]
+		if !ok {
+			return fmt.Errorf("%s: %w %s", path, ErrUnknownSetting, key)
+		}
+		var raw string
+		switch v := value.(type) {
+		case string:
+			raw = v
+		case nil:
+			// yaml keys without a value
+		case int, int64:
+			raw = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("%s from %s: %w", key, path, ErrMalformedSetting)
+		}
+		if err := c.set(s, raw, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the settings that are used together, it returns every problem at once
+func (c Config) validate() error {
+	errs := []error{}
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	required := func(key, value string) {
+		if value == "" {
+			check(key, ErrEmptySetting)
+		}
+	}
+	switch c.DBBackend {
+	case "json":
+		required("json_folder_path", c.JSONFolderPath)
+		required("json_file_name", c.JSONFileName)
+	case "sqlite":
+		required("sqlite_file_path", c.SQLiteFilePath)
+	case "postgres":
+		required("postgres_host", c.PostgresHost)
+		check("postgres_port", checkPort(c.PostgresPort, false))
+		required("postgres_user", c.PostgresUser)
+		required("postgres_db", c.PostgresDB)
+	default:
+		check("db_backend", database.ErrUnsupportedDatabaseBackend)
+	}
+	switch c.BlobStore {
+	case "local":
+		required("blob_folder_path", c.BlobFolderPath)
+	default:
+		check("blob_store", blobs.ErrUnsupportedBlobStore)
+	}
+	if c.SessionStore != "database" && c.SessionStore != "memory" {
+		check("session_store", ErrUnsupportedSessionStore)
+	}
+	check("token_bytes", checkTokenBytes(c.TokenBytes))
+	check("page_size", checkPageSize(c.PageSize))
+	check("http_port", checkPort(c.HTTPPort, true))
+	check("https_port", checkPort(c.HTTPSPort, true))
+	if c.HTTPPort == 0 && c.HTTPSPort == 0 {
+		errs = append(errs, ErrNoListener)
+	}
+	if c.ShutdownDelay < 0 {
+		check("shutdown_delay", ErrNegativeSetting)
+	}
+	if c.RequestTimeout < 1 {
+		check("request_timeout", ErrTimeoutTooShort)
+	}
+	check("metrics_port", checkPort(c.MetricsPort, true))
+	if c.MetricsPort != 0 && (c.MetricsPort == c.HTTPPort || c.MetricsPort == c.HTTPSPort) {
+		check("metrics_port", ErrPortTaken)
+	}
+	if c.HTTPSPort != 0 && c.Domain == "" {
+		check("domain", ErrHTTPSWithoutDomain)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		required("tls_cert_file", c.TLSCertFile)
+		required("tls_key_file", c.TLSKeyFile)
+	}
+	return errors.Join(errs...)
+}
+
+// checkPort returns ErrInvalidPort if port is not one, 0 is allowed for ports that can be turned off
+func checkPort(port int, optional bool) error {
+	if (port == 0 && optional) || (port > 0 && port <= 65535) {
+		return nil
+	}
+	return ErrInvalidPort
+}
+
+func (c Config) database() database.Config {
+	return database.Config{
+		Backend:        c.DBBackend,
+		JSONFolderPath: c.JSONFolderPath,
+		JSONFileName:   c.JSONFileName,
+		SQLiteFilePath: c.SQLiteFilePath,
+		Postgres: database.PostgresConfig{
+			Host:     c.PostgresHost,
+			Port:     c.PostgresPort,
+			User:     c.PostgresUser,
+			Password: c.PostgresPassword,
+			Database: c.PostgresDB,
+		},
+	}
+}
+
+func (c Config) blobs() blobs.Config {
+	return blobs.Config{
+		Backend:    c.BlobStore,
+		FolderPath: c.BlobFolderPath,
+	}
+}
+
+// listenAddress turns a port into the address to listen on, 0 turns it off
+func listenAddress(port int) string {
+	if port == 0 {
+		return ""
+	}
+	return ":" + strconv.Itoa(port)
+}
+
+// print writes the config as a toml file with secrets redacted,
+// along with where each setting came from
+func (c Config) print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, s := range c.settings() {
+		value := s.Value.String()
+		if s.Secret && value != "" {
+			value = REDACTED
+		}
+		if _, ok := s.Value.(stringSetting); ok {
+			value = strconv.Quote(value)
+		}
+		source, ok := c.sources[s.Key]
+		if !ok {
+			source = "default"
+		}
+		fmt.Fprintf(tw, "%s = %s\t# %s\n", s.Key, value, source)
+	}
+	return tw.Flush()
+}
+
+// configCommand runs "config check", which prints the effective config with secrets redacted
+// and validates it, it returns the exit code
+func configCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(stderr, "usage: carrotbb config check [flags]")
+		return 2
+	}
+	flags := flag.NewFlagSet("carrotbb config check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	config, err := loadConfig(flags, args[1:])
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	for _, warning := range config.warnings {
+		fmt.Fprintln(stderr, "warning:", warning)
+	}
+	if err = config.print(stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if err = config.validate(); err != nil {
+		fmt.Fprintln(stderr, "invalid config:")
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintln(stdout, "# the config is valid")
+	return 0
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// inConfigTestDir runs the test in an empty folder, so no .env is picked up
+func inConfigTestDir(t *testing.T) string {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	inConfigTestDir(t)
+	path := writeConfigFile(t, "carrotbb.toml", `
+db_backend = "postgres"
+postgres_user = "carrot"
+page_size = 20
+domain = "file.example"
+http_port = 9000
+`)
+	t.Setenv(CONFIG_FILE_ENV, path)
+	t.Setenv("PAGE_SIZE", "30")
+	t.Setenv("DOMAIN", "")
+	t.Setenv("HTTP_PORT", "9001")
+	config, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-http-port", "9002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DBBackend != "postgres" || config.PostgresUser != "carrot" {
+		t.Error("settings only in the file should come from it, got:", config.DBBackend, config.PostgresUser)
+	}
+	if config.PageSize != 30 || config.sources["page_size"] != "$PAGE_SIZE" {
+		t.Error("the environment should override the file, got:", config.PageSize, config.sources["page_size"])
+	}
+	if config.Domain != "file.example" {
+		t.Error("empty environment variables should not override the file, got:", config.Domain)
+	}
+	if config.HTTPPort != 9002 || config.sources["http_port"] != "-http-port" {
+		t.Error("flags should override the environment, got:", config.HTTPPort, config.sources["http_port"])
+	}
+	if config.SessionStore != "database" || config.sources["session_store"] != "" {
+		t.Error("unset settings should keep their default, got:", config.SessionStore)
+	}
+}
+
+func TestLoadConfigEmptyPort(t *testing.T) {
+	inConfigTestDir(t)
+	t.Setenv("HTTP_PORT", "")
+	t.Setenv("HTTPS_PORT", "8443")
+	config, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.HTTPPort != 0 || config.sources["http_port"] != "$HTTP_PORT" {
+		t.Error("an empty HTTP_PORT should turn http off, got:", config.HTTPPort, config.sources["http_port"])
+	}
+}
+
+func TestLoadConfigRenamedEnv(t *testing.T) {
+	inConfigTestDir(t)
+	t.Setenv("SSL_CERT_FILE", "certs/cert.pem")
+	config, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.TLSCertFile != "" || len(config.warnings) != 0 {
+		t.Error("SSL_CERT_FILE alone is for root certificates and should be ignored, got:", config.TLSCertFile, config.warnings)
+	}
+
+	t.Setenv("SSL_KEY_FILE", "certs/key.pem")
+	config, err = loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.TLSCertFile != "certs/cert.pem" || config.TLSKeyFile != "certs/key.pem" || config.sources["tls_key_file"] != "$SSL_KEY_FILE" {
+		t.Error("the old names should still be read, got:", config.TLSCertFile, config.TLSKeyFile, config.sources["tls_key_file"])
+	}
+	if len(config.warnings) != 2 {
+		t.Error("expected a warning for each old name, got:", config.warnings)
+	}
+
+	t.Setenv("TLS_CERT_FILE", "tls/cert.pem")
+	t.Setenv("TLS_KEY_FILE", "tls/key.pem")
+	config, err = loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.TLSCertFile != "tls/cert.pem" || config.TLSKeyFile != "tls/key.pem" || len(config.warnings) != 0 {
+		t.Error("the new names should win, got:", config.TLSCertFile, config.TLSKeyFile, config.warnings)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	inConfigTestDir(t)
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	path := writeConfigFile(t, "carrotbb.yaml", "db_backend: json\njson_file_name: board.json\npostgres_port: 6432\ndomain:\n")
+	config, err := loadConfig(flags, []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DBBackend != "json" || config.JSONFileName != "board.json" || config.PostgresPort != 6432 {
+		t.Error("yaml config was not applied:", config)
+	}
+
+	payloads := map[string]error{
+		"carrotbb.ini":  ErrUnknownConfigFormat,
+		"unknown.toml":  ErrUnknownSetting,
+		"number.toml":   ErrNotANumber,
+		"table.toml":    ErrMalformedSetting,
+		"boolean.yaml":  ErrMalformedSetting,
+		"fraction.toml": ErrMalformedSetting,
+	}
+	contents := map[string]string{
+		"carrotbb.ini":  "db_backend=json",
+		"unknown.toml":  `db_backnd = "json"`,
+		"number.toml":   `page_size = "many"`,
+		"table.toml":    "[db_backend]\nname = \"json\"",
+		"boolean.yaml":  "http_port: true",
+		"fraction.toml": "page_size = 2.5",
+	}
+	for name, expected := range payloads {
+		path := writeConfigFile(t, name, contents[name])
+		_, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path})
+		if !errors.Is(err, expected) {
+			t.Error(name, "expected:", expected, "got:", err)
+		}
+	}
+
+	t.Setenv("TOKEN_BYTES", "lots")
+	_, err = loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	if !errors.Is(err, ErrNotANumber) || !strings.Contains(err.Error(), "$TOKEN_BYTES") {
+		t.Error("malformed environment variable expected:", ErrNotANumber, "naming it, got:", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	config := defaultConfig()
+	if err := config.validate(); err != nil {
+		t.Error("the default config should be valid, got:", err)
+	}
+	config.DBBackend = "postgres"
+	config.PostgresUser = ""
+	config.PageSize = 0
+	config.HTTPPort = 0
+	config.HTTPSPort = 70000
+	config.BlobStore = "s3"
+	config.RequestTimeout = 0
+	err := config.validate()
+	for _, expected := range []error{ErrEmptySetting, ErrInvalidPageSize, ErrInvalidPort, ErrHTTPSWithoutDomain, ErrTimeoutTooShort} {
+		if !errors.Is(err, expected) {
+			t.Error("validate expected:", expected, "got:", err)
+		}
+	}
+	for _, key := range []string{"postgres_user", "page_size", "https_port", "domain", "blob_store", "request_timeout"} {
+		if err == nil || !strings.Contains(err.Error(), key+": ") {
+			t.Error("validate should name", key, "got:", err)
+		}
+	}
+	config = defaultConfig()
+	config.HTTPPort = 0
+	if err = config.validate(); !errors.Is(err, ErrNoListener) {
+		t.Error("validate expected:", ErrNoListener, "got:", err)
+	}
+	config = defaultConfig()
+	config.MetricsPort = config.HTTPPort
+	config.ShutdownDelay = -1
+	err = config.validate()
+	for _, expected := range []error{ErrPortTaken, ErrNegativeSetting} {
+		if !errors.Is(err, expected) {
+			t.Error("validate expected:", expected, "got:", err)
+		}
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	inConfigTestDir(t)
+	t.Setenv("POSTGRES_PASSWORD", "hunter2")
+	var stdout, stderr bytes.Buffer
+	if code := configCommand([]string{"check", "-page-size", "25"}, &stdout, &stderr); code != 0 {
+		t.Fatal("config check of a valid config expected 0, got:", code, stderr.String())
+	}
+	out := stdout.String()
+	if strings.Contains(out, "hunter2") || !strings.Contains(out, `postgres_password = "`+REDACTED+`"`) {
+		t.Error("config check should redact secrets, got:", out)
+	}
+	if !strings.Contains(out, "page_size = 25") || !strings.Contains(out, "# -page-size") {
+		t.Error("config check should print settings with where they came from, got:", out)
+	}
+
+	stdout.Reset()
+	if code := configCommand([]string{"check", "-db-backend", "mongo"}, &stdout, &stderr); code != 1 {
+		t.Error("config check of an invalid config expected 1, got:", code)
+	}
+	if !strings.Contains(stderr.String(), "db_backend: ") {
+		t.Error("config check should explain what is invalid, got:", stderr.String())
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/courtier/carrotbb/templates"
+	"go.uber.org/zap"
+)
+
+const (
+	CSRF_COOKIE_NAME = "csrf_token"
+	CSRF_HEADER_NAME = "X-CSRF-Token"
+)
+
+// CSRFMiddleware implements the double submit cookie pattern, every visitor gets a
+// csrf_token cookie and every unsafe request has to echo it back either as
+// the csrf_token form field or in the X-CSRF-Token header.
+// the token of a signed in visitor is derived from their session, so a cookie planted from
+// another site or subdomain is not accepted and the token changes with every new session.
+// requests authenticated with a bearer token are exempt
+type CSRFMiddleware struct {
+	handler http.Handler
+}
+
+func NewCSRFMiddleware(handler http.Handler) *CSRFMiddleware {
+	return &CSRFMiddleware{handler}
+}
+
+func (c *CSRFMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := extractCSRFToken(r)
+	if sessionToken, ok := r.Context().Value(ContextString("session")).(string); ok {
+		if bound := sessionCSRFToken(sessionToken); !tokensEqual(token, bound) {
+			token = bound
+			setCSRFCookie(w, token)
+		}
+	} else if token == "" {
+		var err error
+		token, err = newToken(CSRFToken)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			templates.GenerateErrorPage(w, "error creating csrf token")
+			zapper.Error("error", zap.Error(err))
+			return
+		}
+		setCSRFCookie(w, token)
+	}
+	// forms with uploads send the token in a multipart body, which is capped before anything parses it
+	if !isSafeMethod(r.Method) && isMultipartRequest(r) {
+		if !acceptsUploads(w, r) || !parseUploadForm(w, r) {
+			return
+		}
+		// the server only cleans up after the request it created, handlers get copies of it
+		defer r.MultipartForm.RemoveAll()
+	}
+	if !isSafeMethod(r.Method) && bearerToken(r) == "" && !tokensEqual(token, submittedCSRFToken(r)) {
+		if isAPIRequest(r) {
+			writeAPIError(w, http.StatusForbidden, "invalid csrf token, send the csrf_token cookie back in the "+CSRF_HEADER_NAME+" header")
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		templates.GenerateErrorPage(w, "invalid csrf token, reload the page and try again")
+		return
+	}
+	reqCtx := context.WithValue(r.Context(), ContextString("csrf"), token)
+	c.handler.ServeHTTP(w, r.WithContext(reqCtx))
+}
+
+// sessionCSRFToken derives the csrf token of a session from its token, keyed by the session token
+// so only whoever holds the session can work it out, and nothing about the session can be told from it
+func sessionCSRFToken(sessionToken string) string {
+	mac := hmac.New(sha256.New, []byte(sessionToken))
+	mac.Write([]byte(CSRFToken))
+	return string(CSRFToken) + "_" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func setCSRFCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRF_COOKIE_NAME,
+		Value:    token,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+	})
+}
+
+// extractCSRFToken returns the token in the csrf_token cookie,
+// or an empty string if there is no cookie or it does not hold a csrf token
+func extractCSRFToken(r *http.Request) string {
+	c, err := r.Cookie(CSRF_COOKIE_NAME)
+	if err != nil || !isTokenOfKind(c.Value, CSRFToken) {
+		return ""
+	}
+	return c.Value
+}
+
+// submittedCSRFToken returns the token the request echoed back, the header takes precedence
+func submittedCSRFToken(r *http.Request) string {
+	if token := r.Header.Get(CSRF_HEADER_NAME); token != "" {
+		return token
+	}
+	return r.FormValue(templates.CSRFFieldName)
+}
+
+// isSafeMethod reports whether a method is not supposed to change any state
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rs/xid"
+)
+
+// csrfEcho records the token the middleware put in the context
+type csrfEcho struct {
+	token  string
+	called bool
+}
+
+func (c *csrfEcho) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.called = true
+	c.token = profileFromCtx(r.Context()).CSRF
+}
+
+func TestCSRFMiddlewareIssuesToken(t *testing.T) {
+	echo := &csrfEcho{}
+	rec := httptest.NewRecorder()
+	NewCSRFMiddleware(echo).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !echo.called {
+		t.Fatal("GET without a csrf cookie should reach the handler")
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CSRF_COOKIE_NAME {
+		t.Fatal("CSRF cookie expected, got:", cookies)
+	}
+	if !isTokenOfKind(cookies[0].Value, CSRFToken) {
+		t.Error("CSRF token expected, got:", cookies[0].Value)
+	}
+	if echo.token != cookies[0].Value {
+		t.Error("Context token expected:", cookies[0].Value, "got:", echo.token)
+	}
+}
+
+func TestCSRFMiddlewareKeepsToken(t *testing.T) {
+	token, err := newToken(CSRFToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := &csrfEcho{}
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: CSRF_COOKIE_NAME, Value: token})
+	NewCSRFMiddleware(echo).ServeHTTP(rec, r)
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("No new cookie expected, got:", rec.Result().Cookies())
+	}
+	if echo.token != token {
+		t.Error("Context token expected:", token, "got:", echo.token)
+	}
+}
+
+func TestCSRFMiddlewareChecksUnsafeMethods(t *testing.T) {
+	token, err := newToken(CSRFToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newToken(CSRFToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]struct {
+		cookie string
+		form   string
+		header string
+		ok     bool
+	}{
+		"form field":       {cookie: token, form: token, ok: true},
+		"header":           {cookie: token, header: token, ok: true},
+		"missing":          {cookie: token},
+		"mismatch":         {cookie: token, form: other},
+		"no cookie":        {form: token},
+		"session token":    {cookie: "sess_" + strings.TrimPrefix(token, "csrf_"), form: "sess_" + strings.TrimPrefix(token, "csrf_")},
+		"header over form": {cookie: token, form: token, header: other},
+	}
+	for name, c := range cases {
+		form := url.Values{}
+		if c.form != "" {
+			form.Set("csrf_token", c.form)
+		}
+		r := httptest.NewRequest("POST", "/createpost", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if c.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: CSRF_COOKIE_NAME, Value: c.cookie})
+		}
+		if c.header != "" {
+			r.Header.Set(CSRF_HEADER_NAME, c.header)
+		}
+		echo := &csrfEcho{}
+		rec := httptest.NewRecorder()
+		NewCSRFMiddleware(echo).ServeHTTP(rec, r)
+		if echo.called != c.ok {
+			t.Error("Case:", name, "expected handler called:", c.ok, "got:", echo.called)
+		}
+		if !c.ok && rec.Code != http.StatusForbidden {
+			t.Error("Case:", name, "expected:", http.StatusForbidden, "got:", rec.Code)
+		}
+	}
+}
+
+func TestCSRFMiddlewareBindsSession(t *testing.T) {
+	sessionToken, err := newToken(SessionToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planted, err := newToken(CSRFToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound := sessionCSRFToken(sessionToken)
+	if !isTokenOfKind(bound, CSRFToken) {
+		t.Fatal("CSRF token expected, got:", bound)
+	}
+	if other, _ := newToken(SessionToken); sessionCSRFToken(other) == bound {
+		t.Error("sessions expected tokens of their own")
+	}
+	signedIn := func(r *http.Request) *http.Request {
+		return r.WithContext(context.WithValue(r.Context(), ContextString("session"), sessionToken))
+	}
+
+	echo := &csrfEcho{}
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: CSRF_COOKIE_NAME, Value: planted})
+	NewCSRFMiddleware(echo).ServeHTTP(rec, signedIn(r))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != bound || echo.token != bound {
+		t.Error("the cookie expected to be replaced with the token of the session, got:", cookies, echo.token)
+	}
+
+	for token, ok := range map[string]bool{planted: false, bound: true} {
+		form := url.Values{"csrf_token": {token}}
+		r := httptest.NewRequest("POST", "/createpost", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: CSRF_COOKIE_NAME, Value: token})
+		echo := &csrfEcho{}
+		NewCSRFMiddleware(echo).ServeHTTP(httptest.NewRecorder(), signedIn(r))
+		if echo.called != ok {
+			t.Error("Token bound to the session:", ok, "expected handler called:", ok, "got:", echo.called)
+		}
+	}
+}
+
+func TestAuthenticateUserRotatesCSRFToken(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := authenticateUser(context.Background(), rec, xid.New()); err != nil {
+		t.Fatal(err)
+	}
+	tokens := make(map[string]string)
+	for _, c := range rec.Result().Cookies() {
+		tokens[c.Name] = c.Value
+	}
+	if tokens[CSRF_COOKIE_NAME] == "" || tokens[CSRF_COOKIE_NAME] != sessionCSRFToken(tokens["session_token"]) {
+		t.Error("a new session expected the csrf token bound to it, got:", tokens)
+	}
+}
@@ -0,0 +1,1255 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// databaseConstructor returns an empty Database, it is called once per subtest
+// and is responsible for registering its own cleanup
+type databaseConstructor func(t *testing.T) Database
+
+// runConformanceSuite checks that a Database implementation behaves
+// the same way as every other backend, every backend should be run through it
+func runConformanceSuite(t *testing.T, newDB databaseConstructor) {
+	tests := map[string]func(t *testing.T, db Database){
+		"Users":           testConformanceUsers,
+		"UpdatePassword":  testConformanceUpdatePassword,
+		"Posts":           testConformancePosts,
+		"Boards":          testConformanceBoards,
+		"Comments":        testConformanceComments,
+		"PagePosts":       testConformancePagePosts,
+		"PageUserPosts":   testConformancePageUserPosts,
+		"GetPostPageData": testConformancePostPageData,
+		"PageComments":    testConformancePageComments,
+		"Threads":         testConformanceThreads,
+		"UpdatePost":      testConformanceUpdatePost,
+		"UpdateComment":   testConformanceUpdateComment,
+		"DeletePost":      testConformanceDeletePost,
+		"DeleteComment":   testConformanceDeleteComment,
+		"DeleteUser":      testConformanceDeleteUser,
+		"Moderation":      testConformanceModeration,
+		"APITokens":       testConformanceAPITokens,
+		"Attachments":     testConformanceAttachments,
+		"Search":          testConformanceSearch,
+		"Sessions":        testConformanceSessions,
+		"Ping":            testConformancePing,
+		"Cancellation":    testConformanceCancellation,
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			test(t, newDB(t))
+		})
+	}
+}
+
+func TestJSONConformance(t *testing.T) {
+	runConformanceSuite(t, func(t *testing.T) Database {
+		j, err := ConnectJSON(t.TempDir(), "conformance.json", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { j.Disconnect(context.Background()) })
+		return j
+	})
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	runConformanceSuite(t, func(t *testing.T) Database {
+		s, err := ConnectSQLite(filepath.Join(t.TempDir(), "conformance.sqlite"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Disconnect(context.Background()) })
+		return s
+	})
+}
+
+// TestPostgresConformance needs a disposable database, its tables get truncated
+func TestPostgresConformance(t *testing.T) {
+	if os.Getenv("POSTGRES_TEST") == "" {
+		t.Skip("set POSTGRES_TEST and the POSTGRES_* variables to run against a disposable database")
+	}
+	runConformanceSuite(t, func(t *testing.T) Database {
+		p, err := ConnectPostgres(PostgresConfig{
+			Host:     "localhost",
+			Port:     5432,
+			User:     os.Getenv("POSTGRES_USER"),
+			Password: os.Getenv("POSTGRES_PASSWORD"),
+			Database: os.Getenv("POSTGRES_DB"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = p.pool.Exec(context.Background(), `TRUNCATE posts, comments, users`); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { p.Disconnect(context.Background()) })
+		return p
+	})
+}
+
+func testConformanceUsers(t *testing.T, db Database) {
+	ctx := context.Background()
+	id, err := db.AddUser(ctx, "courtier", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.GetUser(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != id || user.Name != "courtier" || user.Password != "hash" {
+		t.Error("GetUser returned wrong user:", user)
+	}
+	if user.DateJoined.IsZero() {
+		t.Error("DateJoined was not set")
+	}
+	byName, err := db.FindUserByName(ctx, "courtier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byName.ID != id {
+		t.Error("FindUserByName expected:", id, "got:", byName.ID)
+	}
+	if _, err = db.AddUser(ctx, "courtier", "other"); err != ErrUserNameTaken {
+		t.Error("Duplicate AddUser expected:", ErrUserNameTaken, "got:", err)
+	}
+	if _, err = db.GetUser(ctx, xid.New()); err != ErrNoUserFoundByID {
+		t.Error("GetUser expected:", ErrNoUserFoundByID, "got:", err)
+	}
+	if _, err = db.FindUserByName(ctx, "nobody"); err != ErrNoUserFoundByName {
+		t.Error("FindUserByName expected:", ErrNoUserFoundByName, "got:", err)
+	}
+}
+
+func testConformanceUpdatePassword(t *testing.T, db Database) {
+	ctx := context.Background()
+	id := mustAddUser(t, db, "courtier")
+	if err := db.UpdatePassword(ctx, id, "rehashed"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.GetUser(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password != "rehashed" {
+		t.Error("UpdatePassword expected: rehashed got:", user.Password)
+	}
+	if err = db.UpdatePassword(ctx, xid.New(), "rehashed"); err != ErrNoUserFoundByID {
+		t.Error("UpdatePassword expected:", ErrNoUserFoundByID, "got:", err)
+	}
+	if err = db.DeleteUser(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.UpdatePassword(ctx, id, "rehashed"); err != ErrNoUserFoundByID {
+		t.Error("UpdatePassword on deleted user expected:", ErrNoUserFoundByID, "got:", err)
+	}
+}
+
+func testConformancePosts(t *testing.T, db Database) {
+	ctx := context.Background()
+	posterID := mustAddUser(t, db, "poster")
+	boardID := mustAddBoard(t, db, "general")
+	id, err := db.AddPost(ctx, "title", "content", posterID, boardID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post, err := db.GetPost(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.ID != id || post.Title != "title" || post.Content != "content" || post.PosterID != posterID || post.BoardID != boardID {
+		t.Error("GetPost returned wrong post:", post)
+	}
+	if len(post.CommentIDs) != 0 {
+		t.Error("new post expected no comments, got:", len(post.CommentIDs))
+	}
+	if post.DateCreated.IsZero() {
+		t.Error("DateCreated was not set")
+	}
+	if _, err = db.GetPost(ctx, xid.New()); err != ErrNoPostFoundByID {
+		t.Error("GetPost expected:", ErrNoPostFoundByID, "got:", err)
+	}
+	if _, err = db.AddPost(ctx, "title", "content", posterID, xid.New()); err != ErrNoBoardFoundByID {
+		t.Error("AddPost to a missing board expected:", ErrNoBoardFoundByID, "got:", err)
+	}
+}
+
+func testConformanceBoards(t *testing.T, db Database) {
+	ctx := context.Background()
+	posterID := mustAddUser(t, db, "poster")
+	emptyID, err := db.AddBoard(ctx, "empty", "empty", "nothing here", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busyID, err := db.AddBoard(ctx, "busy", "busy", "lots going on", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.AddBoard(ctx, "again", "busy", "", 2); err != ErrBoardSlugTaken {
+		t.Error("AddBoard expected:", ErrBoardSlugTaken, "got:", err)
+	}
+	board, err := db.GetBoard(ctx, emptyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if board.Name != "empty" || board.Slug != "empty" || board.Description != "nothing here" || board.Position != 1 || board.DateCreated.IsZero() {
+		t.Error("GetBoard returned wrong board:", board)
+	}
+	if board, err = db.FindBoardBySlug(ctx, "busy"); err != nil || board.ID != busyID {
+		t.Error("FindBoardBySlug expected:", busyID, "got:", board.ID, err)
+	}
+	if _, err = db.GetBoard(ctx, xid.New()); err != ErrNoBoardFoundByID {
+		t.Error("GetBoard expected:", ErrNoBoardFoundByID, "got:", err)
+	}
+	if _, err = db.FindBoardBySlug(ctx, "missing"); err != ErrNoBoardFoundBySlug {
+		t.Error("FindBoardBySlug expected:", ErrNoBoardFoundBySlug, "got:", err)
+	}
+	if err = db.UpdateBoard(ctx, emptyID, "quiet", "quiet", "still nothing", 3); err != nil {
+		t.Fatal(err)
+	}
+	if board, err = db.GetBoard(ctx, emptyID); err != nil || board.Name != "quiet" || board.Slug != "quiet" || board.Description != "still nothing" || board.Position != 3 {
+		t.Error("UpdateBoard should change the board, got:", board, err)
+	}
+	if err = db.UpdateBoard(ctx, emptyID, "quiet", "busy", "", 3); err != ErrBoardSlugTaken {
+		t.Error("UpdateBoard to a taken slug expected:", ErrBoardSlugTaken, "got:", err)
+	}
+	if err = db.UpdateBoard(ctx, xid.New(), "missing", "missing", "", 0); err != ErrNoBoardFoundByID {
+		t.Error("UpdateBoard of a missing board expected:", ErrNoBoardFoundByID, "got:", err)
+	}
+	if err = db.UpdateBoard(ctx, emptyID, "empty", "empty", "nothing here", 1); err != nil {
+		t.Fatal(err)
+	}
+	first, err := db.AddPost(ctx, "first", "content", posterID, busyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	second, err := db.AddPost(ctx, "second", "content", posterID, busyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	commentID, err := db.AddComment(ctx, "comment", first, posterID, xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment, err := db.GetComment(ctx, commentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boards, err := db.AllBoards(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(boards) != 2 || boards[0].ID != busyID || boards[1].ID != emptyID {
+		t.Fatal("AllBoards should be ordered by position, got:", boards)
+	}
+	if boards[0].PostCount != 2 || !boards[0].LatestActivity.Equal(comment.DateCreated) {
+		t.Error("busy board expected 2 posts and activity at:", comment.DateCreated, "got:", boards[0].PostCount, boards[0].LatestActivity)
+	}
+	if boards[1].PostCount != 0 || !boards[1].LatestActivity.IsZero() {
+		t.Error("empty board expected no posts and no activity, got:", boards[1].PostCount, boards[1].LatestActivity)
+	}
+	posts, _, err := db.PageBoardPosts(ctx, busyID, allPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 2 || posts[0].ID != second || posts[1].ID != first {
+		t.Error("PageBoardPosts should return the posts of the board newest first, got:", posts)
+	}
+	posts, cursors, err := db.PageBoardPosts(ctx, busyID, Page{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].ID != second || cursors.Next.IsZero() {
+		t.Fatal("PageBoardPosts first page expected:", second, "got:", posts, cursors)
+	}
+	if posts, _, err = db.PageBoardPosts(ctx, busyID, Page{Cursor: cursors.Next, Limit: 1}); err != nil || len(posts) != 1 || posts[0].ID != first {
+		t.Error("PageBoardPosts second page expected:", first, "got:", posts, err)
+	}
+	if posts, _, err = db.PageBoardPosts(ctx, emptyID, allPage); err != nil || len(posts) != 0 {
+		t.Error("PageBoardPosts on an empty board expected no posts, got:", posts, err)
+	}
+}
+
+func testConformancePageUserPosts(t *testing.T, db Database) {
+	ctx := context.Background()
+	posterID := mustAddUser(t, db, "poster")
+	otherID := mustAddUser(t, db, "other")
+	first := mustAddPost(t, db, posterID)
+	// postgres timestamps only go down to microseconds
+	time.Sleep(2 * time.Millisecond)
+	mustAddPost(t, db, otherID)
+	time.Sleep(2 * time.Millisecond)
+	second := mustAddPost(t, db, posterID)
+	posts, cursors, err := db.PageUserPosts(ctx, posterID, Page{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].ID != second || cursors.Next.IsZero() {
+		t.Fatal("PageUserPosts first page expected:", second, "got:", posts, cursors)
+	}
+	posts, cursors, err = db.PageUserPosts(ctx, posterID, Page{Cursor: cursors.Next, Limit: 1})
+	if err != nil || len(posts) != 1 || posts[0].ID != first || !cursors.Next.IsZero() {
+		t.Error("PageUserPosts second page expected:", first, "got:", posts, cursors, err)
+	}
+	if posts, _, err = db.PageUserPosts(ctx, mustAddUser(t, db, "lurker"), Page{Limit: 10}); err != nil || len(posts) != 0 {
+		t.Error("PageUserPosts of a user without posts expected no posts, got:", posts, err)
+	}
+}
+
+func testConformanceComments(t *testing.T, db Database) {
+	ctx := context.Background()
+	posterID := mustAddUser(t, db, "poster")
+	postID := mustAddPost(t, db, posterID)
+	id, err := db.AddComment(ctx, "comment", postID, posterID, xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment, err := db.GetComment(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment.ID != id || comment.Content != "comment" || comment.PostID != postID || comment.PosterID != posterID {
+		t.Error("GetComment returned wrong comment:", comment)
+	}
+	post, err := db.GetPost(ctx, postID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(post.CommentIDs) != 1 {
+		t.Error("post expected 1 comment, got:", len(post.CommentIDs))
+	}
+	if _, err = db.AddComment(ctx, "comment", xid.New(), posterID, xid.NilID()); err != ErrNoPostFoundByID {
+		t.Error("AddComment on missing post expected:", ErrNoPostFoundByID, "got:", err)
+	}
+	if _, err = db.GetComment(ctx, xid.New()); err != ErrNoCommentFoundByID {
+		t.Error("GetComment expected:", ErrNoCommentFoundByID, "got:", err)
+	}
+}
+
+func testConformancePagePosts(t *testing.T, db Database) {
+	ctx := context.Background()
+	const POST_AMOUNT = 5
+	posterID := mustAddUser(t, db, "poster")
+	ids := []xid.ID{}
+	for i := 0; i < POST_AMOUNT; i++ {
+		ids = append(ids, mustAddPost(t, db, posterID))
+		// postgres timestamps only go down to microseconds
+		time.Sleep(2 * time.Millisecond)
+	}
+	pagePosts := func(page Page, expected ...xid.ID) PageCursors {
+		t.Helper()
+		posts, cursors, err := db.PagePosts(ctx, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []xid.ID{}
+		for _, post := range posts {
+			got = append(got, post.ID)
+		}
+		if !equalIDs(got, expected) {
+			t.Error("PagePosts", page, "expected:", expected, "got:", got)
+		}
+		return cursors
+	}
+	// newest first
+	cursors := pagePosts(Page{Limit: 2}, ids[4], ids[3])
+	if !cursors.Previous.IsZero() || cursors.Next.IsZero() {
+		t.Fatal("first page should only have a next page, got:", cursors)
+	}
+	cursors = pagePosts(Page{Cursor: cursors.Next, Limit: 2}, ids[2], ids[1])
+	if cursors.Previous.IsZero() || cursors.Next.IsZero() {
+		t.Fatal("middle page should have both pages, got:", cursors)
+	}
+	middle := cursors
+	cursors = pagePosts(Page{Cursor: cursors.Next, Limit: 2}, ids[0])
+	if cursors.Previous.IsZero() || !cursors.Next.IsZero() {
+		t.Fatal("last page should only have a previous page, got:", cursors)
+	}
+	cursors = pagePosts(Page{Cursor: cursors.Previous, Before: true, Limit: 2}, ids[2], ids[1])
+	if cursors != middle {
+		t.Error("walking back expected:", middle, "got:", cursors)
+	}
+	cursors = pagePosts(Page{Cursor: cursors.Previous, Before: true, Limit: 2}, ids[4], ids[3])
+	if !cursors.Previous.IsZero() || cursors.Next.IsZero() {
+		t.Error("walking back to the first page should only have a next page, got:", cursors)
+	}
+	// the zero cursor walking back starts at the end
+	pagePosts(Page{Before: true, Limit: 2}, ids[1], ids[0])
+	pagePosts(Page{Limit: 0})
+	pagePosts(Page{Limit: -1})
+	pagePosts(Page{Limit: 10}, ids[4], ids[3], ids[2], ids[1], ids[0])
+	// hidden posts are left out before the page is cut, so pages stay full
+	for _, id := range ids[2:4] {
+		if err := db.SetPostHidden(ctx, id, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cursors = pagePosts(Page{Limit: 2}, ids[4], ids[1])
+	pagePosts(Page{Cursor: cursors.Next, Limit: 2}, ids[0])
+	pagePosts(Page{Limit: 2, IncludeHidden: true}, ids[4], ids[3])
+	if posts, _, err := db.PageUserPosts(ctx, posterID, Page{Limit: 10}); err != nil || len(posts) != 3 {
+		t.Error("PageUserPosts expected hidden posts to be left out, got:", posts, err)
+	}
+}
+
+func testConformancePostPageData(t *testing.T, db Database) {
+	ctx := context.Background()
+	posterID := mustAddUser(t, db, "poster")
+	commenterID := mustAddUser(t, db, "commenter")
+	postID := mustAddPost(t, db, posterID)
+	first, err := db.AddComment(ctx, "first", postID, commenterID, xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	// a commenter that does not exist anymore
+	second, err := db.AddComment(ctx, "second", postID, xid.New(), xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	post, poster, comments, users, _, err := db.GetPostPageData(ctx, postID, allPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.ID != postID || poster.ID != posterID {
+		t.Error("GetPostPageData returned wrong post or poster:", post, poster)
+	}
+	if len(comments) != 2 {
+		t.Fatal("expected 2 comments, got:", len(comments))
+	}
+	if comments[0].ID != first || comments[1].ID != second {
+		t.Error("comments should be oldest first, got:", comments[0].ID, comments[1].ID)
+	}
+	if users[first].ID != commenterID {
+		t.Error("users should be keyed by comment id, expected:", commenterID, "got:", users[first].ID)
+	}
+	if users[second] != DeletedUser {
+		t.Error("missing commenter expected DeletedUser, got:", users[second])
+	}
+	orphanID := mustAddPost(t, db, xid.New())
+	if _, poster, _, _, _, err = db.GetPostPageData(ctx, orphanID, allPage); err != nil || poster != DeletedUser {
+		t.Error("missing poster expected DeletedUser, got:", poster, err)
+	}
+	if _, _, _, _, _, err = db.GetPostPageData(ctx, xid.New(), allPage); err != ErrNoPostFoundByID {
+		t.Error("GetPostPageData expected:", ErrNoPostFoundByID, "got:", err)
+	}
+}
+
+func testConformancePageComments(t *testing.T, db Database) {
+	ctx := context.Background()
+	const COMMENT_AMOUNT = 5
+	posterID := mustAddUser(t, db, "poster")
+	postID := mustAddPost(t, db, posterID)
+	ids := []xid.ID{}
+	for i := 0; i < COMMENT_AMOUNT; i++ {
+		id, err := db.AddComment(ctx, "comment", postID, posterID, xid.NilID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		time.Sleep(2 * time.Millisecond)
+	}
+	pageComments := func(page Page, expected ...xid.ID) PageCursors {
+		t.Helper()
+		_, _, comments, users, cursors, err := db.GetPostPageData(ctx, postID, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []xid.ID{}
+		for _, comment := range comments {
+			got = append(got, comment.ID)
+		}
+		if !equalIDs(got, expected) {
+			t.Error("GetPostPageData", page, "expected:", expected, "got:", got)
+		}
+		if len(users) != len(expected) {
+			t.Error("users should only hold the commenters of the page, expected:", len(expected), "got:", len(users))
+		}
+		return cursors
+	}
+	// oldest first
+	cursors := pageComments(Page{Limit: 3}, ids[0], ids[1], ids[2])
+	if !cursors.Previous.IsZero() || cursors.Next.IsZero() {
+		t.Fatal("first page should only have a next page, got:", cursors)
+	}
+	cursors = pageComments(Page{Cursor: cursors.Next, Limit: 3}, ids[3], ids[4])
+	if cursors.Previous.IsZero() || !cursors.Next.IsZero() {
+		t.Fatal("last page should only have a previous page, got:", cursors)
+	}
+	cursors = pageComments(Page{Cursor: cursors.Previous, Before: true, Limit: 3}, ids[0], ids[1], ids[2])
+	if !cursors.Previous.IsZero() || cursors.Next.IsZero() {
+		t.Error("walking back to the first page should only have a next page, got:", cursors)
+	}
+	pageComments(Page{Before: true, Limit: 2}, ids[3], ids[4])
+	pageComments(Page{Limit: 0})
+}
+
+func testConformanceThreads(t *testing.T, db Database) {
+	ctx := context.Background()
+	posterID := mustAddUser(t, db, "poster")
+	postID := mustAddPost(t, db, posterID)
+	otherPostID := mustAddPost(t, db, posterID)
+	addComment := func(parentID xid.ID) xid.ID {
+		t.Helper()
+		id, err := db.AddComment(ctx, "comment", postID, posterID, parentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+		return id
+	}
+	first := addComment(xid.NilID())
+	second := addComment(xid.NilID())
+	reply := addComment(first)
+	secondReply := addComment(second)
+	nested := addComment(reply)
+	comment, err := db.GetComment(ctx, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment.ParentID != first {
+		t.Error("GetComment parent expected:", first, "got:", comment.ParentID)
+	}
+	_, _, comments, users, _, err := db.GetPostPageData(ctx, postID, allPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 2 || comments[0].ID != first || comments[1].ID != second {
+		t.Fatal("only top level comments expected at the top of the tree, got:", comments)
+	}
+	if len(comments[0].Replies) != 1 || comments[0].Replies[0].ID != reply {
+		t.Fatal("reply expected under the first comment, got:", comments[0].Replies)
+	}
+	if len(comments[0].Replies[0].Replies) != 1 || comments[0].Replies[0].Replies[0].ID != nested {
+		t.Error("nested reply expected under the reply, got:", comments[0].Replies[0].Replies)
+	}
+	if len(comments[1].Replies) != 1 || comments[1].Replies[0].ID != secondReply {
+		t.Error("reply expected under the second comment, got:", comments[1].Replies)
+	}
+	if len(users) != 5 {
+		t.Error("users should hold every commenter in the tree, expected: 5 got:", len(users))
+	}
+	// pages only count top level comments and take their replies along
+	_, _, comments, users, cursors, err := db.GetPostPageData(ctx, postID, Page{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 1 || len(comments[0].Replies) != 1 || len(comments[0].Replies[0].Replies) != 1 || cursors.Next.IsZero() {
+		t.Error("first page expected the first thread, got:", comments, cursors)
+	}
+	if len(users) != 3 {
+		t.Error("users should only hold the commenters of the page, expected: 3 got:", len(users))
+	}
+	latest, users, err := db.LatestComments(ctx, postID, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 3 || latest[0].ID != nested || latest[1].ID != secondReply || latest[2].ID != reply {
+		t.Error("LatestComments expected the newest comments at any depth, newest first, got:", latest)
+	}
+	if len(users) != 3 || users[nested].Name != "poster" {
+		t.Error("LatestComments expected the commenters keyed by comment id, got:", users)
+	}
+	if err = db.SetCommentHidden(ctx, nested, true); err != nil {
+		t.Fatal(err)
+	}
+	if latest, _, err = db.LatestComments(ctx, postID, 10); err != nil || len(latest) != 4 || latest[0].ID != secondReply {
+		t.Error("LatestComments expected hidden comments to be left out, got:", latest, err)
+	}
+	if _, err = db.AddComment(ctx, "comment", otherPostID, posterID, first); err != ErrNoCommentFoundByID {
+		t.Error("AddComment replying across posts expected:", ErrNoCommentFoundByID, "got:", err)
+	}
+	if _, err = db.AddComment(ctx, "comment", postID, posterID, xid.New()); err != ErrNoCommentFoundByID {
+		t.Error("AddComment replying to a missing comment expected:", ErrNoCommentFoundByID, "got:", err)
+	}
+	if _, err = db.AddComment(ctx, "comment", xid.New(), posterID, first); err != ErrNoPostFoundByID {
+		t.Error("AddComment replying on a missing post expected:", ErrNoPostFoundByID, "got:", err)
+	}
+	if err = db.DeleteComment(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.AddComment(ctx, "comment", postID, posterID, second); err != ErrNoCommentFoundByID {
+		t.Error("AddComment replying to a deleted comment expected:", ErrNoCommentFoundByID, "got:", err)
+	}
+	post, err := db.GetPost(ctx, postID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(post.CommentIDs) != 5 {
+		t.Error("failed replies should not be counted, expected: 5 got:", len(post.CommentIDs))
+	}
+}
+
+func testConformanceUpdatePost(t *testing.T, db Database) {
+	ctx := context.Background()
+	posterID := mustAddUser(t, db, "poster")
+	postID := mustAddPost(t, db, posterID)
+	if err := db.UpdatePost(ctx, postID, "second title", "second content"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if err := db.UpdatePost(ctx, postID, "third title", "third content"); err != nil {
+		t.Fatal(err)
+	}
+	post, err := db.GetPost(ctx, postID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Title != "third title" || post.Content != "third content" || post.DateEdited.IsZero() {
+		t.Error("UpdatePost did not update the post, got:", post)
+	}
+	revisions, err := db.GetPostRevisions(ctx, postID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postRevisions := revisions[postID]
+	if len(postRevisions) != 2 {
+		t.Fatal("expected 2 revisions, got:", len(postRevisions))
+	}
+	if postRevisions[0].Title != "title" || postRevisions[0].Content != "content" || postRevisions[1].Title != "second title" {
+		t.Error("revisions should hold previous versions oldest first, got:", postRevisions)
+	}
+	if postRevisions[0].PostID != postID || postRevisions[0].DateEdited.IsZero() {
+		t.Error("revision is missing its post id or date, got:", postRevisions[0])
+	}
+	if err = db.UpdatePost(ctx, xid.New(), "title", "content"); err != ErrNoPostFoundByID {
+		t.Error("UpdatePost expected:", ErrNoPostFoundByID, "got:", err)
+	}
+}
+
+func testConformanceUpdateComment(t *testing.T, db Database) {
+	ctx := context.Background()
+	posterID := mustAddUser(t, db, "poster")
+	postID := mustAddPost(t, db, posterID)
+	commentID, err := db.AddComment(ctx, "comment", postID, posterID, xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.UpdateComment(ctx, commentID, "edited"); err != nil {
+		t.Fatal(err)
+	}
+	comment, err := db.GetComment(ctx, commentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment.Content != "edited" || comment.DateEdited.IsZero() {
+		t.Error("UpdateComment did not update the comment, got:", comment)
+	}
+	_, _, comments, _, _, err := db.GetPostPageData(ctx, postID, allPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 1 || comments[0].DateEdited.IsZero() {
+		t.Error("GetPostPageData should return the edit date, got:", comments)
+	}
+	revisions, err := db.GetPostRevisions(ctx, postID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions[commentID]) != 1 || revisions[commentID][0].Content != "comment" {
+		t.Error("expected the original comment as a revision, got:", revisions[commentID])
+	}
+	if err = db.DeleteComment(ctx, commentID); err != nil {
+		t.Fatal(err)
+	}
+	if revisions, err = db.GetPostRevisions(ctx, postID); err != nil || len(revisions[commentID]) != 0 {
+		t.Error("deleting a comment should delete its revisions, got:", revisions[commentID], err)
+	}
+	if err = db.UpdateComment(ctx, commentID, "edited"); err != ErrNoCommentFoundByID {
+		t.Error("UpdateComment on deleted comment expected:", ErrNoCommentFoundByID, "got:", err)
+	}
+}
+
+func testConformanceDeletePost(t *testing.T, db Database) {
+	ctx := context.Background()
+	posterID := mustAddUser(t, db, "poster")
+	postID := mustAddPost(t, db, posterID)
+	keptID := mustAddPost(t, db, posterID)
+	commentID, err := db.AddComment(ctx, "comment", postID, posterID, xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.UpdatePost(ctx, postID, "edited", "edited"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeletePost(ctx, postID); err != nil {
+		t.Fatal(err)
+	}
+	if revisions, err := db.GetPostRevisions(ctx, postID); err != nil || len(revisions) != 0 {
+		t.Error("deleting a post should delete its revisions, got:", revisions, err)
+	}
+	if _, err = db.GetPost(ctx, postID); err != ErrNoPostFoundByID {
+		t.Error("GetPost on deleted post expected:", ErrNoPostFoundByID, "got:", err)
+	}
+	if _, err = db.GetComment(ctx, commentID); err != ErrNoCommentFoundByID {
+		t.Error("comments of a deleted post expected:", ErrNoCommentFoundByID, "got:", err)
+	}
+	posts, _, err := db.PagePosts(ctx, allPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].ID != keptID {
+		t.Error("PagePosts should only return the remaining post, got:", posts)
+	}
+	if err = db.DeletePost(ctx, postID); err != ErrNoPostFoundByID {
+		t.Error("DeletePost twice expected:", ErrNoPostFoundByID, "got:", err)
+	}
+}
+
+func testConformanceDeleteComment(t *testing.T, db Database) {
+	ctx := context.Background()
+	posterID := mustAddUser(t, db, "poster")
+	postID := mustAddPost(t, db, posterID)
+	commentID, err := db.AddComment(ctx, "comment", postID, posterID, xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteComment(ctx, commentID); err != nil {
+		t.Fatal(err)
+	}
+	comment, err := db.GetComment(ctx, commentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !comment.Deleted || comment.Content != "" {
+		t.Error("deleted comment should be flagged and emptied, got:", comment)
+	}
+	_, _, comments, _, _, err := db.GetPostPageData(ctx, postID, allPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 1 || !comments[0].Deleted {
+		t.Error("deleted comment should stay in the thread, got:", comments)
+	}
+	if err = db.DeleteComment(ctx, xid.New()); err != ErrNoCommentFoundByID {
+		t.Error("DeleteComment expected:", ErrNoCommentFoundByID, "got:", err)
+	}
+}
+
+func testConformanceAttachments(t *testing.T, db Database) {
+	ctx := context.Background()
+	posterID := mustAddUser(t, db, "poster")
+	postID := mustAddPost(t, db, posterID)
+	otherPostID := mustAddPost(t, db, posterID)
+	commentID, err := db.AddComment(ctx, "comment", postID, posterID, xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachment := Attachment{
+		TargetID:     postID,
+		PostID:       postID,
+		UploaderID:   posterID,
+		Key:          "image",
+		ThumbnailKey: "thumbnail",
+		ContentType:  "image/png",
+		Width:        640,
+		Height:       480,
+		Size:         1234,
+	}
+	postAttachmentID, err := db.AddAttachment(ctx, attachment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetAttachment(ctx, postAttachmentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != postAttachmentID || got.TargetID != postID || got.PostID != postID || got.UploaderID != posterID ||
+		got.Key != "image" || got.ThumbnailKey != "thumbnail" || got.ContentType != "image/png" ||
+		got.Width != 640 || got.Height != 480 || got.Size != 1234 || got.DateCreated.IsZero() {
+		t.Error("GetAttachment returned a different attachment:", got)
+	}
+	if _, err = db.GetAttachment(ctx, xid.New()); err != ErrNoAttachmentFound {
+		t.Error("GetAttachment expected:", ErrNoAttachmentFound, "got:", err)
+	}
+
+	attachment.TargetID = commentID
+	var commentAttachmentIDs []xid.ID
+	for n := 0; n < 2; n++ {
+		id, err := db.AddAttachment(ctx, attachment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commentAttachmentIDs = append(commentAttachmentIDs, id)
+	}
+	attachment.TargetID, attachment.PostID = otherPostID, otherPostID
+	otherAttachmentID, err := db.AddAttachment(ctx, attachment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := db.AttachmentKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !keys["image"] || !keys["thumbnail"] {
+		t.Error("AttachmentKeys should return the image and thumbnail keys, got:", keys)
+	}
+
+	attachments, err := db.PostAttachments(ctx, postID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 2 || len(attachments[postID]) != 1 || attachments[postID][0].ID != postAttachmentID {
+		t.Error("PostAttachments should key the attachments of the post by its id, got:", attachments)
+	}
+	if len(attachments[commentID]) != 2 || attachments[commentID][0].ID != commentAttachmentIDs[0] || attachments[commentID][1].ID != commentAttachmentIDs[1] {
+		t.Error("PostAttachments should key the attachments of a comment by its id oldest first, got:", attachments[commentID])
+	}
+
+	if err = db.DeleteComment(ctx, commentID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.GetAttachment(ctx, commentAttachmentIDs[0]); err != ErrNoAttachmentFound {
+		t.Error("attachments of a deleted comment should be removed, got:", err)
+	}
+	if err = db.DeletePost(ctx, postID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.GetAttachment(ctx, postAttachmentID); err != ErrNoAttachmentFound {
+		t.Error("attachments of a deleted post should be removed, got:", err)
+	}
+	if _, err = db.GetAttachment(ctx, otherAttachmentID); err != nil {
+		t.Error("attachments of other posts should be kept, got:", err)
+	}
+}
+
+func testConformanceDeleteUser(t *testing.T, db Database) {
+	ctx := context.Background()
+	userID := mustAddUser(t, db, "leaver")
+	postID := mustAddPost(t, db, userID)
+	commentID, err := db.AddComment(ctx, "comment", postID, userID, xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachmentID, err := db.AddAttachment(ctx, Attachment{TargetID: postID, PostID: postID, UploaderID: userID, Key: "image", ThumbnailKey: "thumbnail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stayerID := mustAddUser(t, db, "stayer")
+	for _, session := range []Session{{Token: "leaver", UserID: userID, Expiry: time.Now().Add(time.Hour)}, {Token: "stayer", UserID: stayerID, Expiry: time.Now().Add(time.Hour)}} {
+		if err = db.AddSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = db.AddAPIToken(ctx, userID, "script", "leaverhash", []string{"read"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteUser(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.GetSession(ctx, "leaver"); err != ErrNoSessionFoundByToken {
+		t.Error("sessions of a deleted user should be removed, got:", err)
+	}
+	if _, err = db.GetSession(ctx, "stayer"); err != nil {
+		t.Error("sessions of other users should be kept, got:", err)
+	}
+	if _, err = db.FindAPITokenByHash(ctx, "leaverhash"); err != ErrNoAPITokenFound {
+		t.Error("api tokens of a deleted user should be removed, got:", err)
+	}
+	if _, err = db.GetAttachment(ctx, attachmentID); err != ErrNoAttachmentFound {
+		t.Error("attachments of a deleted user should be removed, got:", err)
+	}
+	user, err := db.GetUser(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Deleted {
+		t.Error("GetUser should return the user flagged as deleted")
+	}
+	if _, err = db.FindUserByName(ctx, "leaver"); err != ErrNoUserFoundByName {
+		t.Error("FindUserByName on deleted user expected:", ErrNoUserFoundByName, "got:", err)
+	}
+	if _, err = db.AddUser(ctx, "leaver", "leaver"); err != ErrUserNameTaken {
+		t.Error("name of deleted user should stay reserved, expected:", ErrUserNameTaken, "got:", err)
+	}
+	_, poster, _, users, _, err := db.GetPostPageData(ctx, postID, allPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if poster != DeletedUser || users[commentID] != DeletedUser {
+		t.Error("deleted poster and commenter expected DeletedUser, got:", poster, users[commentID])
+	}
+	if err = db.DeleteUser(ctx, xid.New()); err != ErrNoUserFoundByID {
+		t.Error("DeleteUser expected:", ErrNoUserFoundByID, "got:", err)
+	}
+}
+
+func testConformanceModeration(t *testing.T, db Database) {
+	ctx := context.Background()
+	userID := mustAddUser(t, db, "user")
+	leaverID := mustAddUser(t, db, "leaver")
+	if count, err := db.CountUsers(ctx); err != nil || count != 2 {
+		t.Error("CountUsers expected:", 2, "got:", count, err)
+	}
+	if err := db.SetUserRole(ctx, userID, RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetUserBanned(ctx, userID, true); err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.FindUserByName(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != RoleModerator || !user.Banned {
+		t.Error("user expected to be a banned moderator, got:", user)
+	}
+	if err = db.DeleteUser(ctx, leaverID); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := db.CountUsers(ctx); err != nil || count != 2 {
+		t.Error("CountUsers should count deleted users, expected:", 2, "got:", count, err)
+	}
+	if err = db.SetUserRole(ctx, leaverID, RoleAdmin); err != ErrNoUserFoundByID {
+		t.Error("SetUserRole on deleted user expected:", ErrNoUserFoundByID, "got:", err)
+	}
+	if err = db.SetUserBanned(ctx, xid.New(), true); err != ErrNoUserFoundByID {
+		t.Error("SetUserBanned expected:", ErrNoUserFoundByID, "got:", err)
+	}
+
+	postID := mustAddPost(t, db, userID)
+	commentID, err := db.AddComment(ctx, "comment", postID, userID, xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SetPostLocked(ctx, postID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SetPostHidden(ctx, postID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SetCommentHidden(ctx, commentID, true); err != nil {
+		t.Fatal(err)
+	}
+	post, _, comments, users, _, err := db.GetPostPageData(ctx, postID, allPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !post.Locked || !post.Hidden {
+		t.Error("post expected to be locked and hidden, got:", post)
+	}
+	if len(comments) != 1 || !comments[0].Hidden {
+		t.Error("comment expected to be hidden, got:", comments)
+	}
+	if users[commentID].Role != RoleModerator {
+		t.Error("commenter role expected:", RoleModerator, "got:", users[commentID].Role)
+	}
+	if err = db.SetPostLocked(ctx, postID, false); err != nil {
+		t.Fatal(err)
+	}
+	if post, err = db.GetPost(ctx, postID); err != nil || post.Locked {
+		t.Error("post expected to be unlocked, got:", post, err)
+	}
+	if err = db.SetPostHidden(ctx, xid.New(), true); err != ErrNoPostFoundByID {
+		t.Error("SetPostHidden expected:", ErrNoPostFoundByID, "got:", err)
+	}
+	if err = db.SetPostLocked(ctx, xid.New(), true); err != ErrNoPostFoundByID {
+		t.Error("SetPostLocked expected:", ErrNoPostFoundByID, "got:", err)
+	}
+	if err = db.SetCommentHidden(ctx, xid.New(), true); err != ErrNoCommentFoundByID {
+		t.Error("SetCommentHidden expected:", ErrNoCommentFoundByID, "got:", err)
+	}
+}
+
+func testConformanceAPITokens(t *testing.T, db Database) {
+	ctx := context.Background()
+	userID := mustAddUser(t, db, "user")
+	otherID := mustAddUser(t, db, "other")
+	firstID, err := db.AddAPIToken(ctx, userID, "bot", "hash1", []string{"read", "comment"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondID, err := db.AddAPIToken(ctx, userID, "script", "hash2", []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := db.FindAPITokenByHash(ctx, "hash1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.ID != firstID || token.Name != "bot" || token.UserID != userID || !token.LastUsed.IsZero() {
+		t.Error("FindAPITokenByHash returned wrong token:", token)
+	}
+	if len(token.Scopes) != 2 || token.Scopes[0] != "read" || token.Scopes[1] != "comment" {
+		t.Error("scopes expected: [read comment] got:", token.Scopes)
+	}
+	if _, err = db.FindAPITokenByHash(ctx, "missing"); err != ErrNoAPITokenFound {
+		t.Error("FindAPITokenByHash expected:", ErrNoAPITokenFound, "got:", err)
+	}
+	used := time.Now()
+	if err = db.TouchAPIToken(ctx, firstID, used); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := db.UserAPITokens(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0].ID != firstID || tokens[1].ID != secondID {
+		t.Fatal("UserAPITokens expected both tokens oldest first, got:", tokens)
+	}
+	if drift := tokens[0].LastUsed.Sub(used); drift > time.Millisecond || drift < -time.Millisecond {
+		t.Error("last used expected:", used, "got:", tokens[0].LastUsed)
+	}
+	if len(tokens[1].Scopes) != 0 {
+		t.Error("scopes expected to be empty, got:", tokens[1].Scopes)
+	}
+	if err = db.DeleteAPIToken(ctx, firstID, otherID); err != ErrNoAPITokenFound {
+		t.Error("deleting the token of another user expected:", ErrNoAPITokenFound, "got:", err)
+	}
+	if err = db.DeleteAPIToken(ctx, firstID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.FindAPITokenByHash(ctx, "hash1"); err != ErrNoAPITokenFound {
+		t.Error("revoked token expected:", ErrNoAPITokenFound, "got:", err)
+	}
+	if err = db.TouchAPIToken(ctx, firstID, used); err != ErrNoAPITokenFound {
+		t.Error("TouchAPIToken expected:", ErrNoAPITokenFound, "got:", err)
+	}
+}
+
+func testConformanceSessions(t *testing.T, db Database) {
+	ctx := context.Background()
+	userID := mustAddUser(t, db, "user")
+	now := time.Now()
+	live := Session{Token: "live", UserID: userID, Expiry: now.Add(time.Hour)}
+	expired := Session{Token: "expired", UserID: userID, Expiry: now.Add(-time.Hour)}
+	for _, session := range []Session{live, expired} {
+		if err := db.AddSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session, err := db.GetSession(ctx, "live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Token != "live" || session.UserID != userID {
+		t.Error("GetSession returned wrong session:", session)
+	}
+	// backends may round the expiry, postgres only keeps microseconds
+	if drift := session.Expiry.Sub(live.Expiry); drift > time.Millisecond || drift < -time.Millisecond {
+		t.Error("GetSession expiry expected:", live.Expiry, "got:", session.Expiry)
+	}
+	extended := live
+	extended.Expiry = now.Add(2 * time.Hour)
+	if err = db.AddSession(ctx, extended); err != nil {
+		t.Fatal(err)
+	}
+	if session, err = db.GetSession(ctx, "live"); err != nil || !session.Expiry.After(live.Expiry) {
+		t.Error("AddSession should replace a session with the same token, got:", session, err)
+	}
+	if count, err := db.CountSessions(ctx, now); err != nil || count != 1 {
+		t.Error("CountSessions expected 1, got:", count, err)
+	}
+	deleted, err := db.DeleteExpiredSessions(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Error("DeleteExpiredSessions expected 1, got:", deleted)
+	}
+	if _, err = db.GetSession(ctx, "expired"); err != ErrNoSessionFoundByToken {
+		t.Error("GetSession on swept session expected:", ErrNoSessionFoundByToken, "got:", err)
+	}
+	if err = db.DeleteSession(ctx, "live"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.GetSession(ctx, "live"); err != ErrNoSessionFoundByToken {
+		t.Error("GetSession on deleted session expected:", ErrNoSessionFoundByToken, "got:", err)
+	}
+	if err = db.DeleteSession(ctx, "missing"); err != nil {
+		t.Error("DeleteSession on missing session should not error, got:", err)
+	}
+}
+
+func testConformancePing(t *testing.T, db Database) {
+	if err := db.Ping(context.Background()); err != nil {
+		t.Error("Ping expected no error, got:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.Ping(ctx); err == nil {
+		t.Error("Ping with a canceled context expected an error")
+	}
+}
+
+func testConformanceCancellation(t *testing.T, db Database) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.AddUser(ctx, "courtier", "hash"); !errors.Is(err, context.Canceled) {
+		t.Error("AddUser with a canceled context expected:", context.Canceled, "got:", err)
+	}
+	if _, err := db.AllPosts(ctx); !errors.Is(err, context.Canceled) {
+		t.Error("AllPosts with a canceled context expected:", context.Canceled, "got:", err)
+	}
+	if _, err := db.FindUserByName(context.Background(), "courtier"); err != ErrNoUserFoundByName {
+		t.Error("a canceled AddUser should not add the user, got:", err)
+	}
+}
+
+func testConformanceSearch(t *testing.T, db Database) {
+	ctx := context.Background()
+	aliceID := mustAddUser(t, db, "alice")
+	bobID := mustAddUser(t, db, "bob")
+	boardID := mustAddBoard(t, db, "test")
+	// postgres timestamps only go down to microseconds
+	add := func(id xid.ID, err error) xid.ID {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+		return id
+	}
+	carrotsID := add(db.AddPost(ctx, "Growing carrots", "orange roots in spring", aliceID, boardID))
+	purpleID := add(db.AddComment(ctx, "I have purple CARROTS too", carrotsID, bobID, xid.NilID()))
+	winterID := add(db.AddComment(ctx, "winter roots", carrotsID, aliceID, xid.NilID()))
+	potatoesID := add(db.AddPost(ctx, "Potatoes", "boiled potatoes", bobID, boardID))
+	purple, err := db.GetComment(ctx, purpleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(query SearchQuery, page Page, expected ...xid.ID) PageCursors {
+		t.Helper()
+		results, cursors, err := db.Search(ctx, query, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []xid.ID{}
+		for _, result := range results {
+			if result.IsComment() {
+				got = append(got, result.Comment.ID)
+			} else {
+				got = append(got, result.Post.ID)
+			}
+		}
+		if len(got) != len(expected) {
+			t.Fatal("search", query, "expected:", expected, "got:", got)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatal("search", query, "expected:", expected, "got:", got)
+			}
+		}
+		return cursors
+	}
+	all := Page{Limit: 10}
+	search(SearchQuery{Text: "carrots"}, all, purpleID, carrotsID)
+	search(SearchQuery{Text: "Purple carrots!"}, all, purpleID)
+	search(SearchQuery{Text: "roots"}, all, winterID, carrotsID)
+	search(SearchQuery{Text: "turnips"}, all)
+	search(SearchQuery{Text: "carrots", AuthorID: aliceID}, all, carrotsID)
+	search(SearchQuery{Text: "carrots", Since: purple.DateCreated}, all, purpleID)
+	search(SearchQuery{Text: "carrots", Until: purple.DateCreated}, all, carrotsID)
+	search(SearchQuery{AuthorID: bobID}, all, potatoesID, purpleID)
+
+	cursors := search(SearchQuery{Text: "carrots"}, Page{Limit: 1}, purpleID)
+	if !cursors.Previous.IsZero() || cursors.Next.IsZero() {
+		t.Fatal("first page of search expected only a next cursor, got:", cursors)
+	}
+	search(SearchQuery{Text: "carrots"}, Page{Cursor: cursors.Next, Limit: 1}, carrotsID)
+
+	results, _, err := db.Search(ctx, SearchQuery{Text: "purple"}, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Author.ID != bobID || results[0].Post.ID != carrotsID || results[0].Comment.Content != purple.Content {
+		t.Error("search result expected bob's comment under the carrots post, got:", results[0])
+	}
+
+	if err = db.DeleteComment(ctx, winterID); err != nil {
+		t.Fatal(err)
+	}
+	search(SearchQuery{Text: "winter"}, all)
+	if err = db.UpdatePost(ctx, potatoesID, "Turnips", "mashed turnips"); err != nil {
+		t.Fatal(err)
+	}
+	search(SearchQuery{Text: "turnips"}, all, potatoesID)
+	search(SearchQuery{Text: "boiled"}, all)
+
+	if err = db.SetPostHidden(ctx, carrotsID, true); err != nil {
+		t.Fatal(err)
+	}
+	search(SearchQuery{Text: "carrots"}, all)
+	search(SearchQuery{Text: "carrots", IncludeHidden: true}, all, purpleID, carrotsID)
+
+	if err = db.DeleteUser(ctx, bobID); err != nil {
+		t.Fatal(err)
+	}
+	results, _, err = db.Search(ctx, SearchQuery{Text: "turnips"}, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Author != DeletedUser {
+		t.Error("search result of a deleted user expected:", DeletedUser, "got:", results)
+	}
+	if err = db.DeletePost(ctx, potatoesID); err != nil {
+		t.Fatal(err)
+	}
+	search(SearchQuery{Text: "turnips"}, all)
+}
+
+func mustAddUser(t *testing.T, db Database, name string) xid.ID {
+	t.Helper()
+	ctx := context.Background()
+	id, err := db.AddUser(ctx, name, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func mustAddBoard(t *testing.T, db Database, slug string) xid.ID {
+	t.Helper()
+	ctx := context.Background()
+	id, err := db.AddBoard(ctx, slug, slug, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// mustAddPost adds a post to the test board, which is created with the first post
+func mustAddPost(t *testing.T, db Database, posterID xid.ID) xid.ID {
+	t.Helper()
+	ctx := context.Background()
+	board, err := db.FindBoardBySlug(ctx, "test")
+	if err == ErrNoBoardFoundBySlug {
+		board.ID, err = db.AddBoard(ctx, "test", "test", "", 0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := db.AddPost(ctx, "title", "content", posterID, board.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// allPage is a page large enough to hold everything a test adds
+var allPage = Page{Limit: 100}
+
+func equalIDs(a, b []xid.ID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+CREATE TABLE IF NOT EXISTS boards (
+	name			text,
+	slug			text UNIQUE,
+	description		text,
+	position		integer,
+	id				text PRIMARY KEY,
+	date_created	timestamp
+);
+
+CREATE TABLE IF NOT EXISTS posts (
+	title			text,
+	content			text,
+	poster_id		text,
+	board_id		text REFERENCES boards(id),
+	id				text PRIMARY KEY,
+	date_created	timestamp,
+	date_edited		timestamp,
+	locked			boolean NOT NULL DEFAULT false,
+	hidden			boolean NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS posts_board_id ON posts(board_id, date_created);
+CREATE INDEX IF NOT EXISTS posts_poster_id ON posts(poster_id, date_created);
+
+CREATE TABLE IF NOT EXISTS comments (
+	content			text,
+	post_id			text REFERENCES posts(id),
+	poster_id		text,
+	parent_id		text,
+	id				text PRIMARY KEY,
+	date_created	timestamp,
+	deleted			boolean NOT NULL DEFAULT false,
+	date_edited		timestamp,
+	hidden			boolean NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS comments_post_id ON comments(post_id);
+CREATE INDEX IF NOT EXISTS comments_parent_id ON comments(parent_id);
+
+CREATE TABLE IF NOT EXISTS revisions (
+	title			text,
+	content			text,
+	target_id		text,
+	post_id			text,
+	id				text PRIMARY KEY,
+	date_edited		timestamp
+);
+
+CREATE INDEX IF NOT EXISTS revisions_post_id ON revisions(post_id);
+
+CREATE TABLE IF NOT EXISTS users (
+	name			text UNIQUE,
+	id				text PRIMARY KEY,
+	password		text,
+	date_joined		timestamp,
+	deleted			boolean NOT NULL DEFAULT false,
+	role			integer NOT NULL DEFAULT 0,
+	banned			boolean NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	token			text PRIMARY KEY,
+	user_id			text,
+	expiry			timestamp
+);
+
+CREATE INDEX IF NOT EXISTS sessions_expiry ON sessions(expiry);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	name			text,
+	hash			text UNIQUE,
+	user_id			text,
+	scopes			text,
+	id				text PRIMARY KEY,
+	date_created	timestamp,
+	last_used		timestamp
+);
+
+CREATE INDEX IF NOT EXISTS api_tokens_user_id ON api_tokens(user_id);
+
+CREATE TABLE IF NOT EXISTS attachments (
+	target_id		text,
+	post_id			text,
+	uploader_id		text,
+	blob_key		text,
+	thumbnail_key	text,
+	content_type	text,
+	width			integer,
+	height			integer,
+	size			integer,
+	id				text PRIMARY KEY,
+	date_created	timestamp
+);
+
+CREATE INDEX IF NOT EXISTS attachments_post_id ON attachments(post_id);
+CREATE INDEX IF NOT EXISTS attachments_target_id ON attachments(target_id);
@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/rs/xid"
@@ -13,54 +15,225 @@ var (
 	ErrNoCommentFoundByID         = errors.New("no matching comment id found")
 	ErrNoUserFoundByID            = errors.New("no matching user id found")
 	ErrNoUserFoundByName          = errors.New("no matching user name found")
+	ErrUserNameTaken              = errors.New("user name is already taken")
+	ErrNoSessionFoundByToken      = errors.New("no matching session token found")
+	ErrNoBoardFoundByID           = errors.New("no matching board id found")
+	ErrNoBoardFoundBySlug         = errors.New("no matching board slug found")
+	ErrBoardSlugTaken             = errors.New("board slug is already taken")
+	ErrUnknownRole                = errors.New("unknown role")
+	ErrNoAPITokenFound            = errors.New("no matching api token found")
+	ErrNoAttachmentFound          = errors.New("no matching attachment found")
 )
 
 type Database interface {
-	// AddPost adds a post to the database
-	AddPost(title, content string, posterID xid.ID) (xid.ID, error)
-	// AddComment adds a comment to the database
-	AddComment(content string, postID, posterID xid.ID) (xid.ID, error)
-	// AddUser adds a user to the database
-	AddUser(name, password string) (xid.ID, error)
+	// AddPost adds a post to a board,
+	// returns ErrNoBoardFoundByID if the board does not exist
+	AddPost(ctx context.Context, title, content string, posterID, boardID xid.ID) (xid.ID, error)
+	// AddComment adds a comment to the database, parentID is the comment it replies to
+	// or the nil id if it replies to the post itself.
+	// returns ErrNoPostFoundByID if the post does not exist and ErrNoCommentFoundByID
+	// if the parent is deleted or not a comment under the same post
+	AddComment(ctx context.Context, content string, postID, posterID, parentID xid.ID) (xid.ID, error)
+	// AddUser adds a user to the database,
+	// returns ErrUserNameTaken if the name is already in use
+	AddUser(ctx context.Context, name, password string) (xid.ID, error)
+	// AddBoard adds a board to the database,
+	// returns ErrBoardSlugTaken if the slug is already in use
+	AddBoard(ctx context.Context, name, slug, description string, position int) (xid.ID, error)
 
 	// GetPost gets a post from the database
-	GetPost(id xid.ID) (Post, error)
+	GetPost(ctx context.Context, id xid.ID) (Post, error)
 	// GetComment gets a comment from the database
-	GetComment(id xid.ID) (Comment, error)
+	GetComment(ctx context.Context, id xid.ID) (Comment, error)
 	// GetUser gets a user from the database
-	GetUser(id xid.ID) (User, error)
-	// FindUserByName finds a user by that name in the database
-	FindUserByName(name string) (User, error)
+	GetUser(ctx context.Context, id xid.ID) (User, error)
+	// FindUserByName finds a user by that name in the database,
+	// deleted users are never found
+	FindUserByName(ctx context.Context, name string) (User, error)
+	// GetBoard gets a board from the database
+	GetBoard(ctx context.Context, id xid.ID) (Board, error)
+	// FindBoardBySlug finds the board with that slug in the database
+	FindBoardBySlug(ctx context.Context, slug string) (Board, error)
+	// AllBoards returns every board along with its activity, ordered by position
+	AllBoards(ctx context.Context) ([]BoardSummary, error)
+	// UpdateBoard renames, describes and moves a board, returns ErrNoBoardFoundByID
+	// if the board does not exist and ErrBoardSlugTaken if another board has the slug
+	UpdateBoard(ctx context.Context, id xid.ID, name, slug, description string, position int) error
+	// MovePostsWithoutBoard moves the posts created before boards existed into a board,
+	// returns how many were moved, none are if the board does not exist
+	MovePostsWithoutBoard(ctx context.Context, boardID xid.ID) (int64, error)
 
 	// AllPosts returns all the posts in the database
-	AllPosts() ([]Post, error)
+	AllPosts(ctx context.Context) ([]Post, error)
 
-	// PagePosts returns posts [start, end)
-	PagePosts(start, end int) ([]Post, error)
+	// PagePosts returns a page of posts, newest first,
+	// along with the cursors to the pages around it. Hidden posts are left out unless page.IncludeHidden is set,
+	// the same goes for PageBoardPosts and PageUserPosts
+	PagePosts(ctx context.Context, page Page) ([]Post, PageCursors, error)
+	// PageBoardPosts returns a page of the posts in a board, newest first,
+	// along with the cursors to the pages around it
+	PageBoardPosts(ctx context.Context, boardID xid.ID, page Page) ([]Post, PageCursors, error)
+	// PageUserPosts returns a page of the posts of a user, newest first,
+	// along with the cursors to the pages around it
+	PageUserPosts(ctx context.Context, posterID xid.ID, page Page) ([]Post, PageCursors, error)
 
-	// GetPostPageData returns all the data necessary to render a post page
-	GetPostPageData(postID xid.ID) (Post, User, []Comment, map[xid.ID]User, error)
+	// Search returns a page of the posts and comments matching a query, newest first,
+	// along with the cursors to the pages around it. Deleted comments are never found,
+	// authors that are deleted or cannot be found are replaced with DeletedUser
+	Search(ctx context.Context, query SearchQuery, page Page) ([]SearchResult, PageCursors, error)
 
-	// Disconnect gracefully disconnects from a database
-	Disconnect() error
+	// GetPostPageData returns all the data necessary to render a post page,
+	// a page of top level comments oldest first with their replies nested under them, also oldest first,
+	// the cursors to the pages around it and the users keyed by comment id.
+	// Posters and commenters that are deleted or cannot be found are replaced with DeletedUser
+	GetPostPageData(ctx context.Context, postID xid.ID, page Page) (Post, User, []CommentNode, map[xid.ID]User, PageCursors, error)
+	// LatestComments returns up to limit comments of a post newest first, replies at any depth included
+	// and deleted and hidden comments left out, along with the users keyed by comment id.
+	// Commenters that are deleted or cannot be found are replaced with DeletedUser
+	LatestComments(ctx context.Context, postID xid.ID, limit int) ([]Comment, map[xid.ID]User, error)
+
+	// UpdatePost replaces the title and content of a post,
+	// the previous version is kept as a Revision
+	UpdatePost(ctx context.Context, id xid.ID, title, content string) error
+	// UpdateComment replaces the content of a comment that is not deleted,
+	// the previous version is kept as a Revision
+	UpdateComment(ctx context.Context, id xid.ID, content string) error
+	// UpdatePassword replaces the password hash of a user that is not deleted
+	UpdatePassword(ctx context.Context, id xid.ID, password string) error
+	// GetPostRevisions returns the revisions of a post and its comments,
+	// keyed by the id of the post or comment they belong to, oldest first
+	GetPostRevisions(ctx context.Context, postID xid.ID) (map[xid.ID][]Revision, error)
+
+	// AddAttachment records an image uploaded with a post or comment,
+	// the ID and DateCreated of the attachment are set by the database
+	AddAttachment(ctx context.Context, attachment Attachment) (xid.ID, error)
+	// GetAttachment gets an attachment, returns ErrNoAttachmentFound if there is none
+	GetAttachment(ctx context.Context, id xid.ID) (Attachment, error)
+	// PostAttachments returns the attachments of a post and its comments,
+	// keyed by the id of the post or comment they belong to, oldest first
+	PostAttachments(ctx context.Context, postID xid.ID) (map[xid.ID][]Attachment, error)
+	// AttachmentKeys returns the blob keys attachments refer to, of images and thumbnails alike
+	AttachmentKeys(ctx context.Context) (map[string]bool, error)
+
+	// DeletePost removes a post, its comments and their revisions and attachments from the database
+	DeletePost(ctx context.Context, id xid.ID) error
+	// DeleteComment marks a comment as deleted and clears its content, revisions and attachments,
+	// the comment itself is kept so the thread stays intact
+	DeleteComment(ctx context.Context, id xid.ID) error
+	// DeleteUser marks a user as deleted and clears their password and removes their sessions, api tokens
+	// and the attachments they uploaded, the name stays reserved and their posts and comments are kept
+	DeleteUser(ctx context.Context, id xid.ID) error
+
+	// CountUsers returns how many users ever signed up, deleted ones included
+	CountUsers(ctx context.Context) (int, error)
+	// SetUserRole changes the role of a user that is not deleted
+	SetUserRole(ctx context.Context, id xid.ID, role Role) error
+	// SetUserBanned bans or unbans a user that is not deleted
+	SetUserBanned(ctx context.Context, id xid.ID, banned bool) error
+	// SetPostLocked locks or unlocks a post, locked posts take no new comments or edits
+	SetPostLocked(ctx context.Context, id xid.ID, locked bool) error
+	// SetPostHidden hides or unhides a post, hidden posts are only shown to moderators
+	SetPostHidden(ctx context.Context, id xid.ID, hidden bool) error
+	// SetCommentHidden hides or unhides a comment, hidden comments are only shown to moderators
+	SetCommentHidden(ctx context.Context, id xid.ID, hidden bool) error
+
+	// AddSession stores a login session, replacing any session with the same token
+	AddSession(ctx context.Context, session Session) error
+	// GetSession gets a session by its token, expired sessions are returned as well
+	GetSession(ctx context.Context, token string) (Session, error)
+	// DeleteSession removes a session, deleting a missing session is not an error
+	DeleteSession(ctx context.Context, token string) error
+	// DeleteExpiredSessions removes every session that expired before now,
+	// returns how many were removed
+	DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error)
+	// CountSessions returns how many sessions have not expired by now
+	CountSessions(ctx context.Context, now time.Time) (int, error)
+
+	// AddAPIToken stores an api token of a user by the hash of the token
+	AddAPIToken(ctx context.Context, userID xid.ID, name, hash string, scopes []string) (xid.ID, error)
+	// FindAPITokenByHash finds the api token with that hash,
+	// returns ErrNoAPITokenFound if there is none
+	FindAPITokenByHash(ctx context.Context, hash string) (APIToken, error)
+	// UserAPITokens returns the api tokens of a user, oldest first
+	UserAPITokens(ctx context.Context, userID xid.ID) ([]APIToken, error)
+	// DeleteAPIToken revokes an api token of a user,
+	// returns ErrNoAPITokenFound if the user has no such token
+	DeleteAPIToken(ctx context.Context, id, userID xid.ID) error
+	// TouchAPIToken sets when an api token was last used
+	TouchAPIToken(ctx context.Context, id xid.ID, lastUsed time.Time) error
+
+	// Ping checks that the database can be used, for readiness probes
+	Ping(ctx context.Context) error
+	// Disconnect gracefully disconnects from a database, waiting for queries in progress
+	// until ctx is done, after which it returns the error of ctx
+	Disconnect(ctx context.Context) error
 }
 
 type Post struct {
 	Title       string
 	Content     string
 	PosterID    xid.ID
+	BoardID     xid.ID
 	ID          xid.ID
 	CommentIDs  [][]byte
 	DateCreated time.Time
+	// zero if the post was never edited
+	DateEdited time.Time
+	// locked posts take no new comments
+	Locked bool
+	// hidden by a moderator
+	Hidden bool
 }
 
 type Comment struct {
-	Content     string
-	PostID      xid.ID
-	PosterID    xid.ID
+	Content  string
+	PostID   xid.ID
+	PosterID xid.ID
+	// the nil id if the comment replies to the post itself
+	ParentID    xid.ID
 	ID          xid.ID
 	Deleted     bool
 	DateCreated time.Time
+	// zero if the comment was never edited
+	DateEdited time.Time
+	// hidden by a moderator
+	Hidden bool
+}
+
+// CommentNode is a comment along with its replies, oldest first
+type CommentNode struct {
+	Comment
+	Replies []CommentNode
+}
+
+// Revision is a previous version of a post or comment,
+// Title is always empty for comments
+type Revision struct {
+	Title    string
+	Content  string
+	TargetID xid.ID
+	PostID   xid.ID
+	ID       xid.ID
+	// when this version was replaced
+	DateEdited time.Time
+}
+
+// Attachment is an image uploaded with a post or comment, the image and its thumbnail
+// are kept in a blob store under their keys, the database only records them
+type Attachment struct {
+	// the post or comment the attachment belongs to
+	TargetID     xid.ID
+	PostID       xid.ID
+	UploaderID   xid.ID
+	Key          string
+	ThumbnailKey string
+	ContentType  string
+	Width        int
+	Height       int
+	// size of the image in bytes
+	Size        int64
+	ID          xid.ID
+	DateCreated time.Time
 }
 
 type User struct {
@@ -69,6 +242,81 @@ type User struct {
 	Password   string
 	Deleted    bool
 	DateJoined time.Time
+	Role       Role
+	// banned users can still sign in, but not post
+	Banned bool
+}
+
+// Role is what a user is allowed to do, a role can do everything the roles below it can
+type Role int
+
+const (
+	RoleUser Role = iota
+	RoleModerator
+	RoleAdmin
+)
+
+var (
+	roleNames = map[Role]string{
+		RoleUser:      "user",
+		RoleModerator: "moderator",
+		RoleAdmin:     "admin",
+	}
+)
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseRole returns the role with that name, or ErrUnknownRole
+func ParseRole(name string) (Role, error) {
+	for role, n := range roleNames {
+		if n == name {
+			return role, nil
+		}
+	}
+	return RoleUser, ErrUnknownRole
+}
+
+type Board struct {
+	Name        string
+	Slug        string
+	Description string
+	// boards are listed by ascending position, then name
+	Position    int
+	ID          xid.ID
+	DateCreated time.Time
+}
+
+// BoardSummary is a board along with how active it is
+type BoardSummary struct {
+	Board
+	PostCount int
+	// when the newest post or comment in the board was made, zero if the board is empty
+	LatestActivity time.Time
+}
+
+type Session struct {
+	Token  string
+	UserID xid.ID
+	Expiry time.Time
+}
+
+// APIToken is a long lived token a user created for scripts,
+// only the hash of the token is ever stored
+type APIToken struct {
+	Name   string
+	Hash   string
+	UserID xid.ID
+	// what the token may be used for, see the scopes in main
+	Scopes      []string
+	ID          xid.ID
+	DateCreated time.Time
+	// zero if the token was never used
+	LastUsed time.Time
 }
 
 type DBFrontend struct {
@@ -80,23 +328,55 @@ var (
 		Name:       "Deleted",
 		ID:         xid.NilID(),
 		Password:   "",
+		Deleted:    true,
 		DateJoined: time.Time{},
 	}
 )
 
-// Connect connects to the specified database backend
-// Possible values are "json" and "postgres"
-func Connect(backend string) (Database, error) {
-	switch backend {
+// splitScopes splits the comma separated scopes sql backends store
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return []string{}
+	}
+	return strings.Split(scopes, ",")
+}
+
+// Config is what Connect needs to reach a database, only the settings of Backend are used
+type Config struct {
+	// Backend is "json", "postgres" or "sqlite"
+	Backend        string
+	JSONFolderPath string
+	JSONFileName   string
+	SQLiteFilePath string
+	Postgres       PostgresConfig
+}
+
+// closeWithin runs close and waits for it until ctx is done, close keeps running in the background after that
+func closeWithin(ctx context.Context, close func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- close() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Connect connects to the configured database backend
+func Connect(config Config) (Database, error) {
+	switch config.Backend {
 	case "json":
 		interval := 5 * time.Minute
-		js, err := ConnectJSON(interval)
+		js, err := ConnectJSON(config.JSONFolderPath, config.JSONFileName, interval)
 		if err != nil {
 			return nil, err
 		}
 		return js, nil
 	case "postgres":
-		return ConnectPostgres()
+		return ConnectPostgres(config.Postgres)
+	case "sqlite":
+		return ConnectSQLite(config.SQLiteFilePath)
 	default:
 		return nil, ErrUnsupportedDatabaseBackend
 	}
@@ -0,0 +1,269 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// instrumented times every call to the database it wraps
+type instrumented struct {
+	db       Database
+	observer func(method string, duration time.Duration)
+}
+
+// Instrument wraps db so observer is called with the name and duration of every method call,
+// observer is called after the method returns and has to be safe for concurrent use
+func Instrument(db Database, observer func(method string, duration time.Duration)) Database {
+	return &instrumented{db: db, observer: observer}
+}
+
+func (i *instrumented) observe(method string, begin time.Time) {
+	i.observer(method, time.Since(begin))
+}
+
+func (i *instrumented) AddPost(ctx context.Context, title, content string, posterID, boardID xid.ID) (xid.ID, error) {
+	defer i.observe("AddPost", time.Now())
+	return i.db.AddPost(ctx, title, content, posterID, boardID)
+}
+
+func (i *instrumented) AddComment(ctx context.Context, content string, postID, posterID, parentID xid.ID) (xid.ID, error) {
+	defer i.observe("AddComment", time.Now())
+	return i.db.AddComment(ctx, content, postID, posterID, parentID)
+}
+
+func (i *instrumented) AddUser(ctx context.Context, name, password string) (xid.ID, error) {
+	defer i.observe("AddUser", time.Now())
+	return i.db.AddUser(ctx, name, password)
+}
+
+func (i *instrumented) AddBoard(ctx context.Context, name, slug, description string, position int) (xid.ID, error) {
+	defer i.observe("AddBoard", time.Now())
+	return i.db.AddBoard(ctx, name, slug, description, position)
+}
+
+func (i *instrumented) GetPost(ctx context.Context, id xid.ID) (Post, error) {
+	defer i.observe("GetPost", time.Now())
+	return i.db.GetPost(ctx, id)
+}
+
+func (i *instrumented) GetComment(ctx context.Context, id xid.ID) (Comment, error) {
+	defer i.observe("GetComment", time.Now())
+	return i.db.GetComment(ctx, id)
+}
+
+func (i *instrumented) GetUser(ctx context.Context, id xid.ID) (User, error) {
+	defer i.observe("GetUser", time.Now())
+	return i.db.GetUser(ctx, id)
+}
+
+func (i *instrumented) FindUserByName(ctx context.Context, name string) (User, error) {
+	defer i.observe("FindUserByName", time.Now())
+	return i.db.FindUserByName(ctx, name)
+}
+
+func (i *instrumented) GetBoard(ctx context.Context, id xid.ID) (Board, error) {
+	defer i.observe("GetBoard", time.Now())
+	return i.db.GetBoard(ctx, id)
+}
+
+func (i *instrumented) FindBoardBySlug(ctx context.Context, slug string) (Board, error) {
+	defer i.observe("FindBoardBySlug", time.Now())
+	return i.db.FindBoardBySlug(ctx, slug)
+}
+
+func (i *instrumented) AllBoards(ctx context.Context) ([]BoardSummary, error) {
+	defer i.observe("AllBoards", time.Now())
+	return i.db.AllBoards(ctx)
+}
+
+func (i *instrumented) UpdateBoard(ctx context.Context, id xid.ID, name, slug, description string, position int) error {
+	defer i.observe("UpdateBoard", time.Now())
+	return i.db.UpdateBoard(ctx, id, name, slug, description, position)
+}
+
+func (i *instrumented) MovePostsWithoutBoard(ctx context.Context, boardID xid.ID) (int64, error) {
+	defer i.observe("MovePostsWithoutBoard", time.Now())
+	return i.db.MovePostsWithoutBoard(ctx, boardID)
+}
+
+func (i *instrumented) AllPosts(ctx context.Context) ([]Post, error) {
+	defer i.observe("AllPosts", time.Now())
+	return i.db.AllPosts(ctx)
+}
+
+func (i *instrumented) PagePosts(ctx context.Context, page Page) ([]Post, PageCursors, error) {
+	defer i.observe("PagePosts", time.Now())
+	return i.db.PagePosts(ctx, page)
+}
+
+func (i *instrumented) PageBoardPosts(ctx context.Context, boardID xid.ID, page Page) ([]Post, PageCursors, error) {
+	defer i.observe("PageBoardPosts", time.Now())
+	return i.db.PageBoardPosts(ctx, boardID, page)
+}
+
+func (i *instrumented) PageUserPosts(ctx context.Context, posterID xid.ID, page Page) ([]Post, PageCursors, error) {
+	defer i.observe("PageUserPosts", time.Now())
+	return i.db.PageUserPosts(ctx, posterID, page)
+}
+
+func (i *instrumented) Search(ctx context.Context, query SearchQuery, page Page) ([]SearchResult, PageCursors, error) {
+	defer i.observe("Search", time.Now())
+	return i.db.Search(ctx, query, page)
+}
+
+func (i *instrumented) GetPostPageData(ctx context.Context, postID xid.ID, page Page) (Post, User, []CommentNode, map[xid.ID]User, PageCursors, error) {
+	defer i.observe("GetPostPageData", time.Now())
+	return i.db.GetPostPageData(ctx, postID, page)
+}
+
+func (i *instrumented) LatestComments(ctx context.Context, postID xid.ID, limit int) ([]Comment, map[xid.ID]User, error) {
+	defer i.observe("LatestComments", time.Now())
+	return i.db.LatestComments(ctx, postID, limit)
+}
+
+func (i *instrumented) UpdatePost(ctx context.Context, id xid.ID, title, content string) error {
+	defer i.observe("UpdatePost", time.Now())
+	return i.db.UpdatePost(ctx, id, title, content)
+}
+
+func (i *instrumented) UpdateComment(ctx context.Context, id xid.ID, content string) error {
+	defer i.observe("UpdateComment", time.Now())
+	return i.db.UpdateComment(ctx, id, content)
+}
+
+func (i *instrumented) UpdatePassword(ctx context.Context, id xid.ID, password string) error {
+	defer i.observe("UpdatePassword", time.Now())
+	return i.db.UpdatePassword(ctx, id, password)
+}
+
+func (i *instrumented) GetPostRevisions(ctx context.Context, postID xid.ID) (map[xid.ID][]Revision, error) {
+	defer i.observe("GetPostRevisions", time.Now())
+	return i.db.GetPostRevisions(ctx, postID)
+}
+
+func (i *instrumented) AddAttachment(ctx context.Context, attachment Attachment) (xid.ID, error) {
+	defer i.observe("AddAttachment", time.Now())
+	return i.db.AddAttachment(ctx, attachment)
+}
+
+func (i *instrumented) GetAttachment(ctx context.Context, id xid.ID) (Attachment, error) {
+	defer i.observe("GetAttachment", time.Now())
+	return i.db.GetAttachment(ctx, id)
+}
+
+func (i *instrumented) PostAttachments(ctx context.Context, postID xid.ID) (map[xid.ID][]Attachment, error) {
+	defer i.observe("PostAttachments", time.Now())
+	return i.db.PostAttachments(ctx, postID)
+}
+
+func (i *instrumented) AttachmentKeys(ctx context.Context) (map[string]bool, error) {
+	defer i.observe("AttachmentKeys", time.Now())
+	return i.db.AttachmentKeys(ctx)
+}
+
+func (i *instrumented) DeletePost(ctx context.Context, id xid.ID) error {
+	defer i.observe("DeletePost", time.Now())
+	return i.db.DeletePost(ctx, id)
+}
+
+func (i *instrumented) DeleteComment(ctx context.Context, id xid.ID) error {
+	defer i.observe("DeleteComment", time.Now())
+	return i.db.DeleteComment(ctx, id)
+}
+
+func (i *instrumented) DeleteUser(ctx context.Context, id xid.ID) error {
+	defer i.observe("DeleteUser", time.Now())
+	return i.db.DeleteUser(ctx, id)
+}
+
+func (i *instrumented) CountUsers(ctx context.Context) (int, error) {
+	defer i.observe("CountUsers", time.Now())
+	return i.db.CountUsers(ctx)
+}
+
+func (i *instrumented) SetUserRole(ctx context.Context, id xid.ID, role Role) error {
+	defer i.observe("SetUserRole", time.Now())
+	return i.db.SetUserRole(ctx, id, role)
+}
+
+func (i *instrumented) SetUserBanned(ctx context.Context, id xid.ID, banned bool) error {
+	defer i.observe("SetUserBanned", time.Now())
+	return i.db.SetUserBanned(ctx, id, banned)
+}
+
+func (i *instrumented) SetPostLocked(ctx context.Context, id xid.ID, locked bool) error {
+	defer i.observe("SetPostLocked", time.Now())
+	return i.db.SetPostLocked(ctx, id, locked)
+}
+
+func (i *instrumented) SetPostHidden(ctx context.Context, id xid.ID, hidden bool) error {
+	defer i.observe("SetPostHidden", time.Now())
+	return i.db.SetPostHidden(ctx, id, hidden)
+}
+
+func (i *instrumented) SetCommentHidden(ctx context.Context, id xid.ID, hidden bool) error {
+	defer i.observe("SetCommentHidden", time.Now())
+	return i.db.SetCommentHidden(ctx, id, hidden)
+}
+
+func (i *instrumented) AddSession(ctx context.Context, session Session) error {
+	defer i.observe("AddSession", time.Now())
+	return i.db.AddSession(ctx, session)
+}
+
+func (i *instrumented) GetSession(ctx context.Context, token string) (Session, error) {
+	defer i.observe("GetSession", time.Now())
+	return i.db.GetSession(ctx, token)
+}
+
+func (i *instrumented) DeleteSession(ctx context.Context, token string) error {
+	defer i.observe("DeleteSession", time.Now())
+	return i.db.DeleteSession(ctx, token)
+}
+
+func (i *instrumented) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	defer i.observe("DeleteExpiredSessions", time.Now())
+	return i.db.DeleteExpiredSessions(ctx, now)
+}
+
+func (i *instrumented) CountSessions(ctx context.Context, now time.Time) (int, error) {
+	defer i.observe("CountSessions", time.Now())
+	return i.db.CountSessions(ctx, now)
+}
+
+func (i *instrumented) AddAPIToken(ctx context.Context, userID xid.ID, name, hash string, scopes []string) (xid.ID, error) {
+	defer i.observe("AddAPIToken", time.Now())
+	return i.db.AddAPIToken(ctx, userID, name, hash, scopes)
+}
+
+func (i *instrumented) FindAPITokenByHash(ctx context.Context, hash string) (APIToken, error) {
+	defer i.observe("FindAPITokenByHash", time.Now())
+	return i.db.FindAPITokenByHash(ctx, hash)
+}
+
+func (i *instrumented) UserAPITokens(ctx context.Context, userID xid.ID) ([]APIToken, error) {
+	defer i.observe("UserAPITokens", time.Now())
+	return i.db.UserAPITokens(ctx, userID)
+}
+
+func (i *instrumented) DeleteAPIToken(ctx context.Context, id, userID xid.ID) error {
+	defer i.observe("DeleteAPIToken", time.Now())
+	return i.db.DeleteAPIToken(ctx, id, userID)
+}
+
+func (i *instrumented) TouchAPIToken(ctx context.Context, id xid.ID, lastUsed time.Time) error {
+	defer i.observe("TouchAPIToken", time.Now())
+	return i.db.TouchAPIToken(ctx, id, lastUsed)
+}
+
+func (i *instrumented) Ping(ctx context.Context) error {
+	defer i.observe("Ping", time.Now())
+	return i.db.Ping(ctx)
+}
+
+func (i *instrumented) Disconnect(ctx context.Context) error {
+	defer i.observe("Disconnect", time.Now())
+	return i.db.Disconnect(ctx)
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInstrumentedConformance(t *testing.T) {
+	var lock sync.Mutex
+	calls := map[string]int{}
+	runConformanceSuite(t, func(t *testing.T) Database {
+		j, err := ConnectJSON(t.TempDir(), "conformance.json", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := Instrument(j, func(method string, duration time.Duration) {
+			lock.Lock()
+			defer lock.Unlock()
+			calls[method]++
+		})
+		t.Cleanup(func() { db.Disconnect(context.Background()) })
+		return db
+	})
+	for _, method := range []string{"AddUser", "GetPostPageData", "Search", "DeleteExpiredSessions"} {
+		if calls[method] == 0 {
+			t.Error("instrumented database should observe calls to", method, "got:", calls)
+		}
+	}
+}
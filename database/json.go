@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"log"
 	"os"
@@ -13,31 +15,60 @@ import (
 	"github.com/rs/xid"
 )
 
+var (
+	ErrEmptyJSONPath = errors.New("json folder path or file name is empty")
+)
+
 type JSONDatabaseStructure struct {
-	Posts    []Post
-	Comments []Comment
-	Users    []User
+	Boards      []Board
+	Posts       []Post
+	Comments    []Comment
+	Users       []User
+	Revisions   []Revision
+	Sessions    []Session
+	APITokens   []APIToken
+	Attachments []Attachment
 }
 
+// JSONDatabase keeps everything in memory and saves it to a json file every so often.
+// Methods give up with the error of their context if it is done before they start,
+// once they hold their locks they are quick enough to finish
 type JSONDatabase struct {
 	JSONDatabaseStructure
 
-	postsLock    sync.RWMutex
-	commentsLock sync.RWMutex
-	usersLock    sync.RWMutex
+	boardsLock    sync.RWMutex
+	postsLock     sync.RWMutex
+	commentsLock  sync.RWMutex
+	usersLock     sync.RWMutex
+	revisionsLock sync.RWMutex
+	sessionsLock  sync.RWMutex
+	apiTokensLock sync.RWMutex
+	// attachmentsLock is taken after any other lock
+	attachmentsLock sync.RWMutex
+
+	// index is rebuilt on load rather than saved, it has a lock of its own
+	index *invertedIndex
 
 	saveTicker *time.Ticker
-	stopSaving chan bool
+	stopSaving chan struct{}
+	// saverDone is closed once the periodic save has stopped
+	saverDone chan struct{}
+	// lastSave is how long the last successful save took and when it finished
+	lastSave struct {
+		sync.Mutex
+		duration time.Duration
+		at       time.Time
+	}
 
 	backingPath string
 }
 
-func ConnectJSON(saveInterval time.Duration) (*JSONDatabase, error) {
-	if os.Getenv("JSON_FOLDER_PATH") == "" || os.Getenv("JSON_FILE_NAME") == "" {
-		log.Fatalln("json folder path or file name is empty")
+// ConnectJSON loads the database kept in fileName under folder, creating it if it does not exist
+func ConnectJSON(folder, fileName string, saveInterval time.Duration) (*JSONDatabase, error) {
+	if folder == "" || fileName == "" {
+		return nil, ErrEmptyJSONPath
 	}
-	folders := filepath.FromSlash(os.Getenv("JSON_FOLDER_PATH"))
-	fileName := os.Getenv("JSON_FILE_NAME")
+	folders := filepath.FromSlash(folder)
 	if err := os.MkdirAll(folders, 0777); err != nil {
 		return nil, err
 	}
@@ -61,10 +92,21 @@ func ConnectJSON(saveInterval time.Duration) (*JSONDatabase, error) {
 	data := &JSONDatabase{
 		JSONDatabaseStructure: dbStructure,
 		saveTicker:            time.NewTicker(saveInterval),
-		stopSaving:            make(chan bool, 1),
+		stopSaving:            make(chan struct{}),
+		saverDone:             make(chan struct{}),
 		backingPath:           path,
+		index:                 newInvertedIndex(),
+	}
+	for _, p := range data.Posts {
+		data.index.set(p.ID, p.Title+" "+p.Content)
+	}
+	for _, c := range data.Comments {
+		if !c.Deleted {
+			data.index.set(c.ID, c.Content)
+		}
 	}
 	go func() {
+		defer close(data.saverDone)
 		for {
 			select {
 			case <-data.stopSaving:
@@ -80,39 +122,103 @@ func ConnectJSON(saveInterval time.Duration) (*JSONDatabase, error) {
 	return data, nil
 }
 
+// saveDatabase writes the database to a temporary file and renames it over the old one,
+// so a crash halfway through a save does not leave a truncated database behind
 func (j *JSONDatabase) saveDatabase() error {
-	jsonFile, err := os.OpenFile(j.backingPath, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
-	if err != nil {
-		return err
-	}
-	defer jsonFile.Close()
+	begin := time.Now()
+	j.boardsLock.Lock()
 	j.postsLock.Lock()
 	j.commentsLock.Lock()
 	j.usersLock.Lock()
-	defer j.postsLock.Unlock()
-	defer j.commentsLock.Unlock()
-	defer j.usersLock.Unlock()
+	j.revisionsLock.Lock()
+	j.sessionsLock.Lock()
+	j.apiTokensLock.Lock()
+	j.attachmentsLock.Lock()
 	bs, err := json.Marshal(j.JSONDatabaseStructure)
+	j.boardsLock.Unlock()
+	j.postsLock.Unlock()
+	j.commentsLock.Unlock()
+	j.usersLock.Unlock()
+	j.revisionsLock.Unlock()
+	j.sessionsLock.Unlock()
+	j.apiTokensLock.Unlock()
+	j.attachmentsLock.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.backingPath), filepath.Base(j.backingPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err = tmp.Write(bs); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), j.backingPath); err != nil {
+		return err
+	}
+	j.lastSave.Lock()
+	defer j.lastSave.Unlock()
+	j.lastSave.at = time.Now()
+	j.lastSave.duration = j.lastSave.at.Sub(begin)
+	return nil
+}
+
+// LastSave returns how long the last successful save took and when it finished,
+// both are zero if nothing was saved yet
+func (j *JSONDatabase) LastSave() (duration time.Duration, at time.Time) {
+	j.lastSave.Lock()
+	defer j.lastSave.Unlock()
+	return j.lastSave.duration, j.lastSave.at
+}
+
+// Ping checks that the next save can write to the folder of the database,
+// everything else is in memory
+func (j *JSONDatabase) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.backingPath), filepath.Base(j.backingPath)+".ping.*")
 	if err != nil {
 		return err
 	}
-	_, err = jsonFile.Write(bs)
-	return err
+	tmp.Close()
+	return os.Remove(tmp.Name())
 }
 
-func (j *JSONDatabase) Disconnect() error {
-	// TODO: there has to be a race condition here for sure
-	// j.postsLock.Lock()
-	// j.commentsLock.Lock()
-	// j.usersLock.Lock()
-	// defer j.postsLock.Unlock()
-	// defer j.commentsLock.Unlock()
-	// defer j.usersLock.Unlock()
-	j.stopSaving <- true
-	return j.saveDatabase()
+// Disconnect stops the periodic save and waits for a save in progress before saving one last time
+func (j *JSONDatabase) Disconnect(ctx context.Context) error {
+	j.saveTicker.Stop()
+	close(j.stopSaving)
+	select {
+	case <-j.saverDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return closeWithin(ctx, j.saveDatabase)
 }
 
-func (j *JSONDatabase) AddPost(title, content string, posterID xid.ID) (xid.ID, error) {
+func (j *JSONDatabase) AddPost(ctx context.Context, title, content string, posterID, boardID xid.ID) (xid.ID, error) {
+	if err := ctx.Err(); err != nil {
+		return xid.NilID(), err
+	}
+	j.boardsLock.RLock()
+	defer j.boardsLock.RUnlock()
+	if j.boardIndex(boardID) < 0 {
+		return xid.NilID(), ErrNoBoardFoundByID
+	}
 	j.postsLock.Lock()
 	defer j.postsLock.Unlock()
 	newID := xid.New()
@@ -121,37 +227,65 @@ func (j *JSONDatabase) AddPost(title, content string, posterID xid.ID) (xid.ID,
 		Content:     content,
 		ID:          newID,
 		PosterID:    posterID,
+		BoardID:     boardID,
 		DateCreated: time.Now(),
 		CommentIDs:  [][]byte{},
 	}
 	j.Posts = append(j.Posts, newP)
+	j.index.set(newID, title+" "+content)
 	return newID, nil
 }
 
-func (j *JSONDatabase) AddComment(content string, postID, posterID xid.ID) (xid.ID, error) {
+func (j *JSONDatabase) AddComment(ctx context.Context, content string, postID, posterID, parentID xid.ID) (xid.ID, error) {
+	if err := ctx.Err(); err != nil {
+		return xid.NilID(), err
+	}
+	j.postsLock.Lock()
+	defer j.postsLock.Unlock()
+	n := j.postIndex(postID)
+	if n < 0 {
+		return xid.NilID(), ErrNoPostFoundByID
+	}
 	j.commentsLock.Lock()
 	defer j.commentsLock.Unlock()
+	if !parentID.IsNil() {
+		found := false
+		for _, c := range j.Comments {
+			if c.ID == parentID && c.PostID == postID && !c.Deleted {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return xid.NilID(), ErrNoCommentFoundByID
+		}
+	}
 	newID := xid.New()
 	newC := Comment{
 		Content:     content,
 		ID:          newID,
 		PosterID:    posterID,
 		PostID:      postID,
+		ParentID:    parentID,
 		DateCreated: time.Now(),
 	}
 	j.Comments = append(j.Comments, newC)
-	// TODO: this is probably a race condition
-	post, err := j.GetPost(postID)
-	if err != nil {
-		return newID, err
-	}
-	post.CommentIDs = append(post.CommentIDs, newID.Bytes())
+	j.Posts[n].CommentIDs = append(j.Posts[n].CommentIDs, newID.Bytes())
+	j.index.set(newID, content)
 	return newID, nil
 }
 
-func (j *JSONDatabase) AddUser(name, password string) (xid.ID, error) {
+func (j *JSONDatabase) AddUser(ctx context.Context, name, password string) (xid.ID, error) {
+	if err := ctx.Err(); err != nil {
+		return xid.NilID(), err
+	}
 	j.usersLock.Lock()
 	defer j.usersLock.Unlock()
+	for n := range j.Users {
+		if j.Users[n].Name == name {
+			return xid.NilID(), ErrUserNameTaken
+		}
+	}
 	newID := xid.New()
 	newU := User{
 		Name:       name,
@@ -163,18 +297,57 @@ func (j *JSONDatabase) AddUser(name, password string) (xid.ID, error) {
 	return newID, nil
 }
 
-func (j *JSONDatabase) GetPost(id xid.ID) (Post, error) {
+func (j *JSONDatabase) AddBoard(ctx context.Context, name, slug, description string, position int) (xid.ID, error) {
+	if err := ctx.Err(); err != nil {
+		return xid.NilID(), err
+	}
+	j.boardsLock.Lock()
+	defer j.boardsLock.Unlock()
+	for n := range j.Boards {
+		if j.Boards[n].Slug == slug {
+			return xid.NilID(), ErrBoardSlugTaken
+		}
+	}
+	newID := xid.New()
+	newB := Board{
+		Name:        name,
+		Slug:        slug,
+		Description: description,
+		Position:    position,
+		ID:          newID,
+		DateCreated: time.Now(),
+	}
+	j.Boards = append(j.Boards, newB)
+	return newID, nil
+}
+
+func (j *JSONDatabase) GetPost(ctx context.Context, id xid.ID) (Post, error) {
+	if err := ctx.Err(); err != nil {
+		return Post{}, err
+	}
 	j.postsLock.RLock()
 	defer j.postsLock.RUnlock()
+	if n := j.postIndex(id); n >= 0 {
+		return j.Posts[n], nil
+	}
+	return Post{}, ErrNoPostFoundByID
+}
+
+// postIndex returns the index of the post in j.Posts or -1,
+// the caller must hold postsLock
+func (j *JSONDatabase) postIndex(id xid.ID) int {
 	for n := range j.Posts {
 		if j.Posts[n].ID == id {
-			return j.Posts[n], nil
+			return n
 		}
 	}
-	return Post{}, ErrNoPostFoundByID
+	return -1
 }
 
-func (j *JSONDatabase) GetComment(id xid.ID) (Comment, error) {
+func (j *JSONDatabase) GetComment(ctx context.Context, id xid.ID) (Comment, error) {
+	if err := ctx.Err(); err != nil {
+		return Comment{}, err
+	}
 	j.commentsLock.RLock()
 	defer j.commentsLock.RUnlock()
 	for n := range j.Comments {
@@ -185,7 +358,10 @@ func (j *JSONDatabase) GetComment(id xid.ID) (Comment, error) {
 	return Comment{}, ErrNoCommentFoundByID
 }
 
-func (j *JSONDatabase) GetUser(id xid.ID) (User, error) {
+func (j *JSONDatabase) GetUser(ctx context.Context, id xid.ID) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
 	j.usersLock.RLock()
 	defer j.usersLock.RUnlock()
 	for n := range j.Users {
@@ -196,22 +372,155 @@ func (j *JSONDatabase) GetUser(id xid.ID) (User, error) {
 	return User{}, ErrNoUserFoundByID
 }
 
-func (j *JSONDatabase) FindUserByName(name string) (User, error) {
+func (j *JSONDatabase) FindUserByName(ctx context.Context, name string) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
 	j.usersLock.RLock()
 	defer j.usersLock.RUnlock()
 	for n := range j.Users {
-		if j.Users[n].Name == name {
+		if j.Users[n].Name == name && !j.Users[n].Deleted {
 			return j.Users[n], nil
 		}
 	}
 	return User{}, ErrNoUserFoundByName
 }
 
-func (j *JSONDatabase) AllPosts() ([]Post, error) {
-	return j.Posts, nil
+func (j *JSONDatabase) GetBoard(ctx context.Context, id xid.ID) (Board, error) {
+	if err := ctx.Err(); err != nil {
+		return Board{}, err
+	}
+	j.boardsLock.RLock()
+	defer j.boardsLock.RUnlock()
+	if n := j.boardIndex(id); n >= 0 {
+		return j.Boards[n], nil
+	}
+	return Board{}, ErrNoBoardFoundByID
+}
+
+func (j *JSONDatabase) UpdateBoard(ctx context.Context, id xid.ID, name, slug, description string, position int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.boardsLock.Lock()
+	defer j.boardsLock.Unlock()
+	n := j.boardIndex(id)
+	if n < 0 {
+		return ErrNoBoardFoundByID
+	}
+	for _, b := range j.Boards {
+		if b.Slug == slug && b.ID != id {
+			return ErrBoardSlugTaken
+		}
+	}
+	j.Boards[n].Name = name
+	j.Boards[n].Slug = slug
+	j.Boards[n].Description = description
+	j.Boards[n].Position = position
+	return nil
+}
+
+func (j *JSONDatabase) MovePostsWithoutBoard(ctx context.Context, boardID xid.ID) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	j.boardsLock.RLock()
+	defer j.boardsLock.RUnlock()
+	if j.boardIndex(boardID) < 0 {
+		return 0, nil
+	}
+	j.postsLock.Lock()
+	defer j.postsLock.Unlock()
+	var moved int64
+	for n := range j.Posts {
+		if j.Posts[n].BoardID.IsNil() {
+			j.Posts[n].BoardID = boardID
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// boardIndex returns the index of the board in j.Boards or -1,
+// the caller must hold boardsLock
+func (j *JSONDatabase) boardIndex(id xid.ID) int {
+	for n := range j.Boards {
+		if j.Boards[n].ID == id {
+			return n
+		}
+	}
+	return -1
+}
+
+func (j *JSONDatabase) FindBoardBySlug(ctx context.Context, slug string) (Board, error) {
+	if err := ctx.Err(); err != nil {
+		return Board{}, err
+	}
+	j.boardsLock.RLock()
+	defer j.boardsLock.RUnlock()
+	for n := range j.Boards {
+		if j.Boards[n].Slug == slug {
+			return j.Boards[n], nil
+		}
+	}
+	return Board{}, ErrNoBoardFoundBySlug
+}
+
+func (j *JSONDatabase) AllBoards(ctx context.Context) ([]BoardSummary, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	j.boardsLock.RLock()
+	defer j.boardsLock.RUnlock()
+	j.postsLock.RLock()
+	defer j.postsLock.RUnlock()
+	j.commentsLock.RLock()
+	defer j.commentsLock.RUnlock()
+	summaries := make([]BoardSummary, len(j.Boards))
+	byID := make(map[xid.ID]*BoardSummary)
+	for n := range j.Boards {
+		summaries[n].Board = j.Boards[n]
+		byID[j.Boards[n].ID] = &summaries[n]
+	}
+	// the board of every post, to find the board of a comment
+	postBoards := make(map[xid.ID]xid.ID)
+	for _, p := range j.Posts {
+		postBoards[p.ID] = p.BoardID
+		if summary, ok := byID[p.BoardID]; ok {
+			summary.PostCount++
+			if p.DateCreated.After(summary.LatestActivity) {
+				summary.LatestActivity = p.DateCreated
+			}
+		}
+	}
+	for _, c := range j.Comments {
+		if summary, ok := byID[postBoards[c.PostID]]; ok && c.DateCreated.After(summary.LatestActivity) {
+			summary.LatestActivity = c.DateCreated
+		}
+	}
+	sort.SliceStable(summaries, func(a, b int) bool {
+		if summaries[a].Position != summaries[b].Position {
+			return summaries[a].Position < summaries[b].Position
+		}
+		return summaries[a].Name < summaries[b].Name
+	})
+	return summaries, nil
+}
+
+func (j *JSONDatabase) AllPosts(ctx context.Context) ([]Post, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	j.postsLock.RLock()
+	defer j.postsLock.RUnlock()
+	posts := make([]Post, len(j.Posts))
+	copy(posts, j.Posts)
+	return posts, nil
 }
 
 func (j *JSONDatabase) AllCommentsUnderPost(postID xid.ID) ([]Comment, error) {
+	j.commentsLock.RLock()
+	defer j.commentsLock.RUnlock()
 	cs := []Comment{}
 	for _, c := range j.Comments {
 		if c.PostID == postID {
@@ -221,43 +530,704 @@ func (j *JSONDatabase) AllCommentsUnderPost(postID xid.ID) ([]Comment, error) {
 	return cs, nil
 }
 
-func (j *JSONDatabase) GetPostPageData(postID xid.ID) (post Post, poster User, comments []Comment, users map[xid.ID]User, err error) {
-	post, err = j.GetPost(postID)
+func (j *JSONDatabase) GetPostPageData(ctx context.Context, postID xid.ID, page Page) (post Post, poster User, comments []CommentNode, users map[xid.ID]User, cursors PageCursors, err error) {
+	post, err = j.GetPost(ctx, postID)
 	if err != nil {
 		return
 	}
-	poster, err = j.GetUser(post.PosterID)
+	poster, err = j.GetUser(ctx, post.PosterID)
+	if err == ErrNoUserFoundByID || poster.Deleted {
+		poster, err = DeletedUser, nil
+	}
 	if err != nil {
 		return
 	}
-	comments, err = j.AllCommentsUnderPost(postID)
+	all, err := j.AllCommentsUnderPost(postID)
 	if err != nil {
 		return
 	}
-	sort.Slice(comments, func(i, j int) bool {
-		return comments[i].DateCreated.Before(comments[j].DateCreated)
-	})
+	topLevel, replies := []Comment{}, []Comment{}
+	for _, c := range all {
+		if c.ParentID.IsNil() {
+			topLevel = append(topLevel, c)
+		} else {
+			replies = append(replies, c)
+		}
+	}
+	keys := make([]Cursor, len(topLevel))
+	for i, c := range topLevel {
+		keys[i] = c.Cursor()
+	}
+	roots := []Comment{}
+	for _, i := range walkPage(page, false, keys) {
+		roots = append(roots, topLevel[i])
+	}
+	keep, cursors := finishPage(page, len(roots), func(i int) Cursor { return roots[i].Cursor() })
+	roots = roots[:keep]
+	if page.Before {
+		reverseSlice(roots)
+	}
+	sortSliceByDate(replies)
+	comments = buildCommentTree(roots, replies)
 	users = make(map[xid.ID]User)
-	for _, comment := range comments {
-		commenterP, err := j.GetUser(comment.PosterID)
-		if err != nil {
-			users[comment.ID] = DeletedUser
-			continue
+	var addCommenters func(nodes []CommentNode)
+	addCommenters = func(nodes []CommentNode) {
+		for _, node := range nodes {
+			commenterP, err := j.GetUser(ctx, node.PosterID)
+			if err != nil || commenterP.Deleted {
+				users[node.ID] = DeletedUser
+			} else {
+				users[node.ID] = commenterP
+			}
+			addCommenters(node.Replies)
+		}
+	}
+	addCommenters(comments)
+	return
+}
+
+func (j *JSONDatabase) LatestComments(ctx context.Context, postID xid.ID, limit int) (comments []Comment, users map[xid.ID]User, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	all, err := j.AllCommentsUnderPost(postID)
+	if err != nil {
+		return
+	}
+	comments, users = []Comment{}, make(map[xid.ID]User)
+	for _, c := range all {
+		if !c.Deleted && !c.Hidden {
+			comments = append(comments, c)
+		}
+	}
+	sort.Slice(comments, func(a, b int) bool { return comments[b].Cursor().before(comments[a].Cursor()) })
+	if limit < 0 {
+		limit = 0
+	}
+	if len(comments) > limit {
+		comments = comments[:limit]
+	}
+	for _, c := range comments {
+		commenter, err := j.GetUser(ctx, c.PosterID)
+		if err != nil || commenter.Deleted {
+			commenter = DeletedUser
+		}
+		users[c.ID] = commenter
+	}
+	return
+}
+
+func (j *JSONDatabase) PagePosts(ctx context.Context, page Page) ([]Post, PageCursors, error) {
+	all, err := j.AllPosts(ctx)
+	if err != nil {
+		return nil, PageCursors{}, err
+	}
+	posts, cursors := pageJSONPosts(all, page)
+	return posts, cursors, nil
+}
+
+func (j *JSONDatabase) PageBoardPosts(ctx context.Context, boardID xid.ID, page Page) ([]Post, PageCursors, error) {
+	return j.pageFilteredPosts(ctx, page, func(p Post) bool { return p.BoardID == boardID })
+}
+
+func (j *JSONDatabase) PageUserPosts(ctx context.Context, posterID xid.ID, page Page) ([]Post, PageCursors, error) {
+	return j.pageFilteredPosts(ctx, page, func(p Post) bool { return p.PosterID == posterID })
+}
+
+// pageFilteredPosts returns a page of the posts that keep returns true for
+func (j *JSONDatabase) pageFilteredPosts(ctx context.Context, page Page, keep func(Post) bool) ([]Post, PageCursors, error) {
+	all, err := j.AllPosts(ctx)
+	if err != nil {
+		return nil, PageCursors{}, err
+	}
+	kept := []Post{}
+	for _, p := range all {
+		if keep(p) {
+			kept = append(kept, p)
+		}
+	}
+	posts, cursors := pageJSONPosts(kept, page)
+	return posts, cursors, nil
+}
+
+// pageJSONPosts picks a page out of every post that could be on it, leaving out hidden posts unless the page includes them
+func pageJSONPosts(all []Post, page Page) ([]Post, PageCursors) {
+	if !page.IncludeHidden {
+		shown := []Post{}
+		for _, p := range all {
+			if !p.Hidden {
+				shown = append(shown, p)
+			}
+		}
+		all = shown
+	}
+	keys := make([]Cursor, len(all))
+	for i, p := range all {
+		keys[i] = p.Cursor()
+	}
+	posts := []Post{}
+	for _, i := range walkPage(page, true, keys) {
+		posts = append(posts, all[i])
+	}
+	keep, cursors := finishPage(page, len(posts), func(i int) Cursor { return posts[i].Cursor() })
+	posts = posts[:keep]
+	if page.Before {
+		reverseSlice(posts)
+	}
+	return posts, cursors
+}
+
+func (j *JSONDatabase) Search(ctx context.Context, query SearchQuery, page Page) ([]SearchResult, PageCursors, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, PageCursors{}, err
+	}
+	// no terms leaves only the filters
+	var found map[xid.ID]struct{}
+	if terms := SearchTerms(query.Text); len(terms) > 0 {
+		found = j.index.lookup(terms)
+	}
+	matches := func(id xid.ID) bool {
+		_, ok := found[id]
+		return found == nil || ok
+	}
+	j.postsLock.RLock()
+	defer j.postsLock.RUnlock()
+	j.commentsLock.RLock()
+	defer j.commentsLock.RUnlock()
+	posts := make(map[xid.ID]Post, len(j.Posts))
+	all := []SearchResult{}
+	for _, p := range j.Posts {
+		posts[p.ID] = p
+		if matches(p.ID) && query.keeps(p.PosterID, p.DateCreated, p.Hidden) {
+			all = append(all, SearchResult{Post: p})
+		}
+	}
+	for _, c := range j.Comments {
+		post, ok := posts[c.PostID]
+		if ok && !c.Deleted && matches(c.ID) && query.keeps(c.PosterID, c.DateCreated, c.Hidden || post.Hidden) {
+			all = append(all, SearchResult{Post: post, Comment: c})
+		}
+	}
+	keys := make([]Cursor, len(all))
+	for i, result := range all {
+		keys[i] = result.Cursor()
+	}
+	results := []SearchResult{}
+	for _, i := range walkPage(page, true, keys) {
+		results = append(results, all[i])
+	}
+	keep, cursors := finishPage(page, len(results), func(i int) Cursor { return results[i].Cursor() })
+	results = results[:keep]
+	if page.Before {
+		reverseSlice(results)
+	}
+	j.usersLock.RLock()
+	defer j.usersLock.RUnlock()
+	for i := range results {
+		posterID := results[i].Post.PosterID
+		if results[i].IsComment() {
+			posterID = results[i].Comment.PosterID
+		}
+		results[i].Author = DeletedUser
+		for _, u := range j.Users {
+			if u.ID == posterID && !u.Deleted {
+				results[i].Author = u
+				break
+			}
+		}
+	}
+	return results, cursors, nil
+}
+
+func (j *JSONDatabase) UpdatePost(ctx context.Context, id xid.ID, title, content string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.postsLock.Lock()
+	defer j.postsLock.Unlock()
+	n := j.postIndex(id)
+	if n < 0 {
+		return ErrNoPostFoundByID
+	}
+	now := time.Now()
+	j.addRevision(Revision{
+		Title:      j.Posts[n].Title,
+		Content:    j.Posts[n].Content,
+		TargetID:   id,
+		PostID:     id,
+		DateEdited: now,
+	})
+	j.Posts[n].Title = title
+	j.Posts[n].Content = content
+	j.Posts[n].DateEdited = now
+	j.index.set(id, title+" "+content)
+	return nil
+}
+
+func (j *JSONDatabase) UpdateComment(ctx context.Context, id xid.ID, content string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.commentsLock.Lock()
+	defer j.commentsLock.Unlock()
+	for n := range j.Comments {
+		if j.Comments[n].ID == id && !j.Comments[n].Deleted {
+			now := time.Now()
+			j.addRevision(Revision{
+				Content:    j.Comments[n].Content,
+				TargetID:   id,
+				PostID:     j.Comments[n].PostID,
+				DateEdited: now,
+			})
+			j.Comments[n].Content = content
+			j.Comments[n].DateEdited = now
+			j.index.set(id, content)
+			return nil
+		}
+	}
+	return ErrNoCommentFoundByID
+}
+
+func (j *JSONDatabase) UpdatePassword(ctx context.Context, id xid.ID, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.usersLock.Lock()
+	defer j.usersLock.Unlock()
+	for n := range j.Users {
+		if j.Users[n].ID == id && !j.Users[n].Deleted {
+			j.Users[n].Password = password
+			return nil
+		}
+	}
+	return ErrNoUserFoundByID
+}
+
+func (j *JSONDatabase) GetPostRevisions(ctx context.Context, postID xid.ID) (map[xid.ID][]Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	j.revisionsLock.RLock()
+	defer j.revisionsLock.RUnlock()
+	revisions := make(map[xid.ID][]Revision)
+	// revisions are only ever appended, so they are already oldest first
+	for _, r := range j.Revisions {
+		if r.PostID == postID {
+			revisions[r.TargetID] = append(revisions[r.TargetID], r)
+		}
+	}
+	return revisions, nil
+}
+
+// addRevision stores a new revision with a fresh id
+func (j *JSONDatabase) addRevision(r Revision) {
+	j.revisionsLock.Lock()
+	defer j.revisionsLock.Unlock()
+	r.ID = xid.New()
+	j.Revisions = append(j.Revisions, r)
+}
+
+// deleteRevisions removes every revision matching the filter
+func (j *JSONDatabase) deleteRevisions(filter func(Revision) bool) {
+	j.revisionsLock.Lock()
+	defer j.revisionsLock.Unlock()
+	revisions := j.Revisions[:0]
+	for _, r := range j.Revisions {
+		if !filter(r) {
+			revisions = append(revisions, r)
+		}
+	}
+	j.Revisions = revisions
+}
+
+func (j *JSONDatabase) AddAttachment(ctx context.Context, attachment Attachment) (xid.ID, error) {
+	if err := ctx.Err(); err != nil {
+		return xid.NilID(), err
+	}
+	j.attachmentsLock.Lock()
+	defer j.attachmentsLock.Unlock()
+	attachment.ID = xid.New()
+	attachment.DateCreated = time.Now()
+	j.Attachments = append(j.Attachments, attachment)
+	return attachment.ID, nil
+}
+
+func (j *JSONDatabase) GetAttachment(ctx context.Context, id xid.ID) (Attachment, error) {
+	if err := ctx.Err(); err != nil {
+		return Attachment{}, err
+	}
+	j.attachmentsLock.RLock()
+	defer j.attachmentsLock.RUnlock()
+	for n := range j.Attachments {
+		if j.Attachments[n].ID == id {
+			return j.Attachments[n], nil
+		}
+	}
+	return Attachment{}, ErrNoAttachmentFound
+}
+
+func (j *JSONDatabase) PostAttachments(ctx context.Context, postID xid.ID) (map[xid.ID][]Attachment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	j.attachmentsLock.RLock()
+	defer j.attachmentsLock.RUnlock()
+	attachments := make(map[xid.ID][]Attachment)
+	// attachments are only ever appended, so they are already oldest first
+	for _, a := range j.Attachments {
+		if a.PostID == postID {
+			attachments[a.TargetID] = append(attachments[a.TargetID], a)
+		}
+	}
+	return attachments, nil
+}
+
+func (j *JSONDatabase) AttachmentKeys(ctx context.Context) (map[string]bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	j.attachmentsLock.RLock()
+	defer j.attachmentsLock.RUnlock()
+	keys := make(map[string]bool)
+	for _, a := range j.Attachments {
+		keys[a.Key] = true
+		keys[a.ThumbnailKey] = true
+	}
+	return keys, nil
+}
+
+// deleteAttachments removes every attachment matching the filter
+func (j *JSONDatabase) deleteAttachments(filter func(Attachment) bool) {
+	j.attachmentsLock.Lock()
+	defer j.attachmentsLock.Unlock()
+	attachments := j.Attachments[:0]
+	for _, a := range j.Attachments {
+		if !filter(a) {
+			attachments = append(attachments, a)
+		}
+	}
+	j.Attachments = attachments
+}
+
+func (j *JSONDatabase) DeletePost(ctx context.Context, id xid.ID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.postsLock.Lock()
+	defer j.postsLock.Unlock()
+	n := j.postIndex(id)
+	if n < 0 {
+		return ErrNoPostFoundByID
+	}
+	j.commentsLock.Lock()
+	defer j.commentsLock.Unlock()
+	j.Posts = append(j.Posts[:n], j.Posts[n+1:]...)
+	unindexed := []xid.ID{id}
+	comments := j.Comments[:0]
+	for _, c := range j.Comments {
+		if c.PostID != id {
+			comments = append(comments, c)
+		} else {
+			unindexed = append(unindexed, c.ID)
+		}
+	}
+	j.Comments = comments
+	j.index.remove(unindexed...)
+	j.deleteRevisions(func(r Revision) bool { return r.PostID == id })
+	j.deleteAttachments(func(a Attachment) bool { return a.PostID == id })
+	return nil
+}
+
+func (j *JSONDatabase) DeleteComment(ctx context.Context, id xid.ID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.commentsLock.Lock()
+	defer j.commentsLock.Unlock()
+	for n := range j.Comments {
+		if j.Comments[n].ID == id {
+			j.Comments[n].Deleted = true
+			j.Comments[n].Content = ""
+			j.index.remove(id)
+			j.deleteRevisions(func(r Revision) bool { return r.TargetID == id })
+			j.deleteAttachments(func(a Attachment) bool { return a.TargetID == id })
+			return nil
+		}
+	}
+	return ErrNoCommentFoundByID
+}
+
+func (j *JSONDatabase) DeleteUser(ctx context.Context, id xid.ID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.usersLock.Lock()
+	defer j.usersLock.Unlock()
+	for n := range j.Users {
+		if j.Users[n].ID == id {
+			j.Users[n].Deleted = true
+			j.Users[n].Password = ""
+			j.deleteUserTokens(id)
+			j.deleteAttachments(func(a Attachment) bool { return a.UploaderID == id })
+			return nil
+		}
+	}
+	return ErrNoUserFoundByID
+}
+
+// deleteUserTokens removes the sessions and api tokens of a user
+func (j *JSONDatabase) deleteUserTokens(userID xid.ID) {
+	j.sessionsLock.Lock()
+	defer j.sessionsLock.Unlock()
+	sessions := j.Sessions[:0]
+	for _, s := range j.Sessions {
+		if s.UserID != userID {
+			sessions = append(sessions, s)
+		}
+	}
+	j.Sessions = sessions
+	j.apiTokensLock.Lock()
+	defer j.apiTokensLock.Unlock()
+	tokens := j.APITokens[:0]
+	for _, t := range j.APITokens {
+		if t.UserID != userID {
+			tokens = append(tokens, t)
+		}
+	}
+	j.APITokens = tokens
+}
+
+func (j *JSONDatabase) CountUsers(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	j.usersLock.RLock()
+	defer j.usersLock.RUnlock()
+	return len(j.Users), nil
+}
+
+func (j *JSONDatabase) SetUserRole(ctx context.Context, id xid.ID, role Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return j.updateUser(id, func(u *User) { u.Role = role })
+}
+
+func (j *JSONDatabase) SetUserBanned(ctx context.Context, id xid.ID, banned bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return j.updateUser(id, func(u *User) { u.Banned = banned })
+}
+
+// updateUser applies update to the user with id if they are not deleted
+func (j *JSONDatabase) updateUser(id xid.ID, update func(*User)) error {
+	j.usersLock.Lock()
+	defer j.usersLock.Unlock()
+	for n := range j.Users {
+		if j.Users[n].ID == id && !j.Users[n].Deleted {
+			update(&j.Users[n])
+			return nil
+		}
+	}
+	return ErrNoUserFoundByID
+}
+
+func (j *JSONDatabase) SetPostLocked(ctx context.Context, id xid.ID, locked bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.postsLock.Lock()
+	defer j.postsLock.Unlock()
+	n := j.postIndex(id)
+	if n < 0 {
+		return ErrNoPostFoundByID
+	}
+	j.Posts[n].Locked = locked
+	return nil
+}
+
+func (j *JSONDatabase) SetPostHidden(ctx context.Context, id xid.ID, hidden bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.postsLock.Lock()
+	defer j.postsLock.Unlock()
+	n := j.postIndex(id)
+	if n < 0 {
+		return ErrNoPostFoundByID
+	}
+	j.Posts[n].Hidden = hidden
+	return nil
+}
+
+func (j *JSONDatabase) SetCommentHidden(ctx context.Context, id xid.ID, hidden bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.commentsLock.Lock()
+	defer j.commentsLock.Unlock()
+	for n := range j.Comments {
+		if j.Comments[n].ID == id {
+			j.Comments[n].Hidden = hidden
+			return nil
+		}
+	}
+	return ErrNoCommentFoundByID
+}
+
+func (j *JSONDatabase) AddSession(ctx context.Context, session Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.sessionsLock.Lock()
+	defer j.sessionsLock.Unlock()
+	for n := range j.Sessions {
+		if j.Sessions[n].Token == session.Token {
+			j.Sessions[n] = session
+			return nil
+		}
+	}
+	j.Sessions = append(j.Sessions, session)
+	return nil
+}
+
+func (j *JSONDatabase) GetSession(ctx context.Context, token string) (Session, error) {
+	if err := ctx.Err(); err != nil {
+		return Session{}, err
+	}
+	j.sessionsLock.RLock()
+	defer j.sessionsLock.RUnlock()
+	for n := range j.Sessions {
+		if j.Sessions[n].Token == token {
+			return j.Sessions[n], nil
+		}
+	}
+	return Session{}, ErrNoSessionFoundByToken
+}
+
+func (j *JSONDatabase) DeleteSession(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.sessionsLock.Lock()
+	defer j.sessionsLock.Unlock()
+	for n := range j.Sessions {
+		if j.Sessions[n].Token == token {
+			j.Sessions = append(j.Sessions[:n], j.Sessions[n+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (j *JSONDatabase) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	j.sessionsLock.Lock()
+	defer j.sessionsLock.Unlock()
+	sessions := j.Sessions[:0]
+	for _, s := range j.Sessions {
+		if !s.Expiry.Before(now) {
+			sessions = append(sessions, s)
+		}
+	}
+	deleted := int64(len(j.Sessions) - len(sessions))
+	j.Sessions = sessions
+	return deleted, nil
+}
+
+func (j *JSONDatabase) CountSessions(ctx context.Context, now time.Time) (count int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	j.sessionsLock.RLock()
+	defer j.sessionsLock.RUnlock()
+	for _, s := range j.Sessions {
+		if !s.Expiry.Before(now) {
+			count++
 		}
-		users[comment.ID] = commenterP
 	}
 	return
 }
 
-func (j *JSONDatabase) PagePosts(start, end int) ([]Post, error) {
-	// check bounds
-	if start < 0 {
-		start = 0
+func (j *JSONDatabase) AddAPIToken(ctx context.Context, userID xid.ID, name, hash string, scopes []string) (xid.ID, error) {
+	if err := ctx.Err(); err != nil {
+		return xid.NilID(), err
+	}
+	j.apiTokensLock.Lock()
+	defer j.apiTokensLock.Unlock()
+	newID := xid.New()
+	j.APITokens = append(j.APITokens, APIToken{
+		Name:        name,
+		Hash:        hash,
+		UserID:      userID,
+		Scopes:      scopes,
+		ID:          newID,
+		DateCreated: time.Now(),
+	})
+	return newID, nil
+}
+
+func (j *JSONDatabase) FindAPITokenByHash(ctx context.Context, hash string) (APIToken, error) {
+	if err := ctx.Err(); err != nil {
+		return APIToken{}, err
+	}
+	j.apiTokensLock.RLock()
+	defer j.apiTokensLock.RUnlock()
+	for n := range j.APITokens {
+		if j.APITokens[n].Hash == hash {
+			return j.APITokens[n], nil
+		}
+	}
+	return APIToken{}, ErrNoAPITokenFound
+}
+
+func (j *JSONDatabase) UserAPITokens(ctx context.Context, userID xid.ID) ([]APIToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	if end > len(j.Posts) {
-		end = len(j.Posts)
+	j.apiTokensLock.RLock()
+	defer j.apiTokensLock.RUnlock()
+	// tokens are only ever appended, so they are already oldest first
+	tokens := []APIToken{}
+	for _, token := range j.APITokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
 	}
-	return j.Posts[start:end], nil
+	return tokens, nil
+}
+
+func (j *JSONDatabase) DeleteAPIToken(ctx context.Context, id, userID xid.ID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.apiTokensLock.Lock()
+	defer j.apiTokensLock.Unlock()
+	for n := range j.APITokens {
+		if j.APITokens[n].ID == id && j.APITokens[n].UserID == userID {
+			j.APITokens = append(j.APITokens[:n], j.APITokens[n+1:]...)
+			return nil
+		}
+	}
+	return ErrNoAPITokenFound
+}
+
+func (j *JSONDatabase) TouchAPIToken(ctx context.Context, id xid.ID, lastUsed time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.apiTokensLock.Lock()
+	defer j.apiTokensLock.Unlock()
+	for n := range j.APITokens {
+		if j.APITokens[n].ID == id {
+			j.APITokens[n].LastUsed = lastUsed
+			return nil
+		}
+	}
+	return ErrNoAPITokenFound
 }
 
 func sortSliceByDate(slice interface{}) {
@@ -276,3 +1246,31 @@ func sortSliceByDate(slice interface{}) {
 		})
 	}
 }
+
+// walkPage returns the indices of the items in a page, keys are the cursors of every item
+// in the list and newestFirst tells the order it is shown in. The indices follow the direction
+// of the page and there is one more than page.Limit if the walk can go on
+func walkPage(page Page, newestFirst bool, keys []Cursor) []int {
+	if page.Limit <= 0 {
+		return []int{}
+	}
+	descending := page.descending(newestFirst)
+	indices := []int{}
+	for i, key := range keys {
+		if page.Cursor.IsZero() ||
+			(descending && key.before(page.Cursor)) ||
+			(!descending && page.Cursor.before(key)) {
+			indices = append(indices, i)
+		}
+	}
+	sort.Slice(indices, func(a, b int) bool {
+		if descending {
+			return keys[indices[b]].before(keys[indices[a]])
+		}
+		return keys[indices[a]].before(keys[indices[b]])
+	})
+	if len(indices) > page.Limit+1 {
+		indices = indices[:page.Limit+1]
+	}
+	return indices
+}
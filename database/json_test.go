@@ -1,26 +1,30 @@
 package database
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/rs/xid"
 )
 
 func TestConnectJSON(t *testing.T) {
-	os.Setenv("JSON_FOLDER_PATH", "carrotbb/storage")
-	os.Setenv("JSON_FILE_NAME", "testdatabase.json")
-	j, err := ConnectJSON(3 * time.Second)
+	ctx := context.Background()
+	folder := t.TempDir()
+	j, err := ConnectJSON(folder, "testdatabase.json", 3*time.Second)
 	if err != nil {
 		t.Log(err)
 		t.FailNow()
 	}
-	if _, err = j.AddUser("courtier", "courtier"); err != nil {
+	if _, err = j.AddUser(ctx, "courtier", "courtier"); err != nil {
 		t.Log(err)
 		t.FailNow()
 	}
 	time.Sleep(5 * time.Second)
-	j.Disconnect()
-	j, err = ConnectJSON(30 * time.Second)
+	j.Disconnect(context.Background())
+	j, err = ConnectJSON(folder, "testdatabase.json", 30*time.Second)
 	if err != nil {
 		t.Log(err)
 		t.FailNow()
@@ -31,6 +35,56 @@ func TestConnectJSON(t *testing.T) {
 	}
 }
 
+func TestJSONDisconnectSaves(t *testing.T) {
+	ctx := context.Background()
+	folder := t.TempDir()
+	j, err := ConnectJSON(folder, "testdatabase.json", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = j.AddUser(ctx, "courtier", "courtier"); err != nil {
+		t.Fatal(err)
+	}
+	if _, at := j.LastSave(); !at.IsZero() {
+		t.Error("LastSave before any save expected zero, got:", at)
+	}
+	if err = j.Disconnect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if duration, at := j.LastSave(); at.IsZero() || duration <= 0 {
+		t.Error("LastSave after disconnect expected the final save, got:", duration, at)
+	}
+	j, err = ConnectJSON(folder, "testdatabase.json", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Disconnect(context.Background())
+	if len(j.Users) != 1 {
+		t.Error("disconnect should save changes made since the last save, got users:", j.Users)
+	}
+	files, err := os.ReadDir(folder)
+	if err != nil || len(files) != 1 {
+		t.Error("saving should not leave temporary files behind, got:", files, err)
+	}
+}
+
+func TestJSONPing(t *testing.T) {
+	folder := filepath.Join(t.TempDir(), "storage")
+	j, err := ConnectJSON(folder, "testdatabase.json", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.RemoveAll(folder); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Ping(context.Background()); err == nil {
+		t.Error("Ping expected an error when the database cannot be saved")
+	}
+}
+
 func TestSortSliceByDate(t *testing.T) {
 	const POST_AMOUNT = 10
 	posts := []Post{}
@@ -57,3 +111,27 @@ func TestSortSliceByDate(t *testing.T) {
 		}
 	}
 }
+
+func TestJSONMovePostsWithoutBoard(t *testing.T) {
+	ctx := context.Background()
+	j, err := ConnectJSON(filepath.Join(t.TempDir(), "storage"), "testdatabase.json", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Disconnect(context.Background())
+	boardID, err := j.AddBoard(ctx, "general", "general", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, err := j.AddPost(ctx, "title", "content", xid.New(), boardID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Posts[0].BoardID = xid.NilID()
+	if moved, err := j.MovePostsWithoutBoard(ctx, boardID); err != nil || moved != 1 {
+		t.Error("MovePostsWithoutBoard expected to move 1 post, got:", moved, err)
+	}
+	if post, err := j.GetPost(ctx, postID); err != nil || post.BoardID != boardID {
+		t.Error("the post should be in the board, got:", post.BoardID, err)
+	}
+}
@@ -0,0 +1,254 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var postgresMigrationFiles embed.FS
+
+const (
+	// MIGRATION_LOCK_KEY is the postgres advisory lock held while migrating,
+	// so instances starting together do not run the same migration twice
+	MIGRATION_LOCK_KEY = 0x63617272
+)
+
+var (
+	ErrMalformedMigration   = errors.New("migrations have to be named like 0001_name.up.sql and 0001_name.down.sql")
+	ErrMissingMigration     = errors.New("migrations have to be numbered from 1 without gaps, each with an up and a down")
+	ErrUnknownSchemaVersion = errors.New("the database was migrated by a newer version, it has migrations this version does not know")
+	ErrNoMigrationApplied   = errors.New("no migrations are applied")
+
+	migrationFileName = regexp.MustCompile(`^(\d{4})_([a-z0-9_]+)\.(up|down)\.sql$`)
+)
+
+// Migration is one numbered step of the schema, Down undoes what Up does
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// String is the name of the files of m, without their direction
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// MigrationStatus is a migration and whether, and when, it was applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// loadMigrations reads the migrations in the root of fsys, in order of their version
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil || entry.IsDir() {
+			return nil, fmt.Errorf("%w: %s", ErrMalformedMigration, entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("%w: %s does not match %s", ErrMalformedMigration, entry.Name(), m.Name)
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for n, m := range migrations {
+		if m.Version != n+1 || m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("%w: %s", ErrMissingMigration, m)
+		}
+	}
+	return migrations, nil
+}
+
+// postgresMigrations are the migrations built into the binary
+func postgresMigrations() ([]Migration, error) {
+	fsys, err := fs.Sub(postgresMigrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return loadMigrations(fsys)
+}
+
+// pendingMigrations are the migrations not in applied, it fails if applied has versions migrations does not
+func pendingMigrations(migrations []Migration, applied map[int]time.Time) ([]Migration, error) {
+	for version := range applied {
+		if version < 1 || version > len(migrations) {
+			return nil, fmt.Errorf("%w: version %d", ErrUnknownSchemaVersion, version)
+		}
+	}
+	pending := []Migration{}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// withMigrationLock runs migrate on a connection holding the migration lock,
+// the schema_version table is created first so migrate can rely on it
+func (p *PostgresDatabase) withMigrationLock(ctx context.Context, migrate func(conn *pgxpool.Conn, applied map[int]time.Time) error) (err error) {
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Release()
+	if _, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, MIGRATION_LOCK_KEY); err != nil {
+		return
+	}
+	// the lock belongs to the session, it has to be given back even if ctx is done
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, MIGRATION_LOCK_KEY)
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+	version			integer PRIMARY KEY,
+	name			text NOT NULL,
+	applied_at		timestamp NOT NULL
+)`)
+	if err != nil {
+		return
+	}
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return
+	}
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			rows.Close()
+			return
+		}
+		applied[version] = appliedAt
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+	return migrate(conn, applied)
+}
+
+// MigrationStatus lists every migration this version knows and whether it was applied
+func (p *PostgresDatabase) MigrationStatus(ctx context.Context) (statuses []MigrationStatus, err error) {
+	migrations, err := postgresMigrations()
+	if err != nil {
+		return
+	}
+	err = p.withMigrationLock(ctx, func(conn *pgxpool.Conn, applied map[int]time.Time) error {
+		if _, err := pendingMigrations(migrations, applied); err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			appliedAt, ok := applied[m.Version]
+			statuses = append(statuses, MigrationStatus{Migration: m, Applied: ok, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+	return
+}
+
+// MigrateUp applies every pending migration in order, each in a transaction of its own,
+// and returns the ones it applied
+func (p *PostgresDatabase) MigrateUp(ctx context.Context) (done []Migration, err error) {
+	migrations, err := postgresMigrations()
+	if err != nil {
+		return
+	}
+	err = p.withMigrationLock(ctx, func(conn *pgxpool.Conn, applied map[int]time.Time) error {
+		pending, err := pendingMigrations(migrations, applied)
+		if err != nil {
+			return err
+		}
+		for _, m := range pending {
+			err = runMigration(ctx, conn, m, m.Up,
+				`INSERT INTO schema_version(version, name, applied_at) VALUES ($1, $2, $3)`, m.Version, m.Name, time.Now())
+			if err != nil {
+				return err
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return
+}
+
+// MigrateDown reverts the latest applied migration and returns it
+func (p *PostgresDatabase) MigrateDown(ctx context.Context) (undone Migration, err error) {
+	migrations, err := postgresMigrations()
+	if err != nil {
+		return
+	}
+	err = p.withMigrationLock(ctx, func(conn *pgxpool.Conn, applied map[int]time.Time) error {
+		if _, err := pendingMigrations(migrations, applied); err != nil {
+			return err
+		}
+		latest := 0
+		for version := range applied {
+			if version > latest {
+				latest = version
+			}
+		}
+		if latest == 0 {
+			return ErrNoMigrationApplied
+		}
+		m := migrations[latest-1]
+		if err := runMigration(ctx, conn, m, m.Down, `DELETE FROM schema_version WHERE version=$1`, m.Version); err != nil {
+			return err
+		}
+		undone = m
+		return nil
+	})
+	return
+}
+
+// runMigration runs script, one direction of m, and records it with record in the same transaction
+func runMigration(ctx context.Context, conn *pgxpool.Conn, m Migration, script, record string, args ...interface{}) (err error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(context.Background())
+	if _, err = tx.Exec(ctx, script); err != nil {
+		return fmt.Errorf("migration %s: %w", m, err)
+	}
+	if _, err = tx.Exec(ctx, record, args...); err != nil {
+		return
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,7 @@
+-- the initial migration is not reverted, it would drop every table and everything in them.
+-- To start over, drop the database and create it again
+DO $$
+BEGIN
+	RAISE EXCEPTION 'the initial migration cannot be reverted, it would drop every table';
+END
+$$;
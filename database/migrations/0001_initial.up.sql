@@ -0,0 +1,123 @@
+-- the schema from before migrations were versioned, it only creates what is missing
+-- so databases set up by older versions are brought up to date instead of failing
+
+CREATE TABLE IF NOT EXISTS boards (
+	name			text,
+	slug			text UNIQUE,
+	description		text,
+	position		integer,
+	id				text PRIMARY KEY,
+	date_created	timestamp
+);
+
+CREATE TABLE IF NOT EXISTS posts (
+	title			text,
+	content			text,
+	poster_id		text,
+	board_id		text,
+	id				text PRIMARY KEY,
+	comment_ids		text ARRAY,
+	date_created	timestamp,
+	date_edited		timestamp,
+	locked			boolean NOT NULL DEFAULT false,
+	hidden			boolean NOT NULL DEFAULT false,
+	search			tsvector GENERATED ALWAYS AS (to_tsvector('simple', coalesce(title, '') || ' ' || coalesce(content, ''))) STORED
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+	content			text,
+	post_id			text,
+	poster_id		text,
+	parent_id		text,
+	id				text PRIMARY KEY,
+	date_created	timestamp,
+	deleted			boolean NOT NULL DEFAULT false,
+	date_edited		timestamp,
+	hidden			boolean NOT NULL DEFAULT false,
+	search			tsvector GENERATED ALWAYS AS (to_tsvector('simple', coalesce(content, ''))) STORED
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	name			text UNIQUE,
+	id				text,
+	password		text PRIMARY KEY,
+	date_joined		timestamp,
+	deleted			boolean NOT NULL DEFAULT false,
+	role			integer NOT NULL DEFAULT 0,
+	banned			boolean NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS revisions (
+	title			text,
+	content			text,
+	target_id		text,
+	post_id			text,
+	id				text PRIMARY KEY,
+	date_edited		timestamp
+);
+
+CREATE INDEX IF NOT EXISTS revisions_post_id ON revisions(post_id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	token			text PRIMARY KEY,
+	user_id			text,
+	expiry			timestamp
+);
+
+CREATE INDEX IF NOT EXISTS sessions_expiry ON sessions(expiry);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	name			text,
+	hash			text UNIQUE,
+	user_id			text,
+	scopes			text,
+	id				text PRIMARY KEY,
+	date_created	timestamp,
+	last_used		timestamp
+);
+
+CREATE INDEX IF NOT EXISTS api_tokens_user_id ON api_tokens(user_id);
+
+CREATE TABLE IF NOT EXISTS attachments (
+	target_id		text,
+	post_id			text,
+	uploader_id		text,
+	blob_key		text,
+	thumbnail_key	text,
+	content_type	text,
+	width			integer,
+	height			integer,
+	size			bigint,
+	id				text PRIMARY KEY,
+	date_created	timestamp
+);
+
+CREATE INDEX IF NOT EXISTS attachments_post_id ON attachments(post_id);
+CREATE INDEX IF NOT EXISTS attachments_target_id ON attachments(target_id);
+
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted boolean NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted boolean NOT NULL DEFAULT false;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS date_edited timestamp;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS date_edited timestamp;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS board_id text;
+
+CREATE INDEX IF NOT EXISTS posts_board_id ON posts(board_id, date_created);
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS parent_id text;
+
+CREATE INDEX IF NOT EXISTS comments_parent_id ON comments(parent_id);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role integer NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS banned boolean NOT NULL DEFAULT false;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS locked boolean NOT NULL DEFAULT false;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS hidden boolean NOT NULL DEFAULT false;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS hidden boolean NOT NULL DEFAULT false;
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS search tsvector
+	GENERATED ALWAYS AS (to_tsvector('simple', coalesce(title, '') || ' ' || coalesce(content, ''))) STORED;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS search tsvector
+	GENERATED ALWAYS AS (to_tsvector('simple', coalesce(content, ''))) STORED;
+
+CREATE INDEX IF NOT EXISTS posts_search ON posts USING GIN (search);
+CREATE INDEX IF NOT EXISTS comments_search ON comments USING GIN (search);
+
+CREATE INDEX IF NOT EXISTS posts_poster_id ON posts(poster_id, date_created);
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP CONSTRAINT users_pkey;
+UPDATE users SET password=id WHERE deleted;
+ALTER TABLE users ADD PRIMARY KEY (password);
@@ -0,0 +1,4 @@
+-- users were keyed by their password, so deleting a user had to put their id in it
+ALTER TABLE users DROP CONSTRAINT users_pkey;
+UPDATE users SET password='' WHERE deleted;
+ALTER TABLE users ADD PRIMARY KEY (id);
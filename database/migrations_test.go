@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(content string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(content)}
+	}
+	migrations, err := loadMigrations(fstest.MapFS{
+		"0002_second.up.sql":   file("up 2"),
+		"0001_first.down.sql":  file("down 1"),
+		"0001_first.up.sql":    file("up 1"),
+		"0002_second.down.sql": file("down 2"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Migration{{1, "first", "up 1", "down 1"}, {2, "second", "up 2", "down 2"}}
+	if len(migrations) != len(expected) || migrations[0] != expected[0] || migrations[1] != expected[1] {
+		t.Error("loadMigrations expected:", expected, "got:", migrations)
+	}
+
+	for name, broken := range map[string]struct {
+		fsys fstest.MapFS
+		err  error
+	}{
+		"gap":           {fstest.MapFS{"0001_a.up.sql": file("up"), "0001_a.down.sql": file("down"), "0003_c.up.sql": file("up"), "0003_c.down.sql": file("down")}, ErrMissingMigration},
+		"no down":       {fstest.MapFS{"0001_a.up.sql": file("up")}, ErrMissingMigration},
+		"bad name":      {fstest.MapFS{"1_a.up.sql": file("up")}, ErrMalformedMigration},
+		"names differ":  {fstest.MapFS{"0001_a.up.sql": file("up"), "0001_b.down.sql": file("down")}, ErrMalformedMigration},
+		"not migration": {fstest.MapFS{"readme.md": file("")}, ErrMalformedMigration},
+	} {
+		if _, err := loadMigrations(broken.fsys); !errors.Is(err, broken.err) {
+			t.Error(name, "expected:", broken.err, "got:", err)
+		}
+	}
+}
+
+func TestPostgresMigrationsLoad(t *testing.T) {
+	migrations, err := postgresMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) < 2 || migrations[0].String() != "0001_initial" {
+		t.Error("expected the built in migrations to start with 0001_initial, got:", migrations)
+	}
+	if len(migrations) > 0 && !strings.Contains(migrations[0].Down, "RAISE EXCEPTION") {
+		t.Error("reverting 0001_initial should fail instead of dropping every table, got:", migrations[0].Down)
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	migrations := []Migration{{Version: 1}, {Version: 2}, {Version: 3}}
+	pending, err := pendingMigrations(migrations, map[int]time.Time{1: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Version != 2 || pending[1].Version != 3 {
+		t.Error("expected migrations 2 and 3 to be pending, got:", pending)
+	}
+	if _, err = pendingMigrations(migrations, map[int]time.Time{4: time.Now()}); !errors.Is(err, ErrUnknownSchemaVersion) {
+		t.Error("a newer schema expected:", ErrUnknownSchemaVersion, "got:", err)
+	}
+}
+
+// TestPostgresMigrate needs a disposable database, every migration but the initial one gets reverted
+func TestPostgresMigrate(t *testing.T) {
+	if os.Getenv("POSTGRES_TEST") == "" {
+		t.Skip("set POSTGRES_TEST and the POSTGRES_* variables to run against a disposable database")
+	}
+	ctx := context.Background()
+	p, err := ConnectPostgres(PostgresConfig{
+		Host:     "localhost",
+		Port:     5432,
+		User:     os.Getenv("POSTGRES_USER"),
+		Password: os.Getenv("POSTGRES_PASSWORD"),
+		Database: os.Getenv("POSTGRES_DB"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Disconnect(context.Background())
+	migrations, err := postgresMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := p.MigrateUp(ctx)
+	if err != nil || len(done) != 0 {
+		t.Fatal("connecting should have migrated already, got:", done, err)
+	}
+
+	for n := len(migrations); n > 1; n-- {
+		undone, err := p.MigrateDown(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if undone.Version != n {
+			t.Error("MigrateDown expected to revert:", n, "got:", undone.Version)
+		}
+	}
+	if _, err = p.MigrateDown(ctx); err == nil {
+		t.Error("MigrateDown expected the initial migration to refuse being reverted")
+	}
+	if _, err = p.AllBoards(ctx); err != nil {
+		t.Error("the tables should survive reverting the initial migration, got:", err)
+	}
+
+	if done, err = p.MigrateUp(ctx); err != nil || len(done) != len(migrations)-1 {
+		t.Fatal("MigrateUp expected to apply every migration but the initial one, got:", done, err)
+	}
+	statuses, err := p.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.AppliedAt.IsZero() {
+			t.Error("expected every migration to be applied, got:", status)
+		}
+	}
+}
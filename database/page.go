@@ -0,0 +1,168 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+var (
+	ErrMalformedCursor = errors.New("malformed page cursor")
+)
+
+// Cursor marks a position in a list ordered by creation date,
+// the id breaks ties between items created at the same time
+type Cursor struct {
+	DateCreated time.Time
+	ID          xid.ID
+}
+
+// IsZero reports whether the cursor points nowhere
+func (c Cursor) IsZero() bool {
+	return c.ID.IsNil()
+}
+
+// String encodes the cursor to be put in urls, it can be decoded with ParseCursor
+func (c Cursor) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(c.DateCreated.UnixNano(), 36) + "." + c.ID.String()
+}
+
+// ParseCursor decodes a cursor encoded with Cursor.String, an empty string is the zero cursor
+func ParseCursor(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+	split := strings.SplitN(s, ".", 2)
+	if len(split) != 2 {
+		return Cursor{}, ErrMalformedCursor
+	}
+	nanos, err := strconv.ParseInt(split[0], 36, 64)
+	if err != nil {
+		return Cursor{}, ErrMalformedCursor
+	}
+	id, err := xid.FromString(split[1])
+	if err != nil || id.IsNil() {
+		return Cursor{}, ErrMalformedCursor
+	}
+	return Cursor{DateCreated: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+// before reports whether c comes before other, oldest first
+func (c Cursor) before(other Cursor) bool {
+	if !c.DateCreated.Equal(other.DateCreated) {
+		return c.DateCreated.Before(other.DateCreated)
+	}
+	return c.ID.Compare(other.ID) < 0
+}
+
+func (p Post) Cursor() Cursor {
+	return Cursor{DateCreated: p.DateCreated, ID: p.ID}
+}
+
+func (c Comment) Cursor() Cursor {
+	return Cursor{DateCreated: c.DateCreated, ID: c.ID}
+}
+
+// Page selects up to Limit items right after Cursor in the order the list is shown in,
+// or right before it if Before is set. The zero Cursor is the start of the list,
+// or its end if Before is set
+type Page struct {
+	Cursor Cursor
+	Before bool
+	Limit  int
+	// IncludeHidden also lists hidden posts, for moderators. Only pages of posts look at it,
+	// hidden comments stay in their threads
+	IncludeHidden bool
+}
+
+// descending reports whether walking a list in the direction of the page
+// goes from newer to older items, newestFirst tells the order the list is shown in
+func (p Page) descending(newestFirst bool) bool {
+	return newestFirst != p.Before
+}
+
+// sqlKeyset returns the comparison operator that keeps the rows past the cursor and
+// the direction to order the rows by, to walk a list in the direction of the page
+func (p Page) sqlKeyset(newestFirst bool) (cmp, order string) {
+	if p.descending(newestFirst) {
+		return "<", "DESC"
+	}
+	return ">", "ASC"
+}
+
+// PageCursors point to the pages around a page, a zero cursor means there is no page that way
+type PageCursors struct {
+	Previous Cursor
+	Next     Cursor
+}
+
+// finishPage takes the n items fetched walking in the direction of page, which are one more
+// than page.Limit if the walk can go on, and returns how many of them to keep and the cursors
+// to the pages around them. cursor returns the cursor of the i-th fetched item
+func finishPage(page Page, n int, cursor func(i int) Cursor) (keep int, cursors PageCursors) {
+	keep = n
+	if keep > page.Limit {
+		keep = page.Limit
+	}
+	if keep <= 0 {
+		return 0, cursors
+	}
+	// the page was reached from its cursor, so unless that was an end of the list
+	// there is always a page back that way
+	first, last := cursor(0), cursor(keep-1)
+	if page.Before {
+		if n > keep {
+			cursors.Previous = last
+		}
+		if !page.Cursor.IsZero() {
+			cursors.Next = first
+		}
+	} else {
+		if n > keep {
+			cursors.Next = last
+		}
+		if !page.Cursor.IsZero() {
+			cursors.Previous = first
+		}
+	}
+	return
+}
+
+// reverseSlice reverses a slice in place, pages walked with Before
+// are fetched backwards and have to be turned around
+func reverseSlice(slice interface{}) {
+	swap := reflect.Swapper(slice)
+	n := reflect.ValueOf(slice).Len()
+	for i := 0; i < n/2; i++ {
+		swap(i, n-1-i)
+	}
+}
+
+// buildCommentTree nests replies under the top level comments of a page,
+// replies have to be oldest first and those whose parent is not in the tree are left out
+func buildCommentTree(roots, replies []Comment) []CommentNode {
+	children := make(map[xid.ID][]Comment)
+	for _, reply := range replies {
+		children[reply.ParentID] = append(children[reply.ParentID], reply)
+	}
+	var build func(comment Comment) CommentNode
+	build = func(comment Comment) CommentNode {
+		node := CommentNode{Comment: comment, Replies: []CommentNode{}}
+		for _, child := range children[comment.ID] {
+			node.Replies = append(node.Replies, build(child))
+		}
+		return node
+	}
+	tree := make([]CommentNode, 0, len(roots))
+	for _, root := range roots {
+		tree = append(tree, build(root))
+	}
+	return tree
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := Cursor{DateCreated: time.Date(2022, 10, 1, 12, 30, 0, 123456789, time.UTC), ID: xid.New()}
+	parsed, err := ParseCursor(cursor.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.DateCreated.Equal(cursor.DateCreated) || parsed.ID != cursor.ID {
+		t.Error("ParseCursor expected:", cursor, "got:", parsed)
+	}
+	if zero, err := ParseCursor(""); err != nil || !zero.IsZero() {
+		t.Error("ParseCursor of an empty string expected the zero cursor, got:", zero, err)
+	}
+	if (Cursor{}).String() != "" {
+		t.Error("zero cursor should encode to an empty string, got:", Cursor{}.String())
+	}
+}
+
+func TestParseMalformedCursor(t *testing.T) {
+	payloads := []string{
+		"nodot",
+		"zz!.9m4e2mr0ui3e8a215n4g",
+		"abc.notanid",
+		"abc.00000000000000000000",
+	}
+	for _, payload := range payloads {
+		if _, err := ParseCursor(payload); err != ErrMalformedCursor {
+			t.Error("Content:", payload, "expected:", ErrMalformedCursor, "got:", err)
+		}
+	}
+}
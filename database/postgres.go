@@ -2,11 +2,13 @@ package database
 
 import (
 	"context"
-	_ "embed"
 	"errors"
 	"fmt"
 	"log"
-	"os"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v4"
@@ -18,62 +20,118 @@ var (
 	ErrMistmatchedRowsAffected = errors.New("errors affected does not match desired number")
 )
 
-//go:embed create_tables.sql
-var createTables string
+// columns are listed explicitly as the schema gains columns through migrations
+const (
+	postgresPostColumns       = `title, content, poster_id, id, comment_ids, date_created, date_edited, board_id, locked, hidden`
+	postgresBoardColumns      = `name, slug, description, position, id, date_created`
+	postgresCommentColumns    = `content, post_id, poster_id, id, date_created, deleted, date_edited, parent_id, hidden`
+	postgresUserColumns       = `name, id, password, date_joined, deleted, role, banned`
+	postgresAPITokenColumns   = `name, hash, user_id, scopes, id, date_created, last_used`
+	postgresAttachmentColumns = `target_id, post_id, uploader_id, blob_key, thumbnail_key, content_type, width, height, size, id, date_created`
+)
+
+// PostgresConfig is where the postgres server is and who to connect to it as
+type PostgresConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+}
+
+// URL is the connection url of the config, with its parts escaped
+func (c PostgresConfig) URL() string {
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(c.User, c.Password),
+		Host:   net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:   "/" + c.Database,
+	}
+	return u.String()
+}
 
 type PostgresDatabase struct {
 	pool *pgxpool.Pool
 }
 
-func ConnectPostgres() (db *PostgresDatabase, err error) {
-	config, err := pgxpool.ParseConfig(buildPostgresURL())
+// OpenPostgres connects to postgres without touching the schema, for managing migrations
+func OpenPostgres(postgres PostgresConfig) (db *PostgresDatabase, err error) {
+	pool, err := pgxpool.Connect(context.Background(), postgres.URL())
 	if err != nil {
 		return nil, err
 	}
-	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
-		_, err := conn.Exec(ctx, createTables)
-		if err != nil {
-			log.Fatalln(err)
-			return err
-		}
-		return nil
+	return &PostgresDatabase{pool}, nil
+}
+
+// ConnectPostgres connects to postgres and applies the pending migrations before anything else runs
+func ConnectPostgres(postgres PostgresConfig) (db *PostgresDatabase, err error) {
+	db, err = OpenPostgres(postgres)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.MigrateUp(context.Background()); err != nil {
+		db.Disconnect(context.Background())
+		return nil, err
 	}
-	pool, err := pgxpool.ConnectConfig(context.Background(), config)
-	return &PostgresDatabase{pool}, err
+	return db, nil
 }
 
-func (p *PostgresDatabase) Disconnect() (err error) {
-	p.pool.Close()
-	return
+func (p *PostgresDatabase) Ping(ctx context.Context) error {
+	return p.pool.Ping(ctx)
 }
 
-func (p *PostgresDatabase) AddPost(title, content string, posterID xid.ID) (id xid.ID, err error) {
+// Disconnect closes the pool, which waits for the connections in use to be given back
+func (p *PostgresDatabase) Disconnect(ctx context.Context) error {
+	return closeWithin(ctx, func() error {
+		p.pool.Close()
+		return nil
+	})
+}
+
+func (p *PostgresDatabase) AddPost(ctx context.Context, title, content string, posterID, boardID xid.ID) (id xid.ID, err error) {
 	id = xid.New()
-	ct, err := p.pool.Exec(context.Background(),
-		`INSERT INTO posts(title, content, poster_id, id, comment_ids, date_created)
-	VALUES ($1, $2, $3, $4, $5, $6)
-	ON CONFLICT DO NOTHING`, title, content, posterID, id, []xid.ID{}, time.Now())
+	ct, err := p.pool.Exec(ctx,
+		`INSERT INTO posts(title, content, poster_id, board_id, id, comment_ids, date_created)
+	SELECT $1, $2, $3, id, $4, $5, $6 FROM boards WHERE id=$7
+	ON CONFLICT DO NOTHING`, title, content, posterID, id, []xid.ID{}, time.Now(), boardID)
+	if err != nil {
+		return
+	}
 	if ct.RowsAffected() != 1 {
-		err = ErrMistmatchedRowsAffected
+		err = ErrNoBoardFoundByID
 	}
 	return
 }
 
-func (p *PostgresDatabase) AddComment(content string, postID, posterID xid.ID) (id xid.ID, err error) {
+func (p *PostgresDatabase) AddComment(ctx context.Context, content string, postID, posterID, parentID xid.ID) (id xid.ID, err error) {
 	id = xid.New()
 	batch := &pgx.Batch{}
-	batch.Queue(`INSERT INTO comments(content, post_id, poster_id, id, date_created)
-	VALUES ($1, $2, $3, $4, $5)
-	ON CONFLICT DO NOTHING`, content, postID, posterID, id, time.Now())
-	batch.Queue(`UPDATE posts SET comment_ids = array_append(comment_ids, $1) WHERE id=$2`, id, postID)
-	br := p.pool.SendBatch(context.Background(), batch)
+	if parentID.IsNil() {
+		batch.Queue(`INSERT INTO comments(content, post_id, poster_id, id, date_created)
+	SELECT $1, id, $2, $3, $4 FROM posts WHERE id=$5
+	ON CONFLICT DO NOTHING`, content, posterID, id, time.Now(), postID)
+	} else {
+		batch.Queue(`INSERT INTO comments(content, post_id, poster_id, parent_id, id, date_created)
+	SELECT $1, post_id, $2, id, $3, $4 FROM comments WHERE id=$5 AND post_id=$6 AND NOT deleted
+	ON CONFLICT DO NOTHING`, content, posterID, id, time.Now(), parentID, postID)
+	}
+	// only keep track of the comment if it was inserted, both statements are sent at once
+	batch.Queue(`UPDATE posts SET comment_ids = array_append(comment_ids, $1)
+	WHERE id=$2 AND EXISTS (SELECT 1 FROM comments WHERE id=$1)`, id, postID)
+	br := p.pool.SendBatch(ctx, batch)
 	defer br.Close()
 	ct, err := br.Exec()
 	if err != nil {
 		return
 	}
 	if ct.RowsAffected() != 1 {
-		err = ErrMistmatchedRowsAffected
+		err = ErrNoPostFoundByID
+		if !parentID.IsNil() {
+			// tell a missing post apart from a missing parent
+			if _, err = p.GetPost(ctx, postID); err == nil {
+				err = ErrNoCommentFoundByID
+			}
+		}
 		return
 	}
 	ct, err = br.Exec()
@@ -86,68 +144,155 @@ func (p *PostgresDatabase) AddComment(content string, postID, posterID xid.ID) (
 	return
 }
 
-func (p *PostgresDatabase) AddUser(name, password string) (id xid.ID, err error) {
+func (p *PostgresDatabase) AddUser(ctx context.Context, name, password string) (id xid.ID, err error) {
 	id = xid.New()
-	ct, err := p.pool.Exec(context.Background(),
+	ct, err := p.pool.Exec(ctx,
 		`INSERT INTO users(name, id, password, date_joined)
 	VALUES ($1, $2, $3, $4)
 	ON CONFLICT DO NOTHING`, name, id, password, time.Now())
+	if err != nil {
+		return
+	}
 	if ct.RowsAffected() != 1 {
-		err = ErrMistmatchedRowsAffected
+		err = ErrUserNameTaken
+	}
+	return
+}
+
+func (p *PostgresDatabase) AddBoard(ctx context.Context, name, slug, description string, position int) (id xid.ID, err error) {
+	id = xid.New()
+	ct, err := p.pool.Exec(ctx,
+		`INSERT INTO boards(name, slug, description, position, id, date_created)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT DO NOTHING`, name, slug, description, position, id, time.Now())
+	if err != nil {
+		return
+	}
+	if ct.RowsAffected() != 1 {
+		err = ErrBoardSlugTaken
 	}
 	return
 }
 
-func (p *PostgresDatabase) GetPost(id xid.ID) (post Post, err error) {
-	err = p.pool.QueryRow(context.Background(),
-		`SELECT * FROM posts WHERE id=$1`, id).
-		Scan(&post.Title, &post.Content, &post.PosterID, &post.ID, &post.CommentIDs, &post.DateCreated)
+func (p *PostgresDatabase) GetPost(ctx context.Context, id xid.ID) (post Post, err error) {
+	post, err = scanPostgresPost(p.pool.QueryRow(ctx,
+		`SELECT `+postgresPostColumns+` FROM posts WHERE id=$1`, id))
 	if err == pgx.ErrNoRows {
 		err = ErrNoPostFoundByID
 	}
 	return
 }
 
-func (p *PostgresDatabase) GetComment(id xid.ID) (comment Comment, err error) {
-	err = p.pool.QueryRow(context.Background(),
-		`SELECT * FROM comments WHERE id=$1`, id).
-		Scan(&comment.Content, &comment.PostID, &comment.PosterID, &comment.ID, &comment.DateCreated)
+func (p *PostgresDatabase) GetComment(ctx context.Context, id xid.ID) (comment Comment, err error) {
+	comment, err = scanPostgresComment(p.pool.QueryRow(ctx,
+		`SELECT `+postgresCommentColumns+` FROM comments WHERE id=$1`, id))
 	if err == pgx.ErrNoRows {
 		err = ErrNoCommentFoundByID
 	}
 	return
 }
 
-func (p *PostgresDatabase) GetUser(id xid.ID) (user User, err error) {
-	err = p.pool.QueryRow(context.Background(),
-		`SELECT * FROM users WHERE id=$1`, id).
-		Scan(&user.Name, &user.ID, &user.Password, &user.DateJoined)
+func (p *PostgresDatabase) GetUser(ctx context.Context, id xid.ID) (user User, err error) {
+	user, err = scanPostgresUser(p.pool.QueryRow(ctx,
+		`SELECT `+postgresUserColumns+` FROM users WHERE id=$1`, id))
 	if err == pgx.ErrNoRows {
 		err = ErrNoUserFoundByID
 	}
 	return
 }
 
-func (p *PostgresDatabase) FindUserByName(name string) (user User, err error) {
-	err = p.pool.QueryRow(context.Background(),
-		`SELECT * FROM users WHERE name=$1`, name).
-		Scan(&user.Name, &user.ID, &user.Password, &user.DateJoined)
+func (p *PostgresDatabase) FindUserByName(ctx context.Context, name string) (user User, err error) {
+	user, err = scanPostgresUser(p.pool.QueryRow(ctx,
+		`SELECT `+postgresUserColumns+` FROM users WHERE name=$1 AND NOT deleted`, name))
 	if err == pgx.ErrNoRows {
 		err = ErrNoUserFoundByName
 	}
 	return
 }
 
+func (p *PostgresDatabase) GetBoard(ctx context.Context, id xid.ID) (board Board, err error) {
+	board, err = scanPostgresBoard(p.pool.QueryRow(ctx,
+		`SELECT `+postgresBoardColumns+` FROM boards WHERE id=$1`, id))
+	if err == pgx.ErrNoRows {
+		err = ErrNoBoardFoundByID
+	}
+	return
+}
+
+func (p *PostgresDatabase) UpdateBoard(ctx context.Context, id xid.ID, name, slug, description string, position int) (err error) {
+	ct, err := p.pool.Exec(ctx, `UPDATE boards SET name=$1, slug=$2, description=$3, position=$4
+	WHERE id=$5 AND NOT EXISTS (SELECT 1 FROM boards WHERE slug=$2 AND id<>$5)`, name, slug, description, position, id)
+	if err != nil || ct.RowsAffected() == 1 {
+		return
+	}
+	// nothing was updated, either the board is missing or the slug is taken
+	if _, err = p.GetBoard(ctx, id); err != nil {
+		return
+	}
+	return ErrBoardSlugTaken
+}
+
+func (p *PostgresDatabase) MovePostsWithoutBoard(ctx context.Context, boardID xid.ID) (moved int64, err error) {
+	ct, err := p.pool.Exec(ctx, `UPDATE posts SET board_id=$1
+	WHERE (board_id IS NULL OR board_id='') AND EXISTS (SELECT 1 FROM boards WHERE id=$1)`, boardID)
+	if err != nil {
+		return
+	}
+	moved = ct.RowsAffected()
+	return
+}
+
+func (p *PostgresDatabase) FindBoardBySlug(ctx context.Context, slug string) (board Board, err error) {
+	board, err = scanPostgresBoard(p.pool.QueryRow(ctx,
+		`SELECT `+postgresBoardColumns+` FROM boards WHERE slug=$1`, slug))
+	if err == pgx.ErrNoRows {
+		err = ErrNoBoardFoundBySlug
+	}
+	return
+}
+
+func (p *PostgresDatabase) AllBoards(ctx context.Context) (boards []BoardSummary, err error) {
+	rows, err := p.pool.Query(ctx,
+		`SELECT b.name, b.slug, b.description, b.position, b.id, b.date_created,
+	(SELECT count(*) FROM posts p WHERE p.board_id = b.id),
+	(SELECT max(activity.date_created) FROM (
+		SELECT p.date_created FROM posts p WHERE p.board_id = b.id
+		UNION ALL
+		SELECT c.date_created FROM comments c JOIN posts p ON p.id = c.post_id WHERE p.board_id = b.id
+	) activity)
+	FROM boards b ORDER BY b.position, b.name`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	boards = []BoardSummary{}
+	for rows.Next() {
+		var summary BoardSummary
+		var latest *time.Time
+		err = rows.Scan(&summary.Name, &summary.Slug, &summary.Description, &summary.Position, &summary.ID, &summary.DateCreated,
+			&summary.PostCount, &latest)
+		if err != nil {
+			return
+		}
+		if latest != nil {
+			summary.LatestActivity = *latest
+		}
+		boards = append(boards, summary)
+	}
+	err = rows.Err()
+	return
+}
+
 // TODO: paging
-func (p *PostgresDatabase) AllPosts() (posts []Post, err error) {
-	rows, err := p.pool.Query(context.TODO(), "SELECT * FROM posts")
+func (p *PostgresDatabase) AllPosts(ctx context.Context) (posts []Post, err error) {
+	rows, err := p.pool.Query(ctx, "SELECT "+postgresPostColumns+" FROM posts")
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var post Post
-		err = rows.Scan(&post.Title, &post.Content, &post.PosterID, &post.ID, &post.CommentIDs, &post.DateCreated)
+		post, err = scanPostgresPost(rows)
 		if err != nil {
 			log.Println(err)
 			return
@@ -157,68 +302,622 @@ func (p *PostgresDatabase) AllPosts() (posts []Post, err error) {
 	return
 }
 
-func (p *PostgresDatabase) PagePosts(start, end int) (posts []Post, err error) {
-	rows, err := p.pool.Query(context.TODO(), "SELECT * FROM posts ORDER BY date_created DESC LIMIT $1 OFFSET $2", end-start, start)
+func (p *PostgresDatabase) PagePosts(ctx context.Context, page Page) ([]Post, PageCursors, error) {
+	return p.pagePosts(ctx, page, "")
+}
+
+func (p *PostgresDatabase) PageBoardPosts(ctx context.Context, boardID xid.ID, page Page) ([]Post, PageCursors, error) {
+	return p.pagePosts(ctx, page, "board_id = $1", boardID)
+}
+
+func (p *PostgresDatabase) PageUserPosts(ctx context.Context, posterID xid.ID, page Page) ([]Post, PageCursors, error) {
+	return p.pagePosts(ctx, page, "poster_id = $1", posterID)
+}
+
+// pagePosts returns a page of the posts that match condition, or of every post if it is empty.
+// the placeholders of condition have to be numbered from $1
+func (p *PostgresDatabase) pagePosts(ctx context.Context, page Page, condition string, args ...interface{}) (posts []Post, cursors PageCursors, err error) {
+	if page.Limit <= 0 {
+		return []Post{}, cursors, nil
+	}
+	cmp, order := page.sqlKeyset(true)
+	conditions := []string{}
+	if condition != "" {
+		conditions = append(conditions, condition)
+	}
+	if !page.IncludeHidden {
+		conditions = append(conditions, "NOT hidden")
+	}
+	if !page.Cursor.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("(date_created, id) %s ($%d, $%d)", cmp, len(args)+1, len(args)+2))
+		args = append(args, page.Cursor.DateCreated, page.Cursor.ID)
+	}
+	query := "SELECT " + postgresPostColumns + " FROM posts"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY date_created %s, id %s LIMIT $%d", order, order, len(args)+1)
+	args = append(args, page.Limit+1)
+	rows, err := p.pool.Query(ctx, query, args...)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var post Post
-		err = rows.Scan(&post.Title, &post.Content, &post.PosterID, &post.ID, &post.CommentIDs, &post.DateCreated)
+		post, err = scanPostgresPost(rows)
 		if err != nil {
 			log.Println(err)
 			return
 		}
 		posts = append(posts, post)
 	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	keep, cursors := finishPage(page, len(posts), func(i int) Cursor { return posts[i].Cursor() })
+	posts = posts[:keep]
+	if page.Before {
+		reverseSlice(posts)
+	}
 	return
 }
 
-func (p *PostgresDatabase) GetPostPageData(postID xid.ID) (post Post, poster User, comments []Comment, users map[xid.ID]User, err error) {
-	post, err = p.GetPost(postID)
+// Search matches whole words with the search columns, which use the simple text search
+// configuration so words are not stemmed, the same as in the other backends
+func (p *PostgresDatabase) Search(ctx context.Context, query SearchQuery, page Page) (results []SearchResult, cursors PageCursors, err error) {
+	results = []SearchResult{}
+	if page.Limit <= 0 {
+		return
+	}
+	cmp, order := page.sqlKeyset(true)
+	conditions := []string{}
+	args := []interface{}{}
+	if terms := SearchTerms(query.Text); len(terms) > 0 {
+		args = append(args, strings.Join(terms, " "))
+		conditions = append(conditions, fmt.Sprintf("m.search @@ plainto_tsquery('simple', $%d)", len(args)))
+	}
+	if !query.AuthorID.IsNil() {
+		args = append(args, query.AuthorID)
+		conditions = append(conditions, fmt.Sprintf("m.poster_id = $%d", len(args)))
+	}
+	if !query.Since.IsZero() {
+		args = append(args, query.Since)
+		conditions = append(conditions, fmt.Sprintf("m.date_created >= $%d", len(args)))
+	}
+	if !query.Until.IsZero() {
+		args = append(args, query.Until)
+		conditions = append(conditions, fmt.Sprintf("m.date_created < $%d", len(args)))
+	}
+	if !query.IncludeHidden {
+		conditions = append(conditions, "NOT m.hidden")
+	}
+	if !page.Cursor.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("(m.date_created, m.id) %s ($%d, $%d)", cmp, len(args)+1, len(args)+2))
+		args = append(args, page.Cursor.DateCreated, page.Cursor.ID)
+	}
+	q := `SELECT m.post_id, m.comment_id, m.poster_id, m.date_created, m.id FROM (
+		SELECT p.id AS post_id, NULL AS comment_id, p.poster_id, p.date_created, p.id, p.hidden, p.search
+		FROM posts p
+		UNION ALL
+		SELECT c.post_id, c.id, c.poster_id, c.date_created, c.id, c.hidden OR p.hidden, c.search
+		FROM comments c JOIN posts p ON p.id = c.post_id WHERE NOT c.deleted
+	) m`
+	if len(conditions) > 0 {
+		q += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	q += fmt.Sprintf(" ORDER BY m.date_created %s, m.id %s LIMIT $%d", order, order, len(args)+1)
+	args = append(args, page.Limit+1)
+	rows, err := p.pool.Query(ctx, q, args...)
 	if err != nil {
 		return
 	}
-	batch := &pgx.Batch{}
-	batch.Queue(`SELECT * FROM users WHERE id=$1`, post.PosterID)
-	batch.Queue(`SELECT * FROM comments WHERE id = ANY ($1) ORDER BY date_created ASC`, post.CommentIDs)
-	br := p.pool.SendBatch(context.Background(), batch)
-	defer br.Close()
-	err = br.QueryRow().Scan(&poster.Name, &poster.ID, &poster.Password, &poster.DateJoined)
+	defer rows.Close()
+	matches := []searchMatch{}
+	for rows.Next() {
+		var match searchMatch
+		var commentID *string
+		if err = rows.Scan(&match.PostID, &commentID, &match.PosterID, &match.Cursor.DateCreated, &match.Cursor.ID); err != nil {
+			return
+		}
+		if commentID != nil {
+			if match.CommentID, err = xid.FromString(*commentID); err != nil {
+				return
+			}
+		}
+		matches = append(matches, match)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	keep, cursors := finishPage(page, len(matches), func(i int) Cursor { return matches[i].Cursor })
+	matches = matches[:keep]
+	if page.Before {
+		reverseSlice(matches)
+	}
+	results, err = loadSearchResults(ctx, matches, p.GetPost, p.GetComment, p.GetUser)
+	return
+}
+
+func (p *PostgresDatabase) GetPostPageData(ctx context.Context, postID xid.ID, page Page) (post Post, poster User, comments []CommentNode, users map[xid.ID]User, cursors PageCursors, err error) {
+	post, err = p.GetPost(ctx, postID)
+	if err != nil {
+		return
+	}
+	poster, err = p.GetUser(ctx, post.PosterID)
+	if err == ErrNoUserFoundByID || poster.Deleted {
+		poster, err = DeletedUser, nil
+	}
+	if err != nil {
+		return
+	}
+	comments = []CommentNode{}
+	users = make(map[xid.ID]User)
+	if page.Limit <= 0 {
+		return
+	}
+	cmp, order := page.sqlKeyset(false)
+	query := `SELECT c.content, c.post_id, c.poster_id, c.id, c.date_created, c.deleted, c.date_edited, c.parent_id, c.hidden,
+	u.name, u.id, u.password, u.date_joined, u.deleted, u.role, u.banned
+	FROM comments c LEFT JOIN users u ON u.id = c.poster_id
+	WHERE c.post_id=$1 AND c.parent_id IS NULL`
+	args := []interface{}{postID}
+	if !page.Cursor.IsZero() {
+		query += " AND (c.date_created, c.id) " + cmp + " ($2, $3)"
+		args = append(args, page.Cursor.DateCreated, page.Cursor.ID)
+	}
+	query += fmt.Sprintf(" ORDER BY c.date_created %s, c.id %s LIMIT $%d", order, order, len(args)+1)
+	args = append(args, page.Limit+1)
+	roots, err := p.queryCommentsWithPosters(ctx, users, query, args...)
+	if err != nil {
+		return
+	}
+	keep, cursors := finishPage(page, len(roots), func(i int) Cursor { return roots[i].Cursor() })
+	for _, comment := range roots[keep:] {
+		delete(users, comment.ID)
+	}
+	roots = roots[:keep]
+	if page.Before {
+		reverseSlice(roots)
+	}
+	if len(roots) == 0 {
+		return
+	}
+	// every reply below the roots of the page, however deep
+	rootIDs := make([]string, len(roots))
+	for i, root := range roots {
+		rootIDs[i] = root.ID.String()
+	}
+	replies, err := p.queryCommentsWithPosters(ctx, users, `WITH RECURSIVE thread(id) AS (
+		SELECT id FROM comments WHERE parent_id = ANY($1)
+		UNION ALL
+		SELECT c.id FROM comments c JOIN thread t ON c.parent_id = t.id
+	)
+	SELECT c.content, c.post_id, c.poster_id, c.id, c.date_created, c.deleted, c.date_edited, c.parent_id, c.hidden,
+	u.name, u.id, u.password, u.date_joined, u.deleted, u.role, u.banned
+	FROM comments c LEFT JOIN users u ON u.id = c.poster_id
+	WHERE c.id IN (SELECT id FROM thread) ORDER BY c.date_created ASC, c.id ASC`, rootIDs)
 	if err != nil {
 		return
 	}
-	rows, err := br.Query()
+	comments = buildCommentTree(roots, replies)
+	return
+}
+
+func (p *PostgresDatabase) LatestComments(ctx context.Context, postID xid.ID, limit int) (comments []Comment, users map[xid.ID]User, err error) {
+	comments, users = []Comment{}, make(map[xid.ID]User)
+	if limit <= 0 {
+		return
+	}
+	latest, err := p.queryCommentsWithPosters(ctx, users, `SELECT c.content, c.post_id, c.poster_id, c.id, c.date_created, c.deleted, c.date_edited, c.parent_id, c.hidden,
+	u.name, u.id, u.password, u.date_joined, u.deleted, u.role, u.banned
+	FROM comments c LEFT JOIN users u ON u.id = c.poster_id
+	WHERE c.post_id=$1 AND NOT c.deleted AND NOT c.hidden
+	ORDER BY c.date_created DESC, c.id DESC LIMIT $2`, postID, limit)
+	if err != nil {
+		return
+	}
+	comments = append(comments, latest...)
+	return
+}
+
+// queryCommentsWithPosters runs a query selecting postgresCommentColumns followed by the columns of the poster,
+// the posters are put in users keyed by comment id
+func (p *PostgresDatabase) queryCommentsWithPosters(ctx context.Context, users map[xid.ID]User, query string, args ...interface{}) (comments []Comment, err error) {
+	rows, err := p.pool.Query(ctx, query, args...)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var comment Comment
-		err = rows.Scan(&comment.Content, &comment.PostID, &comment.PosterID, &comment.ID, &comment.DateCreated)
+		var name, password *string
+		var userID xid.ID
+		var dateEdited, dateJoined *time.Time
+		var deleted, banned *bool
+		var role *int
+		err = rows.Scan(&comment.Content, &comment.PostID, &comment.PosterID, &comment.ID, &comment.DateCreated, &comment.Deleted, &dateEdited, &comment.ParentID, &comment.Hidden,
+			&name, &userID, &password, &dateJoined, &deleted, &role, &banned)
 		if err != nil {
 			return
 		}
+		if dateEdited != nil {
+			comment.DateEdited = *dateEdited
+		}
 		comments = append(comments, comment)
+		if userID.IsNil() || *deleted {
+			users[comment.ID] = DeletedUser
+			continue
+		}
+		users[comment.ID] = User{Name: *name, ID: userID, Password: *password, DateJoined: *dateJoined, Role: Role(*role), Banned: *banned}
 	}
-	users = make(map[xid.ID]User)
-	commenterBatch := &pgx.Batch{}
-	for _, comment := range comments {
-		commenterBatch.Queue(`SELECT * FROM users WHERE id=$1`, comment.PosterID)
-	}
-	commenterBR := p.pool.SendBatch(context.Background(), commenterBatch)
-	defer commenterBR.Close()
-	for _, comment := range comments {
-		var user User
-		if err = commenterBR.QueryRow().Scan(&user.Name, &user.ID, &user.Password, &user.DateJoined); err != nil {
+	err = rows.Err()
+	return
+}
+
+func (p *PostgresDatabase) UpdatePost(ctx context.Context, id xid.ID, title, content string) (err error) {
+	now := time.Now()
+	batch := &pgx.Batch{}
+	batch.Queue(`INSERT INTO revisions(title, content, target_id, post_id, id, date_edited)
+	SELECT title, content, id, id, $1, $2 FROM posts WHERE id=$3`, xid.New(), now, id)
+	batch.Queue(`UPDATE posts SET title=$1, content=$2, date_edited=$3 WHERE id=$4`, title, content, now, id)
+	br := p.pool.SendBatch(ctx, batch)
+	defer br.Close()
+	ct, err := br.Exec()
+	if err != nil {
+		return
+	}
+	if ct.RowsAffected() != 1 {
+		err = ErrNoPostFoundByID
+		return
+	}
+	_, err = br.Exec()
+	return
+}
+
+func (p *PostgresDatabase) UpdateComment(ctx context.Context, id xid.ID, content string) (err error) {
+	now := time.Now()
+	batch := &pgx.Batch{}
+	batch.Queue(`INSERT INTO revisions(title, content, target_id, post_id, id, date_edited)
+	SELECT '', content, id, post_id, $1, $2 FROM comments WHERE id=$3 AND NOT deleted`, xid.New(), now, id)
+	batch.Queue(`UPDATE comments SET content=$1, date_edited=$2 WHERE id=$3 AND NOT deleted`, content, now, id)
+	br := p.pool.SendBatch(ctx, batch)
+	defer br.Close()
+	ct, err := br.Exec()
+	if err != nil {
+		return
+	}
+	if ct.RowsAffected() != 1 {
+		err = ErrNoCommentFoundByID
+		return
+	}
+	_, err = br.Exec()
+	return
+}
+
+func (p *PostgresDatabase) UpdatePassword(ctx context.Context, id xid.ID, password string) (err error) {
+	ct, err := p.pool.Exec(ctx,
+		`UPDATE users SET password=$1 WHERE id=$2 AND NOT deleted`, password, id)
+	if err != nil {
+		return
+	}
+	if ct.RowsAffected() != 1 {
+		err = ErrNoUserFoundByID
+	}
+	return
+}
+
+func (p *PostgresDatabase) GetPostRevisions(ctx context.Context, postID xid.ID) (revisions map[xid.ID][]Revision, err error) {
+	rows, err := p.pool.Query(ctx,
+		`SELECT title, content, target_id, post_id, id, date_edited FROM revisions
+	WHERE post_id=$1 ORDER BY date_edited ASC`, postID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	revisions = make(map[xid.ID][]Revision)
+	for rows.Next() {
+		var r Revision
+		err = rows.Scan(&r.Title, &r.Content, &r.TargetID, &r.PostID, &r.ID, &r.DateEdited)
+		if err != nil {
+			return
+		}
+		revisions[r.TargetID] = append(revisions[r.TargetID], r)
+	}
+	err = rows.Err()
+	return
+}
+
+func (p *PostgresDatabase) AddAttachment(ctx context.Context, attachment Attachment) (id xid.ID, err error) {
+	id = xid.New()
+	_, err = p.pool.Exec(ctx,
+		`INSERT INTO attachments(`+postgresAttachmentColumns+`)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`, attachment.TargetID, attachment.PostID, attachment.UploaderID, attachment.Key,
+		attachment.ThumbnailKey, attachment.ContentType, attachment.Width, attachment.Height, attachment.Size, id, time.Now())
+	return
+}
+
+func (p *PostgresDatabase) GetAttachment(ctx context.Context, id xid.ID) (attachment Attachment, err error) {
+	attachment, err = scanAttachment(p.pool.QueryRow(ctx,
+		`SELECT `+postgresAttachmentColumns+` FROM attachments WHERE id=$1`, id))
+	if err == pgx.ErrNoRows {
+		err = ErrNoAttachmentFound
+	}
+	return
+}
+
+func (p *PostgresDatabase) PostAttachments(ctx context.Context, postID xid.ID) (attachments map[xid.ID][]Attachment, err error) {
+	rows, err := p.pool.Query(ctx,
+		`SELECT `+postgresAttachmentColumns+` FROM attachments WHERE post_id=$1 ORDER BY date_created ASC, id ASC`, postID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	attachments = make(map[xid.ID][]Attachment)
+	for rows.Next() {
+		var a Attachment
+		a, err = scanAttachment(rows)
+		if err != nil {
+			return
+		}
+		attachments[a.TargetID] = append(attachments[a.TargetID], a)
+	}
+	err = rows.Err()
+	return
+}
+
+func (p *PostgresDatabase) AttachmentKeys(ctx context.Context) (keys map[string]bool, err error) {
+	rows, err := p.pool.Query(ctx, `SELECT blob_key, thumbnail_key FROM attachments`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	keys = make(map[string]bool)
+	for rows.Next() {
+		var key, thumbnailKey string
+		if err = rows.Scan(&key, &thumbnailKey); err != nil {
+			return
+		}
+		keys[key] = true
+		keys[thumbnailKey] = true
+	}
+	err = rows.Err()
+	return
+}
+
+func (p *PostgresDatabase) DeletePost(ctx context.Context, id xid.ID) (err error) {
+	batch := &pgx.Batch{}
+	batch.Queue(`DELETE FROM posts WHERE id=$1`, id)
+	batch.Queue(`DELETE FROM comments WHERE post_id=$1`, id)
+	batch.Queue(`DELETE FROM revisions WHERE post_id=$1`, id)
+	batch.Queue(`DELETE FROM attachments WHERE post_id=$1`, id)
+	br := p.pool.SendBatch(ctx, batch)
+	defer br.Close()
+	ct, err := br.Exec()
+	if err != nil {
+		return
+	}
+	if ct.RowsAffected() != 1 {
+		err = ErrNoPostFoundByID
+		return
+	}
+	for n := 0; n < 3; n++ {
+		if _, err = br.Exec(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (p *PostgresDatabase) DeleteComment(ctx context.Context, id xid.ID) (err error) {
+	batch := &pgx.Batch{}
+	batch.Queue(`UPDATE comments SET deleted=true, content='' WHERE id=$1`, id)
+	batch.Queue(`DELETE FROM revisions WHERE target_id=$1`, id)
+	batch.Queue(`DELETE FROM attachments WHERE target_id=$1`, id)
+	br := p.pool.SendBatch(ctx, batch)
+	defer br.Close()
+	ct, err := br.Exec()
+	if err != nil {
+		return
+	}
+	if ct.RowsAffected() != 1 {
+		err = ErrNoCommentFoundByID
+		return
+	}
+	if _, err = br.Exec(); err != nil {
+		return
+	}
+	_, err = br.Exec()
+	return
+}
+
+func (p *PostgresDatabase) DeleteUser(ctx context.Context, id xid.ID) (err error) {
+	batch := &pgx.Batch{}
+	batch.Queue(`UPDATE users SET deleted=true, password='' WHERE id=$1`, id)
+	batch.Queue(`DELETE FROM sessions WHERE user_id=$1`, id)
+	batch.Queue(`DELETE FROM api_tokens WHERE user_id=$1`, id)
+	batch.Queue(`DELETE FROM attachments WHERE uploader_id=$1`, id)
+	br := p.pool.SendBatch(ctx, batch)
+	defer br.Close()
+	ct, err := br.Exec()
+	if err != nil {
+		return
+	}
+	if ct.RowsAffected() != 1 {
+		err = ErrNoUserFoundByID
+		return
+	}
+	for n := 0; n < 3; n++ {
+		if _, err = br.Exec(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (p *PostgresDatabase) CountUsers(ctx context.Context) (count int, err error) {
+	err = p.pool.QueryRow(ctx, `SELECT count(*) FROM users`).Scan(&count)
+	return
+}
+
+func (p *PostgresDatabase) SetUserRole(ctx context.Context, id xid.ID, role Role) error {
+	return p.updateOne(ctx, ErrNoUserFoundByID, `UPDATE users SET role=$1 WHERE id=$2 AND NOT deleted`, int(role), id)
+}
+
+func (p *PostgresDatabase) SetUserBanned(ctx context.Context, id xid.ID, banned bool) error {
+	return p.updateOne(ctx, ErrNoUserFoundByID, `UPDATE users SET banned=$1 WHERE id=$2 AND NOT deleted`, banned, id)
+}
+
+func (p *PostgresDatabase) SetPostLocked(ctx context.Context, id xid.ID, locked bool) error {
+	return p.updateOne(ctx, ErrNoPostFoundByID, `UPDATE posts SET locked=$1 WHERE id=$2`, locked, id)
+}
+
+func (p *PostgresDatabase) SetPostHidden(ctx context.Context, id xid.ID, hidden bool) error {
+	return p.updateOne(ctx, ErrNoPostFoundByID, `UPDATE posts SET hidden=$1 WHERE id=$2`, hidden, id)
+}
+
+func (p *PostgresDatabase) SetCommentHidden(ctx context.Context, id xid.ID, hidden bool) error {
+	return p.updateOne(ctx, ErrNoCommentFoundByID, `UPDATE comments SET hidden=$1 WHERE id=$2`, hidden, id)
+}
+
+// updateOne runs an update that has to affect exactly one row, returns notFound if it did not
+func (p *PostgresDatabase) updateOne(ctx context.Context, notFound error, query string, args ...interface{}) (err error) {
+	ct, err := p.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	if ct.RowsAffected() != 1 {
+		err = notFound
+	}
+	return
+}
+
+func (p *PostgresDatabase) AddAPIToken(ctx context.Context, userID xid.ID, name, hash string, scopes []string) (id xid.ID, err error) {
+	id = xid.New()
+	_, err = p.pool.Exec(ctx,
+		`INSERT INTO api_tokens(name, hash, user_id, scopes, id, date_created)
+	VALUES ($1, $2, $3, $4, $5, $6)`, name, hash, userID, strings.Join(scopes, ","), id, time.Now())
+	return
+}
+
+func (p *PostgresDatabase) FindAPITokenByHash(ctx context.Context, hash string) (token APIToken, err error) {
+	token, err = scanPostgresAPIToken(p.pool.QueryRow(ctx,
+		`SELECT `+postgresAPITokenColumns+` FROM api_tokens WHERE hash=$1`, hash))
+	if err == pgx.ErrNoRows {
+		err = ErrNoAPITokenFound
+	}
+	return
+}
+
+func (p *PostgresDatabase) UserAPITokens(ctx context.Context, userID xid.ID) (tokens []APIToken, err error) {
+	rows, err := p.pool.Query(ctx,
+		`SELECT `+postgresAPITokenColumns+` FROM api_tokens WHERE user_id=$1 ORDER BY date_created ASC, id ASC`, userID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	tokens = []APIToken{}
+	for rows.Next() {
+		var token APIToken
+		token, err = scanPostgresAPIToken(rows)
+		if err != nil {
 			return
 		}
-		users[comment.ID] = user
+		tokens = append(tokens, token)
+	}
+	err = rows.Err()
+	return
+}
+
+func (p *PostgresDatabase) DeleteAPIToken(ctx context.Context, id, userID xid.ID) error {
+	return p.updateOne(ctx, ErrNoAPITokenFound, `DELETE FROM api_tokens WHERE id=$1 AND user_id=$2`, id, userID)
+}
+
+func (p *PostgresDatabase) TouchAPIToken(ctx context.Context, id xid.ID, lastUsed time.Time) error {
+	return p.updateOne(ctx, ErrNoAPITokenFound, `UPDATE api_tokens SET last_used=$1 WHERE id=$2`, lastUsed, id)
+}
+
+// sessions are stored in UTC, as timestamp columns drop the time zone
+// and expiries have to be compared against the current time
+func (p *PostgresDatabase) AddSession(ctx context.Context, session Session) (err error) {
+	_, err = p.pool.Exec(ctx,
+		`INSERT INTO sessions(token, user_id, expiry)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (token) DO UPDATE SET user_id=EXCLUDED.user_id, expiry=EXCLUDED.expiry`, session.Token, session.UserID, session.Expiry.UTC())
+	return
+}
+
+func (p *PostgresDatabase) GetSession(ctx context.Context, token string) (session Session, err error) {
+	err = p.pool.QueryRow(ctx,
+		`SELECT token, user_id, expiry FROM sessions WHERE token=$1`, token).
+		Scan(&session.Token, &session.UserID, &session.Expiry)
+	if err == pgx.ErrNoRows {
+		err = ErrNoSessionFoundByToken
+	}
+	return
+}
+
+func (p *PostgresDatabase) DeleteSession(ctx context.Context, token string) (err error) {
+	_, err = p.pool.Exec(ctx, `DELETE FROM sessions WHERE token=$1`, token)
+	return
+}
+
+func (p *PostgresDatabase) DeleteExpiredSessions(ctx context.Context, now time.Time) (deleted int64, err error) {
+	ct, err := p.pool.Exec(ctx, `DELETE FROM sessions WHERE expiry < $1`, now.UTC())
+	if err != nil {
+		return
+	}
+	deleted = ct.RowsAffected()
+	return
+}
+
+func (p *PostgresDatabase) CountSessions(ctx context.Context, now time.Time) (count int, err error) {
+	err = p.pool.QueryRow(ctx, `SELECT count(*) FROM sessions WHERE expiry >= $1`, now.UTC()).Scan(&count)
+	return
+}
+
+// scanPostgresPost scans a row selected with postgresPostColumns into a Post
+func scanPostgresPost(row pgx.Row) (post Post, err error) {
+	var dateEdited *time.Time
+	err = row.Scan(&post.Title, &post.Content, &post.PosterID, &post.ID, &post.CommentIDs, &post.DateCreated, &dateEdited, &post.BoardID, &post.Locked, &post.Hidden)
+	if dateEdited != nil {
+		post.DateEdited = *dateEdited
+	}
+	return
+}
+
+// scanPostgresBoard scans a row selected with postgresBoardColumns into a Board
+func scanPostgresBoard(row pgx.Row) (board Board, err error) {
+	err = row.Scan(&board.Name, &board.Slug, &board.Description, &board.Position, &board.ID, &board.DateCreated)
+	return
+}
+
+// scanPostgresComment scans a row selected with postgresCommentColumns into a Comment
+func scanPostgresComment(row pgx.Row) (comment Comment, err error) {
+	var dateEdited *time.Time
+	err = row.Scan(&comment.Content, &comment.PostID, &comment.PosterID, &comment.ID, &comment.DateCreated, &comment.Deleted, &dateEdited, &comment.ParentID, &comment.Hidden)
+	if dateEdited != nil {
+		comment.DateEdited = *dateEdited
 	}
 	return
 }
 
-func buildPostgresURL() string {
-	return fmt.Sprintf("postgres://%s:%s@localhost:5432/%s", os.Getenv("POSTGRES_USER"), os.Getenv("POSTGRES_PASSWORD"), os.Getenv("POSTGRES_DB"))
+// scanPostgresUser scans a row selected with postgresUserColumns into a User
+func scanPostgresUser(row pgx.Row) (user User, err error) {
+	var role int
+	err = row.Scan(&user.Name, &user.ID, &user.Password, &user.DateJoined, &user.Deleted, &role, &user.Banned)
+	user.Role = Role(role)
+	return
+}
+
+// scanPostgresAPIToken scans a row selected with postgresAPITokenColumns into an APIToken
+func scanPostgresAPIToken(row pgx.Row) (token APIToken, err error) {
+	var scopes string
+	var lastUsed *time.Time
+	err = row.Scan(&token.Name, &token.Hash, &token.UserID, &scopes, &token.ID, &token.DateCreated, &lastUsed)
+	token.Scopes = splitScopes(scopes)
+	if lastUsed != nil {
+		token.LastUsed = *lastUsed
+	}
+	return
 }
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/rs/xid"
+)
+
+// MAX_SEARCH_TERMS caps how many words of a search are looked up, the rest are ignored
+const MAX_SEARCH_TERMS = 8
+
+// SearchQuery is what to search for, the zero value of a filter does not filter
+type SearchQuery struct {
+	// Text is split with SearchTerms, posts and comments have to contain every term
+	Text string
+	// AuthorID keeps only the posts and comments of one user
+	AuthorID xid.ID
+	// Since and Until keep only the posts and comments created in [Since, Until)
+	Since time.Time
+	Until time.Time
+	// IncludeHidden also searches hidden posts and comments, for moderators
+	IncludeHidden bool
+}
+
+// SearchResult is a post or a comment that matched a search,
+// Comment is the zero Comment when the post itself matched
+type SearchResult struct {
+	Post    Post
+	Comment Comment
+	Author  User
+}
+
+// IsComment reports whether the result is a comment rather than a post
+func (r SearchResult) IsComment() bool {
+	return !r.Comment.ID.IsNil()
+}
+
+func (r SearchResult) Cursor() Cursor {
+	if r.IsComment() {
+		return r.Comment.Cursor()
+	}
+	return r.Post.Cursor()
+}
+
+// SearchTerms splits text into the lowercase words a search looks for,
+// without duplicates and at most MAX_SEARCH_TERMS of them
+func SearchTerms(text string) []string {
+	terms := []string{}
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isNotWordRune) {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+		if len(terms) == MAX_SEARCH_TERMS {
+			break
+		}
+	}
+	return terms
+}
+
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// searchMatch is a row matched by a search in the sql backends,
+// CommentID is the nil id when the post itself matched
+type searchMatch struct {
+	PostID    xid.ID
+	CommentID xid.ID
+	PosterID  xid.ID
+	Cursor    Cursor
+}
+
+// loadSearchResults fetches the posts, comments and authors of a page of matches,
+// posts and authors that show up more than once are only fetched once.
+// Authors that are deleted or cannot be found are replaced with DeletedUser
+func loadSearchResults(ctx context.Context, matches []searchMatch, getPost func(context.Context, xid.ID) (Post, error),
+	getComment func(context.Context, xid.ID) (Comment, error), getUser func(context.Context, xid.ID) (User, error)) ([]SearchResult, error) {
+	posts := make(map[xid.ID]Post)
+	users := make(map[xid.ID]User)
+	results := make([]SearchResult, 0, len(matches))
+	for _, match := range matches {
+		var result SearchResult
+		var ok bool
+		var err error
+		if result.Post, ok = posts[match.PostID]; !ok {
+			if result.Post, err = getPost(ctx, match.PostID); err != nil {
+				return nil, err
+			}
+			posts[match.PostID] = result.Post
+		}
+		if !match.CommentID.IsNil() {
+			if result.Comment, err = getComment(ctx, match.CommentID); err != nil {
+				return nil, err
+			}
+		}
+		if result.Author, ok = users[match.PosterID]; !ok {
+			result.Author, err = getUser(ctx, match.PosterID)
+			if err == ErrNoUserFoundByID || result.Author.Deleted {
+				result.Author, err = DeletedUser, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			users[match.PosterID] = result.Author
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// invertedIndex maps the search terms of posts and comments to their ids,
+// the json backend keeps one in memory since it has no query engine to search with
+type invertedIndex struct {
+	lock  sync.RWMutex
+	terms map[string]map[xid.ID]struct{}
+	// the terms each document was indexed under, to unindex it
+	documents map[xid.ID][]string
+}
+
+func newInvertedIndex() *invertedIndex {
+	return &invertedIndex{
+		terms:     make(map[string]map[xid.ID]struct{}),
+		documents: make(map[xid.ID][]string),
+	}
+}
+
+// set indexes a document under the terms of text, replacing what it was indexed under before
+func (i *invertedIndex) set(id xid.ID, text string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.unindex(id)
+	terms := []string{}
+	for _, term := range strings.FieldsFunc(strings.ToLower(text), isNotWordRune) {
+		ids, ok := i.terms[term]
+		if !ok {
+			ids = make(map[xid.ID]struct{})
+			i.terms[term] = ids
+		}
+		if _, ok := ids[id]; !ok {
+			ids[id] = struct{}{}
+			terms = append(terms, term)
+		}
+	}
+	i.documents[id] = terms
+}
+
+// remove unindexes documents
+func (i *invertedIndex) remove(ids ...xid.ID) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for _, id := range ids {
+		i.unindex(id)
+	}
+}
+
+// unindex removes a document from the index, the caller must hold lock
+func (i *invertedIndex) unindex(id xid.ID) {
+	for _, term := range i.documents[id] {
+		delete(i.terms[term], id)
+		if len(i.terms[term]) == 0 {
+			delete(i.terms, term)
+		}
+	}
+	delete(i.documents, id)
+}
+
+// lookup returns the ids of the documents indexed under every one of terms
+func (i *invertedIndex) lookup(terms []string) map[xid.ID]struct{} {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	found := make(map[xid.ID]struct{})
+	if len(terms) == 0 {
+		return found
+	}
+	// start from the rarest term so there is as little as possible to intersect
+	rarest := terms[0]
+	for _, term := range terms[1:] {
+		if len(i.terms[term]) < len(i.terms[rarest]) {
+			rarest = term
+		}
+	}
+	for id := range i.terms[rarest] {
+		inAll := true
+		for _, term := range terms {
+			if _, ok := i.terms[term][id]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			found[id] = struct{}{}
+		}
+	}
+	return found
+}
+
+// keeps reports whether the filters of a query keep a post or comment,
+// hidden tells if it or the post it is under is hidden
+func (q SearchQuery) keeps(posterID xid.ID, created time.Time, hidden bool) bool {
+	return (q.AuthorID.IsNil() || posterID == q.AuthorID) &&
+		(q.Since.IsZero() || !created.Before(q.Since)) &&
+		(q.Until.IsZero() || created.Before(q.Until)) &&
+		(q.IncludeHidden || !hidden)
+}
@@ -0,0 +1,929 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/rs/xid"
+)
+
+var (
+	ErrEmptySQLitePath     = errors.New("sqlite file path is empty")
+	ErrMalformedSQLiteTime = errors.New("malformed sqlite timestamp")
+)
+
+//go:embed create_tables_sqlite.sql
+var createTablesSQLite string
+
+// sqlitePostColumns selects a post along with a comma separated list of its comment ids,
+// since sqlite has no array type to keep them in the posts table like postgres does
+const sqlitePostColumns = `p.title, p.content, p.poster_id, p.board_id, p.id, p.date_created, p.date_edited, p.locked, p.hidden,
+	(SELECT group_concat(c.id) FROM comments c WHERE c.post_id = p.id)`
+
+const sqliteBoardColumns = `b.name, b.slug, b.description, b.position, b.id, b.date_created`
+
+const sqliteCommentColumns = `c.content, c.post_id, c.poster_id, c.parent_id, c.id, c.date_created, c.deleted, c.date_edited, c.hidden`
+
+const sqliteUserColumns = `name, id, password, date_joined, deleted, role, banned`
+
+const sqliteAPITokenColumns = `name, hash, user_id, scopes, id, date_created, last_used`
+
+const sqliteAttachmentColumns = `target_id, post_id, uploader_id, blob_key, thumbnail_key, content_type, width, height, size, id, date_created`
+
+type SQLiteDatabase struct {
+	db *sql.DB
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// ConnectSQLite opens the database in the file at path, creating it if it does not exist
+func ConnectSQLite(path string) (*SQLiteDatabase, error) {
+	if path == "" {
+		return nil, ErrEmptySQLitePath
+	}
+	path = filepath.FromSlash(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only allows a single writer at a time, sharing one connection
+	// serializes our writes instead of handing back SQLITE_BUSY errors
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(createTablesSQLite); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteDatabase{db}, nil
+}
+
+func (s *SQLiteDatabase) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Disconnect closes the database, which waits for the queries that have started to finish
+func (s *SQLiteDatabase) Disconnect(ctx context.Context) error {
+	return closeWithin(ctx, s.db.Close)
+}
+
+func (s *SQLiteDatabase) AddPost(ctx context.Context, title, content string, posterID, boardID xid.ID) (id xid.ID, err error) {
+	id = xid.New()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO posts(title, content, poster_id, board_id, id, date_created)
+	SELECT ?, ?, ?, id, ?, ? FROM boards WHERE id=?
+	ON CONFLICT DO NOTHING`, title, content, posterID, id, time.Now().UTC(), boardID)
+	if err != nil {
+		return
+	}
+	err = checkRowsAffected(res, 1)
+	if err == ErrMistmatchedRowsAffected {
+		err = ErrNoBoardFoundByID
+	}
+	return
+}
+
+func (s *SQLiteDatabase) AddComment(ctx context.Context, content string, postID, posterID, parentID xid.ID) (id xid.ID, err error) {
+	id = xid.New()
+	if parentID.IsNil() {
+		var res sql.Result
+		res, err = s.db.ExecContext(ctx, `INSERT INTO comments(content, post_id, poster_id, id, date_created)
+	SELECT ?, id, ?, ?, ? FROM posts WHERE id=?
+	ON CONFLICT DO NOTHING`, content, posterID, id, time.Now().UTC(), postID)
+		if err != nil {
+			return
+		}
+		err = checkRowsAffected(res, 1)
+		if err == ErrMistmatchedRowsAffected {
+			err = ErrNoPostFoundByID
+		}
+		return
+	}
+	res, err := s.db.ExecContext(ctx, `INSERT INTO comments(content, post_id, poster_id, parent_id, id, date_created)
+	SELECT ?, post_id, ?, id, ?, ? FROM comments WHERE id=? AND post_id=? AND NOT deleted
+	ON CONFLICT DO NOTHING`, content, posterID, id, time.Now().UTC(), parentID, postID)
+	if err != nil {
+		return
+	}
+	err = checkRowsAffected(res, 1)
+	if err == ErrMistmatchedRowsAffected {
+		// tell a missing post apart from a missing parent
+		if _, err = s.GetPost(ctx, postID); err == nil {
+			err = ErrNoCommentFoundByID
+		}
+	}
+	return
+}
+
+func (s *SQLiteDatabase) AddUser(ctx context.Context, name, password string) (id xid.ID, err error) {
+	id = xid.New()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO users(name, id, password, date_joined)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT DO NOTHING`, name, id, password, time.Now().UTC())
+	if err != nil {
+		return
+	}
+	err = checkRowsAffected(res, 1)
+	if err == ErrMistmatchedRowsAffected {
+		err = ErrUserNameTaken
+	}
+	return
+}
+
+func (s *SQLiteDatabase) AddBoard(ctx context.Context, name, slug, description string, position int) (id xid.ID, err error) {
+	id = xid.New()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO boards(name, slug, description, position, id, date_created)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT DO NOTHING`, name, slug, description, position, id, time.Now().UTC())
+	if err != nil {
+		return
+	}
+	err = checkRowsAffected(res, 1)
+	if err == ErrMistmatchedRowsAffected {
+		err = ErrBoardSlugTaken
+	}
+	return
+}
+
+func (s *SQLiteDatabase) GetPost(ctx context.Context, id xid.ID) (post Post, err error) {
+	post, err = scanSQLitePost(s.db.QueryRowContext(ctx, `SELECT `+sqlitePostColumns+` FROM posts p WHERE p.id=?`, id))
+	if err == sql.ErrNoRows {
+		err = ErrNoPostFoundByID
+	}
+	return
+}
+
+func (s *SQLiteDatabase) GetComment(ctx context.Context, id xid.ID) (comment Comment, err error) {
+	comment, err = scanSQLiteComment(s.db.QueryRowContext(ctx, `SELECT `+sqliteCommentColumns+` FROM comments c WHERE c.id=?`, id))
+	if err == sql.ErrNoRows {
+		err = ErrNoCommentFoundByID
+	}
+	return
+}
+
+func (s *SQLiteDatabase) GetUser(ctx context.Context, id xid.ID) (user User, err error) {
+	user, err = scanSQLiteUser(s.db.QueryRowContext(ctx, `SELECT `+sqliteUserColumns+` FROM users WHERE id=?`, id))
+	if err == sql.ErrNoRows {
+		err = ErrNoUserFoundByID
+	}
+	return
+}
+
+func (s *SQLiteDatabase) FindUserByName(ctx context.Context, name string) (user User, err error) {
+	user, err = scanSQLiteUser(s.db.QueryRowContext(ctx, `SELECT `+sqliteUserColumns+` FROM users WHERE name=? AND NOT deleted`, name))
+	if err == sql.ErrNoRows {
+		err = ErrNoUserFoundByName
+	}
+	return
+}
+
+func (s *SQLiteDatabase) GetBoard(ctx context.Context, id xid.ID) (board Board, err error) {
+	board, err = scanSQLiteBoard(s.db.QueryRowContext(ctx, `SELECT `+sqliteBoardColumns+` FROM boards b WHERE b.id=?`, id))
+	if err == sql.ErrNoRows {
+		err = ErrNoBoardFoundByID
+	}
+	return
+}
+
+func (s *SQLiteDatabase) UpdateBoard(ctx context.Context, id xid.ID, name, slug, description string, position int) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE boards SET name=?, slug=?, description=?, position=?
+	WHERE id=? AND NOT EXISTS (SELECT 1 FROM boards WHERE slug=? AND id<>?)`, name, slug, description, position, id, slug, id)
+	if err != nil {
+		return err
+	}
+	if err = checkRowsAffected(res, 1); err != ErrMistmatchedRowsAffected {
+		return err
+	}
+	// nothing was updated, either the board is missing or the slug is taken
+	if _, err = s.GetBoard(ctx, id); err != nil {
+		return err
+	}
+	return ErrBoardSlugTaken
+}
+
+func (s *SQLiteDatabase) MovePostsWithoutBoard(ctx context.Context, boardID xid.ID) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE posts SET board_id=?
+	WHERE (board_id IS NULL OR board_id='') AND EXISTS (SELECT 1 FROM boards WHERE id=?)`, boardID, boardID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *SQLiteDatabase) FindBoardBySlug(ctx context.Context, slug string) (board Board, err error) {
+	board, err = scanSQLiteBoard(s.db.QueryRowContext(ctx, `SELECT `+sqliteBoardColumns+` FROM boards b WHERE b.slug=?`, slug))
+	if err == sql.ErrNoRows {
+		err = ErrNoBoardFoundBySlug
+	}
+	return
+}
+
+func (s *SQLiteDatabase) AllBoards(ctx context.Context) (boards []BoardSummary, err error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sqliteBoardColumns+`,
+	(SELECT count(*) FROM posts p WHERE p.board_id = b.id),
+	(SELECT max(activity.date_created) FROM (
+		SELECT p.date_created FROM posts p WHERE p.board_id = b.id
+		UNION ALL
+		SELECT c.date_created FROM comments c JOIN posts p ON p.id = c.post_id WHERE p.board_id = b.id
+	) activity)
+	FROM boards b ORDER BY b.position, b.name`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	boards = []BoardSummary{}
+	for rows.Next() {
+		var summary BoardSummary
+		var latest sql.NullString
+		err = rows.Scan(&summary.Name, &summary.Slug, &summary.Description, &summary.Position, &summary.ID, &summary.DateCreated,
+			&summary.PostCount, &latest)
+		if err != nil {
+			return
+		}
+		if latest.Valid {
+			summary.LatestActivity, err = parseSQLiteTime(latest.String)
+			if err != nil {
+				return
+			}
+		}
+		boards = append(boards, summary)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SQLiteDatabase) AllPosts(ctx context.Context) ([]Post, error) {
+	return s.queryPosts(ctx, `SELECT `+sqlitePostColumns+` FROM posts p ORDER BY p.date_created DESC`)
+}
+
+func (s *SQLiteDatabase) PagePosts(ctx context.Context, page Page) ([]Post, PageCursors, error) {
+	return s.pagePosts(ctx, page, "")
+}
+
+func (s *SQLiteDatabase) PageBoardPosts(ctx context.Context, boardID xid.ID, page Page) ([]Post, PageCursors, error) {
+	return s.pagePosts(ctx, page, `p.board_id = ?`, boardID)
+}
+
+func (s *SQLiteDatabase) PageUserPosts(ctx context.Context, posterID xid.ID, page Page) ([]Post, PageCursors, error) {
+	return s.pagePosts(ctx, page, `p.poster_id = ?`, posterID)
+}
+
+// pagePosts returns a page of the posts that match condition, or of every post if it is empty
+func (s *SQLiteDatabase) pagePosts(ctx context.Context, page Page, condition string, args ...interface{}) (posts []Post, cursors PageCursors, err error) {
+	if page.Limit <= 0 {
+		return []Post{}, cursors, nil
+	}
+	cmp, order := page.sqlKeyset(true)
+	conditions := []string{}
+	if condition != "" {
+		conditions = append(conditions, condition)
+	}
+	if !page.IncludeHidden {
+		conditions = append(conditions, `NOT p.hidden`)
+	}
+	if !page.Cursor.IsZero() {
+		conditions = append(conditions, `(p.date_created, p.id) `+cmp+` (?, ?)`)
+		args = append(args, page.Cursor.DateCreated.UTC(), page.Cursor.ID)
+	}
+	query := `SELECT ` + sqlitePostColumns + ` FROM posts p`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	query += ` ORDER BY p.date_created ` + order + `, p.id ` + order + ` LIMIT ?`
+	args = append(args, page.Limit+1)
+	posts, err = s.queryPosts(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	keep, cursors := finishPage(page, len(posts), func(i int) Cursor { return posts[i].Cursor() })
+	posts = posts[:keep]
+	if page.Before {
+		reverseSlice(posts)
+	}
+	return
+}
+
+// Search matches terms anywhere in a word with LIKE, which only folds the case of ascii letters
+func (s *SQLiteDatabase) Search(ctx context.Context, query SearchQuery, page Page) (results []SearchResult, cursors PageCursors, err error) {
+	results = []SearchResult{}
+	if page.Limit <= 0 {
+		return
+	}
+	cmp, order := page.sqlKeyset(true)
+	conditions := []string{}
+	args := []interface{}{}
+	// terms are only letters and digits, so they never hold a LIKE wildcard
+	for _, term := range SearchTerms(query.Text) {
+		conditions = append(conditions, `m.body LIKE ?`)
+		args = append(args, "%"+term+"%")
+	}
+	if !query.AuthorID.IsNil() {
+		conditions = append(conditions, `m.poster_id = ?`)
+		args = append(args, query.AuthorID)
+	}
+	if !query.Since.IsZero() {
+		conditions = append(conditions, `m.date_created >= ?`)
+		args = append(args, query.Since.UTC())
+	}
+	if !query.Until.IsZero() {
+		conditions = append(conditions, `m.date_created < ?`)
+		args = append(args, query.Until.UTC())
+	}
+	if !query.IncludeHidden {
+		conditions = append(conditions, `NOT m.hidden`)
+	}
+	if !page.Cursor.IsZero() {
+		conditions = append(conditions, `(m.date_created, m.id) `+cmp+` (?, ?)`)
+		args = append(args, page.Cursor.DateCreated.UTC(), page.Cursor.ID)
+	}
+	q := `SELECT m.post_id, m.comment_id, m.poster_id, m.date_created, m.id FROM (
+		SELECT p.id AS post_id, NULL AS comment_id, p.poster_id, p.date_created, p.id, p.hidden, p.title || ' ' || p.content AS body
+		FROM posts p
+		UNION ALL
+		SELECT c.post_id, c.id, c.poster_id, c.date_created, c.id, c.hidden OR p.hidden, c.content
+		FROM comments c JOIN posts p ON p.id = c.post_id WHERE NOT c.deleted
+	) m`
+	if len(conditions) > 0 {
+		q += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	q += ` ORDER BY m.date_created ` + order + `, m.id ` + order + ` LIMIT ?`
+	args = append(args, page.Limit+1)
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	matches := []searchMatch{}
+	for rows.Next() {
+		var match searchMatch
+		if err = rows.Scan(&match.PostID, &match.CommentID, &match.PosterID, &match.Cursor.DateCreated, &match.Cursor.ID); err != nil {
+			return
+		}
+		matches = append(matches, match)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	keep, cursors := finishPage(page, len(matches), func(i int) Cursor { return matches[i].Cursor })
+	matches = matches[:keep]
+	if page.Before {
+		reverseSlice(matches)
+	}
+	results, err = loadSearchResults(ctx, matches, s.GetPost, s.GetComment, s.GetUser)
+	return
+}
+
+func (s *SQLiteDatabase) GetPostPageData(ctx context.Context, postID xid.ID, page Page) (post Post, poster User, comments []CommentNode, users map[xid.ID]User, cursors PageCursors, err error) {
+	post, err = s.GetPost(ctx, postID)
+	if err != nil {
+		return
+	}
+	poster, err = s.GetUser(ctx, post.PosterID)
+	if err == ErrNoUserFoundByID || poster.Deleted {
+		poster, err = DeletedUser, nil
+	}
+	if err != nil {
+		return
+	}
+	comments = []CommentNode{}
+	users = make(map[xid.ID]User)
+	if page.Limit <= 0 {
+		return
+	}
+	cmp, order := page.sqlKeyset(false)
+	query := `SELECT ` + sqliteCommentColumns + `,
+	u.name, u.id, u.password, u.date_joined, u.deleted, u.role, u.banned
+	FROM comments c LEFT JOIN users u ON u.id = c.poster_id
+	WHERE c.post_id=? AND c.parent_id IS NULL`
+	args := []interface{}{postID}
+	if !page.Cursor.IsZero() {
+		query += ` AND (c.date_created, c.id) ` + cmp + ` (?, ?)`
+		args = append(args, page.Cursor.DateCreated.UTC(), page.Cursor.ID)
+	}
+	query += ` ORDER BY c.date_created ` + order + `, c.id ` + order + ` LIMIT ?`
+	args = append(args, page.Limit+1)
+	roots, err := s.queryCommentsWithPosters(ctx, users, query, args...)
+	if err != nil {
+		return
+	}
+	keep, cursors := finishPage(page, len(roots), func(i int) Cursor { return roots[i].Cursor() })
+	for _, comment := range roots[keep:] {
+		delete(users, comment.ID)
+	}
+	roots = roots[:keep]
+	if page.Before {
+		reverseSlice(roots)
+	}
+	if len(roots) == 0 {
+		return
+	}
+	// every reply below the roots of the page, however deep
+	rootIDs := make([]interface{}, len(roots))
+	for i, root := range roots {
+		rootIDs[i] = root.ID
+	}
+	replies, err := s.queryCommentsWithPosters(ctx, users, `WITH RECURSIVE thread(id) AS (
+		SELECT id FROM comments WHERE parent_id IN (?`+strings.Repeat(`, ?`, len(rootIDs)-1)+`)
+		UNION ALL
+		SELECT c.id FROM comments c JOIN thread t ON c.parent_id = t.id
+	)
+	SELECT `+sqliteCommentColumns+`,
+	u.name, u.id, u.password, u.date_joined, u.deleted, u.role, u.banned
+	FROM comments c LEFT JOIN users u ON u.id = c.poster_id
+	WHERE c.id IN (SELECT id FROM thread) ORDER BY c.date_created ASC, c.id ASC`, rootIDs...)
+	if err != nil {
+		return
+	}
+	comments = buildCommentTree(roots, replies)
+	return
+}
+
+func (s *SQLiteDatabase) LatestComments(ctx context.Context, postID xid.ID, limit int) (comments []Comment, users map[xid.ID]User, err error) {
+	comments, users = []Comment{}, make(map[xid.ID]User)
+	if limit <= 0 {
+		return
+	}
+	latest, err := s.queryCommentsWithPosters(ctx, users, `SELECT `+sqliteCommentColumns+`,
+	u.name, u.id, u.password, u.date_joined, u.deleted, u.role, u.banned
+	FROM comments c LEFT JOIN users u ON u.id = c.poster_id
+	WHERE c.post_id=? AND NOT c.deleted AND NOT c.hidden
+	ORDER BY c.date_created DESC, c.id DESC LIMIT ?`, postID, limit)
+	if err != nil {
+		return
+	}
+	comments = append(comments, latest...)
+	return
+}
+
+// queryCommentsWithPosters runs a query selecting sqliteCommentColumns followed by the columns of the poster,
+// the posters are put in users keyed by comment id
+func (s *SQLiteDatabase) queryCommentsWithPosters(ctx context.Context, users map[xid.ID]User, query string, args ...interface{}) (comments []Comment, err error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var comment Comment
+		var name, password sql.NullString
+		var userID xid.ID
+		var dateEdited, dateJoined sql.NullTime
+		var deleted, banned sql.NullBool
+		var role sql.NullInt64
+		err = rows.Scan(&comment.Content, &comment.PostID, &comment.PosterID, &comment.ParentID, &comment.ID, &comment.DateCreated, &comment.Deleted, &dateEdited, &comment.Hidden,
+			&name, &userID, &password, &dateJoined, &deleted, &role, &banned)
+		if err != nil {
+			return
+		}
+		comment.DateEdited = dateEdited.Time
+		comments = append(comments, comment)
+		if userID.IsNil() || deleted.Bool {
+			users[comment.ID] = DeletedUser
+			continue
+		}
+		users[comment.ID] = User{Name: name.String, ID: userID, Password: password.String, DateJoined: dateJoined.Time, Role: Role(role.Int64), Banned: banned.Bool}
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SQLiteDatabase) UpdatePost(ctx context.Context, id xid.ID, title, content string) error {
+	now := time.Now().UTC()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `INSERT INTO revisions(title, content, target_id, post_id, id, date_edited)
+	SELECT title, content, id, id, ?, ? FROM posts WHERE id=?`, xid.New(), now, id)
+	if err != nil {
+		return err
+	}
+	if err = checkRowsAffected(res, 1); err != nil {
+		if err == ErrMistmatchedRowsAffected {
+			err = ErrNoPostFoundByID
+		}
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE posts SET title=?, content=?, date_edited=? WHERE id=?`, title, content, now, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteDatabase) UpdateComment(ctx context.Context, id xid.ID, content string) error {
+	now := time.Now().UTC()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `INSERT INTO revisions(title, content, target_id, post_id, id, date_edited)
+	SELECT '', content, id, post_id, ?, ? FROM comments WHERE id=? AND NOT deleted`, xid.New(), now, id)
+	if err != nil {
+		return err
+	}
+	if err = checkRowsAffected(res, 1); err != nil {
+		if err == ErrMistmatchedRowsAffected {
+			err = ErrNoCommentFoundByID
+		}
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE comments SET content=?, date_edited=? WHERE id=?`, content, now, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteDatabase) UpdatePassword(ctx context.Context, id xid.ID, password string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET password=? WHERE id=? AND NOT deleted`, password, id)
+	if err != nil {
+		return err
+	}
+	err = checkRowsAffected(res, 1)
+	if err == ErrMistmatchedRowsAffected {
+		err = ErrNoUserFoundByID
+	}
+	return err
+}
+
+func (s *SQLiteDatabase) GetPostRevisions(ctx context.Context, postID xid.ID) (revisions map[xid.ID][]Revision, err error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT title, content, target_id, post_id, id, date_edited FROM revisions
+	WHERE post_id=? ORDER BY date_edited ASC`, postID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	revisions = make(map[xid.ID][]Revision)
+	for rows.Next() {
+		var r Revision
+		err = rows.Scan(&r.Title, &r.Content, &r.TargetID, &r.PostID, &r.ID, &r.DateEdited)
+		if err != nil {
+			return
+		}
+		revisions[r.TargetID] = append(revisions[r.TargetID], r)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SQLiteDatabase) AddAttachment(ctx context.Context, attachment Attachment) (id xid.ID, err error) {
+	id = xid.New()
+	_, err = s.db.ExecContext(ctx, `INSERT INTO attachments(`+sqliteAttachmentColumns+`)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, attachment.TargetID, attachment.PostID, attachment.UploaderID, attachment.Key,
+		attachment.ThumbnailKey, attachment.ContentType, attachment.Width, attachment.Height, attachment.Size, id, time.Now().UTC())
+	return
+}
+
+func (s *SQLiteDatabase) GetAttachment(ctx context.Context, id xid.ID) (attachment Attachment, err error) {
+	attachment, err = scanAttachment(s.db.QueryRowContext(ctx, `SELECT `+sqliteAttachmentColumns+` FROM attachments WHERE id=?`, id))
+	if err == sql.ErrNoRows {
+		err = ErrNoAttachmentFound
+	}
+	return
+}
+
+func (s *SQLiteDatabase) PostAttachments(ctx context.Context, postID xid.ID) (attachments map[xid.ID][]Attachment, err error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sqliteAttachmentColumns+` FROM attachments
+	WHERE post_id=? ORDER BY date_created ASC, id ASC`, postID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	attachments = make(map[xid.ID][]Attachment)
+	for rows.Next() {
+		var a Attachment
+		a, err = scanAttachment(rows)
+		if err != nil {
+			return
+		}
+		attachments[a.TargetID] = append(attachments[a.TargetID], a)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SQLiteDatabase) AttachmentKeys(ctx context.Context) (keys map[string]bool, err error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT blob_key, thumbnail_key FROM attachments`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	keys = make(map[string]bool)
+	for rows.Next() {
+		var key, thumbnailKey string
+		if err = rows.Scan(&key, &thumbnailKey); err != nil {
+			return
+		}
+		keys[key] = true
+		keys[thumbnailKey] = true
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SQLiteDatabase) DeletePost(ctx context.Context, id xid.ID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, `DELETE FROM comments WHERE post_id=?`, id); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM revisions WHERE post_id=?`, id); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM attachments WHERE post_id=?`, id); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM posts WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if err = checkRowsAffected(res, 1); err != nil {
+		if err == ErrMistmatchedRowsAffected {
+			err = ErrNoPostFoundByID
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteDatabase) DeleteComment(ctx context.Context, id xid.ID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `UPDATE comments SET deleted=true, content='' WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if err = checkRowsAffected(res, 1); err != nil {
+		if err == ErrMistmatchedRowsAffected {
+			err = ErrNoCommentFoundByID
+		}
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM revisions WHERE target_id=?`, id); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM attachments WHERE target_id=?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteDatabase) DeleteUser(ctx context.Context, id xid.ID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `UPDATE users SET deleted=true, password='' WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if err = checkRowsAffected(res, 1); err != nil {
+		if err == ErrMistmatchedRowsAffected {
+			err = ErrNoUserFoundByID
+		}
+		return err
+	}
+	for _, query := range []string{
+		`DELETE FROM sessions WHERE user_id=?`,
+		`DELETE FROM api_tokens WHERE user_id=?`,
+		`DELETE FROM attachments WHERE uploader_id=?`,
+	} {
+		if _, err = tx.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteDatabase) CountUsers(ctx context.Context) (count int, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM users`).Scan(&count)
+	return
+}
+
+func (s *SQLiteDatabase) SetUserRole(ctx context.Context, id xid.ID, role Role) error {
+	return s.updateOne(ctx, ErrNoUserFoundByID, `UPDATE users SET role=? WHERE id=? AND NOT deleted`, role, id)
+}
+
+func (s *SQLiteDatabase) SetUserBanned(ctx context.Context, id xid.ID, banned bool) error {
+	return s.updateOne(ctx, ErrNoUserFoundByID, `UPDATE users SET banned=? WHERE id=? AND NOT deleted`, banned, id)
+}
+
+func (s *SQLiteDatabase) SetPostLocked(ctx context.Context, id xid.ID, locked bool) error {
+	return s.updateOne(ctx, ErrNoPostFoundByID, `UPDATE posts SET locked=? WHERE id=?`, locked, id)
+}
+
+func (s *SQLiteDatabase) SetPostHidden(ctx context.Context, id xid.ID, hidden bool) error {
+	return s.updateOne(ctx, ErrNoPostFoundByID, `UPDATE posts SET hidden=? WHERE id=?`, hidden, id)
+}
+
+func (s *SQLiteDatabase) SetCommentHidden(ctx context.Context, id xid.ID, hidden bool) error {
+	return s.updateOne(ctx, ErrNoCommentFoundByID, `UPDATE comments SET hidden=? WHERE id=?`, hidden, id)
+}
+
+// updateOne runs an update that has to affect exactly one row, returns notFound if it did not
+func (s *SQLiteDatabase) updateOne(ctx context.Context, notFound error, query string, args ...interface{}) error {
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	err = checkRowsAffected(res, 1)
+	if err == ErrMistmatchedRowsAffected {
+		err = notFound
+	}
+	return err
+}
+
+func (s *SQLiteDatabase) AddSession(ctx context.Context, session Session) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO sessions(token, user_id, expiry)
+	VALUES (?, ?, ?)
+	ON CONFLICT (token) DO UPDATE SET user_id=excluded.user_id, expiry=excluded.expiry`, session.Token, session.UserID, session.Expiry.UTC())
+	return err
+}
+
+func (s *SQLiteDatabase) GetSession(ctx context.Context, token string) (session Session, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT token, user_id, expiry FROM sessions WHERE token=?`, token).
+		Scan(&session.Token, &session.UserID, &session.Expiry)
+	if err == sql.ErrNoRows {
+		err = ErrNoSessionFoundByToken
+	}
+	return
+}
+
+func (s *SQLiteDatabase) DeleteSession(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE token=?`, token)
+	return err
+}
+
+func (s *SQLiteDatabase) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE expiry < ?`, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *SQLiteDatabase) CountSessions(ctx context.Context, now time.Time) (count int, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM sessions WHERE expiry >= ?`, now.UTC()).Scan(&count)
+	return
+}
+
+func (s *SQLiteDatabase) AddAPIToken(ctx context.Context, userID xid.ID, name, hash string, scopes []string) (id xid.ID, err error) {
+	id = xid.New()
+	_, err = s.db.ExecContext(ctx, `INSERT INTO api_tokens(name, hash, user_id, scopes, id, date_created)
+	VALUES (?, ?, ?, ?, ?, ?)`, name, hash, userID, strings.Join(scopes, ","), id, time.Now().UTC())
+	return
+}
+
+func (s *SQLiteDatabase) FindAPITokenByHash(ctx context.Context, hash string) (token APIToken, err error) {
+	token, err = scanSQLiteAPIToken(s.db.QueryRowContext(ctx, `SELECT `+sqliteAPITokenColumns+` FROM api_tokens WHERE hash=?`, hash))
+	if err == sql.ErrNoRows {
+		err = ErrNoAPITokenFound
+	}
+	return
+}
+
+func (s *SQLiteDatabase) UserAPITokens(ctx context.Context, userID xid.ID) (tokens []APIToken, err error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sqliteAPITokenColumns+` FROM api_tokens WHERE user_id=? ORDER BY date_created ASC, id ASC`, userID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	tokens = []APIToken{}
+	for rows.Next() {
+		var token APIToken
+		token, err = scanSQLiteAPIToken(rows)
+		if err != nil {
+			return
+		}
+		tokens = append(tokens, token)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SQLiteDatabase) DeleteAPIToken(ctx context.Context, id, userID xid.ID) error {
+	return s.updateOne(ctx, ErrNoAPITokenFound, `DELETE FROM api_tokens WHERE id=? AND user_id=?`, id, userID)
+}
+
+func (s *SQLiteDatabase) TouchAPIToken(ctx context.Context, id xid.ID, lastUsed time.Time) error {
+	return s.updateOne(ctx, ErrNoAPITokenFound, `UPDATE api_tokens SET last_used=? WHERE id=?`, lastUsed.UTC(), id)
+}
+
+func (s *SQLiteDatabase) queryPosts(ctx context.Context, query string, args ...interface{}) (posts []Post, err error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var post Post
+		post, err = scanSQLitePost(rows)
+		if err != nil {
+			return
+		}
+		posts = append(posts, post)
+	}
+	err = rows.Err()
+	return
+}
+
+// scanSQLitePost scans a row selected with sqlitePostColumns into a Post
+func scanSQLitePost(row rowScanner) (post Post, err error) {
+	var commentIDs sql.NullString
+	var dateEdited sql.NullTime
+	err = row.Scan(&post.Title, &post.Content, &post.PosterID, &post.BoardID, &post.ID, &post.DateCreated, &dateEdited, &post.Locked, &post.Hidden, &commentIDs)
+	if err != nil {
+		return
+	}
+	post.DateEdited = dateEdited.Time
+	post.CommentIDs = [][]byte{}
+	if commentIDs.String == "" {
+		return
+	}
+	for _, s := range strings.Split(commentIDs.String, ",") {
+		var commentID xid.ID
+		commentID, err = xid.FromString(s)
+		if err != nil {
+			return
+		}
+		post.CommentIDs = append(post.CommentIDs, commentID.Bytes())
+	}
+	return
+}
+
+// scanSQLiteBoard scans a row selected with sqliteBoardColumns into a Board
+func scanSQLiteBoard(row rowScanner) (board Board, err error) {
+	err = row.Scan(&board.Name, &board.Slug, &board.Description, &board.Position, &board.ID, &board.DateCreated)
+	return
+}
+
+// parseSQLiteTime parses a timestamp that sqlite handed back as text, the driver only
+// parses the values of columns declared as timestamps and not computed ones like max()
+func parseSQLiteTime(value string) (time.Time, error) {
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(format, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrMalformedSQLiteTime
+}
+
+// scanSQLiteComment scans a row selected with sqliteCommentColumns into a Comment
+func scanSQLiteComment(row rowScanner) (comment Comment, err error) {
+	var dateEdited sql.NullTime
+	err = row.Scan(&comment.Content, &comment.PostID, &comment.PosterID, &comment.ParentID, &comment.ID, &comment.DateCreated, &comment.Deleted, &dateEdited, &comment.Hidden)
+	comment.DateEdited = dateEdited.Time
+	return
+}
+
+// scanSQLiteUser scans a row selected with sqliteUserColumns into a User
+func scanSQLiteUser(row rowScanner) (user User, err error) {
+	err = row.Scan(&user.Name, &user.ID, &user.Password, &user.DateJoined, &user.Deleted, &user.Role, &user.Banned)
+	return
+}
+
+// scanSQLiteAPIToken scans a row selected with sqliteAPITokenColumns into an APIToken
+func scanSQLiteAPIToken(row rowScanner) (token APIToken, err error) {
+	var scopes string
+	var lastUsed sql.NullTime
+	err = row.Scan(&token.Name, &token.Hash, &token.UserID, &scopes, &token.ID, &token.DateCreated, &lastUsed)
+	token.Scopes = splitScopes(scopes)
+	token.LastUsed = lastUsed.Time
+	return
+}
+
+// scanAttachment scans a row selected with the attachment columns into an Attachment,
+// both sql backends select the same columns
+func scanAttachment(row rowScanner) (a Attachment, err error) {
+	err = row.Scan(&a.TargetID, &a.PostID, &a.UploaderID, &a.Key, &a.ThumbnailKey, &a.ContentType, &a.Width, &a.Height, &a.Size, &a.ID, &a.DateCreated)
+	return
+}
+
+// checkRowsAffected returns ErrMistmatchedRowsAffected if the result did not affect exactly n rows
+func checkRowsAffected(res sql.Result, n int64) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected != n {
+		return ErrMistmatchedRowsAffected
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/xid"
+)
+
+func TestConnectSQLite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "testdatabase.sqlite")
+	s, err := ConnectSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := s.AddUser(ctx, "courtier", "courtier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	boardID, err := s.AddBoard(ctx, "general", "general", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, err := s.AddPost(ctx, "title", "content", userID, boardID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.AddComment(ctx, "comment", postID, userID, xid.NilID()); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Disconnect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s, err = ConnectSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Disconnect(context.Background())
+	post, err := s.GetPost(ctx, postID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Title != "title" || len(post.CommentIDs) != 1 {
+		t.Error("could not read back post, got:", post)
+	}
+	if _, err = s.FindUserByName(ctx, "courtier"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLiteMovePostsWithoutBoard(t *testing.T) {
+	ctx := context.Background()
+	s, err := ConnectSQLite(filepath.Join(t.TempDir(), "testdatabase.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Disconnect(context.Background())
+	boardID, err := s.AddBoard(ctx, "general", "general", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, err := s.AddPost(ctx, "title", "content", xid.New(), boardID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// posts from before boards were added have no board_id at all
+	if _, err = s.db.Exec(`UPDATE posts SET board_id=NULL WHERE id=?`, postID); err != nil {
+		t.Fatal(err)
+	}
+	if moved, err := s.MovePostsWithoutBoard(ctx, xid.New()); err != nil || moved != 0 {
+		t.Error("moving posts into a missing board should move none, got:", moved, err)
+	}
+	if moved, err := s.MovePostsWithoutBoard(ctx, boardID); err != nil || moved != 1 {
+		t.Error("MovePostsWithoutBoard expected to move 1 post, got:", moved, err)
+	}
+	if post, err := s.GetPost(ctx, postID); err != nil || post.BoardID != boardID {
+		t.Error("the post should be in the board, got:", post.BoardID, err)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/courtier/carrotbb/templates"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+const (
+	EVENTS_PREFIX = "/events/"
+	// SSE_HEARTBEAT_INTERVAL keeps idle streams from being cut by proxies
+	SSE_HEARTBEAT_INTERVAL = 30 * time.Second
+	MAX_SSE_CONNECTIONS    = 1024
+	// MAX_SSE_CONNECTIONS_PER_CLIENT is per remote address, a few open tabs fit in it
+	MAX_SSE_CONNECTIONS_PER_CLIENT = 8
+	// SSE_BUFFER is how many events a subscriber can fall behind before it misses some
+	SSE_BUFFER = 16
+	// INDEX_TOPIC is where new posts are published, posts publish their comments to their id
+	INDEX_TOPIC = "index"
+)
+
+var (
+	ErrTooManyConnections = errors.New("too many live connections, try again later")
+)
+
+var (
+	hub = newEventHub(MAX_SSE_CONNECTIONS, MAX_SSE_CONNECTIONS_PER_CLIENT)
+)
+
+// event is a server-sent event, data is sent as a single line of json
+type event struct {
+	ID   string
+	Name string
+	Data []byte
+}
+
+// eventHub fans events out to everyone subscribed to a topic, within this process only
+type eventHub struct {
+	lock      sync.Mutex
+	topics    map[string]map[chan event]struct{}
+	clients   map[string]int
+	total     int
+	max       int
+	maxClient int
+	// done is closed on shutdown to end every stream, which would otherwise hold the server open
+	done     chan struct{}
+	shutOnce sync.Once
+}
+
+// commentEvent is what the post page gets for a new comment
+type commentEvent struct {
+	ID       xid.ID `json:"id"`
+	ParentID xid.ID `json:"parent_id"`
+	// the comment rendered the way the post page shows it
+	HTML string `json:"html"`
+}
+
+// postEvent is what the index gets for a new post
+type postEvent struct {
+	ID       xid.ID `json:"id"`
+	Title    string `json:"title"`
+	BoardID  xid.ID `json:"board_id"`
+	PosterID xid.ID `json:"poster_id"`
+	Poster   string `json:"poster"`
+}
+
+func newEventHub(max, maxClient int) *eventHub {
+	return &eventHub{
+		topics:    make(map[string]map[chan event]struct{}),
+		clients:   make(map[string]int),
+		max:       max,
+		maxClient: maxClient,
+		done:      make(chan struct{}),
+	}
+}
+
+// shutdown ends every open stream, it is safe to call more than once
+func (h *eventHub) shutdown() {
+	h.shutOnce.Do(func() { close(h.done) })
+}
+
+// subscribe opens a channel that receives the events of topic until it is unsubscribed,
+// returns ErrTooManyConnections if the hub or the client are at their limit
+func (h *eventHub) subscribe(topic, client string) (chan event, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.total >= h.max || h.clients[client] >= h.maxClient {
+		return nil, ErrTooManyConnections
+	}
+	h.total++
+	h.clients[client]++
+	ch := make(chan event, SSE_BUFFER)
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[chan event]struct{})
+	}
+	h.topics[topic][ch] = struct{}{}
+	return ch, nil
+}
+
+func (h *eventHub) unsubscribe(topic, client string, ch chan event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := h.topics[topic][ch]; !ok {
+		return
+	}
+	delete(h.topics[topic], ch)
+	if len(h.topics[topic]) == 0 {
+		delete(h.topics, topic)
+	}
+	h.total--
+	if h.clients[client]--; h.clients[client] <= 0 {
+		delete(h.clients, client)
+	}
+}
+
+// publish sends an event to every subscriber of topic without waiting,
+// subscribers whose buffer is full miss it rather than hold everyone up
+func (h *eventHub) publish(topic string, e event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for ch := range h.topics[topic] {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// publishComment tells the open pages of a post about a new comment
+func publishComment(ctx context.Context, post database.Post, commentID xid.ID, author database.User, attachments []database.Attachment) {
+	comment, err := db.GetComment(ctx, commentID)
+	if err != nil {
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	var html bytes.Buffer
+	if err := templates.RenderComment(&html, post, comment, author, attachments); err != nil {
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	publishJSON(post.ID.String(), "comment", comment.ID, commentEvent{ID: comment.ID, ParentID: comment.ParentID, HTML: html.String()})
+}
+
+// publishPost tells the index about a new post
+func publishPost(postID xid.ID, title string, boardID xid.ID, poster database.User) {
+	publishJSON(INDEX_TOPIC, "post", postID, postEvent{ID: postID, Title: title, BoardID: boardID, PosterID: poster.ID, Poster: poster.Name})
+}
+
+func publishJSON(topic, name string, id xid.ID, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	hub.publish(topic, event{ID: id.String(), Name: name, Data: data})
+}
+
+// EventsHandler streams server-sent events, /events/index for new posts
+// and /events/post/{id} for new comments under a post
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Add("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	pathSplit := pathIntoArray(strings.TrimPrefix(r.URL.EscapedPath(), EVENTS_PREFIX))
+	var topic string
+	switch {
+	case len(pathSplit) == 1 && pathSplit[0] == INDEX_TOPIC:
+		topic = INDEX_TOPIC
+	case len(pathSplit) == 2 && pathSplit[0] == "post":
+		postID, err := xid.FromString(pathSplit[1])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			templates.GenerateErrorPage(w, "malformed post id")
+			return
+		}
+		post, err := db.GetPost(r.Context(), postID)
+		if err == database.ErrNoPostFoundByID || (err == nil && post.Hidden && !profileFromCtx(r.Context()).Moderator) {
+			w.WriteHeader(http.StatusNotFound)
+			templates.GenerateErrorPage(w, "that post does not exist")
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			templates.GenerateErrorPage(w, "error while fetching the post")
+			zapper.Error("error", zap.Error(err))
+			return
+		}
+		topic = post.ID.String()
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "unknown event stream")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "streaming is not supported")
+		return
+	}
+	client := remoteHost(r)
+	events, err := hub.subscribe(topic, client)
+	if err != nil {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		templates.GenerateErrorPage(w, err.Error())
+		return
+	}
+	defer hub.unsubscribe(topic, client, events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// keeps nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	// streams outlive the write timeout of the server
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		zapper.Error("error", zap.Error(err))
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	heartbeat := time.NewTicker(SSE_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-hub.done:
+			return
+		case <-heartbeat.C:
+			_, err = w.Write([]byte(": heartbeat\n\n"))
+		case e := <-events:
+			_, err = w.Write([]byte("id: " + e.ID + "\nevent: " + e.Name + "\ndata: " + string(e.Data) + "\n\n"))
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// remoteHost is the address a request came from without its port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
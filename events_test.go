@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+func TestEventHubLimits(t *testing.T) {
+	h := newEventHub(3, 2)
+	first, err := h.subscribe("a", "carrot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = h.subscribe("b", "carrot"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = h.subscribe("a", "carrot"); err != ErrTooManyConnections {
+		t.Error("third connection of a client expected:", ErrTooManyConnections, "got:", err)
+	}
+	if _, err = h.subscribe("a", "potato"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = h.subscribe("a", "turnip"); err != ErrTooManyConnections {
+		t.Error("connection over the total limit expected:", ErrTooManyConnections, "got:", err)
+	}
+	h.unsubscribe("a", "carrot", first)
+	if _, err = h.subscribe("a", "turnip"); err != nil {
+		t.Error("connection after one closed expected no error, got:", err)
+	}
+
+	// a subscriber that does not read misses events instead of blocking publishers
+	h = newEventHub(1, 1)
+	slow, err := h.subscribe("a", "carrot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < SSE_BUFFER*2; i++ {
+		h.publish("a", event{Name: "comment"})
+	}
+	if len(slow) != SSE_BUFFER {
+		t.Error("slow subscriber expected a full buffer of:", SSE_BUFFER, "got:", len(slow))
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	ctx := context.Background()
+	newAPITestServer(t)
+	user, err := db.FindUserByName(ctx, "carrot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	board, err := db.FindBoardBySlug(ctx, DEFAULT_BOARD_SLUG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, err := db.AddPost(ctx, "hello", "world", user.ID, board.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post, err := db.GetPost(ctx, postID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(EventsHandler))
+	defer server.Close()
+
+	for path, code := range map[string]int{
+		"/events/post/carrot":                http.StatusBadRequest,
+		"/events/post/" + xid.New().String(): http.StatusNotFound,
+		"/events/carrots":                    http.StatusNotFound,
+	} {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Error("Path:", path, "expected:", code, "got:", res.StatusCode)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/events/post/"+postID.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal("expected an event stream, got:", res.StatusCode, res.Header)
+	}
+	commentID, err := db.AddComment(ctx, "*first*", postID, user.ID, xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishComment(ctx, post, commentID, user, nil)
+
+	lines := bufio.NewScanner(res.Body)
+	received := map[string]string{}
+	for lines.Scan() && lines.Text() != "" {
+		split := strings.SplitN(lines.Text(), ": ", 2)
+		received[split[0]] = split[1]
+	}
+	if received["id"] != commentID.String() || received["event"] != "comment" {
+		t.Fatal("expected a comment event, got:", received)
+	}
+	var data commentEvent
+	if err = json.Unmarshal([]byte(received["data"]), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.ID != commentID || !strings.Contains(data.HTML, `id="comment-`+commentID.String()+`"`) || !strings.Contains(data.HTML, "<em>first</em>") {
+		t.Error("comment event expected the rendered comment, got:", data)
+	}
+}
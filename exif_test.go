@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// exifOrientation is a tiff structure with nothing but an orientation, in either byte order
+func exifOrientation(bigEndian bool, orientation byte) string {
+	if bigEndian {
+		return "MM\x00\x2a\x00\x00\x00\x08" + "\x00\x01" + "\x01\x12\x00\x03\x00\x00\x00\x01\x00" + string(orientation) + "\x00\x00" + "\x00\x00\x00\x00"
+	}
+	return "II\x2a\x00\x08\x00\x00\x00" + "\x01\x00" + "\x12\x01\x03\x00\x01\x00\x00\x00" + string(orientation) + "\x00\x00\x00" + "\x00\x00\x00\x00"
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	for name, test := range map[string]struct {
+		data        []byte
+		orientation int
+	}{
+		"big endian":    {testJPEG(t, 8, 8, exifOrientation(true, 6)), 6},
+		"little endian": {testJPEG(t, 8, 8, exifOrientation(false, 8)), 8},
+		"out of range":  {testJPEG(t, 8, 8, exifOrientation(true, 9)), 1},
+		"other exif":    {testJPEG(t, 8, 8, "GPS 52.5200 13.4050"), 1},
+		"not a jpeg":    {[]byte("carrot"), 1},
+	} {
+		if orientation := jpegOrientation(test.data); orientation != test.orientation {
+			t.Error(name, "expected:", test.orientation, "got:", orientation)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// a 3 by 2 image with every pixel telling where it came from
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	// where the top left and top right pixels end up
+	for orientation, corners := range map[int][2]image.Point{
+		1: {{0, 0}, {2, 0}},
+		2: {{2, 0}, {0, 0}},
+		3: {{2, 1}, {0, 1}},
+		4: {{0, 1}, {2, 1}},
+		5: {{0, 0}, {0, 2}},
+		6: {{1, 0}, {1, 2}},
+		7: {{1, 2}, {1, 0}},
+		8: {{0, 2}, {0, 0}},
+	} {
+		oriented := orient(img, orientation)
+		size := oriented.Bounds().Size()
+		if (orientation >= 5) != (size == image.Pt(2, 3)) {
+			t.Error(orientation, "turned to the wrong size:", size)
+		}
+		if oriented.At(corners[0].X, corners[0].Y) != img.At(0, 0) || oriented.At(corners[1].X, corners[1].Y) != img.At(2, 0) {
+			t.Error(orientation, "expected the top corners at:", corners)
+		}
+	}
+}
+
+func TestProcessImageOrients(t *testing.T) {
+	u, err := processImage(testJPEG(t, 200, 100, exifOrientation(true, 6)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Width != 100 || u.Height != 200 {
+		t.Error("a quarter turned image should swap its sides, got:", u.Width, u.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(u.Image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the bottom left of the original ends up top left, jpeg only keeps the colors roughly
+	if r, g, _, _ := img.At(0, 0).RGBA(); r>>8 > 16 || g>>8 < 83 {
+		t.Error("expected the bottom left of the original at the top left, got:", r>>8, g>>8)
+	}
+}
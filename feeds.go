@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/courtier/carrotbb/templates"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+const (
+	// FEED_LENGTH is how many entries a feed holds
+	FEED_LENGTH = 20
+	FEED_PREFIX = "/feed/"
+)
+
+var (
+	ErrMalformedFeedPath = errors.New("malformed feed path, feeds end in .atom or .rss")
+	ErrMalformedFeedID   = errors.New("malformed id in feed path")
+)
+
+var (
+	// siteURL is the scheme and host that links in feeds start with, see setSiteURL.
+	// empty uses the host of each request
+	siteURL = ""
+)
+
+// feed is a feed before it is written out as atom or rss
+type feed struct {
+	Title string
+	// path of the page the feed follows
+	Link    string
+	Updated time.Time
+	Items   []feedItem
+}
+
+type feedItem struct {
+	Title  string
+	Link   string
+	Author string
+	// rendered html
+	Content   string
+	Published time.Time
+	Updated   time.Time
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    atomAuthor  `xml:"author"`
+	Link      atomLink    `xml:"link"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// setSiteURL builds the start of absolute links from the configured domain,
+// links stay relative to the host of each request if it is empty
+func setSiteURL(domain string, https bool) {
+	if domain == "" {
+		return
+	}
+	if https {
+		siteURL = "https://" + domain
+	} else {
+		siteURL = "http://" + domain
+	}
+}
+
+// absoluteURL turns a path into a link that works outside of the site
+func absoluteURL(r *http.Request, path string) string {
+	if siteURL != "" {
+		return siteURL + path
+	}
+	scheme := "http://"
+	if r.TLS != nil {
+		scheme = "https://"
+	}
+	return scheme + r.Host + path
+}
+
+// FeedHandler serves the atom and rss feeds: /feed.atom and /feed.rss for the latest posts,
+// /feed/post/{id}.atom for the latest comments under a post and /feed/user/{id}.atom for the posts of a user.
+// feed readers are often shared, so hidden posts and comments are left out even for moderators
+func FeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Add("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := r.URL.EscapedPath()
+	format := ""
+	for _, f := range []string{"atom", "rss"} {
+		if strings.HasSuffix(path, "."+f) {
+			format = f
+			path = strings.TrimSuffix(path, "."+f)
+		}
+	}
+	pathSplit := pathIntoArray(strings.TrimPrefix(path, FEED_PREFIX))
+	var f feed
+	var err error
+	switch {
+	case format == "":
+		err = ErrMalformedFeedPath
+	case path == "/feed":
+		f, err = latestPostsFeed(r.Context())
+	case len(pathSplit) == 2 && pathSplit[0] == "post":
+		f, err = postFeed(r.Context(), pathSplit[1])
+	case len(pathSplit) == 2 && pathSplit[0] == "user":
+		f, err = userFeed(r.Context(), pathSplit[1])
+	default:
+		err = ErrMalformedFeedPath
+	}
+	switch err {
+	case nil:
+	case ErrMalformedFeedPath, ErrMalformedFeedID:
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, err.Error())
+		return
+	case database.ErrNoPostFoundByID, database.ErrNoUserFoundByID:
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "there is no such feed")
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while building the feed")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	var body []byte
+	if format == "atom" {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		body, err = encodeAtom(r, f, r.URL.EscapedPath())
+	} else {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		body, err = encodeRSS(r, f)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while building the feed")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	// ServeContent answers If-None-Match and If-Modified-Since with 304 Not Modified
+	http.ServeContent(w, r, "", f.Updated, bytes.NewReader(body))
+}
+
+func latestPostsFeed(ctx context.Context) (feed, error) {
+	posts, _, err := db.PagePosts(ctx, database.Page{Limit: FEED_LENGTH})
+	if err != nil {
+		return feed{}, err
+	}
+	f := feed{Title: "carrotbb - latest posts", Link: "/"}
+	return f, addPostItems(ctx, &f, posts, nil)
+}
+
+// postFeed follows the comments under a post, newest first, replies included however deep they are
+func postFeed(ctx context.Context, encodedID string) (feed, error) {
+	postID, err := xid.FromString(encodedID)
+	if err != nil {
+		return feed{}, ErrMalformedFeedID
+	}
+	post, err := db.GetPost(ctx, postID)
+	if err != nil {
+		return feed{}, err
+	}
+	if post.Hidden {
+		return feed{}, database.ErrNoPostFoundByID
+	}
+	comments, users, err := db.LatestComments(ctx, postID, FEED_LENGTH)
+	if err != nil {
+		return feed{}, err
+	}
+	f := feed{Title: "carrotbb - " + post.Title, Link: "/post/" + post.ID.String(), Updated: latest(post.DateCreated, post.DateEdited)}
+	for _, comment := range comments {
+		f.Items = append(f.Items, feedItem{
+			Title:     "comment by " + users[comment.ID].Name,
+			Link:      "/post/" + post.ID.String() + "#comment-" + comment.ID.String(),
+			Author:    users[comment.ID].Name,
+			Content:   string(templates.RenderMarkdown(comment.ID, comment.DateEdited, comment.Content)),
+			Published: comment.DateCreated,
+			Updated:   latest(comment.DateCreated, comment.DateEdited),
+		})
+		f.Updated = latest(f.Updated, latest(comment.DateCreated, comment.DateEdited))
+	}
+	return f, nil
+}
+
+func userFeed(ctx context.Context, encodedID string) (feed, error) {
+	userID, err := xid.FromString(encodedID)
+	if err != nil {
+		return feed{}, ErrMalformedFeedID
+	}
+	user, err := db.GetUser(ctx, userID)
+	if err != nil {
+		return feed{}, err
+	}
+	if user.Deleted {
+		return feed{}, database.ErrNoUserFoundByID
+	}
+	posts, _, err := db.PageUserPosts(ctx, userID, database.Page{Limit: FEED_LENGTH})
+	if err != nil {
+		return feed{}, err
+	}
+	f := feed{Title: "carrotbb - posts by " + user.Name, Link: "/user/" + user.ID.String(), Updated: user.DateJoined}
+	return f, addPostItems(ctx, &f, posts, map[xid.ID]database.User{user.ID: user})
+}
+
+// addPostItems adds posts to a feed, looking up the posters that are not in users yet
+func addPostItems(ctx context.Context, f *feed, posts []database.Post, users map[xid.ID]database.User) error {
+	if users == nil {
+		users = make(map[xid.ID]database.User)
+	}
+	for _, post := range posts {
+		poster, ok := users[post.PosterID]
+		if !ok {
+			var err error
+			poster, err = db.GetUser(ctx, post.PosterID)
+			if err == database.ErrNoUserFoundByID || poster.Deleted {
+				poster, err = database.DeletedUser, nil
+			}
+			if err != nil {
+				return err
+			}
+			users[post.PosterID] = poster
+		}
+		f.Items = append(f.Items, feedItem{
+			Title:     post.Title,
+			Link:      "/post/" + post.ID.String(),
+			Author:    poster.Name,
+			Content:   string(templates.RenderMarkdown(post.ID, post.DateEdited, post.Content)),
+			Published: post.DateCreated,
+			Updated:   latest(post.DateCreated, post.DateEdited),
+		})
+		f.Updated = latest(f.Updated, latest(post.DateCreated, post.DateEdited))
+	}
+	return nil
+}
+
+func encodeAtom(r *http.Request, f feed, self string) ([]byte, error) {
+	atom := atomFeed{
+		ID:      absoluteURL(r, self),
+		Title:   f.Title,
+		Updated: f.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: absoluteURL(r, f.Link), Rel: "alternate", Type: "text/html"},
+			{Href: absoluteURL(r, self), Rel: "self", Type: "application/atom+xml"},
+		},
+		Entries: []atomEntry{},
+	}
+	for _, item := range f.Items {
+		link := absoluteURL(r, item.Link)
+		atom.Entries = append(atom.Entries, atomEntry{
+			ID:        link,
+			Title:     item.Title,
+			Published: item.Published.UTC().Format(time.RFC3339),
+			Updated:   item.Updated.UTC().Format(time.RFC3339),
+			Author:    atomAuthor{Name: item.Author},
+			Link:      atomLink{Href: link, Rel: "alternate", Type: "text/html"},
+			Content:   atomContent{Type: "html", Body: item.Content},
+		})
+	}
+	return encodeXML(atom)
+}
+
+func encodeRSS(r *http.Request, f feed) ([]byte, error) {
+	rss := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          absoluteURL(r, f.Link),
+			Description:   f.Title,
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+			Items:         []rssItem{},
+		},
+	}
+	for _, item := range f.Items {
+		link := absoluteURL(r, item.Link)
+		rss.Channel.Items = append(rss.Channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			PubDate:     item.Published.UTC().Format(time.RFC1123Z),
+			Description: item.Content,
+		})
+	}
+	return encodeXML(rss)
+}
+
+func encodeXML(v interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// latest returns the later of two times, a zero time is never later
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
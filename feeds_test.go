@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+func feedRequest(t *testing.T, path string, header http.Header, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("GET", path, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	FeedHandler(rec, r)
+	if out != nil && rec.Code == http.StatusOK {
+		if err := xml.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatal(path, "returned malformed xml:", err)
+		}
+	}
+	return rec
+}
+
+func TestFeeds(t *testing.T) {
+	ctx := context.Background()
+	newAPITestServer(t)
+	t.Cleanup(func() { siteURL = "" })
+	setSiteURL("carrot.example", true)
+	user, err := db.FindUserByName(ctx, "carrot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	board, err := db.FindBoardBySlug(ctx, DEFAULT_BOARD_SLUG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, err := db.AddPost(ctx, "hello", "*world*", user.ID, board.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commentID, err := db.AddComment(ctx, "first", postID, user.ID, xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	replyID, err := db.AddComment(ctx, "reply", postID, user.ID, commentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var atom atomFeed
+	rec := feedRequest(t, "/feed.atom", nil, &atom)
+	if rec.Code != http.StatusOK || len(atom.Entries) != 1 || atom.Entries[0].Title != "hello" {
+		t.Fatal("latest posts feed expected the post, got:", rec.Code, atom)
+	}
+	if atom.Entries[0].ID != "https://carrot.example/post/"+postID.String() || !strings.Contains(atom.Entries[0].Content.Body, "<em>world</em>") {
+		t.Error("atom entry expected an absolute link and rendered content, got:", atom.Entries[0])
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" || rec.Header().Get("Last-Modified") == "" {
+		t.Error("feed expected an ETag and a Last-Modified header, got:", rec.Header())
+	}
+	if rec := feedRequest(t, "/feed.atom", http.Header{"If-None-Match": {etag}}, nil); rec.Code != http.StatusNotModified {
+		t.Error("feed with a matching ETag expected:", http.StatusNotModified, "got:", rec.Code)
+	}
+
+	var rss rssFeed
+	if rec := feedRequest(t, "/feed/post/"+postID.String()+".rss", nil, &rss); rec.Code != http.StatusOK || len(rss.Channel.Items) != 2 {
+		t.Fatal("post feed expected the comment and its reply, got:", rec.Code, rss)
+	}
+	if link := rss.Channel.Items[0].Link; link != "https://carrot.example/post/"+postID.String()+"#comment-"+replyID.String() {
+		t.Error("rss item expected a link to the newest comment, the reply, got:", link)
+	}
+	if link := rss.Channel.Items[1].Link; link != "https://carrot.example/post/"+postID.String()+"#comment-"+commentID.String() {
+		t.Error("rss item expected a link to the comment, got:", link)
+	}
+	atom = atomFeed{}
+	if rec := feedRequest(t, "/feed/user/"+user.ID.String()+".atom", nil, &atom); rec.Code != http.StatusOK || len(atom.Entries) != 1 {
+		t.Error("user feed expected the post, got:", rec.Code, atom)
+	}
+
+	if err = db.SetPostHidden(ctx, postID, true); err != nil {
+		t.Fatal(err)
+	}
+	payloads := map[string]int{
+		"/feed.xml":              http.StatusBadRequest,
+		"/feed/post/carrot.atom": http.StatusBadRequest,
+		"/feed/post/" + xid.New().String() + ".atom": http.StatusNotFound,
+		"/feed/post/" + postID.String() + ".atom":    http.StatusNotFound,
+		"/feed/board/carrots.rss":                    http.StatusBadRequest,
+	}
+	for k, v := range payloads {
+		if rec := feedRequest(t, k, nil, nil); rec.Code != v {
+			t.Error("Path:", k, "expected:", v, "got:", rec.Code)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// READY_TIMEOUT bounds every readiness check, probes give up after a few seconds anyway
+	READY_TIMEOUT = 2 * time.Second
+)
+
+var (
+	// shuttingDown is set once a shutdown begins, readiness fails from then on
+	shuttingDown atomic.Bool
+)
+
+// componentStatus is the result of checking one thing the board depends on
+type componentStatus struct {
+	Status string `json:"status"`
+}
+
+type readiness struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentStatus `json:"components"`
+}
+
+// HealthHandler reports that the process is up, without checking anything it depends on
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Add("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, componentStatus{Status: "ok"})
+}
+
+// ReadyHandler reports whether the board can serve requests, checking the database and the blob store.
+// It is not ready once a shutdown begins, so load balancers stop sending requests before the listeners close
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Add("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), READY_TIMEOUT)
+	defer cancel()
+	ready := readiness{
+		Status: "ready",
+		Components: map[string]componentStatus{
+			"database": checkComponent(ctx, db.Ping),
+			"blobs":    checkComponent(ctx, blobStore.Ping),
+		},
+	}
+	for _, component := range ready.Components {
+		if component.Status != "ok" {
+			ready.Status = "not ready"
+		}
+	}
+	if shuttingDown.Load() {
+		ready.Status = "shutting down"
+	}
+	status := http.StatusOK
+	if ready.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, ready)
+}
+
+// checkComponent runs a check, errors are logged rather than shown since the probes are public
+func checkComponent(ctx context.Context, ping func(context.Context) error) componentStatus {
+	if err := ping(ctx); err != nil {
+		zapper.Error("readiness check failed", zap.Error(err))
+		return componentStatus{Status: "error"}
+	}
+	return componentStatus{Status: "ok"}
+}
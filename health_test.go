@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/courtier/carrotbb/blobs"
+)
+
+func TestHealthHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	HealthHandler(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Error("healthz expected:", http.StatusOK, "got:", rec.Code, rec.Header())
+	}
+	rec = httptest.NewRecorder()
+	HealthHandler(rec, httptest.NewRequest("POST", "/healthz", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Error("POST expected:", http.StatusMethodNotAllowed, "got:", rec.Code)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	newAPITestServer(t)
+	root := filepath.Join(t.TempDir(), "blobs")
+	store, err := blobs.ConnectLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobStore = store
+	t.Cleanup(func() { shuttingDown.Store(false) })
+
+	ready := func(expected int) readiness {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		ReadyHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+		var body readiness
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != expected {
+			t.Error("readyz expected:", expected, "got:", rec.Code, body)
+		}
+		return body
+	}
+
+	body := ready(http.StatusOK)
+	if body.Status != "ready" || body.Components["database"].Status != "ok" || body.Components["blobs"].Status != "ok" {
+		t.Error("readyz expected every component to be ok, got:", body)
+	}
+
+	if err = os.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	body = ready(http.StatusServiceUnavailable)
+	if body.Status != "not ready" || body.Components["blobs"].Status != "error" || body.Components["database"].Status != "ok" {
+		t.Error("readyz expected the blob store to fail, got:", body)
+	}
+	if err = os.MkdirAll(root, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	shuttingDown.Store(true)
+	if body = ready(http.StatusServiceUnavailable); body.Status != "shutting down" {
+		t.Error("readyz expected to report the shutdown, got:", body)
+	}
+}
@@ -3,15 +3,18 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/courtier/carrotbb/blobs"
 	"github.com/courtier/carrotbb/database"
 	"github.com/courtier/carrotbb/templates"
-	"github.com/joho/godotenv"
 	"github.com/rs/xid"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/acme/autocert"
@@ -23,11 +26,23 @@ var (
 )
 
 func main() {
-	var err error
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrateCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
 
-	err = godotenv.Load()
+	flags := flag.NewFlagSet("carrotbb", flag.ExitOnError)
+	adminName := flags.String("admin", "", "promote the user with this name to admin on startup")
+	config, err := loadConfig(flags, os.Args[1:])
+	if err == nil {
+		err = config.validate()
+	}
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, "invalid config:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	zapper, err = zap.NewProduction()
@@ -35,89 +50,231 @@ func main() {
 		panic(err)
 	}
 	defer zapper.Sync()
+	for _, warning := range config.warnings {
+		zapper.Warn(warning)
+	}
 
-	dbBackend := os.Getenv("DB_BACKEND")
-	httpPort := os.Getenv("HTTP_PORT")
-	httpsPort := os.Getenv("HTTPS_PORT")
-	certFile := os.Getenv("SSL_CERT_FILE")
-	keyFile := os.Getenv("SSL_KEY_FILE")
-	domain := os.Getenv("DOMAIN")
-
-	if httpPort != "" && httpPort[0] != ':' {
-		httpPort = ":" + httpPort
+	if err = setTokenBytes(config.TokenBytes); err != nil {
+		panic(err)
 	}
-	if httpsPort != "" && httpsPort[0] != ':' {
-		httpsPort = ":" + httpsPort
+	if err = setPageSize(config.PageSize); err != nil {
+		panic(err)
 	}
 
-	db, err = database.Connect(dbBackend)
+	httpPort := listenAddress(config.HTTPPort)
+	httpsPort := listenAddress(config.HTTPSPort)
+	setSiteURL(config.Domain, httpsPort != "")
+
+	db, err = database.Connect(config.database())
 	if err != nil {
 		panic(err)
 	}
-	defer func() {
-		if err := db.Disconnect(); err != nil {
-			zapper.Error("error", zap.Error(err))
+	jsonDB, _ := db.(*database.JSONDatabase)
+	db = instrumentDatabase(db)
+
+	zapper.Info("connected to database", zap.String("backend", config.DBBackend))
+
+	blobStore, err = blobs.Connect(config.blobs())
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+	if err = ensureDefaultBoard(ctx, db); err != nil {
+		panic(err)
+	}
+	if *adminName != "" {
+		if err = promoteAdmin(ctx, *adminName); err != nil {
+			panic(err)
 		}
-	}()
+	}
 
-	zapper.Info("connected to database", zap.String("backend", dbBackend))
+	sessionCache, err = NewSessionStore(config.SessionStore, db)
+	if err != nil {
+		panic(err)
+	}
+	registerGauges(registry, sessionCache, jsonDB)
+	sweepCtx, stopSweeping := context.WithCancel(ctx)
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		sweepSessions(sweepCtx, sessionCache, SESSION_SWEEP_INTERVAL)
+	}()
+	blobSweeperDone := make(chan struct{})
+	go func() {
+		defer close(blobSweeperDone)
+		sweepBlobs(sweepCtx, blobStore, BLOB_SWEEP_INTERVAL, BLOB_GRACE_PERIOD)
+	}()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", IndexPageHandler)
+	mux.HandleFunc("/b/", BoardPageHandler)
+	mux.HandleFunc("/boards", BoardsHandler)
 	mux.HandleFunc("/createpost", CreatePostHandler)
 	mux.HandleFunc("/post/", PostPageHandler)
+	mux.HandleFunc("/search", SearchHandler)
+	mux.HandleFunc("/feed.atom", FeedHandler)
+	mux.HandleFunc("/feed.rss", FeedHandler)
+	mux.HandleFunc(FEED_PREFIX, FeedHandler)
+	mux.HandleFunc(EVENTS_PREFIX, EventsHandler)
+	mux.HandleFunc(ATTACHMENTS_PREFIX, AttachmentHandler)
 	mux.HandleFunc("/createcomment", CreateCommentHandler)
+	mux.HandleFunc("/editpost", EditPostHandler)
+	mux.HandleFunc("/editcomment", EditCommentHandler)
+	mux.HandleFunc("/deletepost", DeletePostHandler)
+	mux.HandleFunc("/deletecomment", DeleteCommentHandler)
+	mux.HandleFunc("/moderate", ModerateHandler)
 	mux.HandleFunc("/signup", SignupHandler)
 	mux.HandleFunc("/signin", SigninHandler)
 	mux.HandleFunc("/logout", LogoutHandler)
 	mux.HandleFunc("/self", ProfilePageHandler)
-	mux.HandleFunc("/user", ProfilePageHandler)
+	mux.HandleFunc("/user/", ProfilePageHandler)
+	mux.HandleFunc(API_PREFIX, APIHandler)
+	mux.HandleFunc("/healthz", HealthHandler)
+	mux.HandleFunc("/readyz", ReadyHandler)
 
-	auther := NewAuthMiddleware(mux)
-	logger := NewLoggerMiddleware(auther, zapper)
+	csrf := NewCSRFMiddleware(mux)
+	auther := NewAuthMiddleware(csrf)
+	deadline := NewDeadlineMiddleware(auther, time.Duration(config.RequestTimeout)*time.Second, UPLOAD_TIMEOUT, EVENTS_PREFIX)
+	measurer := NewMetricsMiddleware(deadline, mux)
+	logger := NewLoggerMiddleware(measurer, zapper)
 
 	terminate := make(chan os.Signal, 1)
 	signal.Notify(terminate, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 
-	if httpPort != "" {
-		go func() {
-			zapper.Info("listening http", zap.String("port", httpPort))
-			zapper.Fatal("http error", zap.Error(http.ListenAndServe(httpPort, logger)))
-		}()
-	}
+	var servers []*http.Server
+	failed := make(chan error, 3)
 	if httpsPort != "" {
-		certManager := autocert.Manager{
-			Prompt:     autocert.AcceptTOS,
-			Cache:      autocert.DirCache("/cert-cache"),
-			HostPolicy: autocert.HostWhitelist(domain),
+		server := newServer(httpsPort, logger)
+		server.TLSConfig = &tls.Config{}
+		redirect := httpsRedirect(config.Domain, config.HTTPSPort)
+		// certificates are fetched for the domain unless they are given
+		if config.TLSCertFile == "" {
+			certManager := &autocert.Manager{
+				Prompt:     autocert.AcceptTOS,
+				Cache:      autocert.DirCache("/cert-cache"),
+				HostPolicy: autocert.HostWhitelist(config.Domain),
+			}
+			server.TLSConfig = certManager.TLSConfig()
+			// http challenges still have to be answered over http
+			redirect = certManager.HTTPHandler(redirect)
 		}
-
-		server := &http.Server{
-			Addr:    httpsPort,
-			Handler: logger,
-			TLSConfig: &tls.Config{
-				GetCertificate: certManager.GetCertificate,
-			},
+		servers = append(servers, server)
+		go listen(server, config.TLSCertFile, config.TLSKeyFile, failed)
+		// with both ports set plain http only sends people over to https
+		if httpPort != "" {
+			server := newServer(httpPort, NewLoggerMiddleware(redirect, zapper))
+			servers = append(servers, server)
+			go listen(server, "", "", failed)
 		}
+	} else {
+		server := newServer(httpPort, logger)
+		servers = append(servers, server)
+		go listen(server, "", "", failed)
+	}
 
-		go func() {
-			zapper.Info("listening https", zap.String("port", httpsPort))
-			zapper.Fatal("https error", zap.Error(server.ListenAndServeTLS(certFile, keyFile)))
-		}()
+	if metricsPort := listenAddress(config.MetricsPort); metricsPort != "" {
+		server := newServer(metricsPort, metricsHandler())
+		servers = append(servers, server)
+		go listen(server, "", "", failed)
 	}
 
-	<-terminate
+	var serveErr error
+	select {
+	case sig := <-terminate:
+		zapper.Info("shutting down", zap.String("signal", sig.String()))
+	case serveErr = <-failed:
+		zapper.Error("server error, shutting down", zap.Error(serveErr))
+	}
+
+	shuttingDown.Store(true)
+	if config.ShutdownDelay > 0 {
+		zapper.Info("waiting before shutting down", zap.Int("seconds", config.ShutdownDelay))
+		time.Sleep(time.Duration(config.ShutdownDelay) * time.Second)
+	}
+	// stop accepting and let in-flight requests finish before anything they use goes away
+	if err = drainServers(SHUTDOWN_TIMEOUT, servers...); err != nil {
+		zapper.Error("error draining requests", zap.Error(err))
+	}
+	stopSweeping()
+	<-sweeperDone
+	<-blobSweeperDone
+	// expired sessions are not worth keeping around until the next start
+	if err = sessionCache.Sweep(ctx); err != nil {
+		zapper.Error("error sweeping sessions", zap.Error(err))
+	}
+	disconnectCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err = db.Disconnect(disconnectCtx); err != nil {
+		zapper.Error("error disconnecting from database", zap.Error(err))
+	}
+	zapper.Info("shut down")
+	if serveErr != nil {
+		zapper.Sync()
+		os.Exit(1)
+	}
 }
 
 func IndexPageHandler(w http.ResponseWriter, r *http.Request) {
-	posts, err := db.PagePosts(0, 50)
+	page, err := pageFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, err.Error())
+		return
+	}
+	boards, err := db.AllBoards(r.Context())
 	if err != nil {
 		zapper.Error("error", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		templates.GenerateErrorPage(w, err.Error())
 		return
 	}
-	if err := templates.GenerateIndexPage(w, profileFromCtx(r.Context()), posts); err != nil {
+	page.IncludeHidden = profileFromCtx(r.Context()).Moderator
+	posts, cursors, err := db.PagePosts(r.Context(), page)
+	if err != nil {
+		zapper.Error("error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, err.Error())
+		return
+	}
+	if err := templates.GenerateIndexPage(w, profileFromCtx(r.Context()), boards, posts, cursors); err != nil {
+		zapper.Error("error", zap.Error(err))
+	}
+}
+
+func BoardPageHandler(w http.ResponseWriter, r *http.Request) {
+	pathSplit := pathIntoArray(r.URL.EscapedPath())
+	if len(pathSplit) != 2 || pathSplit[0] != "b" {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "malformed request path")
+		return
+	}
+	page, err := pageFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, err.Error())
+		return
+	}
+	board, err := db.FindBoardBySlug(r.Context(), pathSplit[1])
+	if err != nil {
+		if err == database.ErrNoBoardFoundBySlug {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		templates.GenerateErrorPage(w, "error while fetching the board")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	page.IncludeHidden = profileFromCtx(r.Context()).Moderator
+	posts, cursors, err := db.PageBoardPosts(r.Context(), board.ID, page)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while fetching the posts")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	if err := templates.GenerateBoardPage(w, profileFromCtx(r.Context()), board, posts, cursors); err != nil {
 		zapper.Error("error", zap.Error(err))
 	}
 }
@@ -135,14 +292,58 @@ func PostPageHandler(w http.ResponseWriter, r *http.Request) {
 		templates.GenerateErrorPage(w, "malformed post id")
 		return
 	}
-	post, poster, comments, users, err := db.GetPostPageData(postID)
+	page, err := pageFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, err.Error())
+		return
+	}
+	post, poster, comments, users, cursors, err := db.GetPostPageData(r.Context(), postID, page)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		templates.GenerateErrorPage(w, "error while fetching the post")
 		zapper.Error("error", zap.Error(err))
 		return
 	}
-	if err := templates.GeneratePostPage(w, profileFromCtx(r.Context()), post, poster, comments, users); err != nil {
+	if post.Hidden && !profileFromCtx(r.Context()).Moderator {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "this post has been hidden by a moderator")
+		return
+	}
+	revisions, err := db.GetPostRevisions(r.Context(), postID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while fetching the edit history")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	attachments, err := db.PostAttachments(r.Context(), postID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while fetching the attachments")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	board, err := db.GetBoard(r.Context(), post.BoardID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while fetching the board")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	reply, err := replyFromRequest(r, postID)
+	if err == ErrCannotReply {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, err.Error())
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while fetching the comment to reply to")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	if err := templates.GeneratePostPage(w, profileFromCtx(r.Context()), board, post, poster, comments, users, revisions, attachments, cursors, reply); err != nil {
 		zapper.Error("error", zap.Error(err))
 	}
 }
@@ -154,7 +355,7 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	switch r.Method {
 	case "GET":
-		if err := templates.GenerateSignupTemplate(w, r.Referer()); err != nil {
+		if err := templates.GenerateSignupTemplate(w, profileFromCtx(r.Context()), r.Referer()); err != nil {
 			zapper.Error("error", zap.Error(err))
 		}
 	case "POST":
@@ -172,7 +373,7 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 			templates.GenerateErrorPage(w, err.Error())
 			return
 		}
-		if _, err := db.FindUserByName(name); err == nil {
+		if _, err := db.FindUserByName(r.Context(), name); err == nil {
 			w.WriteHeader(http.StatusConflict)
 			templates.GenerateErrorPage(w, "username is taken")
 			return
@@ -182,22 +383,35 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 			templates.GenerateErrorPage(w, err.Error())
 			return
 		}
-		hashedP := saltAndHash(password, name)
-		userID, err := db.AddUser(name, hashedP)
+		hashedP, err := hashPassword(password)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			templates.GenerateErrorPage(w, "error during signup")
 			zapper.Error("error", zap.Error(err))
 			return
 		}
-		token, err := newRandomToken()
+		userID, err := db.AddUser(r.Context(), name, hashedP)
+		if err == database.ErrUserNameTaken {
+			w.WriteHeader(http.StatusConflict)
+			templates.GenerateErrorPage(w, "username is taken")
+			return
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			templates.GenerateErrorPage(w, "error generating session token")
+			templates.GenerateErrorPage(w, "error during signup")
+			zapper.Error("error", zap.Error(err))
+			return
+		}
+		// the first account runs the forum
+		if err := promoteFirstUser(r.Context(), userID); err != nil {
+			zapper.Error("error", zap.Error(err))
+		}
+		if err := authenticateUser(r.Context(), w, userID); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			templates.GenerateErrorPage(w, "error creating session")
 			zapper.Error("error", zap.Error(err))
 			return
 		}
-		authenticateUser(w, token, userID)
 		if redirect == "" {
 			redirect = "/"
 		}
@@ -215,7 +429,9 @@ func SigninHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	switch r.Method {
 	case "GET":
-		templates.GenerateSigninTemplate(w, r.Referer())
+		if err := templates.GenerateSigninTemplate(w, profileFromCtx(r.Context()), r.Referer()); err != nil {
+			zapper.Error("error", zap.Error(err))
+		}
 	case "POST":
 		if err := r.ParseForm(); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
@@ -226,8 +442,7 @@ func SigninHandler(w http.ResponseWriter, r *http.Request) {
 		name := r.Form.Get("username")
 		password := r.Form.Get("password")
 		redirect := r.Form.Get("redirect")
-		hashedP := saltAndHash(password, name)
-		user, err := db.FindUserByName(name)
+		user, err := db.FindUserByName(r.Context(), name)
 		if err != nil {
 			if err == database.ErrNoUserFoundByName {
 				w.WriteHeader(http.StatusNotFound)
@@ -238,19 +453,32 @@ func SigninHandler(w http.ResponseWriter, r *http.Request) {
 			zapper.Error("error", zap.Error(err))
 			return
 		}
-		if hashedP != user.Password {
+		ok, needsRehash, err := verifyPassword(password, user.Name, user.Password)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			templates.GenerateErrorPage(w, "error checking password")
+			zapper.Error("error", zap.Error(err))
+			return
+		}
+		if !ok {
 			w.WriteHeader(http.StatusUnauthorized)
 			templates.GenerateErrorPage(w, "incorrect password")
 			return
 		}
-		token, err := newRandomToken()
-		if err != nil {
+		if needsRehash {
+			// A failed rehash is retried on the next sign in, no need to fail this one
+			if hashedP, err := hashPassword(password); err != nil {
+				zapper.Error("error rehashing password", zap.Error(err))
+			} else if err := db.UpdatePassword(r.Context(), user.ID, hashedP); err != nil {
+				zapper.Error("error rehashing password", zap.Error(err))
+			}
+		}
+		if err := authenticateUser(r.Context(), w, user.ID); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			templates.GenerateErrorPage(w, "error generating session token")
+			templates.GenerateErrorPage(w, "error creating session")
 			zapper.Error("error", zap.Error(err))
 			return
 		}
-		authenticateUser(w, token, user.ID)
 		if redirect == "" {
 			redirect = "/"
 		}
@@ -261,13 +489,17 @@ func SigninHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// TODO: if the session cookie is old and is not in cache
-// and we log in again, this will return unauthorized
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	if !profileFromCtx(r.Context()).OK {
 		http.Redirect(w, r, "/", http.StatusUnauthorized)
 		return
 	}
+	// logging out changes state, so it has to go through the csrf check
+	if r.Method != "POST" {
+		w.Header().Add("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
 	token, err := extractSessionToken(r)
 	if err != nil {
 		// This is an internal server error, because we have already
@@ -278,7 +510,7 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 		zapper.Error("error", zap.Error(err))
 		return
 	}
-	unauthenticateUser(w, token)
+	unauthenticateUser(r.Context(), w, token)
 	var redirect string
 	if redirect = r.Referer(); redirect == "" {
 		redirect = "/"
@@ -291,9 +523,21 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	if !requirePermission(w, r, PermissionPost) {
+		return
+	}
 	switch r.Method {
 	case "GET":
-		templates.ServeCreatePostTemplate(w)
+		boards, err := db.AllBoards(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			templates.GenerateErrorPage(w, "error while fetching the boards")
+			zapper.Error("error", zap.Error(err))
+			return
+		}
+		if err := templates.GenerateCreatePostPage(w, profileFromCtx(r.Context()), boards, r.URL.Query().Get("board")); err != nil {
+			zapper.Error("error", zap.Error(err))
+		}
 	case "POST":
 		if err := r.ParseForm(); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
@@ -313,15 +557,43 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 			templates.GenerateErrorPage(w, err.Error())
 			return
 		}
+		board, err := db.FindBoardBySlug(r.Context(), r.Form.Get("board"))
+		if err == database.ErrNoBoardFoundBySlug {
+			w.WriteHeader(http.StatusBadRequest)
+			templates.GenerateErrorPage(w, "that board does not exist")
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			templates.GenerateErrorPage(w, "error creating post")
+			zapper.Error("error", zap.Error(err))
+			return
+		}
+		uploads, ok := uploadsFromRequest(w, r)
+		if !ok {
+			return
+		}
 		// This has to be OK as we  already check for it.
 		profile := profileFromCtx(r.Context())
-		postID, err := db.AddPost(title, content, profile.User.ID)
+		postID, err := db.AddPost(r.Context(), title, content, profile.User.ID, board.ID)
+		if err == database.ErrNoBoardFoundByID {
+			w.WriteHeader(http.StatusBadRequest)
+			templates.GenerateErrorPage(w, "that board does not exist")
+			return
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			templates.GenerateErrorPage(w, "error creating post")
 			zapper.Error("error", zap.Error(err))
 			return
 		}
+		if _, err = recordAttachments(r.Context(), uploads, postID, postID, profile.User.ID); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			templates.GenerateErrorPage(w, "the post was created, but its images could not be saved")
+			zapper.Error("error", zap.Error(err))
+			return
+		}
+		publishPost(postID, title, board.ID, profile.User)
 		http.Redirect(w, r, "/post/"+postID.String(), http.StatusFound)
 	default:
 		w.Header().Add("Allow", "GET, POST")
@@ -339,6 +611,9 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	if !requirePermission(w, r, PermissionPost) {
+		return
+	}
 	if err := r.ParseForm(); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		templates.GenerateErrorPage(w, "error parsing form")
@@ -352,6 +627,27 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		templates.GenerateErrorPage(w, "malformed post id")
 		return
 	}
+	post, err := db.GetPost(r.Context(), postID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "error while fetching the post")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	// moderators can still answer in locked threads
+	if post.Locked && !requirePermission(w, r, PermissionModerate) {
+		return
+	}
+	// replies carry the comment they answer, top level comments do not
+	parentID := xid.NilID()
+	if parentIDString := r.Form.Get("parentID"); parentIDString != "" {
+		parentID, err = xid.FromString(parentIDString)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			templates.GenerateErrorPage(w, "malformed parent comment id")
+			return
+		}
+	}
 	content := r.Form.Get("comment")
 	if err := isContentValid(content); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -359,63 +655,380 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		zapper.Error("error", zap.Error(err))
 		return
 	}
+	uploads, ok := uploadsFromRequest(w, r)
+	if !ok {
+		return
+	}
 	// Has to be OK.
 	profile := profileFromCtx(r.Context())
-	_, err = db.AddComment(content, postID, profile.User.ID)
+	commentID, err := db.AddComment(r.Context(), content, postID, profile.User.ID, parentID)
+	if err == database.ErrNoCommentFoundByID {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, ErrCannotReply.Error())
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		templates.GenerateErrorPage(w, "error creating comment")
 		zapper.Error("error", zap.Error(err))
 		return
 	}
-	http.Redirect(w, r, "/post/"+postID.String(), http.StatusFound)
+	attachments, err := recordAttachments(r.Context(), uploads, postID, commentID, profile.User.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "the comment was created, but its images could not be saved")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	publishComment(r.Context(), post, commentID, profile.User, attachments)
+	http.Redirect(w, r, "/post/"+postID.String()+"#comment-"+commentID.String(), http.StatusFound)
 }
 
-func ProfilePageHandler(w http.ResponseWriter, r *http.Request) {
-	if !profileFromCtx(r.Context()).OK && r.URL.EscapedPath() == "/self" {
+func EditPostHandler(w http.ResponseWriter, r *http.Request) {
+	if !profileFromCtx(r.Context()).OK {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-	// TODO: add POST for editing profile
-	if r.Method != "GET" {
-		w.Header().Add("Allow", "GET")
+	if r.Method != "GET" && r.Method != "POST" {
+		w.Header().Add("Allow", "GET, POST")
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	var user templates.Profile
-	var err error
-	if r.URL.EscapedPath() == "/self" {
-		user = profileFromCtx(r.Context())
-	} else {
-		pathSplit := pathIntoArray(r.URL.EscapedPath())
-		if len(pathSplit) != 2 || pathSplit[0] != "user" {
-			w.WriteHeader(http.StatusBadRequest)
-			templates.GenerateErrorPage(w, "malformed request path")
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "error parsing form")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	postID, err := xid.FromString(r.Form.Get("postID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "malformed post id")
+		return
+	}
+	post, err := db.GetPost(r.Context(), postID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "error while fetching the post")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	// Has to be OK.
+	profile := profileFromCtx(r.Context())
+	if !profile.Owns(post.PosterID) {
+		w.WriteHeader(http.StatusForbidden)
+		templates.GenerateErrorPage(w, "you can only edit your own posts")
+		return
+	}
+	if !requirePermission(w, r, PermissionPost) {
+		return
+	}
+	// locking a thread freezes what is in it, apart from moderators
+	if post.Locked && !requirePermission(w, r, PermissionModerate) {
+		return
+	}
+	if r.Method == "GET" {
+		if err := templates.GenerateEditPostPage(w, profile, post); err != nil {
+			zapper.Error("error", zap.Error(err))
+		}
+		return
+	}
+	title := r.Form.Get("title")
+	content := r.Form.Get("content")
+	if err := isTitleValid(title); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, err.Error())
+		return
+	}
+	if err := isContentValid(content); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, err.Error())
+		return
+	}
+	if title != post.Title || content != post.Content {
+		if err := db.UpdatePost(r.Context(), postID, title, content); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			templates.GenerateErrorPage(w, "error editing post")
 			zapper.Error("error", zap.Error(err))
 			return
 		}
-		var userID xid.ID
-		userID, err = xid.FromString(pathSplit[1])
+	}
+	http.Redirect(w, r, "/post/"+postID.String(), http.StatusFound)
+}
+
+func EditCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if !profileFromCtx(r.Context()).OK {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		w.Header().Add("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "error parsing form")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	commentID, err := xid.FromString(r.Form.Get("commentID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "malformed comment id")
+		return
+	}
+	comment, err := db.GetComment(r.Context(), commentID)
+	if err != nil || comment.Deleted {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "error while fetching the comment")
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			templates.GenerateErrorPage(w, "malformed user id")
+			zapper.Error("error", zap.Error(err))
+		}
+		return
+	}
+	// Has to be OK.
+	profile := profileFromCtx(r.Context())
+	if !profile.Owns(comment.PosterID) {
+		w.WriteHeader(http.StatusForbidden)
+		templates.GenerateErrorPage(w, "you can only edit your own comments")
+		return
+	}
+	if !requirePermission(w, r, PermissionPost) {
+		return
+	}
+	post, err := db.GetPost(r.Context(), comment.PostID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "error while fetching the post")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	// locking a thread freezes what is in it, apart from moderators
+	if post.Locked && !requirePermission(w, r, PermissionModerate) {
+		return
+	}
+	if r.Method == "GET" {
+		if err := templates.GenerateEditCommentPage(w, profile, comment); err != nil {
+			zapper.Error("error", zap.Error(err))
+		}
+		return
+	}
+	content := r.Form.Get("comment")
+	if err := isContentValid(content); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, err.Error())
+		return
+	}
+	if content != comment.Content {
+		if err := db.UpdateComment(r.Context(), commentID, content); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			templates.GenerateErrorPage(w, "error editing comment")
 			zapper.Error("error", zap.Error(err))
 			return
 		}
-		dbUser, err := db.GetUser(userID)
-		user = templates.Profile{User: dbUser, OK: err == nil}
 	}
+	http.Redirect(w, r, "/post/"+comment.PostID.String(), http.StatusFound)
+}
+
+func DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	if !profileFromCtx(r.Context()).OK {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Add("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "error parsing form")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	postID, err := xid.FromString(r.Form.Get("postID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "malformed post id")
+		return
+	}
+	post, err := db.GetPost(r.Context(), postID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "error while fetching the post")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	// Has to be OK.
+	profile := profileFromCtx(r.Context())
+	if !profile.CanDelete(post.PosterID) {
+		w.WriteHeader(http.StatusForbidden)
+		templates.GenerateErrorPage(w, "you can only delete your own posts")
+		return
+	}
+	if err := db.DeletePost(r.Context(), postID); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error deleting post")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if !profileFromCtx(r.Context()).OK {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Add("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "error parsing form")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	commentID, err := xid.FromString(r.Form.Get("commentID"))
 	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "malformed comment id")
+		return
+	}
+	comment, err := db.GetComment(r.Context(), commentID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "error while fetching the comment")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	// Has to be OK.
+	profile := profileFromCtx(r.Context())
+	if !profile.CanDelete(comment.PosterID) {
+		w.WriteHeader(http.StatusForbidden)
+		templates.GenerateErrorPage(w, "you can only delete your own comments")
+		return
+	}
+	if err := db.DeleteComment(r.Context(), commentID); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error deleting comment")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	http.Redirect(w, r, "/post/"+comment.PostID.String(), http.StatusFound)
+}
+
+func ProfilePageHandler(w http.ResponseWriter, r *http.Request) {
+	self := r.URL.EscapedPath() == "/self"
+	if !profileFromCtx(r.Context()).OK && self {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	switch {
+	case r.Method == "POST" && self:
+		profileAction(w, r)
+		return
+	case r.Method == "POST":
+		w.Header().Add("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	case r.Method != "GET":
+		w.Header().Add("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	profile := profileFromCtx(r.Context())
+	if self {
+		generateSelfProfile(r.Context(), w, profile, "")
+		return
+	}
+	pathSplit := pathIntoArray(r.URL.EscapedPath())
+	if len(pathSplit) != 2 || pathSplit[0] != "user" {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "malformed request path")
+		return
+	}
+	userID, err := xid.FromString(pathSplit[1])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "malformed user id")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	user, err := db.GetUser(r.Context(), userID)
+	if err != nil {
+		if err == database.ErrNoUserFoundByID {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
 		templates.GenerateErrorPage(w, "error getting user")
 		zapper.Error("error", zap.Error(err))
 		return
 	}
-	if err = templates.GenerateProfilePage(w, user); err != nil {
+	if err := templates.GenerateProfilePage(w, profile, user, templates.APITokens{}); err != nil {
 		zapper.Error("error", zap.Error(err))
 	}
 }
 
+// generateSelfProfile shows users their own profile along with their api tokens,
+// created is a token that was just created and is shown this once
+func generateSelfProfile(ctx context.Context, w http.ResponseWriter, profile templates.Profile, created string) {
+	tokens, err := db.UserAPITokens(ctx, profile.User.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error getting api tokens")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	apiTokens := templates.APITokens{Tokens: tokens, Created: created}
+	for _, scope := range allScopes {
+		apiTokens.Scopes = append(apiTokens.Scopes, string(scope))
+	}
+	if err := templates.GenerateProfilePage(w, profile, profile.User, apiTokens); err != nil {
+		zapper.Error("error", zap.Error(err))
+	}
+}
+
+// profileAction handles the POST on /self, the user has to be authenticated
+func profileAction(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "error parsing form")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	switch r.Form.Get("action") {
+	case "delete":
+		deleteAccount(w, r)
+	case "createtoken":
+		createTokenAction(w, r)
+	case "revoketoken":
+		revokeTokenAction(w, r)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "unknown profile action")
+	}
+}
+
+// deleteAccount deletes the account of the user and signs them out, the form has to be parsed
+func deleteAccount(w http.ResponseWriter, r *http.Request) {
+	profile := profileFromCtx(r.Context())
+	if err := db.DeleteUser(r.Context(), profile.User.ID); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error deleting account")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	if token, err := extractSessionToken(r); err == nil {
+		unauthenticateUser(r.Context(), w, token)
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
 func pathIntoArray(path string) []string {
 	if path == "" {
 		return []string{}
@@ -433,8 +1046,13 @@ func pathIntoArray(path string) []string {
 // from a context, ideally a request context.
 func profileFromCtx(c context.Context) templates.Profile {
 	user, ok := c.Value(ContextString("user")).(database.User)
+	csrf, _ := c.Value(ContextString("csrf")).(string)
 	return templates.Profile{
-		User: user,
-		OK:   ok,
+		User:      user,
+		OK:        ok,
+		CSRF:      csrf,
+		CanPost:   ok && hasPermission(user, PermissionPost),
+		Moderator: ok && hasPermission(user, PermissionModerate),
+		Admin:     ok && hasPermission(user, PermissionAdminister),
 	}
 }
@@ -1,9 +1,32 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
 )
 
+// newTestDB connects a fresh sqlite database as db, with the default board in it
+func newTestDB(t *testing.T) {
+	sqlite, err := database.ConnectSQLite(filepath.Join(t.TempDir(), "carrotbb.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Disconnect(context.Background()) })
+	db, zapper = sqlite, zap.NewNop()
+	if err = ensureDefaultBoard(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestPathIntoArray(t *testing.T) {
 	payloads := map[string][]string{
 		"":              {},
@@ -26,3 +49,61 @@ func TestPathIntoArray(t *testing.T) {
 		}
 	}
 }
+
+// editRequest posts form to handler as user, leaving the middlewares out
+func editRequest(handler http.HandlerFunc, user database.User, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/edit", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r = r.WithContext(context.WithValue(r.Context(), ContextString("user"), user))
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	return rec
+}
+
+func TestEditLockedPost(t *testing.T) {
+	ctx := context.Background()
+	newTestDB(t)
+	posterID, err := db.AddUser(ctx, "carrot", "carrot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	poster, err := db.GetUser(ctx, posterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moderator := poster
+	moderator.Role = database.RoleModerator
+	board, err := db.FindBoardBySlug(ctx, DEFAULT_BOARD_SLUG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, err := db.AddPost(ctx, "carrots", "orange", posterID, board.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commentID, err := db.AddComment(ctx, "crunchy", postID, posterID, xid.NilID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SetPostLocked(ctx, postID, true); err != nil {
+		t.Fatal(err)
+	}
+	editPost := url.Values{"postID": {postID.String()}, "title": {"roots"}, "content": {"purple"}}
+	editComment := url.Values{"commentID": {commentID.String()}, "comment": {"soft"}}
+
+	if rec := editRequest(EditPostHandler, poster, editPost); rec.Code != http.StatusForbidden {
+		t.Error("editing a post in a locked thread expected:", http.StatusForbidden, "got:", rec.Code)
+	}
+	if rec := editRequest(EditCommentHandler, poster, editComment); rec.Code != http.StatusForbidden {
+		t.Error("editing a comment in a locked thread expected:", http.StatusForbidden, "got:", rec.Code)
+	}
+	if post, err := db.GetPost(ctx, postID); err != nil || post.Title != "carrots" {
+		t.Error("the locked post should be left alone, got:", post, err)
+	}
+	if rec := editRequest(EditPostHandler, moderator, editPost); rec.Code != http.StatusFound {
+		t.Error("a moderator editing their post in a locked thread expected:", http.StatusFound, "got:", rec.Code)
+	}
+	if rec := editRequest(EditCommentHandler, moderator, editComment); rec.Code != http.StatusFound {
+		t.Error("a moderator editing their comment in a locked thread expected:", http.StatusFound, "got:", rec.Code)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/courtier/carrotbb/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	METRICS_PATH = "/metrics"
+	// UNMATCHED_ROUTE labels requests no route matched, such as redirects to a cleaned up path
+	UNMATCHED_ROUTE = "unmatched"
+)
+
+var (
+	registry = metrics.NewRegistry()
+
+	httpRequests = registry.Counter("carrotbb_http_requests_total",
+		"Requests served, by the route that served them and the status code.", "route", "status")
+	httpDuration = registry.Histogram("carrotbb_http_request_duration_seconds",
+		"How long requests took to serve, by route and status code.", metrics.DefaultBuckets, "route", "status")
+	dbDuration = registry.Histogram("carrotbb_db_call_duration_seconds",
+		"How long calls to the database took, by method.", []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}, "method")
+)
+
+// MetricsMiddleware counts requests and how long they took by the route of routes they matched,
+// routes are used instead of paths so every post does not get a series of its own
+type MetricsMiddleware struct {
+	handler http.Handler
+	routes  *http.ServeMux
+}
+
+func NewMetricsMiddleware(handler http.Handler, routes *http.ServeMux) *MetricsMiddleware {
+	return &MetricsMiddleware{handler: handler, routes: routes}
+}
+
+func (m *MetricsMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+	recorder := &statusRecorder{ResponseWriter: w}
+	m.handler.ServeHTTP(recorder, r)
+	_, route := m.routes.Handler(r)
+	if route == "" {
+		route = UNMATCHED_ROUTE
+	}
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	status := strconv.Itoa(recorder.status)
+	httpRequests.Inc(route, status)
+	httpDuration.Observe(time.Since(begin).Seconds(), route, status)
+}
+
+// statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush keeps event streams working through the recorder
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection, to lift the write deadline of event streams
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// instrumentDatabase times every call to db
+func instrumentDatabase(db database.Database) database.Database {
+	return database.Instrument(db, func(method string, duration time.Duration) {
+		dbDuration.Observe(duration.Seconds(), method)
+	})
+}
+
+// registerGauges adds the gauges that are read from the session store and the database when scraped,
+// jsonDB is nil unless the json backend is in use
+func registerGauges(registry *metrics.Registry, sessions SessionStore, jsonDB *database.JSONDatabase) {
+	registry.GaugeFunc("carrotbb_sessions_active", "Sessions that have not expired.", func() float64 {
+		count, err := sessions.Count(context.Background())
+		if err != nil {
+			zapper.Error("error counting sessions", zap.Error(err))
+			return math.NaN()
+		}
+		return float64(count)
+	})
+	if jsonDB == nil {
+		return
+	}
+	registry.GaugeFunc("carrotbb_json_save_duration_seconds", "How long the last save of the json database took.", func() float64 {
+		duration, _ := jsonDB.LastSave()
+		return duration.Seconds()
+	})
+	registry.GaugeFunc("carrotbb_json_last_save_timestamp_seconds", "When the json database was last saved, 0 if it was not saved yet.", func() float64 {
+		_, at := jsonDB.LastSave()
+		if at.IsZero() {
+			return 0
+		}
+		return float64(at.UnixNano()) / 1e9
+	})
+}
+
+// metricsHandler serves the metrics on their own listener, away from the board
+func metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(METRICS_PATH, registry)
+	return mux
+}
@@ -0,0 +1,276 @@
+// Package metrics keeps counters, histograms and gauges and writes them
+// in the prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	// DefaultBuckets suit request latencies in seconds, from 5ms up to 10s
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// metric is anything a Registry can write
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics in the order they were registered, it is safe for concurrent use.
+// Registering a name twice or using the wrong number of label values panics,
+// both are mistakes in the code rather than something to handle at runtime
+type Registry struct {
+	lock    sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.names[name] {
+		panic("metrics: " + name + " is already registered")
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Counter registers a counter, which can only go up, split by labels
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{header: header{name: name, help: help, kind: "counter", labels: labels}, series: make(map[string]*counterSeries)}
+	r.register(name, c)
+	return c
+}
+
+// Histogram registers a histogram counting observations into buckets, split by labels,
+// buckets are the upper bounds of the buckets in increasing order
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{header: header{name: name, help: help, kind: "histogram", labels: labels}, buckets: buckets, series: make(map[string]*histogramSeries)}
+	r.register(name, h)
+	return h
+}
+
+// GaugeFunc registers a gauge whose value is read from value every time the metrics are written
+func (r *Registry) GaugeFunc(name, help string, value func() float64) {
+	r.register(name, &gaugeFunc{header: header{name: name, help: help, kind: "gauge"}, value: value})
+}
+
+// Write writes every metric in the text exposition format
+func (r *Registry) Write(w io.Writer) error {
+	r.lock.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.lock.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics to a scraper
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Add("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	r.Write(w)
+}
+
+// header is what every metric has, along with the names of its labels
+type header struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (h header) write(w *bufio.Writer) {
+	w.WriteString("# HELP " + h.name + " " + escapeHelp(h.help) + "\n")
+	w.WriteString("# TYPE " + h.name + " " + h.kind + "\n")
+}
+
+// key identifies a series by its label values
+func (h header) key(values []string) string {
+	if len(values) != len(h.labels) {
+		panic("metrics: " + h.name + " expects " + strconv.Itoa(len(h.labels)) + " label values, got " + strconv.Itoa(len(values)))
+	}
+	return strings.Join(values, "\x00")
+}
+
+// sample writes a single line, extra is a label added after the labels of the metric
+func (h header) sample(w *bufio.Writer, name string, values []string, extra, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(values) > 0 || extra != "" {
+		w.WriteByte('{')
+		for n, label := range h.labels {
+			if n > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label + `="` + escapeLabel(values[n]) + `"`)
+		}
+		if extra != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	header
+	lock   sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// Inc adds one to the series with those label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which cannot be negative, to the series with those label values
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: " + c.name + " cannot go down")
+	}
+	key := c.key(values)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: append([]string{}, values...)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.header.write(w)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := c.series[key]
+		c.sample(w, c.name, s.values, "", "", s.value)
+	}
+}
+
+// Histogram counts observations into buckets and keeps their sum
+type Histogram struct {
+	header
+	buckets []float64
+	lock    sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	// counts holds the observations of each bucket alone, they are added up when written
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records v in the series with those label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: append([]string{}, values...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if n := sort.SearchFloat64s(h.buckets, v); n < len(h.buckets) {
+		s.counts[n]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.header.write(w)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for n, bound := range h.buckets {
+			cumulative += s.counts[n]
+			h.sample(w, h.name+"_bucket", s.values, "le", formatFloat(bound), float64(cumulative))
+		}
+		h.sample(w, h.name+"_bucket", s.values, "le", "+Inf", float64(s.count))
+		h.sample(w, h.name+"_sum", s.values, "", "", s.sum)
+		h.sample(w, h.name+"_count", s.values, "", "", float64(s.count))
+	}
+}
+
+// gaugeFunc is a gauge read when it is written
+type gaugeFunc struct {
+	header
+	value func() float64
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.header.write(w)
+	g.sample(w, g.name, nil, "", "", g.value())
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
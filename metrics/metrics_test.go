@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests served.", "route", "status")
+	latency := r.Histogram("latency_seconds", "How long\nthings take.", []float64{0.1, 1}, "route")
+	r.GaugeFunc("sessions", "Sessions that are live.", func() float64 { return 3 })
+	r.GaugeFunc("broken", "A gauge that could not be read.", math.NaN)
+
+	requests.Inc("/post/", "200")
+	requests.Add(2, "/", "200")
+	requests.Inc("/", `5"0\0`)
+	latency.Observe(0.05, "/")
+	latency.Observe(0.1, "/")
+	latency.Observe(0.5, "/")
+	latency.Observe(3, "/")
+
+	var out strings.Builder
+	if err := r.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/",status="200"} 2
+requests_total{route="/",status="5\"0\\0"} 1
+requests_total{route="/post/",status="200"} 1
+# HELP latency_seconds How long\nthings take.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/",le="0.1"} 2
+latency_seconds_bucket{route="/",le="1"} 3
+latency_seconds_bucket{route="/",le="+Inf"} 4
+latency_seconds_sum{route="/"} 3.65
+latency_seconds_count{route="/"} 4
+# HELP sessions Sessions that are live.
+# TYPE sessions gauge
+sessions 3
+# HELP broken A gauge that could not be read.
+# TYPE broken gauge
+broken NaN
+`
+	if out.String() != expected {
+		t.Errorf("Write expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRegistryMistakesPanic(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("requests_total", "Requests served.", "route")
+	for name, mistake := range map[string]func(){
+		"duplicate name":    func() { r.GaugeFunc("requests_total", "", math.NaN) },
+		"missing label":     func() { c.Inc() },
+		"negative increase": func() { c.Add(-1, "/") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error(name, "should panic")
+				}
+			}()
+			mistake()
+		}()
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.GaugeFunc("sessions", "Sessions that are live.", func() float64 { return 1 })
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != CONTENT_TYPE || !strings.Contains(rec.Body.String(), "sessions 1\n") {
+		t.Error("metrics expected to be served, got:", rec.Code, rec.Header(), rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("POST", "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Error("POST expected:", http.StatusMethodNotAllowed, "got:", rec.Code)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/courtier/carrotbb/metrics"
+	"github.com/rs/xid"
+)
+
+func scrape(t *testing.T, registry *metrics.Registry) string {
+	t.Helper()
+	var out strings.Builder
+	if err := registry.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	newAPITestServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/post/", PostPageHandler)
+	mux.HandleFunc("/teapot", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	handler := NewMetricsMiddleware(NewAuthMiddleware(mux), mux)
+	postID := xid.New()
+	for _, path := range []string{"/post/" + postID.String(), "/post/" + xid.New().String(), "/teapot"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	out := scrape(t, registry)
+	for _, line := range []string{
+		`carrotbb_http_requests_total{route="/post/",status="404"} 2`,
+		`carrotbb_http_requests_total{route="/teapot",status="418"} 1`,
+		`carrotbb_http_request_duration_seconds_count{route="/post/",status="404"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Error("metrics expected:", line, "got:", out)
+		}
+	}
+	if strings.Contains(out, postID.String()) {
+		t.Error("requests should be counted by route rather than path, got:", out)
+	}
+}
+
+func TestDatabaseMetrics(t *testing.T) {
+	ctx := context.Background()
+	newAPITestServer(t)
+	instrumented := instrumentDatabase(db)
+	if _, err := instrumented.CountUsers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if out := scrape(t, registry); !strings.Contains(out, `carrotbb_db_call_duration_seconds_count{method="CountUsers"}`) {
+		t.Error("database calls expected to be timed by method, got:", out)
+	}
+
+	gauges := metrics.NewRegistry()
+	sessions := NewMapCache()
+	sessions.Write(ctx, "live", session{userID: xid.New(), expiry: time.Now().Add(time.Hour)})
+	sessions.Write(ctx, "expired", session{userID: xid.New(), expiry: time.Now().Add(-time.Hour)})
+	registerGauges(gauges, sessions, nil)
+	out := scrape(t, gauges)
+	if !strings.Contains(out, "carrotbb_sessions_active 1\n") || strings.Contains(out, "carrotbb_json_") {
+		t.Error("gauges expected the live sessions and no json backend, got:", out)
+	}
+}
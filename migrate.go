@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/courtier/carrotbb/database"
+)
+
+var (
+	ErrMigrationsNeedPostgres = errors.New("migrations are only for the postgres backend, json and sqlite set themselves up")
+)
+
+// migrateCommand runs "migrate status", "migrate up" and "migrate down" against the configured
+// postgres database, down reverts a single migration. It returns the exit code
+func migrateCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || (args[0] != "status" && args[0] != "up" && args[0] != "down") {
+		fmt.Fprintln(stderr, "usage: carrotbb migrate status|up|down [flags]")
+		return 2
+	}
+	flags := flag.NewFlagSet("carrotbb migrate "+args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	config, err := loadConfig(flags, args[1:])
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err == nil {
+		err = config.validate()
+	}
+	if err == nil && config.DBBackend != "postgres" {
+		err = ErrMigrationsNeedPostgres
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "invalid config:")
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	for _, warning := range config.warnings {
+		fmt.Fprintln(stderr, "warning:", warning)
+	}
+
+	postgres, err := database.OpenPostgres(config.database().Postgres)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	ctx := context.Background()
+	defer postgres.Disconnect(ctx)
+	switch args[0] {
+	case "status":
+		err = printMigrationStatus(ctx, postgres, stdout)
+	case "up":
+		var done []database.Migration
+		done, err = postgres.MigrateUp(ctx)
+		for _, m := range done {
+			fmt.Fprintln(stdout, "applied", m)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Fprintln(stdout, "the database is up to date")
+		}
+	case "down":
+		var undone database.Migration
+		undone, err = postgres.MigrateDown(ctx)
+		if err == nil {
+			fmt.Fprintln(stdout, "reverted", undone)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func printMigrationStatus(ctx context.Context, postgres *database.PostgresDatabase, w io.Writer) error {
+	statuses, err := postgres.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MIGRATION\tAPPLIED")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.Applied {
+			applied = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", status.Migration, applied)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMigrateCommand(t *testing.T) {
+	inConfigTestDir(t)
+	var stdout, stderr bytes.Buffer
+	if code := migrateCommand([]string{"sideways"}, &stdout, &stderr); code != 2 {
+		t.Error("migrate with an unknown direction expected 2, got:", code)
+	}
+	if !strings.Contains(stderr.String(), "usage: carrotbb migrate") {
+		t.Error("migrate should print its usage, got:", stderr.String())
+	}
+
+	stderr.Reset()
+	if code := migrateCommand([]string{"up", "-db-backend", "sqlite"}, &stdout, &stderr); code != 1 {
+		t.Error("migrate on sqlite expected 1, got:", code)
+	}
+	if !strings.Contains(stderr.String(), ErrMigrationsNeedPostgres.Error()) {
+		t.Error("migrate should explain it needs postgres, got:", stderr.String())
+	}
+}
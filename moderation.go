@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/courtier/carrotbb/templates"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+// ModerateHandler applies the moderation actions posted from the post and profile pages
+func ModerateHandler(w http.ResponseWriter, r *http.Request) {
+	if !profileFromCtx(r.Context()).OK {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Add("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !requirePermission(w, r, PermissionModerate) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "error parsing form")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	switch action := r.Form.Get("action"); action {
+	case "hidepost", "showpost", "lockpost", "unlockpost":
+		moderatePost(w, r, action)
+	case "hidecomment", "showcomment":
+		moderateComment(w, r, action)
+	case "ban", "unban", "setrole":
+		moderateUser(w, r, action)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "unknown moderation action")
+	}
+}
+
+// moderatePost hides, shows, locks or unlocks the post in the postID form value
+func moderatePost(w http.ResponseWriter, r *http.Request, action string) {
+	postID, err := xid.FromString(r.Form.Get("postID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "malformed post id")
+		return
+	}
+	switch action {
+	case "hidepost", "showpost":
+		err = db.SetPostHidden(r.Context(), postID, action == "hidepost")
+	default:
+		err = db.SetPostLocked(r.Context(), postID, action == "lockpost")
+	}
+	if err == database.ErrNoPostFoundByID {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "error while fetching the post")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error moderating post")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	logModeration(r, action, postID)
+	http.Redirect(w, r, "/post/"+postID.String(), http.StatusFound)
+}
+
+// moderateComment hides or shows the comment in the commentID form value
+func moderateComment(w http.ResponseWriter, r *http.Request, action string) {
+	commentID, err := xid.FromString(r.Form.Get("commentID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "malformed comment id")
+		return
+	}
+	comment, err := db.GetComment(r.Context(), commentID)
+	if err == nil {
+		err = db.SetCommentHidden(r.Context(), commentID, action == "hidecomment")
+	}
+	if err == database.ErrNoCommentFoundByID {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "error while fetching the comment")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error moderating comment")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	logModeration(r, action, commentID)
+	http.Redirect(w, r, "/post/"+comment.PostID.String()+"#comment-"+commentID.String(), http.StatusFound)
+}
+
+// moderateUser bans, unbans or changes the role of the user in the userID form value,
+// only admins can change roles and nobody can change their own
+func moderateUser(w http.ResponseWriter, r *http.Request, action string) {
+	userID, err := xid.FromString(r.Form.Get("userID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, "malformed user id")
+		return
+	}
+	target, err := db.GetUser(r.Context(), userID)
+	if err == database.ErrNoUserFoundByID || target.Deleted {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "error getting user")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error getting user")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	moderator := profileFromCtx(r.Context()).User
+	if action == "setrole" {
+		if !requirePermission(w, r, PermissionAdminister) {
+			return
+		}
+		if target.ID == moderator.ID {
+			w.WriteHeader(http.StatusBadRequest)
+			templates.GenerateErrorPage(w, "you cannot change your own role")
+			return
+		}
+		var role database.Role
+		role, err = database.ParseRole(r.Form.Get("role"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			templates.GenerateErrorPage(w, err.Error())
+			return
+		}
+		err = db.SetUserRole(r.Context(), userID, role)
+	} else {
+		if !canModerateUser(moderator, target) {
+			w.WriteHeader(http.StatusForbidden)
+			templates.GenerateErrorPage(w, "you can only ban users below your role")
+			return
+		}
+		err = db.SetUserBanned(r.Context(), userID, action == "ban")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error moderating user")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	logModeration(r, action, userID)
+	http.Redirect(w, r, "/user/"+userID.String(), http.StatusFound)
+}
+
+// logModeration keeps a trail of who moderated what
+func logModeration(r *http.Request, action string, targetID xid.ID) {
+	zapper.Info("moderation",
+		zap.String("action", action),
+		zap.String("moderator", profileFromCtx(r.Context()).User.Name),
+		zap.String("target", targetID.String()),
+	)
+}
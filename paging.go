@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/courtier/carrotbb/database"
+)
+
+const (
+	DEFAULT_PAGE_SIZE = 50
+	MAX_PAGE_SIZE     = 500
+)
+
+var (
+	ErrInvalidPageSize = errors.New("page size has to be between 1 and 500")
+)
+
+var (
+	// pageSize is how many posts or comments are shown per page, see setPageSize
+	pageSize = DEFAULT_PAGE_SIZE
+)
+
+// checkPageSize returns ErrInvalidPageSize if n is not a page size that is allowed
+func checkPageSize(n int) error {
+	if n < 1 || n > MAX_PAGE_SIZE {
+		return ErrInvalidPageSize
+	}
+	return nil
+}
+
+// setPageSize sets the configured page size
+func setPageSize(n int) error {
+	if err := checkPageSize(n); err != nil {
+		return err
+	}
+	pageSize = n
+	return nil
+}
+
+// pageFromRequest reads the page to show from the after or before query parameters,
+// without either of them it is the first page
+func pageFromRequest(r *http.Request) (database.Page, error) {
+	query := r.URL.Query()
+	page := database.Page{Limit: pageSize}
+	encoded := query.Get("after")
+	if before := query.Get("before"); before != "" {
+		encoded = before
+		page.Before = true
+	}
+	cursor, err := database.ParseCursor(encoded)
+	if err != nil {
+		return database.Page{}, err
+	}
+	page.Cursor = cursor
+	return page, nil
+}
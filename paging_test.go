@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/rs/xid"
+)
+
+func TestSetPageSize(t *testing.T) {
+	defer func() { pageSize = DEFAULT_PAGE_SIZE }()
+	payloads := map[int]error{
+		0:    ErrInvalidPageSize,
+		501:  ErrInvalidPageSize,
+		20:   nil,
+		-100: ErrInvalidPageSize,
+	}
+	for k, v := range payloads {
+		if res := setPageSize(k); res != v {
+			t.Error("Size:", k, "expected:", v, "got:", res)
+		}
+	}
+	if pageSize != 20 {
+		t.Error("Page size expected:", 20, "got:", pageSize)
+	}
+}
+
+func TestPageFromRequest(t *testing.T) {
+	cursor := database.Cursor{DateCreated: time.Now().UTC(), ID: xid.New()}
+	payloads := map[string]database.Page{
+		"/":                                {Limit: pageSize},
+		"/?after=" + cursor.String():       {Cursor: cursor, Limit: pageSize},
+		"/?before=" + cursor.String():      {Cursor: cursor, Before: true, Limit: pageSize},
+		"/?after=&before=":                 {Limit: pageSize},
+		"/post/x?after=" + cursor.String(): {Cursor: cursor, Limit: pageSize},
+	}
+	for k, v := range payloads {
+		page, err := pageFromRequest(httptest.NewRequest("GET", k, nil))
+		if err != nil {
+			t.Error("Content:", k, "returned error:", err)
+			continue
+		}
+		if !page.Cursor.DateCreated.Equal(v.Cursor.DateCreated) || page.Cursor.ID != v.Cursor.ID ||
+			page.Before != v.Before || page.Limit != v.Limit {
+			t.Error("Content:", k, "expected:", v, "got:", page)
+		}
+	}
+	if _, err := pageFromRequest(httptest.NewRequest("GET", "/?after=carrot", nil)); err != database.ErrMalformedCursor {
+		t.Error("Expected:", database.ErrMalformedCursor, "got:", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Raising any of these makes users get rehashed the next time they sign in
+const (
+	argonTime    = 4
+	argonMemory  = 16 * 1024
+	argonThreads = 4
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+var (
+	ErrMalformedPasswordHash   = errors.New("password hash is not a PHC encoded argon2id hash")
+	ErrUnsupportedArgonVersion = errors.New("password hash uses an unsupported argon2 version")
+)
+
+// argonParams are the cost parameters of an argon2id hash
+type argonParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	keyLen  uint32
+}
+
+var currentArgonParams = argonParams{
+	memory:  argonMemory,
+	time:    argonTime,
+	threads: argonThreads,
+	keyLen:  argonKeyLen,
+}
+
+// hashPassword returns the PHC encoded Argon2id hash of password
+// with a fresh random salt and the current parameters
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	return encodeArgonHash(currentArgonParams, salt, deriveArgonKey(password, salt, currentArgonParams)), nil
+}
+
+// verifyPassword checks password against the encoded hash of the user called name,
+// needsRehash reports whether the hash should be replaced by hashPassword
+// because it uses outdated parameters or the legacy username salt
+func verifyPassword(password, name, encoded string) (ok, needsRehash bool, err error) {
+	params, salt, key, err := decodeArgonHash(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	// hashes made before per-user salts were salted with the username
+	// and had the key and salt swapped
+	legacy := string(key) == name
+	if legacy {
+		salt, key = key, salt
+		params.keyLen = uint32(len(key))
+	}
+	derived := deriveArgonKey(password, salt, params)
+	if subtle.ConstantTimeCompare(derived, key) != 1 {
+		return false, false, nil
+	}
+	return true, legacy || params != currentArgonParams, nil
+}
+
+func deriveArgonKey(password string, salt []byte, params argonParams) []byte {
+	return argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, params.keyLen)
+}
+
+// encodeArgonHash encodes an argon2id hash in the PHC string format
+// $argon2id$v=19$m=memory,t=time,p=threads$salt$key
+func encodeArgonHash(params argonParams, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.memory, params.time, params.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// decodeArgonHash parses a hash encoded by encodeArgonHash
+func decodeArgonHash(encoded string) (params argonParams, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		err = ErrMalformedPasswordHash
+		return
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		err = ErrMalformedPasswordHash
+		return
+	}
+	if version != argon2.Version {
+		err = ErrUnsupportedArgonVersion
+		return
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		err = ErrMalformedPasswordHash
+		return
+	}
+	if params.memory == 0 || params.time == 0 || params.threads == 0 {
+		err = ErrMalformedPasswordHash
+		return
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		err = ErrMalformedPasswordHash
+		return
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		err = ErrMalformedPasswordHash
+		return
+	}
+	params.keyLen = uint32(len(key))
+	return
+}
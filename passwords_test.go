@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	first, err := hashPassword("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := hashPassword("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("Hashes of the same password should use different salts")
+	}
+	if !strings.HasPrefix(first, "$argon2id$v=19$m=16384,t=4,p=4$") {
+		t.Error("Unexpected hash encoding:", first)
+	}
+	ok, needsRehash, err := verifyPassword("hello", "world", first)
+	if err != nil || !ok || needsRehash {
+		t.Error("Expected a valid current hash, got:", ok, needsRehash, err)
+	}
+	if ok, _, _ := verifyPassword("hellO", "world", first); ok {
+		t.Error("Wrong password should not verify")
+	}
+}
+
+func TestVerifyLegacyPassword(t *testing.T) {
+	// made by the old saltAndHash("hello", "world"), which salted with the username
+	legacy := "$argon2id$v=19$m=16384,t=4,p=4$Kmu5BL5wS9ervTy25ilRQCwjj1T2rkwf00ekySVkvQs$d29ybGQ"
+	ok, needsRehash, err := verifyPassword("hello", "world", legacy)
+	if err != nil || !ok || !needsRehash {
+		t.Error("Expected a valid legacy hash that needs rehashing, got:", ok, needsRehash, err)
+	}
+	if ok, _, _ := verifyPassword("hello", "other", legacy); ok {
+		t.Error("Legacy hash should only verify for its own username")
+	}
+}
+
+func TestVerifyOutdatedParams(t *testing.T) {
+	params := argonParams{memory: 8 * 1024, time: 2, threads: 1, keyLen: argonKeyLen}
+	salt := []byte("0123456789abcdef")
+	outdated := encodeArgonHash(params, salt, deriveArgonKey("hello", salt, params))
+	ok, needsRehash, err := verifyPassword("hello", "world", outdated)
+	if err != nil || !ok || !needsRehash {
+		t.Error("Expected a valid outdated hash that needs rehashing, got:", ok, needsRehash, err)
+	}
+}
+
+func TestDecodeArgonHash(t *testing.T) {
+	payloads := map[string]error{
+		"":                                 ErrMalformedPasswordHash,
+		"plaintext":                        ErrMalformedPasswordHash,
+		"$argon2i$v=19$m=1,t=1,p=1$YQ$YQ":  ErrMalformedPasswordHash,
+		"$argon2id$v=16$m=1,t=1,p=1$YQ$YQ": ErrUnsupportedArgonVersion,
+		"$argon2id$v=19$m=1,t=1$YQ$YQ":     ErrMalformedPasswordHash,
+		"$argon2id$v=19$m=0,t=1,p=1$YQ$YQ": ErrMalformedPasswordHash,
+		"$argon2id$v=19$m=1,t=1,p=1$!$YQ":  ErrMalformedPasswordHash,
+		"$argon2id$v=19$m=1,t=1,p=1$YQ$YQ": nil,
+	}
+	for k, v := range payloads {
+		if _, _, _, err := decodeArgonHash(k); err != v {
+			t.Error("Hash:", k, "expected:", v, "got:", err)
+		}
+	}
+}
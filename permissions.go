@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/courtier/carrotbb/templates"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNotSignedIn  = errors.New("you have to sign in to do that")
+	ErrBanned       = errors.New("you are banned")
+	ErrNoPermission = errors.New("you do not have permission to do that")
+)
+
+// Permission is something a user may or may not do
+type Permission int
+
+const (
+	// PermissionPost allows creating posts and comments and editing or deleting your own
+	PermissionPost Permission = iota
+	// PermissionModerate allows hiding or deleting any post or comment,
+	// locking posts and banning users of lower roles
+	PermissionModerate
+	// PermissionAdminister allows changing the roles of other users
+	PermissionAdminister
+)
+
+// hasPermission reports whether user may do what perm allows,
+// deleted and banned users may do nothing
+func hasPermission(user database.User, perm Permission) bool {
+	if user.Deleted || user.Banned {
+		return false
+	}
+	switch perm {
+	case PermissionPost:
+		return true
+	case PermissionModerate:
+		return user.Role >= database.RoleModerator
+	case PermissionAdminister:
+		return user.Role >= database.RoleAdmin
+	default:
+		return false
+	}
+}
+
+// checkPermission returns nil if the signed in user of a request has perm,
+// otherwise ErrNotSignedIn, ErrBanned or ErrNoPermission
+func checkPermission(r *http.Request, perm Permission) error {
+	profile := profileFromCtx(r.Context())
+	switch {
+	case !profile.OK:
+		return ErrNotSignedIn
+	case hasPermission(profile.User, perm):
+		return nil
+	case profile.User.Banned:
+		return ErrBanned
+	default:
+		return ErrNoPermission
+	}
+}
+
+// requirePermission checks that the signed in user of a request has perm,
+// if not it writes the forbidden error page and returns false
+func requirePermission(w http.ResponseWriter, r *http.Request, perm Permission) bool {
+	err := checkPermission(r, perm)
+	if err == nil {
+		return true
+	}
+	w.WriteHeader(http.StatusForbidden)
+	templates.GenerateErrorPage(w, err.Error())
+	return false
+}
+
+// canModerateUser reports whether moderator may ban target,
+// only users of a lower role can be moderated
+func canModerateUser(moderator, target database.User) bool {
+	return hasPermission(moderator, PermissionModerate) && moderator.Role > target.Role
+}
+
+// promoteAdmin makes the user called name an unbanned admin, it is used to bootstrap
+// an admin on forums where the first account is not the one that should run it
+func promoteAdmin(ctx context.Context, name string) error {
+	user, err := db.FindUserByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err = db.SetUserRole(ctx, user.ID, database.RoleAdmin); err != nil {
+		return err
+	}
+	if err = db.SetUserBanned(ctx, user.ID, false); err != nil {
+		return err
+	}
+	zapper.Info("promoted user to admin", zap.String("name", name))
+	return nil
+}
+
+// promoteFirstUser makes the user with userID an admin if they are the first to ever sign up
+func promoteFirstUser(ctx context.Context, userID xid.ID) error {
+	count, err := db.CountUsers(ctx)
+	if err != nil || count != 1 {
+		return err
+	}
+	return db.SetUserRole(ctx, userID, database.RoleAdmin)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/courtier/carrotbb/database"
+)
+
+func TestHasPermission(t *testing.T) {
+	user := database.User{Role: database.RoleUser}
+	moderator := database.User{Role: database.RoleModerator}
+	admin := database.User{Role: database.RoleAdmin}
+	bannedAdmin := database.User{Role: database.RoleAdmin, Banned: true}
+	payloads := []struct {
+		user     database.User
+		perm     Permission
+		expected bool
+	}{
+		{user, PermissionPost, true},
+		{user, PermissionModerate, false},
+		{moderator, PermissionModerate, true},
+		{moderator, PermissionAdminister, false},
+		{admin, PermissionAdminister, true},
+		{bannedAdmin, PermissionPost, false},
+		{database.DeletedUser, PermissionPost, false},
+	}
+	for _, p := range payloads {
+		if res := hasPermission(p.user, p.perm); res != p.expected {
+			t.Error("User:", p.user.Role, "banned:", p.user.Banned, "permission:", p.perm, "expected:", p.expected, "got:", res)
+		}
+	}
+	if canModerateUser(moderator, moderator) {
+		t.Error("moderators should not be able to ban each other")
+	}
+	if !canModerateUser(admin, moderator) || !canModerateUser(moderator, user) {
+		t.Error("users should be able to ban lower roles")
+	}
+}
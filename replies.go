@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/courtier/carrotbb/templates"
+	"github.com/rs/xid"
+)
+
+const (
+	QUOTE_EXCERPT_LENGTH = 280
+)
+
+var (
+	ErrCannotReply = errors.New("cannot reply to that comment")
+)
+
+// replyFromRequest reads the comment being replied to or quoted from the reply or quote
+// query parameters of a post page, without either of them there is no reply.
+// returns ErrCannotReply if the comment is deleted, hidden or not under the post
+func replyFromRequest(r *http.Request, postID xid.ID) (templates.Reply, error) {
+	query := r.URL.Query()
+	quote := query.Get("quote") != ""
+	encoded := query.Get("reply")
+	if quote {
+		encoded = query.Get("quote")
+	}
+	if encoded == "" {
+		return templates.Reply{}, nil
+	}
+	commentID, err := xid.FromString(encoded)
+	if err != nil {
+		return templates.Reply{}, ErrCannotReply
+	}
+	comment, err := db.GetComment(r.Context(), commentID)
+	if err == database.ErrNoCommentFoundByID || (err == nil && (comment.Deleted || comment.Hidden || comment.PostID != postID)) {
+		return templates.Reply{}, ErrCannotReply
+	}
+	if err != nil {
+		return templates.Reply{}, err
+	}
+	author, err := db.GetUser(r.Context(), comment.PosterID)
+	if err == database.ErrNoUserFoundByID || author.Deleted {
+		author, err = database.DeletedUser, nil
+	}
+	if err != nil {
+		return templates.Reply{}, err
+	}
+	reply := templates.Reply{To: comment, Author: author}
+	if quote {
+		reply.Draft = quoteComment(comment, author)
+	}
+	return reply, nil
+}
+
+// quoteComment returns a draft quoting an excerpt of comment,
+// as a blockquote under a link back to the comment
+func quoteComment(comment database.Comment, author database.User) string {
+	excerpt := []rune(strings.TrimSpace(comment.Content))
+	cut := len(excerpt) > QUOTE_EXCERPT_LENGTH
+	if cut {
+		excerpt = excerpt[:QUOTE_EXCERPT_LENGTH]
+	}
+	lines := strings.Split(strings.ReplaceAll(string(excerpt), "\r\n", "\n"), "\n")
+	var b strings.Builder
+	b.WriteString("[" + author.Name + "](#comment-" + comment.ID.String() + ") wrote:\n")
+	for _, line := range lines {
+		b.WriteString("> " + line + "\n")
+	}
+	if cut {
+		b.WriteString("> …\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
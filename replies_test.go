@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/rs/xid"
+)
+
+func TestQuoteComment(t *testing.T) {
+	author := database.User{Name: "carrot"}
+	comment := database.Comment{Content: "first line\r\nsecond line\n", ID: xid.New()}
+	expected := "[carrot](#comment-" + comment.ID.String() + ") wrote:\n> first line\n> second line\n\n"
+	if res := quoteComment(comment, author); res != expected {
+		t.Errorf("Quote expected: %q got: %q", expected, res)
+	}
+	comment.Content = strings.Repeat("ü", QUOTE_EXCERPT_LENGTH+10)
+	res := quoteComment(comment, author)
+	if !strings.HasSuffix(res, "> …\n\n") {
+		t.Errorf("Long quote should be cut, got: %q", res)
+	}
+	if strings.Count(res, "ü") != QUOTE_EXCERPT_LENGTH {
+		t.Error("Excerpt expected:", QUOTE_EXCERPT_LENGTH, "runes, got:", strings.Count(res, "ü"))
+	}
+}
@@ -6,11 +6,16 @@ import (
 )
 
 var (
-	ErrNameBadLength     = errors.New("username must be between 1 and 24 characters")
-	ErrNameBadCharacter  = errors.New("username can only contain letters, numbers and underscore")
-	ErrTitleBadLength    = errors.New("title must be between 1 and 64 characters")
-	ErrContentBadLength  = errors.New("content must be between 1 and 65535 characters")
-	ErrPasswordBadLength = errors.New("password must be between 1 and 144 characters")
+	ErrNameBadLength      = errors.New("username must be between 1 and 24 characters")
+	ErrNameBadCharacter   = errors.New("username can only contain letters, numbers and underscore")
+	ErrTitleBadLength     = errors.New("title must be between 1 and 64 characters")
+	ErrContentBadLength   = errors.New("content must be between 1 and 65535 characters")
+	ErrPasswordBadLength  = errors.New("password must be between 1 and 144 characters")
+	ErrTokenNameBadLength = errors.New("token name must be between 1 and 32 characters")
+	ErrBoardNameBadLength = errors.New("board name must be between 1 and 32 characters")
+	ErrSlugBadLength      = errors.New("slug must be between 1 and 32 characters")
+	ErrSlugBadCharacter   = errors.New("slug can only contain lowercase letters, numbers and dashes")
+	ErrDescriptionTooLong = errors.New("description can be at most 256 characters")
 )
 
 // isUsernameValid checks: 1 <= length <= 24, only letters, numbers and underscores
@@ -49,3 +54,41 @@ func isPasswordValid(password string) error {
 	}
 	return nil
 }
+
+// isTokenNameValid checks: 1 <= length <= 32
+func isTokenNameValid(name string) error {
+	if len(name) < 1 || len(name) > 32 {
+		return ErrTokenNameBadLength
+	}
+	return nil
+}
+
+// isBoardNameValid checks: 1 <= length <= 32
+func isBoardNameValid(name string) error {
+	if len(name) < 1 || len(name) > 32 {
+		return ErrBoardNameBadLength
+	}
+	return nil
+}
+
+// isSlugValid checks: 1 <= length <= 32, only lowercase ascii letters, numbers and dashes,
+// slugs end up in paths
+func isSlugValid(slug string) error {
+	if len(slug) < 1 || len(slug) > 32 {
+		return ErrSlugBadLength
+	}
+	for _, r := range slug {
+		if !((r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-') {
+			return ErrSlugBadCharacter
+		}
+	}
+	return nil
+}
+
+// isDescriptionValid checks: length <= 256
+func isDescriptionValid(description string) error {
+	if len(description) > 256 {
+		return ErrDescriptionTooLong
+	}
+	return nil
+}
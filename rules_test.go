@@ -72,3 +72,18 @@ func TestIsPasswordValid(t *testing.T) {
 		}
 	}
 }
+
+func TestIsSlugValid(t *testing.T) {
+	payloads := map[string]error{
+		"":                                  ErrSlugBadLength,
+		"carrots-2":                         nil,
+		"carrotscarrotscarrotscarrotscarro": ErrSlugBadLength,
+		"Carrots":                           ErrSlugBadCharacter,
+		"car/rots":                          ErrSlugBadCharacter,
+	}
+	for k, v := range payloads {
+		if res := isSlugValid(k); res != v {
+			t.Error("Slug:", k, "expected:", v, "got:", res)
+		}
+	}
+}
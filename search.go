@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/courtier/carrotbb/database"
+	"github.com/courtier/carrotbb/templates"
+	"go.uber.org/zap"
+)
+
+// SEARCH_DATE_LAYOUT is how the date filters of a search are written, the way date inputs send them
+const SEARCH_DATE_LAYOUT = "2006-01-02"
+
+var (
+	ErrMalformedSearchDate = errors.New("search dates have to look like 2006-01-02")
+)
+
+// SearchHandler searches posts and comments, filtered by author and by the days they were created on
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Add("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	values := r.URL.Query()
+	form := templates.SearchForm{
+		Query:  values.Get("q"),
+		Author: values.Get("author"),
+		Since:  values.Get("since"),
+		Until:  values.Get("until"),
+	}
+	profile := profileFromCtx(r.Context())
+	if len(database.SearchTerms(form.Query)) == 0 && form.Author == "" {
+		if err := templates.GenerateSearchPage(w, profile, form, false, nil, "", ""); err != nil {
+			zapper.Error("error", zap.Error(err))
+		}
+		return
+	}
+	page, err := pageFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, err.Error())
+		return
+	}
+	query, err := searchQueryFromForm(r.Context(), form)
+	if err == database.ErrNoUserFoundByName {
+		w.WriteHeader(http.StatusNotFound)
+		templates.GenerateErrorPage(w, "that author does not exist")
+		return
+	}
+	if err == ErrMalformedSearchDate {
+		w.WriteHeader(http.StatusBadRequest)
+		templates.GenerateErrorPage(w, err.Error())
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while searching")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	query.IncludeHidden = profile.Moderator
+	results, cursors, err := db.Search(r.Context(), query, page)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		templates.GenerateErrorPage(w, "error while searching")
+		zapper.Error("error", zap.Error(err))
+		return
+	}
+	previous, next := searchPageURL(form, "before", cursors.Previous), searchPageURL(form, "after", cursors.Next)
+	if err := templates.GenerateSearchPage(w, profile, form, true, results, previous, next); err != nil {
+		zapper.Error("error", zap.Error(err))
+	}
+}
+
+// searchQueryFromForm turns the filters of the search form into a query,
+// returns database.ErrNoUserFoundByName if the author does not exist
+func searchQueryFromForm(ctx context.Context, form templates.SearchForm) (database.SearchQuery, error) {
+	query := database.SearchQuery{Text: form.Query}
+	if form.Author != "" {
+		author, err := db.FindUserByName(ctx, form.Author)
+		// the posts of deleted accounts are not tied back to their name
+		if err == nil && author.Deleted {
+			err = database.ErrNoUserFoundByName
+		}
+		if err != nil {
+			return database.SearchQuery{}, err
+		}
+		query.AuthorID = author.ID
+	}
+	if form.Since != "" {
+		since, err := time.Parse(SEARCH_DATE_LAYOUT, form.Since)
+		if err != nil {
+			return database.SearchQuery{}, ErrMalformedSearchDate
+		}
+		query.Since = since
+	}
+	if form.Until != "" {
+		until, err := time.Parse(SEARCH_DATE_LAYOUT, form.Until)
+		if err != nil {
+			return database.SearchQuery{}, ErrMalformedSearchDate
+		}
+		// the until day itself is included
+		query.Until = until.AddDate(0, 0, 1)
+	}
+	return query, nil
+}
+
+// searchPageURL links to the page of results on the other side of cursor, empty if there is none
+func searchPageURL(form templates.SearchForm, direction string, cursor database.Cursor) string {
+	if cursor.IsZero() {
+		return ""
+	}
+	values := url.Values{}
+	for key, value := range map[string]string{"q": form.Query, "author": form.Author, "since": form.Since, "until": form.Until} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	values.Set(direction, cursor.String())
+	return "/search?" + values.Encode()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	READ_HEADER_TIMEOUT = 10 * time.Second
+	// READ_TIMEOUT and WRITE_TIMEOUT leave room for MAX_UPLOAD_BYTES over a slow connection,
+	// the write timeout runs from the end of the headers so it has to cover reading the body too
+	READ_TIMEOUT  = 2 * time.Minute
+	WRITE_TIMEOUT = 2 * time.Minute
+	IDLE_TIMEOUT  = 2 * time.Minute
+	// DEFAULT_REQUEST_TIMEOUT is how many seconds a request gets before its context is canceled,
+	// so slow queries give up instead of piling up
+	DEFAULT_REQUEST_TIMEOUT = 10
+	// UPLOAD_TIMEOUT is the deadline of uploads instead, their context runs from before the body is read
+	UPLOAD_TIMEOUT = WRITE_TIMEOUT
+	// SHUTDOWN_TIMEOUT is how long in-flight requests get to finish before they are cut off
+	SHUTDOWN_TIMEOUT = 30 * time.Second
+)
+
+// newServer returns a server with timeouts, so slow or idle clients cannot hold connections forever
+func newServer(addr string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: READ_HEADER_TIMEOUT,
+		ReadTimeout:       READ_TIMEOUT,
+		WriteTimeout:      WRITE_TIMEOUT,
+		IdleTimeout:       IDLE_TIMEOUT,
+		ErrorLog:          zap.NewStdLog(zapper),
+	}
+	// event streams only end when the client leaves, shutdown would wait on them until it times out
+	server.RegisterOnShutdown(hub.shutdown)
+	return server
+}
+
+// DeadlineMiddleware gives every request a deadline, uploads get the longer uploadTimeout to come in over
+// slow connections and requests under exempt path prefixes get none, such as event streams that stay open
+// for as long as the client wants
+type DeadlineMiddleware struct {
+	handler       http.Handler
+	timeout       time.Duration
+	uploadTimeout time.Duration
+	exempt        []string
+}
+
+func NewDeadlineMiddleware(handler http.Handler, timeout, uploadTimeout time.Duration, exempt ...string) *DeadlineMiddleware {
+	return &DeadlineMiddleware{handler: handler, timeout: timeout, uploadTimeout: uploadTimeout, exempt: exempt}
+}
+
+func (d *DeadlineMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, prefix := range d.exempt {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			d.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	timeout := d.timeout
+	if isUploadPath(r.URL.Path) && isMultipartRequest(r) {
+		timeout = d.uploadTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	d.handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// httpsRedirect sends every request to the same path on the https port of domain
+func httpsRedirect(domain string, httpsPort int) http.Handler {
+	host := domain
+	if httpsPort != 443 {
+		host = net.JoinHostPort(domain, strconv.Itoa(httpsPort))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// permanent redirect keeps the method, so forms posted over http still go through
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// listen serves until the server is shut down, any other error is sent on failed.
+// Servers with a tls config serve https with certFile and keyFile, which can be empty
+// when the tls config gets its certificates some other way
+func listen(server *http.Server, certFile, keyFile string, failed chan<- error) {
+	var err error
+	if server.TLSConfig != nil {
+		zapper.Info("listening https", zap.String("port", server.Addr))
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		zapper.Info("listening http", zap.String("port", server.Addr))
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		failed <- err
+	}
+}
+
+// drainServers stops the servers from accepting connections and waits up to timeout
+// for in-flight requests, connections still open after that are closed
+func drainServers(timeout time.Duration, servers ...*http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for n, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				errs[n] = err
+				server.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
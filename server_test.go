@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	for port, location := range map[int]string{
+		443:  "https://carrot.example/post/carrot?page=2",
+		8443: "https://carrot.example:8443/post/carrot?page=2",
+	} {
+		rec := httptest.NewRecorder()
+		httpsRedirect("carrot.example", port).ServeHTTP(rec, httptest.NewRequest("POST", "http://evil.example/post/carrot?page=2", nil))
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != location {
+			t.Error("redirect to port", port, "expected:", location, "got:", rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestDeadlineMiddleware(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	handler := NewDeadlineMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok = r.Context().Deadline()
+	}), time.Second, time.Minute, EVENTS_PREFIX)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/post/carrot", nil))
+	if !ok || time.Until(deadline) > time.Second {
+		t.Error("requests expected a deadline within a second, got:", deadline, ok)
+	}
+	upload := httptest.NewRequest("POST", "/createpost", nil)
+	upload.Header.Set("Content-Type", "multipart/form-data; boundary=carrot")
+	handler.ServeHTTP(httptest.NewRecorder(), upload)
+	if !ok || time.Until(deadline) <= time.Second || time.Until(deadline) > time.Minute {
+		t.Error("uploads expected a deadline within a minute, got:", deadline, ok)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", EVENTS_PREFIX+INDEX_TOPIC, nil))
+	if ok {
+		t.Error("event streams should not get a deadline, got:", deadline)
+	}
+}
+
+func TestDrainServers(t *testing.T) {
+	newAPITestServer(t)
+	old := hub
+	hub = newEventHub(MAX_SSE_CONNECTIONS, MAX_SSE_CONNECTIONS_PER_CLIENT)
+	t.Cleanup(func() { hub = old })
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc(EVENTS_PREFIX, EventsHandler)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+	server := newServer("", mux)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	url := "http://" + listener.Addr().String()
+
+	stream, err := http.Get(url + EVENTS_PREFIX + INDEX_TOPIC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	slow := make(chan string, 1)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		slow <- string(body)
+	}()
+	<-started
+
+	drained := make(chan error, 1)
+	go func() { drained <- drainServers(5*time.Second, server) }()
+	select {
+	case err := <-drained:
+		t.Fatal("drain should wait for in-flight requests, returned:", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if _, err := http.Get(url + "/slow"); err == nil {
+		t.Error("a draining server should not accept new connections")
+	}
+	close(release)
+	if body := <-slow; body != "done" {
+		t.Error("in-flight request should finish, got:", body)
+	}
+	if err = <-drained; err != nil {
+		t.Error("drain with nothing left expected no error, got:", err)
+	}
+	if _, err = io.ReadAll(stream.Body); err != nil {
+		t.Error("event streams should end cleanly on shutdown, got:", err)
+	}
+
+	// requests that outlast the timeout are cut off
+	server = newServer("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-r.Context().Done() }))
+	if listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	go http.Get("http://" + listener.Addr().String())
+	time.Sleep(100 * time.Millisecond)
+	if err = drainServers(100*time.Millisecond, server); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("drain of a stuck request expected:", context.DeadlineExceeded, "got:", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/courtier/carrotbb/database"
+	"go.uber.org/zap"
+)
+
+const (
+	SESSION_SWEEP_INTERVAL = 10 * time.Minute
+)
+
+var (
+	ErrUnsupportedSessionStore = errors.New("unsupported session store")
+)
+
+// SessionStore keeps track of the sessions of logged in users by their token
+type SessionStore interface {
+	// Read returns ErrSessionNotCached if there is no session for that token
+	Read(ctx context.Context, token string) (session, error)
+	// Write stores a session, replacing any session with the same token
+	Write(ctx context.Context, token string, value session) error
+	// Delete removes a session, deleting a missing session is not an error
+	Delete(ctx context.Context, token string) error
+	// Sweep removes every expired session
+	Sweep(ctx context.Context) error
+	// Count returns how many sessions have not expired
+	Count(ctx context.Context) (int, error)
+}
+
+// NewSessionStore returns the session store of the specified kind
+// Possible values are "memory" and "database", which is the default
+func NewSessionStore(kind string, db database.Database) (SessionStore, error) {
+	switch kind {
+	case "memory":
+		return NewMapCache(), nil
+	case "database", "":
+		return NewDatabaseSessionStore(db), nil
+	default:
+		return nil, ErrUnsupportedSessionStore
+	}
+}
+
+// MapCache keeps sessions in memory, they are lost on restart
+// and cannot be shared between multiple instances
+type MapCache struct {
+	cache map[string]session
+	lock  sync.RWMutex
+}
+
+func NewMapCache() *MapCache {
+	return &MapCache{
+		cache: make(map[string]session),
+	}
+}
+
+func (m *MapCache) Read(ctx context.Context, key string) (session, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	s, ok := m.cache[key]
+	if !ok {
+		return session{}, ErrSessionNotCached
+	}
+	return s, nil
+}
+
+func (m *MapCache) Write(ctx context.Context, key string, value session) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cache[key] = value
+	return nil
+}
+
+func (m *MapCache) Delete(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.cache, key)
+	return nil
+}
+
+func (m *MapCache) Sweep(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key, s := range m.cache {
+		if s.isExpired() {
+			delete(m.cache, key)
+		}
+	}
+	return nil
+}
+
+func (m *MapCache) Count(ctx context.Context) (count int, err error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, s := range m.cache {
+		if !s.isExpired() {
+			count++
+		}
+	}
+	return
+}
+
+// DatabaseSessionStore keeps sessions in the database backend,
+// so they survive restarts and are shared between instances.
+// Only the hashes of the tokens are stored
+type DatabaseSessionStore struct {
+	db database.Database
+}
+
+func NewDatabaseSessionStore(db database.Database) *DatabaseSessionStore {
+	return &DatabaseSessionStore{db}
+}
+
+func (d *DatabaseSessionStore) Read(ctx context.Context, token string) (session, error) {
+	s, err := d.db.GetSession(ctx, hashToken(token))
+	if err == database.ErrNoSessionFoundByToken {
+		return session{}, ErrSessionNotCached
+	}
+	if err != nil {
+		return session{}, err
+	}
+	return session{userID: s.UserID, expiry: s.Expiry}, nil
+}
+
+func (d *DatabaseSessionStore) Write(ctx context.Context, token string, value session) error {
+	return d.db.AddSession(ctx, database.Session{
+		Token:  hashToken(token),
+		UserID: value.userID,
+		Expiry: value.expiry,
+	})
+}
+
+func (d *DatabaseSessionStore) Delete(ctx context.Context, token string) error {
+	return d.db.DeleteSession(ctx, hashToken(token))
+}
+
+func (d *DatabaseSessionStore) Sweep(ctx context.Context) error {
+	_, err := d.db.DeleteExpiredSessions(ctx, time.Now())
+	return err
+}
+
+func (d *DatabaseSessionStore) Count(ctx context.Context) (int, error) {
+	return d.db.CountSessions(ctx, time.Now())
+}
+
+// sweepSessions sweeps the store every interval until ctx is done
+func sweepSessions(ctx context.Context, store SessionStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := store.Sweep(ctx); err != nil {
+				zapper.Error("error sweeping sessions", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+func TestMapCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMapCache()
+	if _, err := cache.Read(ctx, "missing"); err != ErrSessionNotCached {
+		t.Error("Expected:", ErrSessionNotCached, "got:", err)
+	}
+	cache.Write(ctx, "live", session{userID: xid.New(), expiry: time.Now().Add(time.Hour)})
+	cache.Write(ctx, "expired", session{userID: xid.New(), expiry: time.Now().Add(-time.Hour)})
+	if count, err := cache.Count(ctx); err != nil || count != 1 {
+		t.Error("Count should leave out expired sessions, got:", count, err)
+	}
+	if err := cache.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Read(ctx, "live"); err != nil {
+		t.Error("live session should survive a sweep, got:", err)
+	}
+	if _, err := cache.Read(ctx, "expired"); err != ErrSessionNotCached {
+		t.Error("expired session should be swept, got:", err)
+	}
+	cache.Delete(ctx, "live")
+	if _, err := cache.Read(ctx, "live"); err != ErrSessionNotCached {
+		t.Error("Expected:", ErrSessionNotCached, "got:", err)
+	}
+}
+
+func TestNewSessionStore(t *testing.T) {
+	if _, err := NewSessionStore("carrot", nil); err != ErrUnsupportedSessionStore {
+		t.Error("Expected:", ErrUnsupportedSessionStore, "got:", err)
+	}
+	if store, err := NewSessionStore("memory", nil); err != nil {
+		t.Error(err)
+	} else if _, ok := store.(*MapCache); !ok {
+		t.Errorf("Expected *MapCache, got: %T", store)
+	}
+}
@@ -0,0 +1,62 @@
+package templates
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/courtier/carrotbb/database"
+)
+
+const boardPageTemplateStr = `<html lang="en">
+
+<head>
+    <meta charset="UTF-8">
+    <meta http-equiv="X-UA-Compatible" content="IE=edge">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>carrotbb - {{.Board.Name}}</title>
+</head>
+
+<body>
+    {{if .User.OK}}
+    <p><a href="/">carrotbb</a> - logged in as <a href="/self">{{.User.User.Name}}</a> <a href="/createpost?board={{.Board.Slug}}">create a post</a> <form action="/logout" method="post" style="display: inline"><input type="hidden" name="csrf_token" value="{{.User.CSRF}}"><input type="submit" value="log out"></form></p>
+    {{else}}
+    <p><a href="/">carrotbb</a> - <a href="/signup">sign up</a> <a href="/signin">sign in</a></p>
+    {{end}}
+    <h2>{{.Board.Name}}</h2>
+    <p>{{.Board.Description}}</p>
+	{{if .Posts}}
+	<ul>
+        {{range .Posts}}
+        <li>
+			<p><a href="/post/{{.ID}}">{{.Title}}</a>{{if .Hidden}} <i>(hidden)</i>{{end}}{{if .Locked}} <i>(locked)</i>{{end}} {{ $length := len .CommentIDs }} {{ if ne $length 1 }} {{ $length }} comments {{else}} 1 comment {{end}}, posted at {{.DateCreated.Format "15:04:05 UTC"}} on {{.DateCreated.Format "Jan 02, 2006"}}</p>
+        </li>
+        {{end}}
+    </ul>
+    <p>{{if not .Cursors.Previous.IsZero}}<a href="/b/{{.Board.Slug}}?before={{.Cursors.Previous}}">newer posts</a>{{end}} {{if not .Cursors.Next.IsZero}}<a href="/b/{{.Board.Slug}}?after={{.Cursors.Next}}">older posts</a>{{end}}</p>
+	{{else}}
+	<h3>no posts found.</h3>
+	{{end}}
+</body>
+
+</html>`
+
+type BoardPageTemplateData struct {
+	User    Profile
+	Board   database.Board
+	Posts   []database.Post
+	Cursors database.PageCursors
+}
+
+var (
+	boardPageTemplate = template.Must(template.New("boardPageTemplate").Parse(boardPageTemplateStr))
+)
+
+func GenerateBoardPage(w http.ResponseWriter, user Profile, board database.Board, posts []database.Post, cursors database.PageCursors) error {
+	data := BoardPageTemplateData{
+		User:    user,
+		Board:   board,
+		Posts:   posts,
+		Cursors: cursors,
+	}
+	return boardPageTemplate.Execute(w, data)
+}
@@ -0,0 +1,68 @@
+package templates
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/courtier/carrotbb/database"
+)
+
+const manageBoardsTemplateStr = `<html lang="en">
+
+<head>
+    <meta charset="UTF-8">
+    <meta http-equiv="X-UA-Compatible" content="IE=edge">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>carrotbb - boards</title>
+</head>
+
+<body>
+    <p><a href="/">carrotbb</a> - logged in as <a href="/self">{{.User.User.Name}}</a></p>
+    <h2>boards</h2>
+    <p>boards are listed by position, lowest first. changing the slug of a board changes its address.</p>
+    {{range .Boards}}
+    <form action="/boards" method="post">
+        <input type="hidden" name="csrf_token" value="{{$.User.CSRF}}">
+        <input type="hidden" name="action" value="update">
+        <input type="hidden" name="boardID" value="{{.ID}}">
+        <input type="text" name="name" value="{{.Name}}" aria-label="name">
+        <input type="text" name="slug" value="{{.Slug}}" aria-label="slug">
+        <input type="text" name="description" value="{{.Description}}" aria-label="description">
+        <input type="number" name="position" value="{{.Position}}" aria-label="position">
+        <input type="submit" value="save"> <a href="/b/{{.Slug}}">{{if ne .PostCount 1}}{{.PostCount}} posts{{else}}1 post{{end}}</a>
+    </form>
+    {{end}}
+    <h3>new board</h3>
+    <form action="/boards" method="post">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
+        <input type="hidden" name="action" value="create">
+        <label for="name">Name</label><br>
+        <input type="text" id="name" name="name" placeholder="carrots"/><br>
+        <label for="slug">Slug</label><br>
+        <input type="text" id="slug" name="slug" placeholder="carrots"/><br>
+        <label for="description">Description</label><br>
+        <input type="text" id="description" name="description"/><br>
+        <label for="position">Position</label><br>
+        <input type="number" id="position" name="position" value="{{len .Boards}}"/><br>
+        <input type="submit" value="create">
+    </form>
+</body>
+
+</html>`
+
+type ManageBoardsTemplateData struct {
+	User   Profile
+	Boards []database.BoardSummary
+}
+
+var (
+	manageBoardsTemplate = template.Must(template.New("manageBoardsTemplate").Parse(manageBoardsTemplateStr))
+)
+
+func GenerateManageBoardsPage(w http.ResponseWriter, user Profile, boards []database.BoardSummary) error {
+	data := ManageBoardsTemplateData{
+		User:   user,
+		Boards: boards,
+	}
+	return manageBoardsTemplate.Execute(w, data)
+}
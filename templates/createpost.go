@@ -1,11 +1,13 @@
 package templates
 
 import (
-	"fmt"
+	"html/template"
 	"net/http"
+
+	"github.com/courtier/carrotbb/database"
 )
 
-const createPostTemplate = `<html lang="en">
+const createPostTemplateStr = `<html lang="en">
 
 <head>
     <meta charset="UTF-8">
@@ -16,22 +18,45 @@ const createPostTemplate = `<html lang="en">
 
 <body>
     <h1>create a post</h1>
-    <form action="/createpost" method="post">
+    <form action="/createpost" method="post" enctype="multipart/form-data">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
+        <label for="board">Board</label><br>
+        <select id="board" name="board">
+            {{range .Boards}}
+            <option value="{{.Slug}}"{{if eq .Slug $.Selected}} selected{{end}}>{{.Name}}</option>
+            {{end}}
+        </select><br>
         <label for="title">Title</label><br>
         <input type="text" id="title" name="title" placeholder="carrot"/><br>
         <label for="content">Content</label><br>
         <textarea rows="10" cols="80" type="text" id="content" name="content"></textarea><br>
+        <label for="attachments">Images</label><br>
+        <input type="file" id="attachments" name="attachments" accept="image/png,image/jpeg,image/gif" multiple><br>
         <input type="submit" value="Submit">
     </form>
 </body>
 
 </html>`
 
-func GenerateCreatePostPage() string {
-	return createPostTemplate
+// AttachmentFieldName is the form field images are uploaded in, on posts and comments alike
+const AttachmentFieldName = "attachments"
+
+type CreatePostTemplateData struct {
+	User   Profile
+	Boards []database.BoardSummary
+	// slug of the board picked by default
+	Selected string
 }
 
-func ServeCreatePostTemplate(w http.ResponseWriter) {
-	w.Header().Add("Content-Type", "text/html; charset=utf-8")
-	fmt.Fprint(w, createPostTemplate)
+var (
+	createPostTemplate = template.Must(template.New("createPostTemplate").Parse(createPostTemplateStr))
+)
+
+func GenerateCreatePostPage(w http.ResponseWriter, user Profile, boards []database.BoardSummary, selected string) error {
+	data := CreatePostTemplateData{
+		User:     user,
+		Boards:   boards,
+		Selected: selected,
+	}
+	return createPostTemplate.Execute(w, data)
 }
@@ -0,0 +1,47 @@
+package templates
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/courtier/carrotbb/database"
+)
+
+const editCommentTemplateStr = `<html lang="en">
+
+<head>
+    <meta charset="UTF-8">
+    <meta http-equiv="X-UA-Compatible" content="IE=edge">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>CarrotBB Edit a Comment</title>
+</head>
+
+<body>
+    <h1>edit your comment</h1>
+    <form action="/editcomment" method="post">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
+        <input type="hidden" id="commentID" name="commentID" value="{{.Comment.ID}}">
+        <textarea rows="7" cols="50" id="comment" name="comment">{{.Comment.Content}}</textarea><br><br>
+        <input type="submit" value="Submit">
+    </form>
+    <p><a href="/post/{{.Comment.PostID}}">back to the post</a></p>
+</body>
+
+</html>`
+
+type EditCommentTemplateData struct {
+	User    Profile
+	Comment database.Comment
+}
+
+var (
+	editCommentTemplate = template.Must(template.New("editCommentTemplate").Parse(editCommentTemplateStr))
+)
+
+func GenerateEditCommentPage(w http.ResponseWriter, user Profile, comment database.Comment) error {
+	data := EditCommentTemplateData{
+		User:    user,
+		Comment: comment,
+	}
+	return editCommentTemplate.Execute(w, data)
+}
@@ -0,0 +1,50 @@
+package templates
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/courtier/carrotbb/database"
+)
+
+const editPostTemplateStr = `<html lang="en">
+
+<head>
+    <meta charset="UTF-8">
+    <meta http-equiv="X-UA-Compatible" content="IE=edge">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>CarrotBB Edit a Post</title>
+</head>
+
+<body>
+    <h1>edit your post</h1>
+    <form action="/editpost" method="post">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
+        <input type="hidden" id="postID" name="postID" value="{{.Post.ID}}">
+        <label for="title">Title</label><br>
+        <input type="text" id="title" name="title" value="{{.Post.Title}}"/><br>
+        <label for="content">Content</label><br>
+        <textarea rows="10" cols="80" type="text" id="content" name="content">{{.Post.Content}}</textarea><br>
+        <input type="submit" value="Submit">
+    </form>
+    <p><a href="/post/{{.Post.ID}}">back to the post</a></p>
+</body>
+
+</html>`
+
+type EditPostTemplateData struct {
+	User Profile
+	Post database.Post
+}
+
+var (
+	editPostTemplate = template.Must(template.New("editPostTemplate").Parse(editPostTemplateStr))
+)
+
+func GenerateEditPostPage(w http.ResponseWriter, user Profile, post database.Post) error {
+	data := EditPostTemplateData{
+		User: user,
+		Post: post,
+	}
+	return editPostTemplate.Execute(w, data)
+}
@@ -14,23 +14,37 @@ const indexPageTemplateStr = `<html lang="en">
     <meta http-equiv="X-UA-Compatible" content="IE=edge">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <title>carrotbb</title>
+    <link rel="alternate" type="application/atom+xml" title="latest posts" href="/feed.atom">
+    <link rel="alternate" type="application/rss+xml" title="latest posts" href="/feed.rss">
 </head>
 
 <body>
     {{if .User.OK}}
-    <p>carrotbb - logged in as <a href="/self">{{.User.User.Name}}</a> <a href="/createpost">create a post</a> <a href="/logout">log out</a></p>
+    <p>carrotbb - logged in as <a href="/self">{{.User.User.Name}}</a> <a href="/createpost">create a post</a> <a href="/search">search</a>{{if .User.Admin}} <a href="/boards">manage boards</a>{{end}} <form action="/logout" method="post" style="display: inline"><input type="hidden" name="csrf_token" value="{{.User.CSRF}}"><input type="submit" value="log out"></form></p>
     {{else}}
-    <p>carrotbb - <a href="/signup">sign up</a> <a href="/signin">sign in</a></p>
+    <p>carrotbb - <a href="/signup">sign up</a> <a href="/signin">sign in</a> <a href="/search">search</a></p>
     {{end}}
+	{{if .Boards}}
+	<h3>boards</h3>
+	<ul>
+        {{range .Boards}}
+        <li>
+			<p><a href="/b/{{.Slug}}">{{.Name}}</a> - {{.Description}}<br>
+                {{if ne .PostCount 1}}{{.PostCount}} posts{{else}}1 post{{end}}{{if not .LatestActivity.IsZero}}, latest activity at {{.LatestActivity.Format "15:04:05 UTC"}} on {{.LatestActivity.Format "Jan 02, 2006"}}{{end}}</p>
+        </li>
+        {{end}}
+    </ul>
+	{{end}}
 	{{if .Posts}}
-	<h3>posts</h3>
+	<h3>latest posts</h3>
 	<ul>
         {{range .Posts}}
         <li>
-			<p><a href="/post/{{.ID}}">{{.Title}}</a> {{ $length := len .CommentIDs }} {{ if ne $length 1 }} {{ $length }} comments {{else}} 1 comment {{end}}, posted at {{.DateCreated.Format "15:04:05 UTC"}} on {{.DateCreated.Format "Jan 02, 2006"}}</p>
+			<p><a href="/post/{{.ID}}">{{.Title}}</a>{{if .Hidden}} <i>(hidden)</i>{{end}}{{if .Locked}} <i>(locked)</i>{{end}} {{ $length := len .CommentIDs }} {{ if ne $length 1 }} {{ $length }} comments {{else}} 1 comment {{end}}, posted at {{.DateCreated.Format "15:04:05 UTC"}} on {{.DateCreated.Format "Jan 02, 2006"}}</p>
         </li>
         {{end}}
     </ul>
+    <p>{{if not .Cursors.Previous.IsZero}}<a href="/?before={{.Cursors.Previous}}">newer posts</a>{{end}} {{if not .Cursors.Next.IsZero}}<a href="/?after={{.Cursors.Next}}">older posts</a>{{end}}</p>
 	{{else}}
 	<h3>no posts found.</h3>
 	{{end}}
@@ -39,18 +53,22 @@ const indexPageTemplateStr = `<html lang="en">
 </html>`
 
 type IndexPageTemplateData struct {
-	User  Profile
-	Posts []database.Post
+	User    Profile
+	Boards  []database.BoardSummary
+	Posts   []database.Post
+	Cursors database.PageCursors
 }
 
 var (
 	indexPageTemplate = template.Must(template.New("indexPageTemplate").Parse(indexPageTemplateStr))
 )
 
-func GenerateIndexPage(w http.ResponseWriter, user Profile, posts []database.Post) error {
+func GenerateIndexPage(w http.ResponseWriter, user Profile, boards []database.BoardSummary, posts []database.Post, cursors database.PageCursors) error {
 	data := IndexPageTemplateData{
-		User:  user,
-		Posts: posts,
+		User:    user,
+		Boards:  boards,
+		Posts:   posts,
+		Cursors: cursors,
 	}
 	return indexPageTemplate.Execute(w, data)
 }
@@ -0,0 +1,83 @@
+package templates
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/rs/xid"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+const (
+	// MARKDOWN_CACHE_SIZE is how many rendered posts, comments and revisions are kept,
+	// the cache is emptied once it grows past it
+	MARKDOWN_CACHE_SIZE = 4096
+)
+
+var (
+	// raw html in the source is dropped by goldmark, the policy catches anything else,
+	// such as javascript: links
+	markdown = goldmark.New(
+		goldmark.WithExtensions(extension.Linkify, extension.Strikethrough),
+		goldmark.WithRendererOptions(html.WithHardWraps()),
+	)
+	sanitiser = newSanitiser()
+
+	renderCache     = make(map[renderKey]template.HTML)
+	renderCacheLock sync.RWMutex
+
+	// functions available to every template
+	funcs = template.FuncMap{
+		"markdown": RenderMarkdown,
+	}
+)
+
+// renderKey tells apart versions of the same post or comment,
+// an edit changes its date so stale renders are never served
+type renderKey struct {
+	id     xid.ID
+	edited time.Time
+}
+
+func newSanitiser() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoFollowOnLinks(true)
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w-]+$`)).OnElements("code")
+	return p
+}
+
+// RenderMarkdown renders the markdown source of the post, comment or revision with id
+// into sanitised html, renders are cached by id and edit date
+func RenderMarkdown(id xid.ID, edited time.Time, source string) template.HTML {
+	key := renderKey{id, edited}
+	renderCacheLock.RLock()
+	rendered, ok := renderCache[key]
+	renderCacheLock.RUnlock()
+	if ok {
+		return rendered
+	}
+	rendered = renderMarkdown(source)
+	renderCacheLock.Lock()
+	defer renderCacheLock.Unlock()
+	if len(renderCache) >= MARKDOWN_CACHE_SIZE {
+		renderCache = make(map[renderKey]template.HTML)
+	}
+	renderCache[key] = rendered
+	return rendered
+}
+
+// renderMarkdown renders source without the cache,
+// if goldmark fails the source is shown escaped instead
+func renderMarkdown(source string) template.HTML {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		return template.HTML("<p>" + template.HTMLEscapeString(source) + "</p>")
+	}
+	return template.HTML(sanitiser.SanitizeBytes(buf.Bytes()))
+}
@@ -0,0 +1,48 @@
+package templates
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	payloads := map[string]string{
+		"*carrot*":                     "<em>carrot</em>",
+		"> quoted":                     "<blockquote>",
+		"- one\n- two":                 "<li>two</li>",
+		"```go\nfmt.Println()\n```":    `<code class="language-go">`,
+		"see https://example.com":      `<a href="https://example.com" rel="nofollow">`,
+		"[top](#comment-1)":            `<a href="#comment-1" rel="nofollow">`,
+		"first\nsecond":                "first<br>",
+		"<script>alert(1)</script>":    "",
+		"[click](javascript:alert(1))": "click",
+		"<img src=x onerror=alert(1)>": "",
+		"`<b>`":                        "<code>&lt;b&gt;</code>",
+	}
+	for k, v := range payloads {
+		res := string(renderMarkdown(k))
+		if !strings.Contains(res, v) {
+			t.Errorf("Source: %q expected to contain: %q got: %q", k, v, res)
+		}
+		for _, bad := range []string{"<script", "javascript:", "onerror"} {
+			if strings.Contains(res, bad) {
+				t.Errorf("Source: %q rendered unsafe html: %q", k, res)
+			}
+		}
+	}
+}
+
+func TestRenderMarkdownCache(t *testing.T) {
+	id := xid.New()
+	edited := time.Now()
+	first := RenderMarkdown(id, edited, "first")
+	if res := RenderMarkdown(id, edited, "changed"); res != first {
+		t.Error("Render expected to be cached, got:", res)
+	}
+	if res := RenderMarkdown(id, edited.Add(time.Second), "changed"); res == first {
+		t.Error("Edited render expected to be fresh, got:", res)
+	}
+}
@@ -2,6 +2,7 @@ package templates
 
 import (
 	"html/template"
+	"io"
 	"net/http"
 
 	"github.com/courtier/carrotbb/database"
@@ -15,58 +16,266 @@ const postPageTemplateStr = `<html lang="en">
     <meta http-equiv="X-UA-Compatible" content="IE=edge">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <title>carrotbb</title>
+    <link rel="alternate" type="application/atom+xml" title="comments" href="/feed/post/{{.Post.ID}}.atom">
 </head>
 
 <body>
     {{if .User.OK}}
-    <p><a href="/">carrotbb</a> - logged in as <a href="/self">{{.User.User.Name}}</a> <a href="/createpost">create a post</a> <a href="/logout">log out</a></p>
+    <p><a href="/">carrotbb</a> - logged in as <a href="/self">{{.User.User.Name}}</a> <a href="/createpost">create a post</a> <form action="/logout" method="post" style="display: inline"><input type="hidden" name="csrf_token" value="{{.User.CSRF}}"><input type="submit" value="log out"></form></p>
     {{else}}
     <p><a href="/">carrotbb</a> - <a href="/signup">sign up</a> <a href="/signin">sign in</a></p>
     {{end}}
+    <p>in <a href="/b/{{.Board.Slug}}">{{.Board.Name}}</a></p>
+    {{if .Post.Hidden}}
+    <p><i>this post is hidden by a moderator.</i></p>
+    {{end}}
+    {{if .Post.Locked}}
+    <p><i>this thread is locked, only moderators can comment.</i></p>
+    {{end}}
     <p><b>{{.Poster.Name}}</b> posted at {{.Post.DateCreated.Format "15:04:05 UTC"}} on {{.Post.DateCreated.Format "Jan 02, 2006"}}:</p>
 	<h2>{{.Post.Title}}</h2>
-    <p>{{.Post.Content}}</p>
+    <div>{{markdown .Post.ID .Post.DateEdited .Post.Content}}</div>
+    {{template "attachments" index .Attachments .Post.ID}}
+    {{if not .Post.DateEdited.IsZero}}
+    <p><i>edited at {{.Post.DateEdited.Format "15:04:05 UTC"}} on {{.Post.DateEdited.Format "Jan 02, 2006"}}</i></p>
+    {{with index $.Revisions .Post.ID}}
+    <details>
+        <summary>edit history</summary>
+        {{range .}}
+        <p>version replaced at {{.DateEdited.Format "15:04:05 UTC"}} on {{.DateEdited.Format "Jan 02, 2006"}}:</p>
+        <h4>{{.Title}}</h4>
+        <div>{{markdown .ID .DateEdited .Content}}</div>
+        {{end}}
+    </details>
+    {{end}}
+    {{end}}
+    {{if .User.CanEdit .Post.PosterID}}
+    <p><a href="/editpost?postID={{.Post.ID}}">edit post</a></p>
+    {{end}}
+    {{if .User.CanDelete .Post.PosterID}}
+    <form action="/deletepost" method="post">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
+        <input type="hidden" name="postID" value="{{.Post.ID}}">
+        <input type="submit" value="Delete post">
+    </form>
+    {{end}}
+    {{if .User.Moderator}}
+    <form action="/moderate" method="post">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
+        <input type="hidden" name="postID" value="{{.Post.ID}}">
+        <button type="submit" name="action" value="{{if .Post.Hidden}}showpost{{else}}hidepost{{end}}">{{if .Post.Hidden}}Unhide{{else}}Hide{{end}} post</button>
+        <button type="submit" name="action" value="{{if .Post.Locked}}unlockpost{{else}}lockpost{{end}}">{{if .Post.Locked}}Unlock{{else}}Lock{{end}} thread</button>
+    </form>
+    {{end}}
     <hr>
-    {{if .Comments}}
+    <div id="comments"{{if .Cursors.Next.IsZero}} data-live{{end}}>
         {{range .Comments}}
-			<p><b>{{ with (index $.Users .ID) }}{{ .Name }}{{ end }}</b> commented at {{.DateCreated.Format "15:04:05 UTC"}} on {{.DateCreated.Format "Jan 02, 2006"}}<br>
-                {{.Content}}</p>
+            {{template "comment" $.Thread .}}
             <hr>
         {{end}}
+    </div>
+    {{if .Comments}}
+    <p>{{if not .Cursors.Previous.IsZero}}<a href="/post/{{.Post.ID}}?before={{.Cursors.Previous}}">earlier comments</a>{{end}} {{if not .Cursors.Next.IsZero}}<a href="/post/{{.Post.ID}}?after={{.Cursors.Next}}">later comments</a>{{end}}</p>
 	{{else}}
-	<p><b>no comments found.{{if .User.OK}} leave one down below!{{end}}</b></p>
+	<p id="no-comments"><b>no comments found.{{if .User.OK}} leave one down below!{{end}}</b></p>
 	{{end}}
-    {{if .User.OK}}
-    <form action="/createcomment" method="post">
+    {{if and .User.CanPost (or (not .Post.Locked) .User.Moderator)}}
+    <form id="reply" action="/createcomment" method="post" enctype="multipart/form-data">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
+        {{if not .Reply.To.ID.IsNil}}
+        <p>replying to <a href="#comment-{{.Reply.To.ID}}">{{.Reply.Author.Name}}</a> <a href="/post/{{.Post.ID}}#reply">cancel</a></p>
+        <input type="hidden" name="parentID" value="{{.Reply.To.ID}}">
+        {{end}}
         <label for="comment">Leave a comment</label><br>
 		<input type="hidden" id="postID" name="postID" value="{{.Post.ID}}">
-        <textarea rows="7" cols="50" id="comment" name="comment"></textarea><br><br>
+        <textarea rows="7" cols="50" id="comment" name="comment">{{.Reply.Draft}}</textarea><br>
+        <input type="file" name="attachments" accept="image/png,image/jpeg,image/gif" multiple><br><br>
         <input type="submit" value="Submit">
     </form>
     {{end}}
+    <script>
+    // appends new comments as they are posted, top level ones only on the last page
+    (function () {
+        if (!window.EventSource) {
+            return;
+        }
+        var postID = "{{.Post.ID}}";
+        var comments = document.getElementById("comments");
+        var canReply = document.getElementById("reply") !== null;
+        var source = new EventSource("/events/post/" + postID);
+        source.addEventListener("comment", function (e) {
+            var data = JSON.parse(e.data);
+            if (document.getElementById("comment-" + data.id)) {
+                return;
+            }
+            var parent = comments;
+            if (data.parent_id) {
+                var parentComment = document.getElementById("comment-" + data.parent_id);
+                if (!parentComment) {
+                    return;
+                }
+                parent = parentComment.querySelector(":scope > .replies");
+                if (!parent) {
+                    parent = document.createElement("div");
+                    parent.className = "replies";
+                    parent.style.marginLeft = "2em";
+                    parentComment.appendChild(parent);
+                }
+            } else if (!comments.hasAttribute("data-live")) {
+                return;
+            }
+            var holder = document.createElement("div");
+            holder.innerHTML = data.html;
+            var comment = holder.firstElementChild;
+            if (canReply) {
+                var links = document.createElement("p");
+                links.innerHTML = '<a href="/post/' + postID + '?reply=' + data.id + '#reply">reply</a> ' +
+                    '<a href="/post/' + postID + '?quote=' + data.id + '#reply">quote</a>';
+                comment.insertBefore(links, comment.querySelector(":scope > .replies"));
+            }
+            parent.appendChild(comment);
+            if (!data.parent_id) {
+                parent.appendChild(document.createElement("hr"));
+            }
+            var none = document.getElementById("no-comments");
+            if (none) {
+                none.remove();
+            }
+        });
+    })();
+    </script>
 </body>
 
-</html>`
+</html>
+
+{{define "comment"}}
+<div id="comment-{{.Node.ID}}">
+    {{if .Node.Deleted}}
+    <p><i>this comment has been deleted.</i></p>
+    {{else if and .Node.Hidden (not .Page.User.Moderator)}}
+    <p><i>this comment has been hidden by a moderator.</i></p>
+    {{else}}
+    {{if .Node.Hidden}}
+    <p><i>this comment is hidden by a moderator.</i></p>
+    {{end}}
+    <p><b>{{ with (index .Page.Users .Node.ID) }}{{ .Name }}{{if ne .Role.String "user"}} <i>({{.Role}})</i>{{end}}{{ end }}</b> commented at {{.Node.DateCreated.Format "15:04:05 UTC"}} on {{.Node.DateCreated.Format "Jan 02, 2006"}}</p>
+    <div>{{markdown .Node.ID .Node.DateEdited .Node.Content}}</div>
+    {{template "attachments" index .Page.Attachments .Node.ID}}
+    {{if not .Node.DateEdited.IsZero}}
+    <p><i>edited at {{.Node.DateEdited.Format "15:04:05 UTC"}} on {{.Node.DateEdited.Format "Jan 02, 2006"}}</i></p>
+    {{with index .Page.Revisions .Node.ID}}
+    <details>
+        <summary>edit history</summary>
+        {{range .}}
+        <p>version replaced at {{.DateEdited.Format "15:04:05 UTC"}} on {{.DateEdited.Format "Jan 02, 2006"}}:</p>
+        <div>{{markdown .ID .DateEdited .Content}}</div>
+        {{end}}
+    </details>
+    {{end}}
+    {{end}}
+    {{if and .Page.User.CanPost (not .Node.Hidden) (or (not .Page.Post.Locked) .Page.User.Moderator)}}
+    <p><a href="/post/{{.Page.Post.ID}}?reply={{.Node.ID}}#reply">reply</a> <a href="/post/{{.Page.Post.ID}}?quote={{.Node.ID}}#reply">quote</a></p>
+    {{end}}
+    {{if .Page.User.CanEdit .Node.PosterID}}
+    <p><a href="/editcomment?commentID={{.Node.ID}}">edit comment</a></p>
+    {{end}}
+    {{if .Page.User.CanDelete .Node.PosterID}}
+    <form action="/deletecomment" method="post">
+        <input type="hidden" name="csrf_token" value="{{.Page.User.CSRF}}">
+        <input type="hidden" name="commentID" value="{{.Node.ID}}">
+        <input type="submit" value="Delete comment">
+    </form>
+    {{end}}
+    {{if .Page.User.Moderator}}
+    <form action="/moderate" method="post">
+        <input type="hidden" name="csrf_token" value="{{.Page.User.CSRF}}">
+        <input type="hidden" name="commentID" value="{{.Node.ID}}">
+        <button type="submit" name="action" value="{{if .Node.Hidden}}showcomment{{else}}hidecomment{{end}}">{{if .Node.Hidden}}Unhide{{else}}Hide{{end}} comment</button>
+    </form>
+    {{end}}
+    {{end}}
+    {{if .Node.Replies}}
+    <div class="replies" style="margin-left: 2em">
+        {{range .Node.Replies}}
+        {{template "comment" $.Page.Thread .}}
+        {{end}}
+    </div>
+    {{end}}
+</div>
+{{end}}
+
+{{define "attachments"}}
+{{if .}}
+<p>
+    {{range .}}
+    <a href="/attachments/{{.ID}}"><img src="/attachments/{{.ID}}/thumbnail" alt="image attachment" loading="lazy"></a>
+    {{end}}
+</p>
+{{end}}
+{{end}}`
+
+// Reply is the comment a new comment is being written in reply to,
+// it is empty for top level comments
+type Reply struct {
+	To     database.Comment
+	Author database.User
+	// Draft is what the comment box starts with, a quote of To when quoting
+	Draft string
+}
 
 type PostPageTemplateData struct {
-	User     Profile
-	Post     database.Post
-	Poster   database.User
-	Comments []database.Comment
-	Users    map[xid.ID]database.User
+	User      Profile
+	Board     database.Board
+	Post      database.Post
+	Poster    database.User
+	Comments  []database.CommentNode
+	Users     map[xid.ID]database.User
+	Revisions map[xid.ID][]database.Revision
+	// keyed by the id of the post or comment they belong to
+	Attachments map[xid.ID][]database.Attachment
+	Cursors     database.PageCursors
+	Reply       Reply
+}
+
+// CommentThreadData is what the comment template renders,
+// a comment with its replies and the page it is on
+type CommentThreadData struct {
+	Page *PostPageTemplateData
+	Node database.CommentNode
+}
+
+// Thread pairs a comment with the page so it can be rendered recursively
+func (d *PostPageTemplateData) Thread(node database.CommentNode) CommentThreadData {
+	return CommentThreadData{Page: d, Node: node}
 }
 
 var (
-	postPageTemplate = template.Must(template.New("postPageTemplate").Parse(postPageTemplateStr))
+	postPageTemplate = template.Must(template.New("postPageTemplate").Funcs(funcs).Parse(postPageTemplateStr))
 )
 
-func GeneratePostPage(w http.ResponseWriter, user Profile, post database.Post, poster database.User, comments []database.Comment, users map[xid.ID]database.User) error {
+func GeneratePostPage(w http.ResponseWriter, user Profile, board database.Board, post database.Post, poster database.User, comments []database.CommentNode, users map[xid.ID]database.User, revisions map[xid.ID][]database.Revision, attachments map[xid.ID][]database.Attachment, cursors database.PageCursors, reply Reply) error {
+	data := PostPageTemplateData{
+		User:        user,
+		Board:       board,
+		Post:        post,
+		Poster:      poster,
+		Comments:    comments,
+		Users:       users,
+		Revisions:   revisions,
+		Attachments: attachments,
+		Cursors:     cursors,
+		Reply:       reply,
+	}
+	return postPageTemplate.Execute(w, &data)
+}
+
+// RenderComment renders a single comment the way the post page shows it to someone signed out,
+// for pages that are already open to add it without a reload
+func RenderComment(w io.Writer, post database.Post, comment database.Comment, author database.User, attachments []database.Attachment) error {
 	data := PostPageTemplateData{
-		User:     user,
-		Post:     post,
-		Poster:   poster,
-		Comments: comments,
-		Users:    users,
+		Post:        post,
+		Users:       map[xid.ID]database.User{comment.ID: author},
+		Attachments: map[xid.ID][]database.Attachment{comment.ID: attachments},
 	}
-	return postPageTemplate.Execute(w, data)
+	return postPageTemplate.ExecuteTemplate(w, "comment", data.Thread(database.CommentNode{Comment: comment}))
 }
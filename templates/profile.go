@@ -1,9 +1,37 @@
 package templates
 
-import "github.com/courtier/carrotbb/database"
+import (
+	"github.com/courtier/carrotbb/database"
+	"github.com/rs/xid"
+)
+
+// CSRFFieldName is the form field that carries the csrf token in every state changing form
+const CSRFFieldName = "csrf_token"
 
 type Profile struct {
 	User database.User
 	// valid user?
 	OK bool
+	// csrf token of the visitor, logged in or not
+	CSRF string
+	// what the user may do, banned users may do nothing
+	CanPost   bool
+	Moderator bool
+	Admin     bool
+}
+
+// Owns reports whether the profile is a valid user that created the post or comment with posterID
+func (p Profile) Owns(posterID xid.ID) bool {
+	return p.OK && p.User.ID == posterID
+}
+
+// CanEdit reports whether the profile may edit the post or comment with posterID
+func (p Profile) CanEdit(posterID xid.ID) bool {
+	return p.Owns(posterID) && p.CanPost
+}
+
+// CanDelete reports whether the profile may delete the post or comment with posterID,
+// moderators may delete anything
+func (p Profile) CanDelete(posterID xid.ID) bool {
+	return p.CanEdit(posterID) || p.Moderator
 }
@@ -3,6 +3,9 @@ package templates
 import (
 	"html/template"
 	"net/http"
+	"strings"
+
+	"github.com/courtier/carrotbb/database"
 )
 
 const profilePageTemplateStr = `<html lang="en">
@@ -12,32 +15,120 @@ const profilePageTemplateStr = `<html lang="en">
     <meta http-equiv="X-UA-Compatible" content="IE=edge">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <title>carrotbb</title>
+    {{if not .Owner.Deleted}}<link rel="alternate" type="application/atom+xml" title="posts by {{.Owner.Name}}" href="/feed/user/{{.Owner.ID}}.atom">{{end}}
 </head>
 
 <body>
     {{if .User.OK}}
-    <p><a href="/">carrotbb</a> - logged in as <a href="/self">{{.User.User.Name}}</a> <a href="/createpost">create a post</a> <a href="/logout">log out</a></p>
-	<p>You have created <b>TODO</b> posts.</p>
-	<p>You have left <b>TODO</b> comments.</p>
+    <p><a href="/">carrotbb</a> - logged in as <a href="/self">{{.User.User.Name}}</a> <a href="/createpost">create a post</a> <form action="/logout" method="post" style="display: inline"><input type="hidden" name="csrf_token" value="{{.User.CSRF}}"><input type="submit" value="log out"></form></p>
+    {{else}}
+    <p><a href="/">carrotbb</a> - <a href="/signup">sign up</a> <a href="/signin">sign in</a></p>
+    {{end}}
+    {{if .Owner.Deleted}}
+    <p>this account has been deleted.</p>
     {{else}}
-    <p>carrotbb - <a href="/signup">sign up</a> <a href="/signin">sign in</a></p>
+    <h1>{{.Owner.Name}}</h1>
+    <p><a href="/feed/user/{{.Owner.ID}}.atom">feed of their posts</a></p>
+    {{if ne .Owner.Role.String "user"}}<p>role: {{.Owner.Role}}</p>{{end}}
+    {{if .Owner.Banned}}<p><i>{{.Owner.Name}} is banned.</i></p>{{end}}
+	<p>{{.Owner.Name}} has created <b>TODO</b> posts.</p>
+	<p>{{.Owner.Name}} has left <b>TODO</b> comments.</p>
+    {{if .Self}}
+    <form action="/self" method="post">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
+        <input type="hidden" name="action" value="delete">
+        <input type="submit" value="Delete account">
+    </form>
+    <h2>api tokens</h2>
+    {{if .Tokens.Created}}
+    <p>your new token is <code>{{.Tokens.Created}}</code>, copy it now, it will not be shown again.</p>
+    {{end}}
+    {{range .Tokens.Tokens}}
+    <form action="/self" method="post">
+        <input type="hidden" name="csrf_token" value="{{$.User.CSRF}}">
+        <input type="hidden" name="action" value="revoketoken">
+        <input type="hidden" name="tokenID" value="{{.ID}}">
+        <b>{{.Name}}</b> ({{join .Scopes ", "}}) created {{.DateCreated.Format "2006-01-02 15:04"}},
+        {{if .LastUsed.IsZero}}never used{{else}}last used {{.LastUsed.Format "2006-01-02 15:04"}}{{end}}
+        <input type="submit" value="Revoke">
+    </form>
+    {{else}}
+    <p>you have no api tokens.</p>
+    {{end}}
+    <form action="/self" method="post">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
+        <input type="hidden" name="action" value="createtoken">
+        <input type="text" name="name" placeholder="token name" maxlength="32">
+        {{range .Tokens.Scopes}}
+        <label><input type="checkbox" name="scope" value="{{.}}"> {{.}}</label>
+        {{end}}
+        <input type="submit" value="Create token">
+    </form>
+    <p>a token without any scopes picked can read, post and comment.</p>
+    {{end}}
+    {{if .Moderatable}}
+    <form action="/moderate" method="post">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
+        <input type="hidden" name="userID" value="{{.Owner.ID}}">
+        <button type="submit" name="action" value="{{if .Owner.Banned}}unban{{else}}ban{{end}}">{{if .Owner.Banned}}Unban{{else}}Ban{{end}} {{.Owner.Name}}</button>
+    </form>
+    {{end}}
+    {{if and .User.Admin (not .Self)}}
+    <form action="/moderate" method="post">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
+        <input type="hidden" name="userID" value="{{.Owner.ID}}">
+        <input type="hidden" name="action" value="setrole">
+        <select name="role">
+            {{range .Roles}}
+            <option value="{{.}}"{{if eq . $.Owner.Role}} selected{{end}}>{{.}}</option>
+            {{end}}
+        </select>
+        <input type="submit" value="Change role">
+    </form>
+    {{end}}
     {{end}}
 </body>
 
 </html>`
 
 type ProfilePageTemplateData struct {
+	// the visitor
 	User Profile
+	// the user whose profile is shown
+	Owner database.User
+	// is the visitor looking at their own profile?
+	Self bool
+	// can the visitor ban the owner?
+	Moderatable bool
+	// the roles an admin can pick from
+	Roles []database.Role
+	// the api tokens of the visitor, only set on their own profile
+	Tokens APITokens
+}
+
+// APITokens is what the api token section of your own profile shows
+type APITokens struct {
+	Tokens []database.APIToken
+	// the scopes a new token can be given
+	Scopes []string
+	// a token that was just created, it is only ever shown once
+	Created string
 }
 
 var (
-	profilePageTemplate = template.Must(template.New("profilePageTemplate").Parse(profilePageTemplateStr))
+	profilePageTemplate = template.Must(template.New("profilePageTemplate").Funcs(template.FuncMap{"join": strings.Join}).Parse(profilePageTemplateStr))
 )
 
 // TODO: add links to all created posts, and comments
-func GenerateProfilePage(w http.ResponseWriter, user Profile) error {
+func GenerateProfilePage(w http.ResponseWriter, user Profile, owner database.User, tokens APITokens) error {
 	data := ProfilePageTemplateData{
-		User: user,
+		User:  user,
+		Owner: owner,
+		Self:  user.Owns(owner.ID),
+		// moderators can only ban users below them
+		Moderatable: user.Moderator && user.User.Role > owner.Role,
+		Roles:       []database.Role{database.RoleUser, database.RoleModerator, database.RoleAdmin},
+		Tokens:      tokens,
 	}
 	return profilePageTemplate.Execute(w, data)
 }
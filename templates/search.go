@@ -0,0 +1,172 @@
+package templates
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/courtier/carrotbb/database"
+)
+
+const (
+	SNIPPET_LENGTH = 200
+	// SNIPPET_CONTEXT is how much text a snippet keeps before the first match
+	SNIPPET_CONTEXT = 60
+)
+
+const searchPageTemplateStr = `<html lang="en">
+
+<head>
+    <meta charset="UTF-8">
+    <meta http-equiv="X-UA-Compatible" content="IE=edge">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>carrotbb - search</title>
+</head>
+
+<body>
+    {{if .User.OK}}
+    <p><a href="/">carrotbb</a> - logged in as <a href="/self">{{.User.User.Name}}</a> <a href="/createpost">create a post</a> <form action="/logout" method="post" style="display: inline"><input type="hidden" name="csrf_token" value="{{.User.CSRF}}"><input type="submit" value="log out"></form></p>
+    {{else}}
+    <p><a href="/">carrotbb</a> - <a href="/signup">sign up</a> <a href="/signin">sign in</a></p>
+    {{end}}
+    <h2>search</h2>
+    <form action="/search" method="get">
+        <input type="text" name="q" value="{{.Form.Query}}" placeholder="words to look for">
+        <input type="text" name="author" value="{{.Form.Author}}" placeholder="author">
+        <label>from <input type="date" name="since" value="{{.Form.Since}}"></label>
+        <label>to <input type="date" name="until" value="{{.Form.Until}}"></label>
+        <input type="submit" value="Search">
+    </form>
+    {{if .Searched}}
+    {{if .Results}}
+    <ul>
+        {{range .Results}}
+        <li>
+            {{if .IsComment}}
+            <p><a href="/post/{{.Post.ID}}#comment-{{.Comment.ID}}">comment on {{.Post.Title}}</a>{{if or .Comment.Hidden .Post.Hidden}} <i>(hidden)</i>{{end}} by {{.Author.Name}}, posted at {{.Comment.DateCreated.Format "15:04:05 UTC"}} on {{.Comment.DateCreated.Format "Jan 02, 2006"}}</p>
+            <p>{{snippet .Comment.Content $.Terms}}</p>
+            {{else}}
+            <p><a href="/post/{{.Post.ID}}">{{snippet .Post.Title $.Terms}}</a>{{if .Post.Hidden}} <i>(hidden)</i>{{end}} by {{.Author.Name}}, posted at {{.Post.DateCreated.Format "15:04:05 UTC"}} on {{.Post.DateCreated.Format "Jan 02, 2006"}}</p>
+            <p>{{snippet .Post.Content $.Terms}}</p>
+            {{end}}
+        </li>
+        {{end}}
+    </ul>
+    <p>{{with .Previous}}<a href="{{.}}">newer results</a>{{end}} {{with .Next}}<a href="{{.}}">older results</a>{{end}}</p>
+    {{else}}
+    <h3>nothing found.</h3>
+    {{end}}
+    {{end}}
+</body>
+
+</html>`
+
+// SearchForm holds the search filters the way they were typed, to fill the form back in
+type SearchForm struct {
+	Query  string
+	Author string
+	Since  string
+	Until  string
+}
+
+type SearchPageTemplateData struct {
+	User Profile
+	Form SearchForm
+	// the terms to highlight
+	Terms []string
+	// was there anything to search for? the bare form is shown otherwise
+	Searched bool
+	Results  []database.SearchResult
+	// links to the pages of results around this one, empty if there is none
+	Previous string
+	Next     string
+}
+
+var (
+	searchPageTemplate = template.Must(template.New("searchPageTemplate").Funcs(template.FuncMap{"snippet": Snippet}).Parse(searchPageTemplateStr))
+)
+
+func GenerateSearchPage(w http.ResponseWriter, user Profile, form SearchForm, searched bool, results []database.SearchResult, previous, next string) error {
+	data := SearchPageTemplateData{
+		User:     user,
+		Form:     form,
+		Terms:    database.SearchTerms(form.Query),
+		Searched: searched,
+		Results:  results,
+		Previous: previous,
+		Next:     next,
+	}
+	return searchPageTemplate.Execute(w, data)
+}
+
+// Snippet cuts up to SNIPPET_LENGTH characters out of text around the first term found in it,
+// with every term highlighted, terms have to be lowercase like database.SearchTerms makes them
+func Snippet(text string, terms []string) template.HTML {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		termRunes := []rune(term)
+		if len(termRunes) == 0 {
+			continue
+		}
+		for i := 0; i+len(termRunes) <= len(lower); i++ {
+			if string(lower[i:i+len(termRunes)]) != term {
+				continue
+			}
+			for k := i; k < i+len(termRunes); k++ {
+				marked[k] = true
+			}
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+	start := 0
+	if first > SNIPPET_CONTEXT {
+		start = first - SNIPPET_CONTEXT
+	}
+	// cut between words rather than inside them
+	for start > 0 && start < first && runes[start-1] != ' ' {
+		start++
+	}
+	end := start + SNIPPET_LENGTH
+	if end > len(runes) {
+		end = len(runes)
+	}
+	if end < len(runes) {
+		// a snippet without a space to cut at, like a long url, keeps the hard cut
+		cut := end
+		for cut > start && cut > first && runes[cut] != ' ' {
+			cut--
+		}
+		if cut > start && runes[cut] == ' ' {
+			end = cut
+		}
+	}
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; {
+		j := i
+		for j < end && marked[j] == marked[i] {
+			j++
+		}
+		chunk := template.HTMLEscapeString(string(runes[i:j]))
+		if marked[i] {
+			chunk = "<mark>" + chunk + "</mark>"
+		}
+		b.WriteString(chunk)
+		i = j
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return template.HTML(b.String())
+}
@@ -0,0 +1,33 @@
+package templates
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("filler ", 20) + "Carrot " + strings.Repeat("filler ", 40)
+	payloads := map[string]string{
+		"I like carrots":         "I like <mark>carrot</mark>s",
+		"CARROT pie":             "<mark>CARROT</mark> pie",
+		"<b>carrot</b>":          "&lt;b&gt;<mark>carrot</mark>&lt;/b&gt;",
+		"line\n\nbreaks carrot":  "line breaks <mark>carrot</mark>",
+		"no match here":          "no match here",
+		"cake and carrot, cake!": "<mark>cake</mark> and <mark>carrot</mark>, <mark>cake</mark>!",
+		long:                     "…" + strings.Repeat("filler ", 8) + "<mark>Carrot</mark> filler",
+	}
+	for k, v := range payloads {
+		res := string(Snippet(k, []string{"carrot", "cake"}))
+		if !strings.HasPrefix(res, v) {
+			t.Errorf("Text: %q expected to start with: %q got: %q", k, v, res)
+		}
+	}
+	if res := string(Snippet(long, []string{"carrot"})); !strings.HasSuffix(res, "filler…") {
+		t.Errorf("Text: %q expected to be cut off, got: %q", long, res)
+	}
+	for _, unbroken := range []string{strings.Repeat("x", 300), "carrot" + strings.Repeat("x", 300)} {
+		if res := string(Snippet(unbroken, []string{"carrot"})); len([]rune(strings.TrimSuffix(strings.ReplaceAll(res, "<mark>carrot</mark>", "carrot"), "…"))) != SNIPPET_LENGTH {
+			t.Errorf("Text without spaces expected to be cut at %d characters, got: %q", SNIPPET_LENGTH, res)
+		}
+	}
+}
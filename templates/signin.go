@@ -17,6 +17,7 @@ const signinTemplateStr = `<html lang="en">
 <body>
     <h1>sign in to carrotbb</h1>
     <form action="/signin" method="post">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
         <label for="username">Username</label><br>
         <input type="text" id="username" name="username" placeholder="carrot"><br>
         <label for="password">Password</label><br>
@@ -29,6 +30,7 @@ const signinTemplateStr = `<html lang="en">
 </html>`
 
 type SigninTemplateData struct {
+	User     Profile
 	Redirect string
 }
 
@@ -36,11 +38,12 @@ var (
 	signinTemplate = template.Must(template.New("signinTemplate").Parse(signinTemplateStr))
 )
 
-func GenerateSigninTemplate(w http.ResponseWriter, referer string) error {
+func GenerateSigninTemplate(w http.ResponseWriter, user Profile, referer string) error {
 	if referer == "" {
 		referer = "/"
 	}
 	data := SigninTemplateData{
+		User:     user,
 		Redirect: referer,
 	}
 	return signinTemplate.Execute(w, data)
@@ -17,6 +17,7 @@ const signupTemplateStr = `<html lang="en">
 <body>
     <h1>sign up to carrotbb</h1>
     <form action="/signup" method="post">
+        <input type="hidden" name="csrf_token" value="{{.User.CSRF}}">
         <label for="username">Username</label><br>
         <input type="text" id="username" name="username" placeholder="carrot"><br>
         <label for="password">Password</label><br>
@@ -29,6 +30,7 @@ const signupTemplateStr = `<html lang="en">
 </html>`
 
 type SignupTemplateData struct {
+	User     Profile
 	Redirect string
 }
 
@@ -36,11 +38,12 @@ var (
 	signupTemplate = template.Must(template.New("signupTemplate").Parse(signupTemplateStr))
 )
 
-func GenerateSignupTemplate(w http.ResponseWriter, referer string) error {
+func GenerateSignupTemplate(w http.ResponseWriter, user Profile, referer string) error {
 	if referer == "" {
 		referer = "/"
 	}
 	data := SignupTemplateData{
+		User:     user,
 		Redirect: referer,
 	}
 	return signupTemplate.Execute(w, data)
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+)
+
+// TokenKind tells apart what a token was issued for, it is used as the token prefix
+// so a token of one kind can never be accepted as another
+type TokenKind string
+
+const (
+	SessionToken       TokenKind = "sess"
+	CSRFToken          TokenKind = "csrf"
+	PasswordResetToken TokenKind = "reset"
+	APIToken           TokenKind = "api"
+)
+
+const (
+	DEFAULT_TOKEN_BYTES = 32
+	MIN_TOKEN_BYTES     = 16
+)
+
+var (
+	ErrTokenTooShort = errors.New("tokens need at least 16 random bytes")
+)
+
+var (
+	// tokenBytes is how many random bytes new tokens carry, see setTokenBytes
+	tokenBytes = DEFAULT_TOKEN_BYTES
+)
+
+// checkTokenBytes returns ErrTokenTooShort if tokens of n random bytes would be too easy to guess
+func checkTokenBytes(n int) error {
+	if n < MIN_TOKEN_BYTES {
+		return ErrTokenTooShort
+	}
+	return nil
+}
+
+// setTokenBytes sets the configured token length
+func setTokenBytes(n int) error {
+	if err := checkTokenBytes(n); err != nil {
+		return err
+	}
+	tokenBytes = n
+	return nil
+}
+
+// newToken returns a token of kind with tokenBytes of crypto/rand entropy,
+// encoded as kind_base64url
+func newToken(kind TokenKind) (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return string(kind) + "_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// isTokenOfKind checks that a token has the prefix of kind and a well formed random part,
+// it does not tell whether the token was ever issued
+func isTokenOfKind(token string, kind TokenKind) bool {
+	random := strings.TrimPrefix(token, string(kind)+"_")
+	if random == token {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(random)
+	return err == nil && len(b) >= MIN_TOKEN_BYTES
+}
+
+// tokensEqual compares two tokens in constant time
+func tokensEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// hashToken returns the hex encoded sha256 of a token,
+// tokens should be stored hashed so a leaked store does not leak usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewToken(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		token, err := newToken(SessionToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[token] {
+			t.Fatal("Duplicate token:", token)
+		}
+		seen[token] = true
+		if !strings.HasPrefix(token, "sess_") {
+			t.Error("Expected sess_ prefix, got:", token)
+		}
+	}
+}
+
+func TestIsTokenOfKind(t *testing.T) {
+	token, err := newToken(CSRFToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloads := map[string]bool{
+		token:                       true,
+		"":                          false,
+		"csrf_":                     false,
+		"csrf_c2hvcnQ":              false,
+		"csrf_!!!!!!!!!!!!!!!!!!!!": false,
+		"sess" + token[4:]:          false,
+		token[5:]:                   false,
+	}
+	for k, v := range payloads {
+		if res := isTokenOfKind(k, CSRFToken); res != v {
+			t.Error("Token:", k, "expected:", v, "got:", res)
+		}
+	}
+}
+
+func TestSetTokenBytes(t *testing.T) {
+	defer func() { tokenBytes = DEFAULT_TOKEN_BYTES }()
+	payloads := map[int]error{
+		8:  ErrTokenTooShort,
+		48: nil,
+	}
+	for k, v := range payloads {
+		if res := setTokenBytes(k); res != v {
+			t.Error("Length:", k, "expected:", v, "got:", res)
+		}
+	}
+	token, err := newToken(APIToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 48 bytes are 64 base64 characters
+	if len(token) != len("api_")+64 {
+		t.Error("Expected a 48 byte token, got:", token)
+	}
+}
+
+func TestTokensEqual(t *testing.T) {
+	if !tokensEqual("carrot", "carrot") {
+		t.Error("Equal tokens should compare equal")
+	}
+	if tokensEqual("carrot", "carrots") || tokensEqual("carrot", "parrot") {
+		t.Error("Different tokens should not compare equal")
+	}
+}